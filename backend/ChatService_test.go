@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"path/filepath"
 	"testing"
+	"time"
 
 	_ "modernc.org/sqlite"
 	"real-time-forum/backend/chat"
@@ -18,7 +19,8 @@ func TestChatServicePersistsMessageAndUnreadNotification(t *testing.T) {
 	defer db.Close()
 
 	_, err = db.Exec(`
-		CREATE TABLE users (id INTEGER PRIMARY KEY, nickname TEXT UNIQUE);
+		CREATE TABLE users (id INTEGER PRIMARY KEY, nickname TEXT UNIQUE, deleted_at DATETIME);
+		CREATE TABLE nickname_history (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id INTEGER, old_nickname TEXT, new_nickname TEXT, changed_at DATETIME);
 		CREATE TABLE messages (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			sender_id INTEGER,
@@ -36,16 +38,20 @@ func TestChatServicePersistsMessageAndUnreadNotification(t *testing.T) {
 			sender_nickname TEXT,
 			unread_messages INTEGER
 		);
+		CREATE TABLE notification_preferences (user_id INTEGER, type TEXT, channel TEXT, PRIMARY KEY(user_id, type));
+		CREATE TABLE quiet_hours (user_id INTEGER PRIMARY KEY, start_time TEXT, end_time TEXT, timezone TEXT);
+		CREATE TABLE conversation_mutes (user_id INTEGER, other_user_id INTEGER, muted_until DATETIME, created_at DATETIME, PRIMARY KEY(user_id, other_user_id));
 		INSERT INTO users (id, nickname) VALUES (1, 'alice'), (2, 'bob');
 	`)
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	notifications := notification.NewRepository(db)
 	service := chat.NewService(
 		db,
 		chat.NewRepository(db),
-		notification.NewRepository(db),
+		notifications,
 	)
 	message, err := service.SendMessage(1, "bob", "<b>hello</b>")
 	if err != nil {
@@ -69,4 +75,18 @@ func TestChatServicePersistsMessageAndUnreadNotification(t *testing.T) {
 	if storedCount != 1 || unread != 1 || storedContent != "<b>hello</b>" {
 		t.Fatalf("got storedCount=%d unread=%d content=%q, want 1, 1, raw content", storedCount, unread, storedContent)
 	}
+
+	if err := notifications.MuteConversation(2, 1, nil, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	message, err = service.SendMessage(1, "bob", "are you there?")
+	if err != nil {
+		t.Fatalf("SendMessage to a muted conversation failed: %v", err)
+	}
+	if err := db.QueryRow("SELECT unread_messages FROM notifications WHERE receiver_id = 2 AND sender_id = 1").Scan(&unread); err != nil {
+		t.Fatal(err)
+	}
+	if message.ID == 0 || message.Delivery.Channel != notification.ChannelOff || unread != 2 {
+		t.Fatalf("muted conversation got message %d, delivery %+v, unread %d; want delivered and counted without alerting", message.ID, message.Delivery, unread)
+	}
 }
@@ -1,24 +1,69 @@
 package backend
 
 import (
+	"crypto/rand"
+	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
+
+	"real-time-forum/backend/mail"
+	"real-time-forum/backend/oidc"
+	"real-time-forum/backend/password"
+	"real-time-forum/backend/webpush"
 )
 
 type Config struct {
 	HTTPAddress      string
 	DatabasePath     string
 	StaticPath       string
+	UploadPath       string
 	Environment      string
 	AllowedWSOrigins []string
+	PublicURL        string
+	SMTPAddress      string
+	SMTPUsername     string
+	SMTPPassword     string
+	MailFrom         string
+	AdminNickname    string
+	SecretKey        string
+	OIDC             oidc.Config
+	PasswordHashing  password.Params
+	BreachCorpusPath string
+	VAPIDPrivateKey  string
+	VAPIDSubject     string
 }
 
 func LoadConfig() Config {
 	config := Config{
-		HTTPAddress:  envOrDefault("FORUM_HTTP_ADDRESS", ":8080"),
-		DatabasePath: envOrDefault("FORUM_DATABASE_PATH", "database/forum.db"),
-		StaticPath:   envOrDefault("FORUM_STATIC_PATH", "static"),
-		Environment:  envOrDefault("FORUM_ENV", "development"),
+		HTTPAddress:   envOrDefault("FORUM_HTTP_ADDRESS", ":8080"),
+		DatabasePath:  envOrDefault("FORUM_DATABASE_PATH", "database/forum.db"),
+		StaticPath:    envOrDefault("FORUM_STATIC_PATH", "static"),
+		UploadPath:    envOrDefault("FORUM_UPLOAD_PATH", "uploads"),
+		Environment:   envOrDefault("FORUM_ENV", "development"),
+		PublicURL:     strings.TrimRight(envOrDefault("FORUM_PUBLIC_URL", "http://localhost:8080"), "/"),
+		SMTPAddress:   os.Getenv("FORUM_SMTP_ADDRESS"),
+		SMTPUsername:  os.Getenv("FORUM_SMTP_USERNAME"),
+		SMTPPassword:  os.Getenv("FORUM_SMTP_PASSWORD"),
+		MailFrom:      envOrDefault("FORUM_MAIL_FROM", "forum@localhost"),
+		AdminNickname: strings.TrimSpace(os.Getenv("FORUM_ADMIN_NICKNAME")),
+		SecretKey:     os.Getenv("FORUM_SECRET_KEY"),
+
+		BreachCorpusPath: strings.TrimSpace(os.Getenv("FORUM_BREACH_CORPUS_PATH")),
+		VAPIDPrivateKey:  strings.TrimSpace(os.Getenv("FORUM_VAPID_PRIVATE_KEY")),
+	}
+	config.VAPIDSubject = envOrDefault("FORUM_VAPID_SUBJECT", "mailto:"+config.MailFrom)
+	config.OIDC = oidc.Config{
+		Issuer:       strings.TrimRight(strings.TrimSpace(os.Getenv("FORUM_OIDC_ISSUER")), "/"),
+		ClientID:     strings.TrimSpace(os.Getenv("FORUM_OIDC_CLIENT_ID")),
+		ClientSecret: os.Getenv("FORUM_OIDC_CLIENT_SECRET"),
+		RedirectURL:  envOrDefault("FORUM_OIDC_REDIRECT_URL", config.PublicURL+"/auth/oidc/callback"),
+	}
+	config.PasswordHashing = password.Params{
+		Memory:      uint32(envUint("FORUM_ARGON2_MEMORY_KIB", 32)),
+		Iterations:  uint32(envUint("FORUM_ARGON2_ITERATIONS", 32)),
+		Parallelism: uint8(envUint("FORUM_ARGON2_PARALLELISM", 8)),
 	}
 
 	origins := os.Getenv("FORUM_WS_ORIGINS")
@@ -45,6 +90,94 @@ func envOrDefault(name, fallback string) string {
 	return fallback
 }
 
+// envUint returns 0, meaning "use the default", when the variable is unset or
+// not an unsigned integer of the given bit size.
+func envUint(name string, bitSize int) uint64 {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return 0
+	}
+	parsed, err := strconv.ParseUint(value, 10, bitSize)
+	if err != nil {
+		log.Printf("ignoring invalid %s=%q", name, value)
+		return 0
+	}
+	return parsed
+}
+
 func (c Config) SecureCookies() bool {
 	return c.Environment == "production"
 }
+
+// Mailer returns an SMTP mailer when FORUM_SMTP_ADDRESS is set and a mailer
+// that only logs messages otherwise.
+func (c Config) Mailer() mail.Mailer {
+	if c.SMTPAddress == "" {
+		return mail.LogMailer{}
+	}
+	return mail.SMTPMailer{
+		Address:  c.SMTPAddress,
+		Username: c.SMTPUsername,
+		Password: c.SMTPPassword,
+		From:     c.MailFrom,
+	}
+}
+
+// OIDCClient returns nil when no issuer and client ID are configured, which
+// disables single sign-on.
+func (c Config) OIDCClient() *oidc.Client {
+	if c.OIDC.Issuer == "" || c.OIDC.ClientID == "" {
+		return nil
+	}
+	return oidc.NewClient(c.OIDC, nil)
+}
+
+// SigningKey returns FORUM_SECRET_KEY. Without it a random key is generated,
+// so signed links stop working when the server restarts.
+func (c Config) SigningKey() []byte {
+	if c.SecretKey != "" {
+		return []byte(c.SecretKey)
+	}
+	log.Println("FORUM_SECRET_KEY is not set; using a random key for this run")
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatal(err)
+	}
+	return key
+}
+
+// PushSender sends Web Push messages signed with the VAPID keys from
+// FORUM_VAPID_PRIVATE_KEY, or with the keys generated and stored on first
+// start when it is not set.
+func (c Config) PushSender(subscriptions *webpush.Repository) *webpush.Sender {
+	keys, err := subscriptions.Keys(c.VAPIDPrivateKey, time.Now())
+	if err != nil {
+		log.Fatalf("load VAPID keys: %v", err)
+	}
+	return &webpush.Sender{
+		Keys:    keys,
+		Subject: c.VAPIDSubject,
+		Client:  webpush.NewClient(10 * time.Second),
+	}
+}
+
+// PasswordHasher hashes new passwords with Argon2id. Parameters left unset
+// fall back to password.DefaultParams. Changing them makes every existing hash
+// outdated, and each one is rehashed at its user's next login.
+func (c Config) PasswordHasher() *password.Hasher {
+	return password.NewHasher(c.PasswordHashing)
+}
+
+// PasswordPolicy checks new passwords against the bundled common-password
+// list and, when FORUM_BREACH_CORPUS_PATH is set, against that offline breach
+// corpus.
+func (c Config) PasswordPolicy() *password.Policy {
+	if c.BreachCorpusPath == "" {
+		return &password.Policy{}
+	}
+	corpus, err := password.OpenFileCorpus(c.BreachCorpusPath)
+	if err != nil {
+		log.Fatalf("open breach corpus: %v", err)
+	}
+	return &password.Policy{Breaches: corpus}
+}
@@ -85,6 +85,22 @@ func (h *Hub) DisconnectSession(userID int64, sessionID string) {
 	}
 }
 
+// RenameUser updates the nickname of every client of the user, so messages
+// they send after a rename carry the new nickname.
+func (h *Hub) RenameUser(userID int64, nickname string) {
+	for _, client := range h.ClientsForUser(userID) {
+		client.nameMu.Lock()
+		client.Username = nickname
+		client.nameMu.Unlock()
+	}
+}
+
+func (c *Client) Nickname() string {
+	c.nameMu.RLock()
+	defer c.nameMu.RUnlock()
+	return c.Username
+}
+
 func (c *Client) Enqueue(message interface{}) bool {
 	c.sendMu.RLock()
 	defer c.sendMu.RUnlock()
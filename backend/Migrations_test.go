@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"path/filepath"
 	"testing"
+
+	"real-time-forum/backend/subscription"
 )
 
 func TestRunMigrationsIsIdempotent(t *testing.T) {
@@ -24,8 +26,8 @@ func TestRunMigrationsIsIdempotent(t *testing.T) {
 	if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count); err != nil {
 		t.Fatal(err)
 	}
-	if count != 4 {
-		t.Fatalf("got %d applied migrations, want 4", count)
+	if count != 30 {
+		t.Fatalf("got %d applied migrations, want 30", count)
 	}
 }
 
@@ -43,3 +45,32 @@ func TestValidateIdentityBackfillPassesCompleteSchema(t *testing.T) {
 		t.Fatalf("expected complete migrated schema to validate: %v", err)
 	}
 }
+
+func TestSubscriptionsMigrationFollowsExistingPosts(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "forum.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := runMigrations(db); err != nil {
+		t.Fatal(err)
+	}
+	// Roll back to before 023 with a post that was written then, so it has
+	// no subscription of its own.
+	if _, err := db.Exec(`
+		INSERT INTO users (id, nickname, email, password) VALUES (1, 'alice', 'alice@example.com', '');
+		INSERT INTO posts (id, user_id, title, content, category) VALUES (1, 1, 'Old post', 'Written before subscriptions', 'general');
+		DROP TABLE subscriptions;
+		DELETE FROM schema_migrations WHERE version = '023_subscriptions.sql';`); err != nil {
+		t.Fatal(err)
+	}
+	if err := runMigrations(db); err != nil {
+		t.Fatal(err)
+	}
+
+	subscribers, err := subscription.NewRepository(db).PostSubscribers(1)
+	if err != nil || len(subscribers) != 1 || subscribers[0] != 1 {
+		t.Fatalf("subscribers of the old post = %v, %v; want its author", subscribers, err)
+	}
+}
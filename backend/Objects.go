@@ -4,6 +4,8 @@ import (
 	"sync"
 
 	"github.com/gorilla/websocket"
+
+	"real-time-forum/backend/mention"
 )
 
 type Post struct {
@@ -25,18 +27,20 @@ type Notification struct {
 type Comment struct {
 	ID        int    `json:"id"`
 	PostID    int    `json:"post_id"`
+	ParentID  int    `json:"parent_id,omitempty"`
 	Content   string `json:"content"`
 	CreatedAt string `json:"created_at"`
 	Author    string `json:"author"`
 }
 
 type Message struct {
-	ID        int    `json:"id,omitempty"`
-	From      string `json:"from"`
-	To        string `json:"to"`
-	Content   string `json:"content"`
-	Timestamp string `json:"timestamp"`
-	Type      string `json:"type"`
+	ID        int            `json:"id,omitempty"`
+	From      string         `json:"from"`
+	To        string         `json:"to"`
+	Content   string         `json:"content"`
+	Timestamp string         `json:"timestamp"`
+	Type      string         `json:"type"`
+	Mentions  []mention.Span `json:"mentions,omitempty"`
 }
 
 type Client struct {
@@ -48,6 +52,7 @@ type Client struct {
 	SessionID string           `json:"session_id"`
 	sendMu    sync.RWMutex
 	closeOnce sync.Once
+	nameMu    sync.RWMutex
 }
 
 type User struct {
@@ -74,6 +79,7 @@ type WSMessage struct {
 type UsersListe struct {
 	Nickname string `json:"nickname"`
 	Status   string `json:"status"`
+	Avatar   string `json:"avatar_url,omitempty"`
 }
 
 type UserConversation struct {
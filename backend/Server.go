@@ -2,19 +2,32 @@ package backend
 
 import (
 	"context"
+	"crypto/subtle"
 	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/twinj/uuid"
 	"real-time-forum/backend/account"
+	"real-time-forum/backend/audit"
+	"real-time-forum/backend/bookmark"
 	"real-time-forum/backend/chat"
+	"real-time-forum/backend/digest"
 	"real-time-forum/backend/forum"
+	"real-time-forum/backend/mail"
+	"real-time-forum/backend/mention"
+	"real-time-forum/backend/moderation"
 	"real-time-forum/backend/notification"
+	"real-time-forum/backend/oidc"
+	"real-time-forum/backend/password"
+	"real-time-forum/backend/storage"
+	"real-time-forum/backend/subscription"
+	"real-time-forum/backend/webpush"
 )
 
 type Server struct {
@@ -25,10 +38,29 @@ type Server struct {
 	httpServer    *http.Server
 	sessions      *account.SessionRepository
 	users         *account.UserRepository
+	throttle      *account.ThrottleRepository
+	apiTokens     *account.APITokenRepository
+	oidcAccounts  *account.OIDCRepository
+	magicLinks    *account.MagicLinkRepository
+	personalData  *account.PersonalDataRepository
+	passwords     *password.Policy
+	oidc          *oidc.Client
 	forum         *forum.Repository
 	chat          *chat.Repository
 	chatService   *chat.Service
 	notifications *notification.Repository
+	mentions      *mention.Repository
+	moderation    *moderation.Repository
+	audit         *audit.Repository
+	mailer        mail.Mailer
+	// linkMails tracks magic sign-in links being mailed in the background.
+	linkMails     sync.WaitGroup
+	digests       *digest.Repository
+	pushes        *webpush.Repository
+	subscriptions *subscription.Repository
+	bookmarks     *bookmark.Repository
+	pushSender    *webpush.Sender
+	blobs         storage.BlobStore
 	upgrader      websocket.Upgrader
 }
 
@@ -75,19 +107,57 @@ func (S *Server) RunWithConfig(config Config) {
 	}
 	defer S.db.Close()
 	S.sessions = account.NewSessionRepository(S.db)
-	S.users = account.NewUserRepository(S.db)
+	S.users = account.NewUserRepository(S.db, config.PasswordHasher())
+	S.passwords = config.PasswordPolicy()
+	S.throttle = account.NewThrottleRepository(S.db)
+	S.apiTokens = account.NewAPITokenRepository(S.db)
+	S.oidcAccounts = account.NewOIDCRepository(S.db)
+	S.oidc = config.OIDCClient()
+	signingKey := config.SigningKey()
+	S.magicLinks = account.NewMagicLinkRepository(S.db, signingKey)
+	S.personalData = account.NewPersonalDataRepository(S.db)
 	S.forum = forum.NewRepository(S.db)
 	S.chat = chat.NewRepository(S.db)
 	S.notifications = notification.NewRepository(S.db)
+	S.mentions = mention.NewRepository(S.db)
+	S.subscriptions = subscription.NewRepository(S.db)
+	S.bookmarks = bookmark.NewRepository(S.db)
+	S.moderation = moderation.NewRepository(S.db)
+	S.audit = audit.NewRepository(S.db)
 	S.chatService = chat.NewService(S.db, S.chat, S.notifications)
+	S.mailer = config.Mailer()
+	S.digests = digest.NewRepository(S.db, signingKey)
+	S.pushes = webpush.NewRepository(S.db)
+	S.pushSender = config.PushSender(S.pushes)
+	S.blobs, err = storage.NewLocalBlobStore(config.UploadPath)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Initialize WebSocket upgrader with CORS protection
 	S.initUpgrader()
 
 	S.initRoutes()
 
+	if config.AdminNickname != "" {
+		if err := S.users.SetRoleByNickname(config.AdminNickname, account.RoleAdmin); err != nil {
+			log.Printf("failed to grant admin role to %s: %v", config.AdminNickname, err)
+		} else {
+			S.recordAudit(nil, audit.Event{
+				Action:     audit.ActionRoleChanged,
+				TargetType: audit.TargetUser,
+				Details:    "nickname=" + config.AdminNickname + " role=admin source=FORUM_ADMIN_NICKNAME",
+			})
+		}
+	}
+
 	S.hub = NewHub()
 
+	stopJobs := make(chan struct{})
+	defer close(stopJobs)
+	go S.runAccountPurge(stopJobs)
+	go S.runEmailDigests(stopJobs)
+
 	S.httpServer = &http.Server{
 		Addr:              config.HTTPAddress,
 		Handler:           S.Mux,
@@ -117,37 +187,157 @@ func (S *Server) initRoutes() {
 	S.Mux.Handle("/", checkHome(home))
 	S.Mux.HandleFunc("/logged", S.LoggedHandler)
 
-	S.Mux.HandleFunc("/notifications", S.GetNotifications)
-	S.Mux.HandleFunc("/notifications/mark-read", S.MarkNotificationsRead)
-
-	S.Mux.Handle("/createPost", S.SessionMiddleware(http.HandlerFunc(S.CreatePostHandler)))
-	S.Mux.Handle("/posts", S.SessionMiddleware(http.HandlerFunc(S.GetPostsHandler)))
-
-	S.Mux.Handle("/createComment", S.SessionMiddleware(http.HandlerFunc(S.CreateCommentHandler)))
-	S.Mux.Handle("/comments", S.SessionMiddleware(http.HandlerFunc(S.GetCommentsHandler)))
+	S.Mux.Handle("/notifications", S.WithScope(account.ScopeNotificationsRead, S.GetNotifications))
+	S.Mux.Handle("/notifications/mark-read", S.SessionMiddleware(http.HandlerFunc(S.MarkNotificationsRead)))
+	S.Mux.Handle("/notifications/feed", S.WithScope(account.ScopeNotificationsRead, S.ListNotificationFeed))
+	S.Mux.Handle("/notifications/feed/read", S.SessionMiddleware(http.HandlerFunc(S.MarkNotificationFeedRead)))
+	S.Mux.Handle("/notifications/preferences", S.SessionMiddleware(http.HandlerFunc(S.NotificationPreferencesHandler)))
+	S.Mux.HandleFunc("/notifications/unsubscribe", S.UnsubscribeDigestHandler)
+	S.Mux.Handle("/subscriptions", S.SessionMiddleware(http.HandlerFunc(S.ListSubscriptionsHandler)))
+	S.Mux.Handle("/subscriptions/subscribe", S.SessionMiddleware(http.HandlerFunc(S.SubscribeHandler)))
+	S.Mux.Handle("/subscriptions/unsubscribe", S.SessionMiddleware(http.HandlerFunc(S.UnsubscribeHandler)))
+	S.Mux.Handle("/bookmarks", S.SessionMiddleware(http.HandlerFunc(S.ListBookmarksHandler)))
+	S.Mux.Handle("/bookmarks/folders", S.SessionMiddleware(http.HandlerFunc(S.ListBookmarkFoldersHandler)))
+	S.Mux.Handle("/bookmarks/save", S.SessionMiddleware(http.HandlerFunc(S.SaveBookmarkHandler)))
+	S.Mux.Handle("/bookmarks/delete", S.SessionMiddleware(http.HandlerFunc(S.DeleteBookmarkHandler)))
+	S.Mux.HandleFunc("/push/key", S.PushKeyHandler)
+	S.Mux.Handle("/push/subscribe", S.SessionMiddleware(http.HandlerFunc(S.PushSubscribeHandler)))
+	S.Mux.Handle("/push/unsubscribe", S.SessionMiddleware(http.HandlerFunc(S.PushUnsubscribeHandler)))
+	S.Mux.Handle("/notifications/mute", S.SessionMiddleware(http.HandlerFunc(S.MuteConversationHandler)))
+
+	S.Mux.Handle("/createPost", S.WithScope(account.ScopePostsWrite, S.CreatePostHandler))
+	S.Mux.Handle("/posts", S.WithScope(account.ScopePostsRead, S.GetPostsHandler))
+	S.Mux.Handle("/posts/read", S.SessionMiddleware(http.HandlerFunc(S.MarkPostReadHandler)))
+
+	S.Mux.Handle("/deletePost", S.SessionMiddleware(http.HandlerFunc(S.DeletePostHandler)))
+
+	S.Mux.Handle("/createComment", S.WithScope(account.ScopeCommentsWrite, S.CreateCommentHandler))
+	S.Mux.Handle("/deleteComment", S.SessionMiddleware(http.HandlerFunc(S.DeleteCommentHandler)))
+	S.Mux.Handle("/comments", S.WithScope(account.ScopePostsRead, S.GetCommentsHandler))
+
+	S.Mux.HandleFunc("/users/{id}", S.GetUserProfileHandler)
+	S.Mux.HandleFunc("/u/{nickname}", S.UserByNicknameHandler)
+	S.Mux.Handle("/profile", S.SessionMiddleware(http.HandlerFunc(S.GetProfileHandler)))
+	S.Mux.Handle("/profile/update", S.SessionMiddleware(http.HandlerFunc(S.UpdateProfileHandler)))
+	S.Mux.Handle("/profile/nickname", S.SessionMiddleware(http.HandlerFunc(S.ChangeNicknameHandler)))
+	S.Mux.Handle("/profile/email", S.SessionMiddleware(http.HandlerFunc(S.ChangeEmailHandler)))
+	S.Mux.HandleFunc("/profile/email/verify", S.VerifyEmailHandler)
+	S.Mux.Handle("/profile/password", S.SessionMiddleware(http.HandlerFunc(S.ChangePasswordHandler)))
+	S.Mux.Handle("/profile/avatar", S.SessionMiddleware(http.HandlerFunc(S.UploadAvatarHandler)))
+	S.Mux.HandleFunc("/media/avatars/{file}", S.AvatarFileHandler)
+	S.Mux.Handle("/account/export", S.SessionMiddleware(http.HandlerFunc(S.ExportAccountHandler)))
+	S.Mux.Handle("/account/delete", S.SessionMiddleware(http.HandlerFunc(S.DeleteAccountHandler)))
+	S.Mux.Handle("/account/delete/cancel", S.SessionMiddleware(http.HandlerFunc(S.CancelAccountDeletionHandler)))
+	S.Mux.Handle("/tokens", S.SessionMiddleware(http.HandlerFunc(S.ListAPITokensHandler)))
+	S.Mux.Handle("/tokens/create", S.SessionMiddleware(http.HandlerFunc(S.CreateAPITokenHandler)))
+	S.Mux.Handle("/tokens/revoke", S.SessionMiddleware(http.HandlerFunc(S.RevokeAPITokenHandler)))
 
 	S.Mux.HandleFunc("/register", S.RegisterHandler)
 	S.Mux.HandleFunc("/login", S.LoginHandler)
+	S.Mux.HandleFunc("/auth/magic-link", S.RequestMagicLinkHandler)
+	S.Mux.HandleFunc("/auth/magic-link/verify", S.VerifyMagicLinkHandler)
+	S.Mux.HandleFunc("/auth/oidc/config", S.OIDCConfigHandler)
+	S.Mux.HandleFunc("/auth/oidc/login", S.OIDCLoginHandler)
+	S.Mux.HandleFunc("/auth/oidc/callback", S.OIDCCallbackHandler)
+	S.Mux.HandleFunc("/auth/oidc/signup", S.OIDCPendingSignupHandler)
+	S.Mux.HandleFunc("/auth/oidc/signup/complete", S.OIDCCompleteSignupHandler)
+	S.Mux.HandleFunc("/auth/oidc/link", S.OIDCPendingLinkHandler)
+	S.Mux.HandleFunc("/auth/oidc/link/confirm", S.OIDCConfirmLinkHandler)
 
 	S.Mux.Handle("/ws", S.SessionMiddleware(http.HandlerFunc(S.HandleWebSocket)))
-	S.Mux.Handle("/messages", S.SessionMiddleware(http.HandlerFunc(S.GetMessagesHandler)))
+	S.Mux.Handle("/messages", S.WithScope(account.ScopeChatRead, S.GetMessagesHandler))
 
 	S.Mux.Handle("/logout", S.SessionMiddleware(http.HandlerFunc(S.LogoutHandler)))
+
+	S.Mux.Handle("/admin/users/role", S.Authorized(account.PermissionManageRoles, S.SetUserRoleHandler))
+	S.Mux.Handle("/admin/audit", S.Authorized(account.PermissionViewAudit, S.ListAuditEventsHandler))
+	S.Mux.Handle("/moderation/suspend", S.Authorized(account.PermissionSuspendUsers, S.SuspendUserHandler))
+	S.Mux.Handle("/moderation/unsuspend", S.Authorized(account.PermissionSuspendUsers, S.UnsuspendUserHandler))
+	S.Mux.Handle("/reports", S.SessionMiddleware(http.HandlerFunc(S.CreateReportHandler)))
+	S.Mux.Handle("/moderation/reports", S.Authorized(account.PermissionReviewReports, S.ListReportsHandler))
+	S.Mux.Handle("/moderation/reports/resolve", S.Authorized(account.PermissionReviewReports, S.ResolveReportHandler))
 }
 
+// SessionMiddleware only accepts browser sessions. Routes that API tokens may
+// call are registered with WithScope instead.
 func (S *Server) SessionMiddleware(next http.Handler) http.Handler {
+	return S.requireIdentity("", next)
+}
+
+// WithScope is the route helper for endpoints open to API tokens. Sessions
+// pass as with SessionMiddleware; tokens must have been granted the scope.
+func (S *Server) WithScope(scope account.Scope, handler http.HandlerFunc) http.Handler {
+	return S.requireIdentity(scope, handler)
+}
+
+func (S *Server) requireIdentity(scope account.Scope, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		identity, err := S.CheckSessionIdentity(r)
 		if err != nil {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
+		if S.rejectSuspended(w, identity.UserID) {
+			return
+		}
+		if identity.TokenID != 0 {
+			// Bearer tokens are not sent automatically by browsers, so they
+			// need no CSRF token, only the route's scope.
+			if scope == "" || !identity.HasScope(scope) {
+				http.Error(w, "API token does not grant access to this endpoint", http.StatusForbidden)
+				return
+			}
+		} else if !validCSRFToken(r, identity) {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
 
 		ctx := account.WithIdentity(r.Context(), identity)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// validCSRFToken accepts safe methods and otherwise requires the session's
+// token in the X-CSRF-Token header. A cross-site form or fetch can send the
+// session cookie but cannot read the token.
+func validCSRFToken(r *http.Request, identity account.Identity) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	token := r.Header.Get("X-CSRF-Token")
+	return token != "" && identity.CSRFToken != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(identity.CSRFToken)) == 1
+}
+
+// RequirePermission must be wrapped by SessionMiddleware. It rejects requests
+// whose role does not grant the permission.
+func (S *Server) RequirePermission(permission account.Permission, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := account.IdentityFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !identity.Role.Can(permission) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Authorized is the route helper for permission-restricted endpoints.
+func (S *Server) Authorized(permission account.Permission, handler http.HandlerFunc) http.Handler {
+	return S.SessionMiddleware(S.RequirePermission(permission, handler))
+}
+
+// canActOn lets owners act on their own content and everyone else only with
+// the given permission.
+func canActOn(identity account.Identity, ownerID int64, permission account.Permission) bool {
+	return identity.UserID == ownerID || identity.Role.Can(permission)
+}
+
 func (S *Server) CheckSession(r *http.Request) (string, string, error) {
 	identity, err := S.CheckSessionIdentity(r)
 	if err != nil {
@@ -156,7 +346,19 @@ func (S *Server) CheckSession(r *http.Request) (string, string, error) {
 	return identity.Nickname, identity.SessionID, nil
 }
 
+// CheckSessionIdentity resolves an "Authorization: Bearer" API token when the
+// header is present and the session cookie otherwise.
 func (S *Server) CheckSessionIdentity(r *http.Request) (account.Identity, error) {
+	if token, ok := bearerToken(r); ok {
+		if S.apiTokens == nil {
+			return account.Identity{}, fmt.Errorf("API token repository is not initialized")
+		}
+		identity, err := S.apiTokens.FindValid(token, time.Now())
+		if err != nil {
+			return account.Identity{}, fmt.Errorf("invalid or expired API token")
+		}
+		return identity, nil
+	}
 	cookie, err := r.Cookie("session_token")
 	if err != nil {
 		return account.Identity{}, fmt.Errorf("no session cookie")
@@ -172,18 +374,29 @@ func (S *Server) CheckSessionIdentity(r *http.Request) (account.Identity, error)
 	return identity, nil
 }
 
-func (S *Server) MakeToken(Writer http.ResponseWriter, username string) {
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// MakeToken starts a session, sets its cookie, and returns the session's CSRF
+// token. It writes an error response and returns "" on failure.
+func (S *Server) MakeToken(Writer http.ResponseWriter, username string) string {
 	sessionID := uuid.NewV4().String()
 	expirationTime := time.Now().Add(24 * time.Hour)
 
 	if S.sessions == nil {
 		http.Error(Writer, "Session repository is not initialized", http.StatusInternalServerError)
-		return
+		return ""
 	}
-	err := S.sessions.Create(sessionID, username, expirationTime)
+	csrfToken, err := S.sessions.Create(sessionID, username, expirationTime)
 	if err != nil {
 		http.Error(Writer, "Error creating session", http.StatusInternalServerError)
-		return
+		return ""
 	}
 
 	http.SetCookie(Writer, &http.Cookie{
@@ -195,6 +408,7 @@ func (S *Server) MakeToken(Writer http.ResponseWriter, username string) {
 		SameSite: http.SameSiteLaxMode,
 		Secure:   S.config.SecureCookies(),
 	})
+	return csrfToken
 }
 
 // Modified HandleWebSocket function - broadcasts status changes when user connects
@@ -221,6 +435,7 @@ func (S *Server) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 
 	S.hub.Register(client)
+	S.markSeen(client.UserID)
 
 	log.Printf("user %s connected to WebSocket", identity.Nickname)
 
@@ -255,7 +470,7 @@ func (s *Server) receiveMessages(client *Client) {
 func (s *Server) handleWebSocketMessage(client *Client, msg Message) {
 	switch msg.Type {
 	case "typing_indicator":
-		msg.From = client.Username
+		msg.From = client.Nickname()
 		s.sendTypingIndicator(msg)
 	case "chat_message":
 		s.handleChatMessage(client, msg)
@@ -277,6 +492,10 @@ func (s *Server) handleChatMessage(client *Client, msg Message) {
 		log.Printf("failed to persist WebSocket message: %v", err)
 		return
 	}
+	s.pushUnread(storedMessage.ReceiverID, storedMessage.SenderID, storedMessage.From)
+	mentions := s.recordMentions(mention.SourceMessage, int64(storedMessage.ID), storedMessage.SenderID, storedMessage.Content,
+		func(userID int64) bool { return userID == storedMessage.ReceiverID },
+		map[string]interface{}{"from": storedMessage.From})
 	s.broadcastUserStatusChange()
 	s.sendMessageToRecipient(Message{
 		ID:        storedMessage.ID,
@@ -285,13 +504,21 @@ func (s *Server) handleChatMessage(client *Client, msg Message) {
 		Content:   storedMessage.Content,
 		Timestamp: storedMessage.Timestamp,
 		Type:      "chat_message",
-	}, storedMessage.ReceiverID, storedMessage.SenderID)
+		Mentions:  mentions,
+	}, storedMessage.ReceiverID, storedMessage.SenderID, storedMessage.Delivery)
 }
 
-func (s *Server) sendMessageToRecipient(msg Message, recipientID, senderID int64) {
-	for _, recipient := range s.hub.ClientsForUser(recipientID) {
+// sendMessageToRecipient delivers a chat message to every connection of both
+// participants. When the recipient has none, it goes out as a Web Push
+// message instead, unless the conversation is muted or it is quiet hours.
+func (s *Server) sendMessageToRecipient(msg Message, recipientID, senderID int64, delivery notification.Delivery) {
+	recipients := s.hub.ClientsForUser(recipientID)
+	for _, recipient := range recipients {
 		recipient.Enqueue(msg)
 	}
+	if len(recipients) == 0 && delivery.Channel != notification.ChannelOff && !delivery.Quiet {
+		go s.pushOffline(recipientID, msg)
+	}
 	for _, senderClient := range s.hub.ClientsForUser(senderID) {
 		senderClient.Enqueue(msg)
 	}
@@ -319,7 +546,11 @@ func (S *Server) broadcastUserList(currentUserID int64) {
 		if S.hub.IsOnline(conversation.UserID) {
 			status = "online"
 		}
-		users = append(users, UsersListe{Nickname: conversation.Nickname, Status: status})
+		users = append(users, UsersListe{
+			Nickname: conversation.Nickname,
+			Status:   status,
+			Avatar:   avatarURL(conversation.AvatarKey, true),
+		})
 	}
 
 	for _, client := range S.hub.ClientsForUser(currentUserID) {
@@ -332,8 +563,9 @@ func (S *Server) broadcastUserList(currentUserID int64) {
 
 func (s *Server) removeClient(client *Client) {
 	s.hub.Unregister(client)
+	s.markSeen(client.UserID)
 
-	log.Printf("user %s disconnected", client.Username)
+	log.Printf("user %s disconnected", client.Nickname())
 
 	go func() {
 		time.Sleep(100 * time.Millisecond)
@@ -341,6 +573,16 @@ func (s *Server) removeClient(client *Client) {
 	}()
 }
 
+// markSeen records a visit, which starts a new period for the email digest.
+func (S *Server) markSeen(userID int64) {
+	if S.users == nil {
+		return
+	}
+	if err := S.users.MarkSeen(userID, time.Now()); err != nil {
+		log.Printf("failed to record visit of user %d: %v", userID, err)
+	}
+}
+
 func (S *Server) broadcastUserStatusChange() {
 	for _, userID := range S.hub.UserIDs() {
 		S.broadcastUserList(userID)
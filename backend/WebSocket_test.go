@@ -32,10 +32,10 @@ func TestWebSocketMessageIsPersistedAndDeliveredToBothSessions(t *testing.T) {
 	}
 
 	sessions := account.NewSessionRepository(db)
-	if err := sessions.Create("alice-session", "alice", time.Now().Add(time.Hour)); err != nil {
+	if _, err := sessions.Create("alice-session", "alice", time.Now().Add(time.Hour)); err != nil {
 		t.Fatal(err)
 	}
-	if err := sessions.Create("bob-session", "bob", time.Now().Add(time.Hour)); err != nil {
+	if _, err := sessions.Create("bob-session", "bob", time.Now().Add(time.Hour)); err != nil {
 		t.Fatal(err)
 	}
 
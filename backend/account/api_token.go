@@ -0,0 +1,189 @@
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrAPITokenNotFound = errors.New("API token not found")
+	ErrInvalidScope     = errors.New("invalid scope")
+)
+
+const APITokenPrefix = "rtf_"
+
+// Scope limits what an API token can do. Browser sessions are not scoped.
+type Scope string
+
+const (
+	ScopePostsRead         Scope = "posts:read"
+	ScopePostsWrite        Scope = "posts:write"
+	ScopeCommentsWrite     Scope = "comments:write"
+	ScopeChatRead          Scope = "chat:read"
+	ScopeNotificationsRead Scope = "notifications:read"
+)
+
+var validScopes = map[Scope]bool{
+	ScopePostsRead:         true,
+	ScopePostsWrite:        true,
+	ScopeCommentsWrite:     true,
+	ScopeChatRead:          true,
+	ScopeNotificationsRead: true,
+}
+
+// ParseScopes validates the requested scopes and drops duplicates.
+func ParseScopes(values []string) ([]Scope, error) {
+	seen := make(map[Scope]bool, len(values))
+	scopes := make([]Scope, 0, len(values))
+	for _, value := range values {
+		scope := Scope(value)
+		if !validScopes[scope] {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidScope, value)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+	return scopes, nil
+}
+
+type APIToken struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []Scope    `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+type APITokenRepository struct {
+	db *sql.DB
+}
+
+func NewAPITokenRepository(db *sql.DB) *APITokenRepository {
+	return &APITokenRepository{db: db}
+}
+
+// Create stores the hash of a new token and returns the plaintext token. The
+// plaintext is not stored and cannot be shown again.
+func (r *APITokenRepository) Create(userID int64, name string, scopes []Scope, expiresAt *time.Time, now time.Time) (APIToken, string, error) {
+	token, tokenHash, err := GenerateToken(APITokenPrefix)
+	if err != nil {
+		return APIToken{}, "", err
+	}
+	record := APIToken{Name: name, Scopes: scopes, CreatedAt: now.UTC()}
+	var expires sql.NullTime
+	if expiresAt != nil {
+		utc := expiresAt.UTC()
+		record.ExpiresAt = &utc
+		expires = sql.NullTime{Time: utc, Valid: true}
+	}
+	result, err := r.db.Exec(`
+		INSERT INTO api_tokens (user_id, name, token_hash, scopes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, name, tokenHash, joinScopes(scopes), record.CreatedAt, expires)
+	if err != nil {
+		return APIToken{}, "", err
+	}
+	record.ID, err = result.LastInsertId()
+	return record, token, err
+}
+
+func (r *APITokenRepository) List(userID int64) ([]APIToken, error) {
+	rows, err := r.db.Query(`
+		SELECT id, name, scopes, created_at, expires_at, last_used_at, revoked_at
+		FROM api_tokens
+		WHERE user_id = ?
+		ORDER BY created_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		var token APIToken
+		var scopes string
+		var expiresAt, lastUsedAt, revokedAt sql.NullTime
+		if err := rows.Scan(&token.ID, &token.Name, &scopes, &token.CreatedAt, &expiresAt, &lastUsedAt, &revokedAt); err != nil {
+			return nil, err
+		}
+		token.Scopes = splitScopes(scopes)
+		token.ExpiresAt = nullTimePointer(expiresAt)
+		token.LastUsedAt = nullTimePointer(lastUsedAt)
+		token.RevokedAt = nullTimePointer(revokedAt)
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (r *APITokenRepository) Revoke(userID, tokenID int64, now time.Time) error {
+	result, err := r.db.Exec(`
+		UPDATE api_tokens SET revoked_at = ?
+		WHERE id = ? AND user_id = ? AND revoked_at IS NULL`, now.UTC(), tokenID, userID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+// FindValid resolves a bearer token to the identity of its owner and records
+// when it was last used.
+func (r *APITokenRepository) FindValid(token string, now time.Time) (Identity, error) {
+	if !strings.HasPrefix(token, APITokenPrefix) {
+		return Identity{}, ErrAPITokenNotFound
+	}
+	now = now.UTC()
+	var identity Identity
+	err := r.db.QueryRow(`
+		SELECT t.id, t.scopes, u.id, u.nickname, u.role
+		FROM api_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ? AND t.revoked_at IS NULL AND (t.expires_at IS NULL OR t.expires_at > ?)`,
+		HashToken(token), now).Scan(&identity.TokenID, &identity.Scopes, &identity.UserID, &identity.Nickname, &identity.Role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Identity{}, ErrAPITokenNotFound
+	}
+	if err != nil {
+		return Identity{}, err
+	}
+	if _, err := r.db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", now, identity.TokenID); err != nil {
+		return Identity{}, err
+	}
+	return identity, nil
+}
+
+func joinScopes(scopes []Scope) string {
+	values := make([]string, len(scopes))
+	for i, scope := range scopes {
+		values[i] = string(scope)
+	}
+	return strings.Join(values, " ")
+}
+
+func splitScopes(value string) []Scope {
+	fields := strings.Fields(value)
+	scopes := make([]Scope, len(fields))
+	for i, field := range fields {
+		scopes[i] = Scope(field)
+	}
+	return scopes
+}
+
+func nullTimePointer(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Time
+}
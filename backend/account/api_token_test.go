@@ -0,0 +1,96 @@
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func newAPITokenTestRepository(t *testing.T) *APITokenRepository {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:api-token-test?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(`
+		DROP TABLE IF EXISTS api_tokens;
+		DROP TABLE IF EXISTS users;
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			nickname TEXT NOT NULL,
+			role TEXT NOT NULL DEFAULT 'member'
+		);
+		CREATE TABLE api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			scopes TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME,
+			last_used_at DATETIME,
+			revoked_at DATETIME
+		);
+		INSERT INTO users (nickname) VALUES ('alice');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewAPITokenRepository(db)
+}
+
+func TestAPITokenLifecycle(t *testing.T) {
+	repository := newAPITokenTestRepository(t)
+	now := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+	expiresAt := now.Add(24 * time.Hour)
+
+	record, token, err := repository.Create(1, "release bot", []Scope{ScopePostsWrite}, &expiresAt, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := repository.FindValid(token, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.UserID != 1 || identity.TokenID != record.ID || !identity.HasScope(ScopePostsWrite) || identity.HasScope(ScopeChatRead) {
+		t.Fatalf("unexpected identity: %#v", identity)
+	}
+
+	tokens, err := repository.List(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].LastUsedAt == nil {
+		t.Fatalf("last use was not recorded: %#v", tokens)
+	}
+
+	if _, err := repository.FindValid(token, expiresAt.Add(time.Second)); !errors.Is(err, ErrAPITokenNotFound) {
+		t.Fatalf("expired token got %v, want ErrAPITokenNotFound", err)
+	}
+	if err := repository.Revoke(1, record.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repository.FindValid(token, now); !errors.Is(err, ErrAPITokenNotFound) {
+		t.Fatalf("revoked token got %v, want ErrAPITokenNotFound", err)
+	}
+	if err := repository.Revoke(2, record.ID, now); !errors.Is(err, ErrAPITokenNotFound) {
+		t.Fatalf("revoking another user's token got %v, want ErrAPITokenNotFound", err)
+	}
+}
+
+func TestParseScopesRejectsUnknownScopes(t *testing.T) {
+	if _, err := ParseScopes([]string{"posts:read", "admin"}); !errors.Is(err, ErrInvalidScope) {
+		t.Fatalf("got %v, want ErrInvalidScope", err)
+	}
+	if _, err := ParseScopes(nil); !errors.Is(err, ErrInvalidScope) {
+		t.Fatalf("empty scopes got %v, want ErrInvalidScope", err)
+	}
+	scopes, err := ParseScopes([]string{"posts:read", "posts:read"})
+	if err != nil || len(scopes) != 1 {
+		t.Fatalf("got %v, %v", scopes, err)
+	}
+}
@@ -1,13 +1,32 @@
 package account
 
-import "context"
+import (
+	"context"
+	"strings"
+)
 
 // Identity is the authenticated request identity established by session
 // middleware. Feature handlers must use it instead of client-provided fields.
+// TokenID is set instead of SessionID when the request used an API token.
 type Identity struct {
 	UserID    int64
 	Nickname  string
 	SessionID string
+	Role      Role
+	CSRFToken string
+	TokenID   int64
+	Scopes    string
+}
+
+// HasScope reports whether an API token identity was granted the scope.
+// Browser sessions have no scopes and are checked by route instead.
+func (i Identity) HasScope(scope Scope) bool {
+	for _, granted := range strings.Fields(i.Scopes) {
+		if Scope(granted) == scope {
+			return true
+		}
+	}
+	return false
 }
 
 type contextKey struct{}
@@ -0,0 +1,87 @@
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func newMagicLinkTestRepository(t *testing.T, secret string) *MagicLinkRepository {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:magic-link-test?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(`
+		DROP TABLE IF EXISTS magic_links;
+		CREATE TABLE magic_links (
+			token_hash TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			used_at DATETIME
+		);
+		DROP TABLE IF EXISTS users;
+		CREATE TABLE users (id INTEGER PRIMARY KEY, email_verified_at DATETIME);
+		INSERT INTO users (id) VALUES (7);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewMagicLinkRepository(db, []byte(secret))
+}
+
+func TestMagicLinkIsSingleUse(t *testing.T) {
+	repository := newMagicLinkTestRepository(t, "secret")
+	now := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	token, err := repository.Issue(7, now, 15*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, err := repository.Redeem(token, now.Add(time.Minute))
+	if err != nil || userID != 7 {
+		t.Fatalf("Redeem = %d, %v; want 7", userID, err)
+	}
+	var verified bool
+	if err := repository.db.QueryRow("SELECT email_verified_at IS NOT NULL FROM users WHERE id = 7").Scan(&verified); err != nil || !verified {
+		t.Fatalf("email verified = %v (%v), want true after redeeming the link", verified, err)
+	}
+	if _, err := repository.Redeem(token, now.Add(2*time.Minute)); !errors.Is(err, ErrMagicLinkInvalid) {
+		t.Fatalf("second Redeem got %v, want ErrMagicLinkInvalid", err)
+	}
+}
+
+func TestMagicLinkRejectsExpiredAndForgedTokens(t *testing.T) {
+	repository := newMagicLinkTestRepository(t, "secret")
+	now := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	token, err := repository.Issue(7, now, 15*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repository.Redeem(token, now.Add(16*time.Minute)); !errors.Is(err, ErrMagicLinkInvalid) {
+		t.Fatalf("expired token got %v, want ErrMagicLinkInvalid", err)
+	}
+
+	token, err = repository.Issue(7, now, 15*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	extended := parts[0] + "." + "9999999999" + "." + parts[2]
+	if _, err := repository.Redeem(extended, now); !errors.Is(err, ErrMagicLinkInvalid) {
+		t.Fatalf("token with a changed expiry got %v, want ErrMagicLinkInvalid", err)
+	}
+	other := NewMagicLinkRepository(repository.db, []byte("another secret"))
+	if _, err := other.Redeem(token, now); !errors.Is(err, ErrMagicLinkInvalid) {
+		t.Fatalf("token checked with another secret got %v, want ErrMagicLinkInvalid", err)
+	}
+	if _, err := repository.Redeem(token, now); err != nil {
+		t.Fatalf("untouched token got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"time"
+)
+
+var (
+	ErrNicknameUnchanged     = errors.New("nickname is unchanged")
+	ErrNicknameChangeTooSoon = errors.New("nickname was changed too recently")
+)
+
+// NicknameChange is one rename, as shown to the account owner.
+type NicknameChange struct {
+	OldNickname string    `json:"old_nickname"`
+	NewNickname string    `json:"new_nickname"`
+	ChangedAt   time.Time `json:"changed_at"`
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// nicknameTaken reports whether the nickname belongs to another account,
+// either as its current nickname or as one it used before. Old nicknames stay
+// reserved so links and mentions that use them keep pointing at the same
+// person.
+func nicknameTaken(db queryRower, nickname string, exceptUserID int64) (bool, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM users WHERE nickname = ? AND id != ?)
+		     + (SELECT COUNT(*) FROM nickname_history WHERE old_nickname = ? AND user_id != ?)`,
+		nickname, exceptUserID, nickname, exceptUserID).Scan(&count)
+	return count > 0, err
+}
+
+// ChangeNickname renames the user and records the old nickname. A user may
+// rename once per cooldown and may take back one of their own old nicknames.
+// The previous nickname is returned.
+func (r *UserRepository) ChangeNickname(userID int64, nickname string, now time.Time, cooldown time.Duration) (string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var current string
+	var changedAt sql.NullTime
+	err = tx.QueryRow(`
+		SELECT nickname, nickname_changed_at FROM users
+		WHERE id = ? AND deleted_at IS NULL`, userID).Scan(&current, &changedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUserNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("load nickname: %w", err)
+	}
+
+	nickname = html.EscapeString(nickname)
+	if nickname == current {
+		return "", ErrNicknameUnchanged
+	}
+	if changedAt.Valid && now.Before(changedAt.Time.Add(cooldown)) {
+		return "", ErrNicknameChangeTooSoon
+	}
+	taken, err := nicknameTaken(tx, nickname, userID)
+	if err != nil {
+		return "", err
+	}
+	if taken {
+		return "", ErrNicknameTaken
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE users SET nickname = ?, nickname_changed_at = ?
+		WHERE id = ?`, nickname, now.UTC(), userID); err != nil {
+		return "", fmt.Errorf("rename user %d: %w", userID, err)
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO nickname_history (user_id, old_nickname, new_nickname, changed_at)
+		VALUES (?, ?, ?, ?)`, userID, current, nickname, now.UTC()); err != nil {
+		return "", fmt.Errorf("record nickname change: %w", err)
+	}
+	return current, tx.Commit()
+}
+
+// NextNicknameChange returns when the user may rename again, or the zero time
+// if they may rename now.
+func (r *UserRepository) NextNicknameChange(userID int64, now time.Time, cooldown time.Duration) (time.Time, error) {
+	var changedAt sql.NullTime
+	err := r.db.QueryRow("SELECT nickname_changed_at FROM users WHERE id = ?", userID).Scan(&changedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, ErrUserNotFound
+	}
+	if err != nil || !changedAt.Valid {
+		return time.Time{}, err
+	}
+	if next := changedAt.Time.Add(cooldown); now.Before(next) {
+		return next, nil
+	}
+	return time.Time{}, nil
+}
+
+// ResolveNickname finds the account a nickname refers to, following renames.
+// It returns the user's ID and current nickname.
+func (r *UserRepository) ResolveNickname(nickname string) (int64, string, error) {
+	var userID int64
+	var current string
+	err := r.db.QueryRow(`
+		SELECT id, nickname FROM users
+		WHERE id = COALESCE(
+			(SELECT id FROM users WHERE nickname = ?),
+			(SELECT user_id FROM nickname_history WHERE old_nickname = ? ORDER BY changed_at DESC LIMIT 1)
+		) AND deleted_at IS NULL`, nickname, nickname).Scan(&userID, &current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, "", ErrUserNotFound
+	}
+	if err != nil {
+		return 0, "", fmt.Errorf("resolve nickname: %w", err)
+	}
+	return userID, current, nil
+}
+
+func (r *UserRepository) NicknameHistory(userID int64) ([]NicknameChange, error) {
+	rows, err := r.db.Query(`
+		SELECT old_nickname, new_nickname, changed_at FROM nickname_history
+		WHERE user_id = ?
+		ORDER BY changed_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []NicknameChange{}
+	for rows.Next() {
+		var change NicknameChange
+		if err := rows.Scan(&change.OldNickname, &change.NewNickname, &change.ChangedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
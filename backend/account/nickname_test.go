@@ -0,0 +1,88 @@
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func newNicknameTestRepository(t *testing.T) *UserRepository {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "nickname.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(`
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY,
+			nickname TEXT UNIQUE,
+			email TEXT,
+			nickname_changed_at DATETIME,
+			deleted_at DATETIME
+		);
+		CREATE TABLE nickname_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			old_nickname TEXT NOT NULL,
+			new_nickname TEXT NOT NULL,
+			changed_at DATETIME NOT NULL
+		);
+		INSERT INTO users (id, nickname, email) VALUES (1, 'alice', 'alice@example.com'), (2, 'bob', 'bob@example.com');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewUserRepository(db, nil)
+}
+
+func TestChangeNicknameKeepsOldNicknameResolvable(t *testing.T) {
+	repository := newNicknameTestRepository(t)
+	now := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	old, err := repository.ChangeNickname(1, "alicia", now, 30*24*time.Hour)
+	if err != nil || old != "alice" {
+		t.Fatalf("ChangeNickname = %q, %v; want alice", old, err)
+	}
+	userID, current, err := repository.ResolveNickname("alice")
+	if err != nil || userID != 1 || current != "alicia" {
+		t.Fatalf("ResolveNickname(alice) = %d, %q, %v; want 1, alicia", userID, current, err)
+	}
+	history, err := repository.NicknameHistory(1)
+	if err != nil || len(history) != 1 || history[0].OldNickname != "alice" || history[0].NewNickname != "alicia" {
+		t.Fatalf("NicknameHistory = %+v, %v", history, err)
+	}
+}
+
+func TestChangeNicknameEnforcesCooldownAndReservations(t *testing.T) {
+	repository := newNicknameTestRepository(t)
+	now := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+	cooldown := 30 * 24 * time.Hour
+
+	if _, err := repository.ChangeNickname(1, "bob", now, cooldown); !errors.Is(err, ErrNicknameTaken) {
+		t.Fatalf("taking a current nickname got %v, want ErrNicknameTaken", err)
+	}
+	if _, err := repository.ChangeNickname(1, "alicia", now, cooldown); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repository.ChangeNickname(1, "ally", now.Add(time.Hour), cooldown); !errors.Is(err, ErrNicknameChangeTooSoon) {
+		t.Fatalf("second rename got %v, want ErrNicknameChangeTooSoon", err)
+	}
+	next, err := repository.NextNicknameChange(1, now.Add(time.Hour), cooldown)
+	if err != nil || !next.Equal(now.Add(cooldown)) {
+		t.Fatalf("NextNicknameChange = %v, %v; want %v", next, err, now.Add(cooldown))
+	}
+
+	if _, err := repository.ChangeNickname(2, "alice", now, cooldown); !errors.Is(err, ErrNicknameTaken) {
+		t.Fatalf("taking another user's old nickname got %v, want ErrNicknameTaken", err)
+	}
+	if exists, err := repository.Exists("new@example.com", "alice"); err != nil || !exists {
+		t.Fatalf("Exists for a reserved nickname = %v, %v; want true", exists, err)
+	}
+	if _, err := repository.ChangeNickname(1, "alice", now.Add(cooldown), cooldown); err != nil {
+		t.Fatalf("taking back an own old nickname got %v", err)
+	}
+}
@@ -0,0 +1,287 @@
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"html"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNicknameTaken         = errors.New("nickname already in use")
+	ErrLoginStateNotFound    = errors.New("login state not found or expired")
+	ErrPendingSignupNotFound = errors.New("pending signup not found or expired")
+	ErrPendingLinkNotFound   = errors.New("pending link not found or expired")
+)
+
+// LoginState is what the callback needs to finish an authorization request.
+// It is keyed by the hash of the state parameter.
+type LoginState struct {
+	Nonce        string
+	CodeVerifier string
+}
+
+// PendingSignup holds a verified provider identity that has no forum account
+// yet, until the user picks a nickname.
+type PendingSignup struct {
+	Issuer            string `json:"-"`
+	Subject           string `json:"-"`
+	Email             string `json:"email"`
+	FirstName         string `json:"first_name"`
+	LastName          string `json:"last_name"`
+	SuggestedNickname string `json:"suggested_nickname"`
+}
+
+// PendingLink holds a provider identity whose email matches a user that
+// has not verified that email, until the user confirms the link with their
+// password.
+type PendingLink struct {
+	UserID   int64  `json:"-"`
+	Issuer   string `json:"-"`
+	Subject  string `json:"-"`
+	Email    string `json:"email"`
+	Nickname string `json:"nickname"`
+}
+
+// OIDCRepository links provider identities (issuer and subject) to users and
+// stores the short-lived state of the sign-in flow.
+type OIDCRepository struct {
+	db *sql.DB
+}
+
+func NewOIDCRepository(db *sql.DB) *OIDCRepository {
+	return &OIDCRepository{db: db}
+}
+
+func (r *OIDCRepository) SaveLoginState(state string, loginState LoginState, now, expiresAt time.Time) error {
+	if _, err := r.db.Exec("DELETE FROM oidc_login_states WHERE expires_at <= ?", now.UTC()); err != nil {
+		return err
+	}
+	_, err := r.db.Exec(`
+		INSERT INTO oidc_login_states (state_hash, nonce, code_verifier, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		HashToken(state), loginState.Nonce, loginState.CodeVerifier, now.UTC(), expiresAt.UTC())
+	return err
+}
+
+// TakeLoginState returns the state and deletes it, so a callback URL cannot
+// be replayed.
+func (r *OIDCRepository) TakeLoginState(state string, now time.Time) (LoginState, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return LoginState{}, err
+	}
+	defer tx.Rollback()
+
+	stateHash := HashToken(state)
+	var loginState LoginState
+	err = tx.QueryRow(`
+		SELECT nonce, code_verifier FROM oidc_login_states
+		WHERE state_hash = ? AND expires_at > ?`, stateHash, now.UTC()).
+		Scan(&loginState.Nonce, &loginState.CodeVerifier)
+	if errors.Is(err, sql.ErrNoRows) {
+		return LoginState{}, ErrLoginStateNotFound
+	}
+	if err != nil {
+		return LoginState{}, err
+	}
+	if _, err := tx.Exec("DELETE FROM oidc_login_states WHERE state_hash = ?", stateHash); err != nil {
+		return LoginState{}, err
+	}
+	return loginState, tx.Commit()
+}
+
+func (r *OIDCRepository) UserIDByIdentity(issuer, subject string) (int64, error) {
+	var userID int64
+	err := r.db.QueryRow(`
+		SELECT user_id FROM user_identities
+		WHERE issuer = ? AND subject = ?`, issuer, subject).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrUserNotFound
+	}
+	return userID, err
+}
+
+// UserIDByEmail matches case-insensitively against the stored, escaped email
+// and reports whether the user has proved they own it.
+func (r *OIDCRepository) UserIDByEmail(email string) (int64, bool, error) {
+	var userID int64
+	var verifiedAt sql.NullTime
+	err := r.db.QueryRow("SELECT id, email_verified_at FROM users WHERE lower(email) = ?",
+		strings.ToLower(html.EscapeString(email))).Scan(&userID, &verifiedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, ErrUserNotFound
+	}
+	return userID, verifiedAt.Valid, err
+}
+
+func (r *OIDCRepository) Link(userID int64, issuer, subject, email string, now time.Time) error {
+	_, err := r.db.Exec(`
+		INSERT INTO user_identities (user_id, issuer, subject, email, created_at)
+		VALUES (?, ?, ?, ?, ?)`, userID, issuer, subject, email, now.UTC())
+	return err
+}
+
+// SavePendingLink stores the identity and returns the token the browser
+// presents when the user confirms the link.
+func (r *OIDCRepository) SavePendingLink(link PendingLink, now, expiresAt time.Time) (string, error) {
+	token, tokenHash, err := GenerateToken("")
+	if err != nil {
+		return "", err
+	}
+	if _, err := r.db.Exec("DELETE FROM oidc_pending_links WHERE expires_at <= ?", now.UTC()); err != nil {
+		return "", err
+	}
+	_, err = r.db.Exec(`
+		INSERT INTO oidc_pending_links (token_hash, user_id, issuer, subject, email, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		tokenHash, link.UserID, link.Issuer, link.Subject, link.Email, now.UTC(), expiresAt.UTC())
+	return token, err
+}
+
+func (r *OIDCRepository) PendingLink(token string, now time.Time) (PendingLink, error) {
+	var link PendingLink
+	err := r.db.QueryRow(`
+		SELECT oidc_pending_links.user_id, issuer, subject, oidc_pending_links.email, users.nickname
+		FROM oidc_pending_links
+		JOIN users ON users.id = oidc_pending_links.user_id AND users.deleted_at IS NULL
+		WHERE token_hash = ? AND expires_at > ?`, HashToken(token), now.UTC()).
+		Scan(&link.UserID, &link.Issuer, &link.Subject, &link.Email, &link.Nickname)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PendingLink{}, ErrPendingLinkNotFound
+	}
+	return link, err
+}
+
+// CompleteLink links the pending identity to its user and consumes the
+// pending link in one transaction.
+func (r *OIDCRepository) CompleteLink(token string, now time.Time) (int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	tokenHash := HashToken(token)
+	var link PendingLink
+	err = tx.QueryRow(`
+		SELECT user_id, issuer, subject, email FROM oidc_pending_links
+		WHERE token_hash = ? AND expires_at > ?`, tokenHash, now.UTC()).
+		Scan(&link.UserID, &link.Issuer, &link.Subject, &link.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrPendingLinkNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO user_identities (user_id, issuer, subject, email, created_at)
+		VALUES (?, ?, ?, ?, ?)`, link.UserID, link.Issuer, link.Subject, link.Email, now.UTC()); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM oidc_pending_links WHERE token_hash = ?", tokenHash); err != nil {
+		return 0, err
+	}
+	return link.UserID, tx.Commit()
+}
+
+// SavePendingSignup stores the identity and returns the token the browser
+// presents when it completes the signup.
+func (r *OIDCRepository) SavePendingSignup(signup PendingSignup, now, expiresAt time.Time) (string, error) {
+	token, tokenHash, err := GenerateToken("")
+	if err != nil {
+		return "", err
+	}
+	if _, err := r.db.Exec("DELETE FROM oidc_pending_signups WHERE expires_at <= ?", now.UTC()); err != nil {
+		return "", err
+	}
+	_, err = r.db.Exec(`
+		INSERT INTO oidc_pending_signups
+			(token_hash, issuer, subject, email, first_name, last_name, suggested_nickname, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		tokenHash, signup.Issuer, signup.Subject, signup.Email, signup.FirstName, signup.LastName,
+		signup.SuggestedNickname, now.UTC(), expiresAt.UTC())
+	return token, err
+}
+
+func (r *OIDCRepository) PendingSignup(token string, now time.Time) (PendingSignup, error) {
+	var signup PendingSignup
+	err := r.db.QueryRow(`
+		SELECT issuer, subject, email, first_name, last_name, suggested_nickname
+		FROM oidc_pending_signups
+		WHERE token_hash = ? AND expires_at > ?`, HashToken(token), now.UTC()).
+		Scan(&signup.Issuer, &signup.Subject, &signup.Email, &signup.FirstName, &signup.LastName, &signup.SuggestedNickname)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PendingSignup{}, ErrPendingSignupNotFound
+	}
+	return signup, err
+}
+
+// CompleteSignup creates a passwordless user with the chosen nickname, links
+// the provider identity, and consumes the pending signup in one transaction.
+// The provider verified the email, so the user starts out verified.
+func (r *OIDCRepository) CompleteSignup(token, nickname string, now time.Time) (int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	tokenHash := HashToken(token)
+	var signup PendingSignup
+	err = tx.QueryRow(`
+		SELECT issuer, subject, email, first_name, last_name
+		FROM oidc_pending_signups
+		WHERE token_hash = ? AND expires_at > ?`, tokenHash, now.UTC()).
+		Scan(&signup.Issuer, &signup.Subject, &signup.Email, &signup.FirstName, &signup.LastName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrPendingSignupNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	escapedNickname := html.EscapeString(nickname)
+	escapedEmail := html.EscapeString(signup.Email)
+	nicknameInUse, err := nicknameTaken(tx, escapedNickname, 0)
+	if err != nil {
+		return 0, err
+	}
+	if nicknameInUse {
+		return 0, ErrNicknameTaken
+	}
+	var taken int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE lower(email) = ?", strings.ToLower(escapedEmail)).Scan(&taken); err != nil {
+		return 0, err
+	}
+	if taken > 0 {
+		return 0, ErrEmailTaken
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO users (nickname, first_name, last_name, email, email_verified_at, password, age, gender)
+		VALUES (?, ?, ?, ?, ?, '', 0, '')`,
+		escapedNickname,
+		html.EscapeString(signup.FirstName),
+		html.EscapeString(signup.LastName),
+		escapedEmail,
+		now.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	userID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO user_identities (user_id, issuer, subject, email, created_at)
+		VALUES (?, ?, ?, ?, ?)`, userID, signup.Issuer, signup.Subject, signup.Email, now.UTC()); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM oidc_pending_signups WHERE token_hash = ?", tokenHash); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
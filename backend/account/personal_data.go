@@ -0,0 +1,278 @@
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var ErrDeletionNotScheduled = errors.New("account deletion is not scheduled")
+
+// DeletedNickname is the nickname an anonymized account keeps so its posts and
+// comments still have an author. The hyphen cannot appear in a registered
+// nickname, so it never collides with a real account.
+func DeletedNickname(userID int64) string {
+	return "deleted-" + strconv.FormatInt(userID, 10)
+}
+
+// PersonalData is everything the forum stores about one user, as exported to
+// that user.
+type PersonalData struct {
+	ExportedAt time.Time         `json:"exported_at"`
+	Profile    Profile           `json:"profile"`
+	Nicknames  []NicknameChange  `json:"nickname_history"`
+	Posts      []ExportedPost    `json:"posts"`
+	Comments   []ExportedComment `json:"comments"`
+	Messages   []ExportedMessage `json:"messages"`
+	Sessions   []ExportedSession `json:"sessions"`
+}
+
+type ExportedPost struct {
+	ID        int64  `json:"id"`
+	Title     string `json:"title"`
+	Content   string `json:"content"`
+	Category  string `json:"category"`
+	CreatedAt string `json:"created_at"`
+	Hidden    bool   `json:"hidden_by_moderator"`
+}
+
+type ExportedComment struct {
+	ID        int64  `json:"id"`
+	PostID    int64  `json:"post_id"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"created_at"`
+	Hidden    bool   `json:"hidden_by_moderator"`
+}
+
+type ExportedMessage struct {
+	ID        int64  `json:"id"`
+	Direction string `json:"direction"`
+	With      string `json:"with"`
+	Content   string `json:"content"`
+	Timestamp string `json:"timestamp"`
+}
+
+// ExportedSession leaves out the session ID and CSRF token, which are
+// credentials rather than personal data.
+type ExportedSession struct {
+	ExpiresAt time.Time `json:"expires_at"`
+	Current   bool      `json:"current"`
+}
+
+// PersonalDataRepository exports and erases a user's data across the
+// account, forum, and chat tables, which is why it lives with accounts
+// rather than in one feature package.
+type PersonalDataRepository struct {
+	db    *sql.DB
+	users *UserRepository
+}
+
+func NewPersonalDataRepository(db *sql.DB) *PersonalDataRepository {
+	return &PersonalDataRepository{db: db, users: NewUserRepository(db, nil)}
+}
+
+func (r *PersonalDataRepository) Export(userID int64, currentSessionID string, now time.Time) (PersonalData, error) {
+	profile, err := r.users.Profile(userID)
+	if err != nil {
+		return PersonalData{}, err
+	}
+	nicknames, err := r.users.NicknameHistory(userID)
+	if err != nil {
+		return PersonalData{}, fmt.Errorf("export nickname history: %w", err)
+	}
+	data := PersonalData{
+		ExportedAt: now.UTC(),
+		Profile:    profile,
+		Nicknames:  nicknames,
+		Posts:      []ExportedPost{},
+		Comments:   []ExportedComment{},
+		Messages:   []ExportedMessage{},
+		Sessions:   []ExportedSession{},
+	}
+
+	rows, err := r.db.Query(`
+		SELECT id, title, content, category, created_at, hidden_at IS NOT NULL
+		FROM posts WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return PersonalData{}, fmt.Errorf("export posts: %w", err)
+	}
+	for rows.Next() {
+		var post ExportedPost
+		if err := rows.Scan(&post.ID, &post.Title, &post.Content, &post.Category, &post.CreatedAt, &post.Hidden); err != nil {
+			rows.Close()
+			return PersonalData{}, err
+		}
+		data.Posts = append(data.Posts, post)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return PersonalData{}, err
+	}
+
+	rows, err = r.db.Query(`
+		SELECT id, post_id, content, created_at, hidden_at IS NOT NULL
+		FROM comments WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return PersonalData{}, fmt.Errorf("export comments: %w", err)
+	}
+	for rows.Next() {
+		var comment ExportedComment
+		if err := rows.Scan(&comment.ID, &comment.PostID, &comment.Content, &comment.CreatedAt, &comment.Hidden); err != nil {
+			rows.Close()
+			return PersonalData{}, err
+		}
+		data.Comments = append(data.Comments, comment)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return PersonalData{}, err
+	}
+
+	rows, err = r.db.Query(`
+		SELECT messages.id,
+		       CASE WHEN messages.sender_id = ? THEN 'sent' ELSE 'received' END,
+		       COALESCE(other.nickname, ''), messages.content, messages.timestamp
+		FROM messages
+		LEFT JOIN users other ON other.id =
+			CASE WHEN messages.sender_id = ? THEN messages.receiver_id ELSE messages.sender_id END
+		WHERE messages.sender_id = ? OR messages.receiver_id = ?
+		ORDER BY messages.id`, userID, userID, userID, userID)
+	if err != nil {
+		return PersonalData{}, fmt.Errorf("export messages: %w", err)
+	}
+	for rows.Next() {
+		var message ExportedMessage
+		if err := rows.Scan(&message.ID, &message.Direction, &message.With, &message.Content, &message.Timestamp); err != nil {
+			rows.Close()
+			return PersonalData{}, err
+		}
+		data.Messages = append(data.Messages, message)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return PersonalData{}, err
+	}
+
+	rows, err = r.db.Query(`
+		SELECT session_id, expires_at FROM sessions
+		WHERE user_id = ? AND expires_at > ?
+		ORDER BY expires_at DESC`, userID, now.UTC())
+	if err != nil {
+		return PersonalData{}, fmt.Errorf("export sessions: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sessionID string
+		var session ExportedSession
+		if err := rows.Scan(&sessionID, &session.ExpiresAt); err != nil {
+			return PersonalData{}, err
+		}
+		session.Current = sessionID == currentSessionID
+		data.Sessions = append(data.Sessions, session)
+	}
+	return data, rows.Err()
+}
+
+// ScheduleDeletion marks the account for erasure at the given time. Until
+// then the user can still sign in and cancel.
+func (r *PersonalDataRepository) ScheduleDeletion(userID int64, at time.Time) error {
+	result, err := r.db.Exec(`
+		UPDATE users SET deletion_scheduled_at = ?
+		WHERE id = ? AND deleted_at IS NULL`, at.UTC(), userID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *PersonalDataRepository) CancelDeletion(userID int64) error {
+	result, err := r.db.Exec(`
+		UPDATE users SET deletion_scheduled_at = NULL
+		WHERE id = ? AND deletion_scheduled_at IS NOT NULL AND deleted_at IS NULL`, userID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrDeletionNotScheduled
+	}
+	return nil
+}
+
+// DueForDeletion returns the accounts whose grace period has ended.
+func (r *PersonalDataRepository) DueForDeletion(now time.Time) ([]int64, error) {
+	rows, err := r.db.Query(`
+		SELECT id FROM users
+		WHERE deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= ? AND deleted_at IS NULL
+		ORDER BY deletion_scheduled_at`, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+// Erase anonymizes the user row and deletes everything that only concerns
+// the user. The row itself stays, because posts, comments, reports, and the
+// audit log reference it; posts and comments stay under DeletedNickname.
+// Messages go with both sides of each conversation, and old nicknames are
+// released. The previous avatar key is returned so the caller can remove the
+// files.
+func (r *PersonalDataRepository) Erase(userID int64, now time.Time) (string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var avatarKey sql.NullString
+	err = tx.QueryRow("SELECT avatar_key FROM users WHERE id = ? AND deleted_at IS NULL", userID).Scan(&avatarKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUserNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	statements := []string{
+		"DELETE FROM bookmarks WHERE target_type = 'message' AND target_id IN (SELECT id FROM messages WHERE sender_id = ? OR receiver_id = ?)",
+		"DELETE FROM messages WHERE sender_id = ? OR receiver_id = ?",
+		"DELETE FROM notifications WHERE receiver_id = ? OR sender_id = ?",
+		"DELETE FROM user_notifications WHERE user_id = ? OR actor_id = ?",
+		"DELETE FROM mentions WHERE author_id = ? OR mentioned_user_id = ?",
+		"DELETE FROM conversation_mutes WHERE user_id = ? OR other_user_id = ?",
+		"DELETE FROM subscriptions WHERE user_id = ? OR (target_type = 'author' AND target_id = ?)",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, userID, userID); err != nil {
+			return "", fmt.Errorf("erase user %d: %w", userID, err)
+		}
+	}
+	for _, table := range []string{"sessions", "api_tokens", "user_identities", "magic_links", "email_changes", "nickname_history", "notification_preferences", "quiet_hours", "email_digests", "push_subscriptions", "bookmarks", "post_reads", "oidc_pending_links"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE user_id = ?", userID); err != nil {
+			return "", fmt.Errorf("erase user %d from %s: %w", userID, table, err)
+		}
+	}
+	if _, err := tx.Exec(`
+		UPDATE users
+		SET nickname = ?, first_name = '', last_name = '', email = NULL, email_verified_at = NULL, password = '',
+		    age = 0, gender = '', avatar_key = NULL, role = ?,
+		    deletion_scheduled_at = NULL, deleted_at = ?
+		WHERE id = ?`, DeletedNickname(userID), RoleMember, now.UTC(), userID); err != nil {
+		return "", fmt.Errorf("anonymize user %d: %w", userID, err)
+	}
+	return avatarKey.String, tx.Commit()
+}
@@ -0,0 +1,234 @@
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"time"
+)
+
+var (
+	ErrEmailTaken          = errors.New("email already in use")
+	ErrEmailChangeNotFound = errors.New("email change not found or expired")
+)
+
+// Profile is the full set of account fields visible to the account owner.
+type Profile struct {
+	ID        int64  `json:"id"`
+	Nickname  string `json:"nickname"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Age       int    `json:"age"`
+	Gender    string `json:"gender"`
+	JoinedAt  string `json:"joined_at"`
+	AvatarKey string `json:"-"`
+	Avatar    string `json:"avatar_url,omitempty"`
+
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+}
+
+// PublicProfile is the subset of account fields any visitor may see.
+type PublicProfile struct {
+	ID        int64  `json:"id"`
+	Nickname  string `json:"nickname"`
+	JoinedAt  string `json:"joined_at"`
+	AvatarKey string `json:"-"`
+	Avatar    string `json:"avatar_url,omitempty"`
+}
+
+type ProfileUpdate struct {
+	FirstName string
+	LastName  string
+	Age       int
+	Gender    string
+}
+
+func (r *UserRepository) Profile(userID int64) (Profile, error) {
+	var profile Profile
+	var deletionScheduledAt sql.NullTime
+	err := r.db.QueryRow(`
+		SELECT id, nickname, first_name, last_name, email, age, gender, created_at,
+		       COALESCE(avatar_key, ''), deletion_scheduled_at
+		FROM users WHERE id = ? AND deleted_at IS NULL`, userID).Scan(
+		&profile.ID, &profile.Nickname, &profile.FirstName, &profile.LastName,
+		&profile.Email, &profile.Age, &profile.Gender, &profile.JoinedAt, &profile.AvatarKey,
+		&deletionScheduledAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Profile{}, ErrUserNotFound
+	}
+	if err != nil {
+		return Profile{}, fmt.Errorf("load profile: %w", err)
+	}
+	profile.DeletionScheduledAt = nullTimePointer(deletionScheduledAt)
+	return profile, nil
+}
+
+func (r *UserRepository) PublicProfile(userID int64) (PublicProfile, error) {
+	var profile PublicProfile
+	err := r.db.QueryRow(`
+		SELECT id, nickname, created_at, COALESCE(avatar_key, '')
+		FROM users WHERE id = ? AND deleted_at IS NULL`, userID).Scan(&profile.ID, &profile.Nickname, &profile.JoinedAt, &profile.AvatarKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PublicProfile{}, ErrUserNotFound
+	}
+	if err != nil {
+		return PublicProfile{}, fmt.Errorf("load public profile: %w", err)
+	}
+	return profile, nil
+}
+
+func (r *UserRepository) UpdateProfile(userID int64, update ProfileUpdate) error {
+	_, err := r.db.Exec(`
+		UPDATE users
+		SET first_name = ?, last_name = ?, age = ?, gender = ?
+		WHERE id = ?`,
+		html.EscapeString(update.FirstName),
+		html.EscapeString(update.LastName),
+		update.Age,
+		update.Gender,
+		userID,
+	)
+	return err
+}
+
+func (r *UserRepository) PasswordHash(userID int64) (string, error) {
+	var hashedPassword string
+	err := r.db.QueryRow("SELECT password FROM users WHERE id = ?", userID).Scan(&hashedPassword)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUserNotFound
+	}
+	return hashedPassword, err
+}
+
+// UpdatePassword replaces the password and, in the same transaction,
+// revokes the user's personal API tokens, so a leaked token does not survive
+// a password reset. It returns how many tokens were revoked.
+func (r *UserRepository) UpdatePassword(userID int64, password string, now time.Time) (int64, error) {
+	hashedPassword, err := r.hasher.Hash(password)
+	if err != nil {
+		return 0, fmt.Errorf("hash password: %w", err)
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET password = ? WHERE id = ?", hashedPassword, userID); err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec("UPDATE api_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", now.UTC(), userID)
+	if err != nil {
+		return 0, err
+	}
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return revoked, tx.Commit()
+}
+
+// CheckPassword verifies a password against a stored hash. needsRehash
+// reports that the hash predates the current hashing parameters and should
+// be replaced through RehashPassword.
+func (r *UserRepository) CheckPassword(hashedPassword, plain string) (needsRehash bool, err error) {
+	return r.hasher.Verify(hashedPassword, plain)
+}
+
+// CheckDummyPassword takes as long as CheckPassword, for identifiers that
+// match no account.
+func (r *UserRepository) CheckDummyPassword(plain string) {
+	r.hasher.VerifyDummy(plain)
+}
+
+// RehashPassword replaces an outdated hash with one under the current
+// parameters. It only updates the row while it still holds oldHash, so a
+// password changed in the meantime is not overwritten.
+func (r *UserRepository) RehashPassword(userID int64, oldHash, plain string) error {
+	hashedPassword, err := r.hasher.Hash(plain)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+	_, err = r.db.Exec("UPDATE users SET password = ? WHERE id = ? AND password = ?", hashedPassword, userID, oldHash)
+	return err
+}
+
+func (r *UserRepository) EmailTaken(email string, exceptUserID int64) (bool, error) {
+	var count int
+	err := r.db.QueryRow(
+		"SELECT COUNT(*) FROM users WHERE email = ? AND id != ?",
+		html.EscapeString(email), exceptUserID,
+	).Scan(&count)
+	return count > 0, err
+}
+
+// RequestEmailChange stores a pending email change. Any earlier pending
+// change for the same user is replaced.
+func (r *UserRepository) RequestEmailChange(userID int64, newEmail, tokenHash string, expiresAt time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM email_changes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO email_changes (token_hash, user_id, new_email, expires_at)
+		VALUES (?, ?, ?, ?)`, tokenHash, userID, html.EscapeString(newEmail), expiresAt.UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ConfirmEmailChange applies the pending change identified by the token hash
+// and returns the user it belongs to.
+func (r *UserRepository) ConfirmEmailChange(tokenHash string, now time.Time) (int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	var newEmail string
+	err = tx.QueryRow(`
+		SELECT user_id, new_email FROM email_changes
+		WHERE token_hash = ? AND expires_at > ?`, tokenHash, now.UTC()).Scan(&userID, &newEmail)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrEmailChangeNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var taken int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE email = ? AND id != ?", newEmail, userID).Scan(&taken); err != nil {
+		return 0, err
+	}
+	if taken > 0 {
+		return 0, ErrEmailTaken
+	}
+	if _, err := tx.Exec("UPDATE users SET email = ?, email_verified_at = ? WHERE id = ?", newEmail, now.UTC(), userID); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM email_changes WHERE user_id = ?", userID); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
+
+// SetAvatar stores the new avatar key and returns the previous one so the
+// caller can remove the old blobs.
+func (r *UserRepository) SetAvatar(userID int64, avatarKey string) (string, error) {
+	var previous sql.NullString
+	if err := r.db.QueryRow("SELECT avatar_key FROM users WHERE id = ?", userID).Scan(&previous); err != nil {
+		return "", err
+	}
+	_, err := r.db.Exec("UPDATE users SET avatar_key = ? WHERE id = ?", avatarKey, userID)
+	return previous.String, err
+}
@@ -0,0 +1,72 @@
+package account
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidRole = errors.New("invalid role")
+
+type Role string
+
+const (
+	RoleMember    Role = "member"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// Permission names one action that is restricted to some roles. Handlers ask
+// for a permission, never for a role, so the mapping lives in one place.
+type Permission string
+
+const (
+	PermissionDeleteAnyPost    Permission = "posts:delete_any"
+	PermissionDeleteAnyComment Permission = "comments:delete_any"
+	PermissionManageRoles      Permission = "users:manage_roles"
+	PermissionSuspendUsers     Permission = "users:suspend"
+	PermissionReviewReports    Permission = "reports:review"
+	PermissionViewAudit        Permission = "audit:view"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleMember: {},
+	RoleModerator: {
+		PermissionDeleteAnyPost,
+		PermissionDeleteAnyComment,
+		PermissionSuspendUsers,
+		PermissionReviewReports,
+	},
+	RoleAdmin: {
+		PermissionDeleteAnyPost,
+		PermissionDeleteAnyComment,
+		PermissionManageRoles,
+		PermissionSuspendUsers,
+		PermissionReviewReports,
+		PermissionViewAudit,
+	},
+}
+
+var roleRanks = map[Role]int{RoleMember: 0, RoleModerator: 1, RoleAdmin: 2}
+
+func ParseRole(value string) (Role, error) {
+	role := Role(value)
+	if _, ok := rolePermissions[role]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrInvalidRole, value)
+	}
+	return role, nil
+}
+
+func (r Role) Can(permission Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Outranks reports whether r is strictly above other. Moderation actions use
+// it so moderators cannot act on other moderators or admins.
+func (r Role) Outranks(other Role) bool {
+	return roleRanks[r] > roleRanks[other]
+}
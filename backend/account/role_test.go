@@ -0,0 +1,30 @@
+package account
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRolePermissions(t *testing.T) {
+	if RoleMember.Can(PermissionDeleteAnyPost) {
+		t.Fatal("members must not delete other users' posts")
+	}
+	if !RoleModerator.Can(PermissionDeleteAnyPost) || RoleModerator.Can(PermissionManageRoles) {
+		t.Fatal("moderators may delete posts but not manage roles")
+	}
+	if !RoleAdmin.Can(PermissionManageRoles) {
+		t.Fatal("admins must be able to manage roles")
+	}
+	if !RoleAdmin.Outranks(RoleModerator) || RoleModerator.Outranks(RoleModerator) {
+		t.Fatal("unexpected role ranking")
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	if role, err := ParseRole("moderator"); err != nil || role != RoleModerator {
+		t.Fatalf("ParseRole(moderator) = %q, %v", role, err)
+	}
+	if _, err := ParseRole("root"); !errors.Is(err, ErrInvalidRole) {
+		t.Fatalf("ParseRole(root) returned %v, want ErrInvalidRole", err)
+	}
+}
@@ -14,24 +14,32 @@ func NewSessionRepository(db *sql.DB) *SessionRepository {
 	return &SessionRepository{db: db}
 }
 
-func (r *SessionRepository) Create(sessionID, nickname string, expiresAt time.Time) error {
+// Create stores a session with a fresh CSRF token and returns that token.
+func (r *SessionRepository) Create(sessionID, nickname string, expiresAt time.Time) (string, error) {
 	var userID int64
 	if err := r.db.QueryRow("SELECT id FROM users WHERE nickname = ?", nickname).Scan(&userID); err != nil {
-		return err
+		return "", err
 	}
-	_, err := r.db.Exec(`
-		INSERT INTO sessions (session_id, user_id, expires_at)
-		VALUES (?, ?, ?)`, sessionID, userID, expiresAt)
-	return err
+	csrfToken, _, err := GenerateToken("")
+	if err != nil {
+		return "", err
+	}
+	_, err = r.db.Exec(`
+		INSERT INTO sessions (session_id, user_id, expires_at, csrf_token)
+		VALUES (?, ?, ?, ?)`, sessionID, userID, expiresAt, csrfToken)
+	if err != nil {
+		return "", err
+	}
+	return csrfToken, nil
 }
 
 func (r *SessionRepository) FindValid(sessionID string) (Identity, error) {
 	var identity Identity
 	err := r.db.QueryRow(`
-		SELECT s.user_id, u.nickname
+		SELECT s.user_id, u.nickname, u.role, s.csrf_token
 		FROM sessions s
 		JOIN users u ON u.id = s.user_id
-		WHERE s.session_id = ? AND s.expires_at > CURRENT_TIMESTAMP`, sessionID).Scan(&identity.UserID, &identity.Nickname)
+		WHERE s.session_id = ? AND s.expires_at > CURRENT_TIMESTAMP`, sessionID).Scan(&identity.UserID, &identity.Nickname, &identity.Role, &identity.CSRFToken)
 	if err != nil {
 		return Identity{}, fmt.Errorf("find valid session: %w", err)
 	}
@@ -43,3 +51,32 @@ func (r *SessionRepository) Delete(sessionID string) error {
 	_, err := r.db.Exec("DELETE FROM sessions WHERE session_id = ?", sessionID)
 	return err
 }
+
+// DeleteForUserExcept removes every session of the user except keepSessionID
+// and returns the IDs that were removed so live connections can be closed.
+func (r *SessionRepository) DeleteForUserExcept(userID int64, keepSessionID string) ([]string, error) {
+	rows, err := r.db.Query("SELECT session_id FROM sessions WHERE user_id = ? AND session_id != ?", userID, keepSessionID)
+	if err != nil {
+		return nil, err
+	}
+	var sessionIDs []string
+	for rows.Next() {
+		var sessionID string
+		if err := rows.Scan(&sessionID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	_, err = r.db.Exec("DELETE FROM sessions WHERE user_id = ? AND session_id != ?", userID, keepSessionID)
+	return sessionIDs, err
+}
+
+func (r *SessionRepository) DeleteForUser(userID int64) ([]string, error) {
+	return r.DeleteForUserExcept(userID, "")
+}
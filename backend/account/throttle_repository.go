@@ -0,0 +1,162 @@
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	ThrottleScopeAccount        = "account"
+	ThrottleScopeIP             = "ip"
+	ThrottleScopeMagicLinkEmail = "magic_link_email"
+	ThrottleScopeMagicLinkIP    = "magic_link_ip"
+)
+
+// ThrottlePolicy describes how many failures are tolerated before a key is
+// locked and how the lockout grows with every further failure.
+type ThrottlePolicy struct {
+	FreeAttempts int
+	BaseLockout  time.Duration
+	MaxLockout   time.Duration
+	Window       time.Duration
+}
+
+var (
+	LoginAccountPolicy = ThrottlePolicy{
+		FreeAttempts: 5,
+		BaseLockout:  30 * time.Second,
+		MaxLockout:   time.Hour,
+		Window:       24 * time.Hour,
+	}
+	LoginIPPolicy = ThrottlePolicy{
+		FreeAttempts: 20,
+		BaseLockout:  30 * time.Second,
+		MaxLockout:   time.Hour,
+		Window:       24 * time.Hour,
+	}
+	// Magic-link requests count every request, not only failures, so these
+	// limit how much mail one address receives and one client can send.
+	MagicLinkEmailPolicy = ThrottlePolicy{
+		FreeAttempts: 3,
+		BaseLockout:  15 * time.Minute,
+		MaxLockout:   time.Hour,
+		Window:       time.Hour,
+	}
+	MagicLinkIPPolicy = ThrottlePolicy{
+		FreeAttempts: 10,
+		BaseLockout:  15 * time.Minute,
+		MaxLockout:   time.Hour,
+		Window:       time.Hour,
+	}
+)
+
+// LockoutFor returns how long a key stays locked after the given number of
+// consecutive failures. The duration doubles for each failure past the free
+// attempts and is capped at MaxLockout.
+func (p ThrottlePolicy) LockoutFor(failures int) time.Duration {
+	if failures < p.FreeAttempts {
+		return 0
+	}
+	lockout := p.BaseLockout
+	for i := p.FreeAttempts; i < failures; i++ {
+		lockout *= 2
+		if lockout >= p.MaxLockout {
+			return p.MaxLockout
+		}
+	}
+	return lockout
+}
+
+// Lockout is returned when a failure pushes a key into a lockout.
+type Lockout struct {
+	Scope       string
+	Key         string
+	Failures    int
+	LockedUntil time.Time
+}
+
+type ThrottleRepository struct {
+	db *sql.DB
+}
+
+func NewThrottleRepository(db *sql.DB) *ThrottleRepository {
+	return &ThrottleRepository{db: db}
+}
+
+func (r *ThrottleRepository) LockedUntil(scope, key string, now time.Time) (time.Time, error) {
+	var lockedUntil sql.NullTime
+	err := r.db.QueryRow(`
+		SELECT locked_until FROM login_throttle
+		WHERE scope = ? AND throttle_key = ?`, scope, key).Scan(&lockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("load throttle state: %w", err)
+	}
+	if !lockedUntil.Valid || !lockedUntil.Time.After(now) {
+		return time.Time{}, nil
+	}
+	return lockedUntil.Time, nil
+}
+
+// RecordFailure counts a failed attempt for the key. When the failure starts
+// a lockout it is also written to login_lockouts and returned.
+func (r *ThrottleRepository) RecordFailure(scope, key, ip string, policy ThrottlePolicy, now time.Time) (*Lockout, error) {
+	now = now.UTC()
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var failures int
+	var lastFailure time.Time
+	err = tx.QueryRow(`
+		SELECT failures, last_failure_at FROM login_throttle
+		WHERE scope = ? AND throttle_key = ?`, scope, key).Scan(&failures, &lastFailure)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("load throttle state: %w", err)
+	}
+	if err == nil && now.Sub(lastFailure) > policy.Window {
+		failures = 0
+	}
+	failures++
+
+	var lockout *Lockout
+	var lockedUntil sql.NullTime
+	if duration := policy.LockoutFor(failures); duration > 0 {
+		lockout = &Lockout{Scope: scope, Key: key, Failures: failures, LockedUntil: now.Add(duration)}
+		lockedUntil = sql.NullTime{Time: lockout.LockedUntil, Valid: true}
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO login_throttle (scope, throttle_key, failures, last_failure_at, locked_until)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(scope, throttle_key) DO UPDATE SET
+			failures = excluded.failures,
+			last_failure_at = excluded.last_failure_at,
+			locked_until = excluded.locked_until`,
+		scope, key, failures, now, lockedUntil); err != nil {
+		return nil, fmt.Errorf("store throttle state: %w", err)
+	}
+	if lockout != nil {
+		if _, err := tx.Exec(`
+			INSERT INTO login_lockouts (scope, throttle_key, failures, ip, locked_until)
+			VALUES (?, ?, ?, ?, ?)`,
+			scope, key, failures, ip, lockout.LockedUntil); err != nil {
+			return nil, fmt.Errorf("record lockout: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return lockout, nil
+}
+
+func (r *ThrottleRepository) Reset(scope, key string) error {
+	_, err := r.db.Exec("DELETE FROM login_throttle WHERE scope = ? AND throttle_key = ?", scope, key)
+	return err
+}
@@ -0,0 +1,99 @@
+package account
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestThrottlePolicyBacksOffExponentially(t *testing.T) {
+	policy := ThrottlePolicy{FreeAttempts: 3, BaseLockout: time.Minute, MaxLockout: 5 * time.Minute}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 2, want: 0},
+		{failures: 3, want: time.Minute},
+		{failures: 4, want: 2 * time.Minute},
+		{failures: 5, want: 4 * time.Minute},
+		{failures: 6, want: 5 * time.Minute},
+	}
+	for _, test := range tests {
+		if got := policy.LockoutFor(test.failures); got != test.want {
+			t.Fatalf("LockoutFor(%d) = %s, want %s", test.failures, got, test.want)
+		}
+	}
+}
+
+func TestThrottleRepositoryLocksAndResets(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:throttle-test?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE login_throttle (
+			scope TEXT NOT NULL,
+			throttle_key TEXT NOT NULL,
+			failures INTEGER NOT NULL DEFAULT 0,
+			last_failure_at DATETIME NOT NULL,
+			locked_until DATETIME,
+			PRIMARY KEY (scope, throttle_key)
+		);
+		CREATE TABLE login_lockouts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			scope TEXT NOT NULL,
+			throttle_key TEXT NOT NULL,
+			failures INTEGER NOT NULL,
+			ip TEXT,
+			locked_until DATETIME NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repository := NewThrottleRepository(db)
+	policy := ThrottlePolicy{FreeAttempts: 2, BaseLockout: time.Minute, MaxLockout: time.Hour, Window: time.Hour}
+	now := time.Date(2026, 8, 1, 12, 0, 0, 0, time.UTC)
+
+	lockout, err := repository.RecordFailure(ThrottleScopeAccount, "user:1", "127.0.0.1", policy, now)
+	if err != nil || lockout != nil {
+		t.Fatalf("first failure: lockout=%v err=%v, want no lockout", lockout, err)
+	}
+	lockout, err = repository.RecordFailure(ThrottleScopeAccount, "user:1", "127.0.0.1", policy, now)
+	if err != nil || lockout == nil {
+		t.Fatalf("second failure: lockout=%v err=%v, want lockout", lockout, err)
+	}
+	if !lockout.LockedUntil.Equal(now.Add(time.Minute)) {
+		t.Fatalf("locked until %s, want %s", lockout.LockedUntil, now.Add(time.Minute))
+	}
+
+	lockedUntil, err := repository.LockedUntil(ThrottleScopeAccount, "user:1", now.Add(30*time.Second))
+	if err != nil || lockedUntil.IsZero() {
+		t.Fatalf("LockedUntil during lockout = %v, %v", lockedUntil, err)
+	}
+	lockedUntil, err = repository.LockedUntil(ThrottleScopeAccount, "user:1", now.Add(2*time.Minute))
+	if err != nil || !lockedUntil.IsZero() {
+		t.Fatalf("LockedUntil after lockout = %v, %v, want zero", lockedUntil, err)
+	}
+
+	var audited int
+	if err := db.QueryRow("SELECT COUNT(*) FROM login_lockouts WHERE throttle_key = 'user:1'").Scan(&audited); err != nil {
+		t.Fatal(err)
+	}
+	if audited != 1 {
+		t.Fatalf("got %d lockout records, want 1", audited)
+	}
+
+	if err := repository.Reset(ThrottleScopeAccount, "user:1"); err != nil {
+		t.Fatal(err)
+	}
+	lockout, err = repository.RecordFailure(ThrottleScopeAccount, "user:1", "127.0.0.1", policy, now)
+	if err != nil || lockout != nil {
+		t.Fatalf("failure after reset: lockout=%v err=%v, want no lockout", lockout, err)
+	}
+}
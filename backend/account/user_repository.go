@@ -5,8 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"html"
+	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"real-time-forum/backend/password"
 )
 
 var ErrUserNotFound = errors.New("user not found")
@@ -21,12 +22,25 @@ type UserRecord struct {
 	Gender    string
 }
 
+// Credentials is what the login flow needs to verify a password.
+type Credentials struct {
+	UserID       int64
+	Nickname     string
+	PasswordHash string
+}
+
 type UserRepository struct {
-	db *sql.DB
+	db     *sql.DB
+	hasher *password.Hasher
 }
 
-func NewUserRepository(db *sql.DB) *UserRepository {
-	return &UserRepository{db: db}
+// NewUserRepository hashes new passwords with the given hasher, or with
+// password.DefaultParams when it is nil.
+func NewUserRepository(db *sql.DB, hasher *password.Hasher) *UserRepository {
+	if hasher == nil {
+		hasher = password.NewHasher(password.DefaultParams())
+	}
+	return &UserRepository{db: db, hasher: hasher}
 }
 
 func (r *UserRepository) Exists(email, nickname string) (bool, error) {
@@ -36,11 +50,14 @@ func (r *UserRepository) Exists(email, nickname string) (bool, error) {
 		email,
 		nickname,
 	).Scan(&count)
-	return count > 0, err
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+	return nicknameTaken(r.db, nickname, 0)
 }
 
 func (r *UserRepository) Create(user UserRecord) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	hashedPassword, err := r.hasher.Hash(user.Password)
 	if err != nil {
 		return fmt.Errorf("hash password: %w", err)
 	}
@@ -52,7 +69,7 @@ func (r *UserRepository) Create(user UserRecord) error {
 		html.EscapeString(user.FirstName),
 		html.EscapeString(user.LastName),
 		html.EscapeString(user.Email),
-		string(hashedPassword),
+		hashedPassword,
 		user.Age,
 		user.Gender,
 	)
@@ -60,16 +77,62 @@ func (r *UserRepository) Create(user UserRecord) error {
 }
 
 func (r *UserRepository) PasswordByIdentifier(identifier string) (string, string, error) {
-	var hashedPassword, nickname string
+	credentials, err := r.CredentialsByIdentifier(identifier)
+	if err != nil {
+		return "", "", err
+	}
+	return credentials.PasswordHash, credentials.Nickname, nil
+}
+
+func (r *UserRepository) CredentialsByIdentifier(identifier string) (Credentials, error) {
+	var credentials Credentials
 	err := r.db.QueryRow(`
-		SELECT password, nickname FROM users
+		SELECT id, password, nickname FROM users
 		WHERE nickname = ? OR email = ?`, identifier, identifier).
-		Scan(&hashedPassword, &nickname)
+		Scan(&credentials.UserID, &credentials.PasswordHash, &credentials.Nickname)
 	if errors.Is(err, sql.ErrNoRows) {
-		return "", "", ErrUserNotFound
+		return Credentials{}, ErrUserNotFound
 	}
 	if err != nil {
-		return "", "", fmt.Errorf("find user credentials: %w", err)
+		return Credentials{}, fmt.Errorf("find user credentials: %w", err)
+	}
+	return credentials, nil
+}
+
+func (r *UserRepository) Role(userID int64) (Role, error) {
+	var role Role
+	err := r.db.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUserNotFound
 	}
-	return hashedPassword, nickname, nil
+	return role, err
+}
+
+func (r *UserRepository) SetRole(userID int64, role Role) error {
+	result, err := r.db.Exec("UPDATE users SET role = ? WHERE id = ?", role, userID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) SetRoleByNickname(nickname string, role Role) error {
+	result, err := r.db.Exec("UPDATE users SET role = ? WHERE nickname = ?", role, nickname)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// MarkSeen records that the user had the app open at now. The email digest
+// only covers activity since the last visit.
+func (r *UserRepository) MarkSeen(userID int64, now time.Time) error {
+	_, err := r.db.Exec("UPDATE users SET last_seen_at = ? WHERE id = ?", now.UTC(), userID)
+	return err
 }
@@ -29,7 +29,7 @@ func TestUserRepositoryCreatesAndFindsCredentials(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	repository := NewUserRepository(db)
+	repository := NewUserRepository(db, nil)
 	user := UserRecord{
 		Nickname: "alice", FirstName: "Alice", LastName: "Example",
 		Email: "alice@example.com", Password: "Password1", Age: 30, Gender: "female",
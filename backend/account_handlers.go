@@ -0,0 +1,236 @@
+package backend
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/audit"
+	"real-time-forum/backend/mail"
+)
+
+const (
+	accountDeletionGrace = 30 * 24 * time.Hour
+	accountPurgeInterval = time.Hour
+)
+
+// ExportAccountHandler returns the user's personal data as one JSON document,
+// or with format=zip as an archive with one JSON file per section.
+func (S *Server) ExportAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "zip" {
+		http.Error(w, "Format must be json or zip", http.StatusBadRequest)
+		return
+	}
+	if S.personalData == nil {
+		http.Error(w, "Account repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	data, err := S.personalData.Export(identity.UserID, identity.SessionID, now)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	data.Profile.Avatar = avatarURL(data.Profile.AvatarKey, false)
+	filename := fmt.Sprintf("forum-export-%s-%s", data.Profile.Nickname, now.UTC().Format("20060102"))
+
+	if format != "zip" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(data)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.zip"`)
+	archive := zip.NewWriter(w)
+	for _, file := range []struct {
+		name  string
+		value interface{}
+	}{
+		{name: "profile.json", value: data.Profile},
+		{name: "nickname_history.json", value: data.Nicknames},
+		{name: "posts.json", value: data.Posts},
+		{name: "comments.json", value: data.Comments},
+		{name: "messages.json", value: data.Messages},
+		{name: "sessions.json", value: data.Sessions},
+	} {
+		writer, err := archive.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			log.Printf("failed to write export for user %d: %v", identity.UserID, err)
+			return
+		}
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.value); err != nil {
+			log.Printf("failed to write export for user %d: %v", identity.UserID, err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("failed to write export for user %d: %v", identity.UserID, err)
+	}
+}
+
+// DeleteAccountHandler schedules the account for erasure after a grace
+// period. Accounts with a password must confirm it; passwordless accounts
+// (single sign-on or magic link only) confirm their nickname instead.
+func (S *Server) DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var request struct {
+		Password        string `json:"password"`
+		ConfirmNickname string `json:"confirm_nickname"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if S.users == nil || S.personalData == nil {
+		http.Error(w, "Account repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	hashedPassword, err := S.users.PasswordHash(identity.UserID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if hashedPassword != "" {
+		if !S.verifyCurrentPassword(w, r, identity.UserID, request.Password) {
+			return
+		}
+	} else if request.ConfirmNickname != identity.Nickname {
+		http.Error(w, "Type your nickname to confirm", http.StatusForbidden)
+		return
+	}
+
+	deleteAt := time.Now().Add(accountDeletionGrace).UTC()
+	if err := S.personalData.ScheduleDeletion(identity.UserID, deleteAt); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	event := actorEvent(identity, audit.ActionDeletionScheduled)
+	event.TargetType, event.TargetID = audit.TargetUser, identity.UserID
+	event.Details = "delete_at=" + deleteAt.Format(time.RFC3339)
+	S.recordAudit(r, event)
+
+	if profile, err := S.users.Profile(identity.UserID); err == nil && profile.Email != "" && S.mailer != nil {
+		err := S.mailer.Send(mail.Message{
+			To:      profile.Email,
+			Subject: "Your forum account will be deleted",
+			Text: "Hi " + identity.Nickname + ",\n\n" +
+				"Your account and personal data will be deleted on " + deleteAt.Format("2 January 2006") + ".\n" +
+				"Sign in and cancel the deletion from your profile before then if you change your mind.\n",
+		})
+		if err != nil {
+			log.Printf("failed to send deletion notice: %v", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":                "scheduled",
+		"deletion_scheduled_at": deleteAt.Format(time.RFC3339),
+	})
+}
+
+func (S *Server) CancelAccountDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if S.personalData == nil {
+		http.Error(w, "Account repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	err := S.personalData.CancelDeletion(identity.UserID)
+	if errors.Is(err, account.ErrDeletionNotScheduled) {
+		http.Error(w, "Account deletion is not scheduled", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	event := actorEvent(identity, audit.ActionDeletionCancelled)
+	event.TargetType, event.TargetID = audit.TargetUser, identity.UserID
+	S.recordAudit(r, event)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// runAccountPurge erases accounts whose grace period has ended, once at
+// startup and then every accountPurgeInterval until stop is closed.
+func (S *Server) runAccountPurge(stop <-chan struct{}) {
+	ticker := time.NewTicker(accountPurgeInterval)
+	defer ticker.Stop()
+	for {
+		S.purgeDeletedAccounts(time.Now())
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (S *Server) purgeDeletedAccounts(now time.Time) {
+	if S.personalData == nil {
+		return
+	}
+	userIDs, err := S.personalData.DueForDeletion(now)
+	if err != nil {
+		log.Printf("failed to list accounts due for deletion: %v", err)
+		return
+	}
+	for _, userID := range userIDs {
+		S.revokeUserSessions(userID, "Your account has been deleted.")
+		avatarKey, err := S.personalData.Erase(userID, now)
+		if err != nil {
+			log.Printf("failed to erase account %d: %v", userID, err)
+			continue
+		}
+		if avatarKey != "" && S.blobs != nil {
+			S.deleteAvatarBlobs(avatarKey)
+		}
+		S.recordAudit(nil, audit.Event{
+			Action:     audit.ActionAccountDeleted,
+			TargetType: audit.TargetUser,
+			TargetID:   userID,
+		})
+	}
+}
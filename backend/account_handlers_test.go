@@ -0,0 +1,172 @@
+package backend
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/forum"
+)
+
+func newAccountTestServer(t *testing.T) *Server {
+	t.Helper()
+	server, _ := newProfileTestServer(t)
+	server.personalData = account.NewPersonalDataRepository(server.db)
+	if err := server.users.Create(account.UserRecord{
+		Nickname: "bob", FirstName: "Bob", LastName: "Example",
+		Email: "bob@example.com", Password: "Password1", Age: 30, Gender: "male",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.forum.CreateComment(1, 0, 1, "Replying to myself"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.db.Exec(`
+		INSERT INTO messages (sender_id, receiver_id, content, timestamp) VALUES (1, 2, 'Hi Bob', ?), (2, 1, 'Hi Alice', ?);
+		INSERT INTO notifications (receiver_id, sender_id, unread_messages) VALUES (1, 2, 1), (2, 1, 1);`,
+		time.Now().UTC().Format(time.RFC3339), time.Now().UTC().Format(time.RFC3339)); err != nil {
+		t.Fatal(err)
+	}
+	for _, nickname := range []string{"alice", "bob"} {
+		if _, err := server.sessions.Create(nickname+"-session", nickname, time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return server
+}
+
+func TestExportAccountReturnsPersonalData(t *testing.T) {
+	server := newAccountTestServer(t)
+	handler := server.SessionMiddleware(http.HandlerFunc(server.ExportAccountHandler))
+
+	request := httptest.NewRequest(http.MethodGet, "/account/export", nil)
+	withSession(t, server, request, "alice-session")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", recorder.Code, recorder.Body.String())
+	}
+	var data account.PersonalData
+	if err := json.NewDecoder(recorder.Body).Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Profile.Email != "alice@example.com" || len(data.Posts) != 1 || len(data.Comments) != 1 {
+		t.Fatalf("unexpected export: %#v", data)
+	}
+	if len(data.Messages) != 2 || data.Messages[0].Direction != "sent" || data.Messages[0].With != "bob" || data.Messages[1].Direction != "received" {
+		t.Fatalf("unexpected messages: %#v", data.Messages)
+	}
+	if len(data.Sessions) != 1 || !data.Sessions[0].Current {
+		t.Fatalf("unexpected sessions: %#v", data.Sessions)
+	}
+	if strings.Contains(recorder.Body.String(), "alice-session") {
+		t.Fatal("export contains the session ID")
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/account/export?format=zip", nil)
+	withSession(t, server, request, "alice-session")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("got content type %q", recorder.Header().Get("Content-Type"))
+	}
+	archive, err := zip.NewReader(bytes.NewReader(recorder.Body.Bytes()), int64(recorder.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range archive.File {
+		names = append(names, file.Name)
+	}
+	if strings.Join(names, ",") != "profile.json,nickname_history.json,posts.json,comments.json,messages.json,sessions.json" {
+		t.Fatalf("unexpected archive files: %v", names)
+	}
+}
+
+func TestAccountDeletionCanBeCancelledDuringGracePeriod(t *testing.T) {
+	server := newAccountTestServer(t)
+	post := func(handler http.HandlerFunc, body string) int {
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		withSession(t, server, request, "alice-session")
+		recorder := httptest.NewRecorder()
+		server.SessionMiddleware(handler).ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	if code := post(server.DeleteAccountHandler, `{"password":"wrong"}`); code != http.StatusForbidden {
+		t.Fatalf("wrong password got %d, want 403", code)
+	}
+	if code := post(server.DeleteAccountHandler, `{"password":"Password1"}`); code != http.StatusAccepted {
+		t.Fatalf("got status %d, want 202", code)
+	}
+	profile, err := server.users.Profile(1)
+	if err != nil || profile.DeletionScheduledAt == nil || profile.DeletionScheduledAt.Before(time.Now().Add(29*24*time.Hour)) {
+		t.Fatalf("deletion was not scheduled after the grace period: %#v (%v)", profile, err)
+	}
+
+	if code := post(server.CancelAccountDeletionHandler, ""); code != http.StatusOK {
+		t.Fatalf("cancel got %d, want 200", code)
+	}
+	if code := post(server.CancelAccountDeletionHandler, ""); code != http.StatusConflict {
+		t.Fatalf("second cancel got %d, want 409", code)
+	}
+	server.purgeDeletedAccounts(time.Now().Add(31 * 24 * time.Hour))
+	if _, err := server.users.Profile(1); err != nil {
+		t.Fatalf("cancelled account was erased: %v", err)
+	}
+}
+
+func TestPurgeAnonymizesContentAndRemovesPrivateData(t *testing.T) {
+	server := newAccountTestServer(t)
+	client := &Client{ID: "alice-client", UserID: 1, Username: "alice", SessionID: "alice-session", Send: make(chan interface{}, 4)}
+	server.hub.Register(client)
+
+	if err := server.personalData.ScheduleDeletion(1, time.Now().Add(accountDeletionGrace)); err != nil {
+		t.Fatal(err)
+	}
+	server.purgeDeletedAccounts(time.Now().Add(time.Hour))
+	if _, err := server.users.Profile(1); err != nil {
+		t.Fatal("account was erased before its grace period ended")
+	}
+	server.purgeDeletedAccounts(time.Now().Add(accountDeletionGrace + time.Hour))
+
+	if server.hub.IsOnline(1) {
+		t.Fatal("the deleted user's WebSocket client is still registered")
+	}
+	if _, err := server.sessions.FindValid("alice-session"); err == nil {
+		t.Fatal("the deleted user's session is still valid")
+	}
+	if _, err := server.sessions.FindValid("bob-session"); err != nil {
+		t.Fatalf("another user's session was removed: %v", err)
+	}
+	if _, err := server.users.PublicProfile(1); err == nil {
+		t.Fatal("the deleted user's public profile is still visible")
+	}
+	if _, err := server.users.CredentialsByIdentifier("alice@example.com"); err == nil {
+		t.Fatal("the deleted user can still be found by email")
+	}
+
+	posts, err := server.forum.ListPosts(forum.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 1 || posts[0].Author != account.DeletedNickname(1) || posts[0].Content != "First post content" {
+		t.Fatalf("post was not kept under the anonymous author: %#v", posts)
+	}
+	var messages, notifications int
+	if err := server.db.QueryRow("SELECT COUNT(*) FROM messages").Scan(&messages); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.db.QueryRow("SELECT COUNT(*) FROM notifications").Scan(&notifications); err != nil {
+		t.Fatal(err)
+	}
+	if messages != 0 || notifications != 0 {
+		t.Fatalf("got %d messages and %d notifications, want none", messages, notifications)
+	}
+}
@@ -0,0 +1,73 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/audit"
+)
+
+func (S *Server) SetUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		UserID int64  `json:"user_id"`
+		Role   string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	role, err := account.ParseRole(request.Role)
+	if err != nil {
+		http.Error(w, "Invalid role: must be member, moderator, or admin", http.StatusBadRequest)
+		return
+	}
+	if request.UserID == identity.UserID {
+		http.Error(w, "You cannot change your own role", http.StatusBadRequest)
+		return
+	}
+	if S.users == nil {
+		http.Error(w, "Account repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	previousRole, err := S.users.Role(request.UserID)
+	if errors.Is(err, account.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	err = S.users.SetRole(request.UserID, role)
+	if errors.Is(err, account.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	event := actorEvent(identity, audit.ActionRoleChanged)
+	event.TargetType, event.TargetID = audit.TargetUser, request.UserID
+	event.Details = "from=" + string(previousRole) + " to=" + string(role)
+	S.recordAudit(r, event)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_id": request.UserID,
+		"role":    role,
+	})
+}
@@ -0,0 +1,144 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/audit"
+)
+
+const maxAPITokenDays = 365
+
+type createAPITokenResponse struct {
+	account.APIToken
+	Token string `json:"token"`
+}
+
+func (S *Server) ListAPITokensHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if S.apiTokens == nil {
+		http.Error(w, "API token repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+	tokens, err := S.apiTokens.List(identity.UserID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// CreateAPITokenHandler returns the plaintext token once. Only its hash is
+// stored, so a lost token has to be revoked and replaced.
+func (S *Server) CreateAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	request.Name = strings.TrimSpace(request.Name)
+	if !isValidTextLength(request.Name, 1, 50) {
+		http.Error(w, "Name must be 1-50 characters", http.StatusBadRequest)
+		return
+	}
+	scopes, err := account.ParseScopes(request.Scopes)
+	if err != nil {
+		http.Error(w, "Invalid scopes: use posts:read, posts:write, comments:write, chat:read, or notifications:read", http.StatusBadRequest)
+		return
+	}
+	if request.ExpiresInDays < 0 || request.ExpiresInDays > maxAPITokenDays {
+		http.Error(w, "Expiry must be 0 (never) to 365 days", http.StatusBadRequest)
+		return
+	}
+	if S.apiTokens == nil {
+		http.Error(w, "API token repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	var expiresAt *time.Time
+	if request.ExpiresInDays > 0 {
+		expires := now.AddDate(0, 0, request.ExpiresInDays)
+		expiresAt = &expires
+	}
+	record, token, err := S.apiTokens.Create(identity.UserID, request.Name, scopes, expiresAt, now)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	event := actorEvent(identity, audit.ActionTokenCreated)
+	event.TargetType, event.TargetID = audit.TargetToken, record.ID
+	event.Details = "name=" + record.Name + " scopes=" + strings.Join(request.Scopes, ",")
+	S.recordAudit(r, event)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createAPITokenResponse{APIToken: record, Token: token})
+}
+
+func (S *Server) RevokeAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		TokenID int64 `json:"token_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if S.apiTokens == nil {
+		http.Error(w, "API token repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	err := S.apiTokens.Revoke(identity.UserID, request.TokenID, time.Now())
+	if errors.Is(err, account.ErrAPITokenNotFound) {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	event := actorEvent(identity, audit.ActionTokenRevoked)
+	event.TargetType, event.TargetID = audit.TargetToken, request.TokenID
+	S.recordAudit(r, event)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
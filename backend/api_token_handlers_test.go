@@ -0,0 +1,96 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/audit"
+)
+
+func TestAPITokenAuthenticatesScopedRoutesOnly(t *testing.T) {
+	server, _ := newProfileTestServer(t)
+	server.apiTokens = account.NewAPITokenRepository(server.db)
+	if _, err := server.sessions.Create("alice-session", "alice", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/tokens/create",
+		strings.NewReader(`{"name":"release notes","scopes":["posts:write"],"expires_in_days":30}`))
+	withSession(t, server, request, "alice-session")
+	recorder := httptest.NewRecorder()
+	server.SessionMiddleware(http.HandlerFunc(server.CreateAPITokenHandler)).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want 201: %s", recorder.Code, recorder.Body.String())
+	}
+	var created createAPITokenResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(created.Token, account.APITokenPrefix) {
+		t.Fatalf("unexpected token %q", created.Token)
+	}
+
+	serve := func(handler http.Handler, method, body string) int {
+		request := httptest.NewRequest(method, "/", strings.NewReader(body))
+		request.Header.Set("Authorization", "Bearer "+created.Token)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	createPost := server.WithScope(account.ScopePostsWrite, server.CreatePostHandler)
+	if code := serve(createPost, http.MethodPost, `{"title":"v1.2.0","content":"Release notes","category":"general"}`); code != http.StatusCreated {
+		t.Fatalf("creating a post with posts:write got %d, want 201", code)
+	}
+	if code := serve(server.WithScope(account.ScopeChatRead, server.GetMessagesHandler), http.MethodPost, ""); code != http.StatusForbidden {
+		t.Fatalf("chat:read route got %d, want 403", code)
+	}
+	if code := serve(server.SessionMiddleware(http.HandlerFunc(server.ListAPITokensHandler)), http.MethodGet, ""); code != http.StatusForbidden {
+		t.Fatalf("session-only route got %d, want 403", code)
+	}
+
+	if err := server.apiTokens.Revoke(1, created.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if code := serve(createPost, http.MethodPost, `{"title":"v1.2.1","content":"More notes","category":"general"}`); code != http.StatusUnauthorized {
+		t.Fatalf("revoked token got %d, want 401", code)
+	}
+}
+
+func TestPasswordChangeRevokesAPITokens(t *testing.T) {
+	server, _ := newProfileTestServer(t)
+	server.apiTokens = account.NewAPITokenRepository(server.db)
+	server.audit = audit.NewRepository(server.db)
+	if _, err := server.sessions.Create("alice-session", "alice", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	_, token, err := server.apiTokens.Create(1, "backup script", []account.Scope{account.ScopePostsRead}, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/profile/password", strings.NewReader(
+		`{"current_password":"Password1","new_password":"Tidal-Cobalt-Lantern7"}`))
+	withSession(t, server, request, "alice-session")
+	recorder := httptest.NewRecorder()
+	server.SessionMiddleware(http.HandlerFunc(server.ChangePasswordHandler)).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", recorder.Code, recorder.Body.String())
+	}
+
+	if _, err := server.apiTokens.FindValid(token, time.Now()); err == nil {
+		t.Fatal("the API token still works after the password change")
+	}
+	events, err := server.audit.List(audit.Filter{Action: audit.ActionTokenRevoked})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Details != "count=1 reason=password_change" {
+		t.Fatalf("audit events = %+v, want one revocation of 1 token", events)
+	}
+}
@@ -0,0 +1,199 @@
+package audit
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidQuery = errors.New("invalid audit filter")
+
+const (
+	ActionLogin             = "auth.login"
+	ActionLoginFailed       = "auth.login_failed"
+	ActionLoginLocked       = "auth.login_locked"
+	ActionLogout            = "auth.logout"
+	ActionMagicLinkSent     = "auth.magic_link_sent"
+	ActionSessionsRevoked   = "auth.sessions_revoked"
+	ActionTokenCreated      = "auth.api_token_created"
+	ActionTokenRevoked      = "auth.api_token_revoked"
+	ActionRegister          = "account.register"
+	ActionRoleChanged       = "account.role_changed"
+	ActionNicknameChanged   = "account.nickname_changed"
+	ActionDeletionScheduled = "account.deletion_scheduled"
+	ActionDeletionCancelled = "account.deletion_cancelled"
+	ActionAccountDeleted    = "account.deleted"
+	ActionPostDeleted       = "forum.post_deleted"
+	ActionCommentDeleted    = "forum.comment_deleted"
+	ActionUserSuspended     = "moderation.user_suspended"
+	ActionUserUnsuspended   = "moderation.user_unsuspended"
+	ActionReportResolved    = "moderation.report_resolved"
+
+	TargetUser    = "user"
+	TargetPost    = "post"
+	TargetComment = "comment"
+	TargetReport  = "report"
+	TargetToken   = "api_token"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// Event is one audit record. ActorID and TargetID are zero when there is no
+// known actor or target, for example a failed login for an unknown nickname.
+type Event struct {
+	ID            int64     `json:"id"`
+	Action        string    `json:"action"`
+	ActorID       int64     `json:"actor_id,omitempty"`
+	ActorNickname string    `json:"actor_nickname,omitempty"`
+	TargetType    string    `json:"target_type,omitempty"`
+	TargetID      int64     `json:"target_id,omitempty"`
+	IP            string    `json:"ip_address,omitempty"`
+	Details       string    `json:"details,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type Filter struct {
+	Action     string
+	ActorID    int64
+	TargetType string
+	TargetID   int64
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+	Offset     int
+}
+
+// Repository only inserts and reads. The audit_events table also rejects
+// UPDATE and DELETE with triggers, so rows cannot be rewritten through SQL.
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+func (r *Repository) Record(event Event) error {
+	if event.Action == "" {
+		return errors.New("audit event requires an action")
+	}
+	_, err := r.db.Exec(`
+		INSERT INTO audit_events (action, actor_id, actor_nickname, target_type, target_id, ip_address, details, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		event.Action, nullID(event.ActorID), event.ActorNickname, event.TargetType, nullID(event.TargetID),
+		event.IP, event.Details, event.CreatedAt.UTC())
+	return err
+}
+
+// List returns events newest first. Zero filter fields match everything.
+func (r *Repository) List(filter Filter) ([]Event, error) {
+	if filter.ActorID < 0 || filter.TargetID < 0 || filter.Offset < 0 {
+		return nil, ErrInvalidQuery
+	}
+	if !filter.Since.IsZero() && !filter.Until.IsZero() && filter.Until.Before(filter.Since) {
+		return nil, ErrInvalidQuery
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultListLimit
+	}
+	if filter.Limit > maxListLimit {
+		filter.Limit = maxListLimit
+	}
+
+	rows, err := r.db.Query(`
+		SELECT id, action, COALESCE(actor_id, 0), actor_nickname, target_type, COALESCE(target_id, 0),
+		       ip_address, details, created_at
+		FROM audit_events
+		WHERE (? = '' OR action = ?)
+		  AND (? = 0 OR actor_id = ?)
+		  AND (? = '' OR target_type = ?)
+		  AND (? = 0 OR target_id = ?)
+		  AND (? IS NULL OR created_at >= ?)
+		  AND (? IS NULL OR created_at < ?)
+		ORDER BY created_at DESC, id DESC
+		LIMIT ? OFFSET ?`,
+		filter.Action, filter.Action,
+		filter.ActorID, filter.ActorID,
+		filter.TargetType, filter.TargetType,
+		filter.TargetID, filter.TargetID,
+		nullTime(filter.Since), nullTime(filter.Since),
+		nullTime(filter.Until), nullTime(filter.Until),
+		filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var event Event
+		if err := rows.Scan(
+			&event.ID, &event.Action, &event.ActorID, &event.ActorNickname, &event.TargetType, &event.TargetID,
+			&event.IP, &event.Details, &event.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+var csvHeader = []string{
+	"id", "created_at", "action", "actor_id", "actor_nickname",
+	"target_type", "target_id", "ip_address", "details",
+}
+
+// WriteCSV writes events with a header row. Text cells that a spreadsheet
+// would evaluate as a formula are prefixed with a quote.
+func WriteCSV(w io.Writer, events []Event) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, event := range events {
+		if err := writer.Write([]string{
+			strconv.FormatInt(event.ID, 10),
+			event.CreatedAt.UTC().Format(time.RFC3339),
+			event.Action,
+			formatID(event.ActorID),
+			csvText(event.ActorNickname),
+			event.TargetType,
+			formatID(event.TargetID),
+			csvText(event.IP),
+			csvText(event.Details),
+		}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func formatID(id int64) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.FormatInt(id, 10)
+}
+
+func nullID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
+
+func nullTime(value time.Time) sql.NullTime {
+	return sql.NullTime{Time: value.UTC(), Valid: !value.IsZero()}
+}
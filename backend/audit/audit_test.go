@@ -0,0 +1,113 @@
+package audit
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func newAuditTestRepository(t *testing.T) (*Repository, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:audit-test?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(`
+		DROP TABLE IF EXISTS audit_events;
+		CREATE TABLE audit_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			action TEXT NOT NULL,
+			actor_id INTEGER,
+			actor_nickname TEXT NOT NULL DEFAULT '',
+			target_type TEXT NOT NULL DEFAULT '',
+			target_id INTEGER,
+			ip_address TEXT NOT NULL DEFAULT '',
+			details TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL
+		);
+		CREATE TRIGGER audit_events_no_update
+		BEFORE UPDATE ON audit_events
+		BEGIN
+			SELECT RAISE(ABORT, 'audit_events is append-only');
+		END;
+		CREATE TRIGGER audit_events_no_delete
+		BEFORE DELETE ON audit_events
+		BEGIN
+			SELECT RAISE(ABORT, 'audit_events is append-only');
+		END;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewRepository(db), db
+}
+
+func TestListFiltersEventsAndRowsAreAppendOnly(t *testing.T) {
+	repository, db := newAuditTestRepository(t)
+	start := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, event := range []Event{
+		{Action: ActionLogin, ActorID: 1, ActorNickname: "alice", IP: "10.0.0.1"},
+		{Action: ActionLoginFailed, TargetType: TargetUser, TargetID: 2, IP: "10.0.0.2", Details: "identifier=bob"},
+		{Action: ActionPostDeleted, ActorID: 3, ActorNickname: "carol", TargetType: TargetPost, TargetID: 9},
+	} {
+		event.CreatedAt = start.Add(time.Duration(i) * time.Hour)
+		if err := repository.Record(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := repository.List(Filter{TargetType: TargetPost, TargetID: 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].ActorNickname != "carol" {
+		t.Fatalf("unexpected events: %#v", events)
+	}
+
+	events, err = repository.List(Filter{Since: start.Add(30 * time.Minute), Until: start.Add(90 * time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Action != ActionLoginFailed || events[0].ActorID != 0 {
+		t.Fatalf("unexpected events: %#v", events)
+	}
+
+	if _, err := db.Exec("UPDATE audit_events SET action = 'x'"); err == nil {
+		t.Fatal("audit events should not be updatable")
+	}
+	if _, err := db.Exec("DELETE FROM audit_events"); err == nil {
+		t.Fatal("audit events should not be deletable")
+	}
+}
+
+func TestWriteCSVNeutralisesFormulas(t *testing.T) {
+	var buffer bytes.Buffer
+	err := WriteCSV(&buffer, []Event{{
+		ID: 1, Action: ActionLoginFailed, Details: "identifier==HYPERLINK(\"x\")",
+		CreatedAt: time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC),
+	}, {
+		ID: 2, Action: ActionLoginFailed, Details: "=1+1",
+		CreatedAt: time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buffer).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0][0] != "id" {
+		t.Fatalf("unexpected CSV: %#v", records)
+	}
+	if records[1][3] != "" || records[1][8] != "identifier==HYPERLINK(\"x\")" {
+		t.Fatalf("unexpected row: %#v", records[1])
+	}
+	if records[2][8] != "'=1+1" {
+		t.Fatalf("formula was not neutralised: %q", records[2][8])
+	}
+}
@@ -0,0 +1,127 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/audit"
+)
+
+// recordAudit stores the event with the request's client IP. Audit failures
+// are logged and never fail the request that caused them.
+func (S *Server) recordAudit(r *http.Request, event audit.Event) {
+	if S.audit == nil {
+		return
+	}
+	if r != nil && event.IP == "" {
+		event.IP = clientIP(r)
+	}
+	event.CreatedAt = time.Now()
+	if err := S.audit.Record(event); err != nil {
+		log.Printf("failed to record audit event %s: %v", event.Action, err)
+	}
+}
+
+// actorEvent starts an audit event performed by the signed-in user.
+func actorEvent(identity account.Identity, action string) audit.Event {
+	return audit.Event{Action: action, ActorID: identity.UserID, ActorNickname: identity.Nickname}
+}
+
+func (S *Server) ListAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	if S.audit == nil {
+		http.Error(w, "Audit repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+	}
+	for _, param := range []struct {
+		name  string
+		value *int64
+	}{
+		{name: "actor_id", value: &filter.ActorID},
+		{name: "target_id", value: &filter.TargetID},
+	} {
+		if raw := query.Get(param.name); raw != "" {
+			id, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || id < 1 {
+				http.Error(w, "Invalid "+param.name, http.StatusBadRequest)
+				return
+			}
+			*param.value = id
+		}
+	}
+	for _, param := range []struct {
+		name  string
+		value *time.Time
+	}{
+		{name: "since", value: &filter.Since},
+		{name: "until", value: &filter.Until},
+	} {
+		if raw := query.Get(param.name); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				http.Error(w, "Invalid "+param.name+": use RFC 3339", http.StatusBadRequest)
+				return
+			}
+			*param.value = parsed
+		}
+	}
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{
+		{name: "limit", value: &filter.Limit},
+		{name: "offset", value: &filter.Offset},
+	} {
+		if raw := query.Get(param.name); raw != "" {
+			number, err := strconv.Atoi(raw)
+			if err != nil || number < 0 {
+				http.Error(w, "Invalid "+param.name, http.StatusBadRequest)
+				return
+			}
+			*param.value = number
+		}
+	}
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		http.Error(w, "Format must be json or csv", http.StatusBadRequest)
+		return
+	}
+
+	events, err := S.audit.List(filter)
+	if errors.Is(err, audit.ErrInvalidQuery) {
+		http.Error(w, "Invalid audit filter", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit-log.csv"`)
+		if err := audit.WriteCSV(w, events); err != nil {
+			log.Printf("failed to write audit CSV: %v", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if query.Has("format") {
+		w.Header().Set("Content-Disposition", `attachment; filename="audit-log.json"`)
+	}
+	json.NewEncoder(w).Encode(events)
+}
@@ -0,0 +1,88 @@
+package backend
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/audit"
+)
+
+func TestAuditLogRecordsSecurityEventsForAdmins(t *testing.T) {
+	server := newModerationTestServer(t)
+	server.audit = audit.NewRepository(server.db)
+	if err := server.users.SetRoleByNickname("alice", account.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.sessions.Create("alice-session", "alice", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.LoginHandler(recorder, httptest.NewRequest(http.MethodPost, "/login",
+		strings.NewReader(`{"identifier":"bob","password":"wrong-password"}`)))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("failed login got %d, want 401", recorder.Code)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/moderation/suspend",
+		strings.NewReader(`{"user_id":2,"reason":"spamming links","duration_hours":1}`))
+	withSession(t, server, request, "carol-session")
+	recorder = httptest.NewRecorder()
+	server.Authorized(account.PermissionSuspendUsers, server.SuspendUserHandler).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("suspend got %d, want 201", recorder.Code)
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/admin/audit?target_id=2", nil)
+	withSession(t, server, request, "alice-session")
+	recorder = httptest.NewRecorder()
+	server.Authorized(account.PermissionViewAudit, server.ListAuditEventsHandler).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", recorder.Code, recorder.Body.String())
+	}
+	var events []audit.Event
+	if err := json.NewDecoder(recorder.Body).Decode(&events); err != nil {
+		t.Fatal(err)
+	}
+	actions := map[string]audit.Event{}
+	for _, event := range events {
+		actions[event.Action] = event
+	}
+	for _, action := range []string{audit.ActionLoginFailed, audit.ActionUserSuspended, audit.ActionSessionsRevoked} {
+		if _, ok := actions[action]; !ok {
+			t.Fatalf("missing %s in %#v", action, events)
+		}
+	}
+	if suspended := actions[audit.ActionUserSuspended]; suspended.ActorNickname != "carol" || suspended.IP == "" {
+		t.Fatalf("unexpected suspension event: %#v", suspended)
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/admin/audit?action=auth.login_failed&format=csv", nil)
+	withSession(t, server, request, "alice-session")
+	recorder = httptest.NewRecorder()
+	server.Authorized(account.PermissionViewAudit, server.ListAuditEventsHandler).ServeHTTP(recorder, request)
+	if got := recorder.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/csv") {
+		t.Fatalf("got content type %q, want text/csv", got)
+	}
+	records, err := csv.NewReader(recorder.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1][2] != audit.ActionLoginFailed {
+		t.Fatalf("unexpected CSV export: %#v", records)
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/admin/audit", nil)
+	withSession(t, server, request, "carol-session")
+	recorder = httptest.NewRecorder()
+	server.Authorized(account.PermissionViewAudit, server.ListAuditEventsHandler).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("moderator reading audit log got %d, want 403", recorder.Code)
+	}
+}
@@ -0,0 +1,106 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"real-time-forum/backend/account"
+)
+
+func TestDeletePostRequiresOwnershipOrPermission(t *testing.T) {
+	server, _ := newProfileTestServer(t)
+	for _, nickname := range []string{"bob", "carol"} {
+		if err := server.users.Create(account.UserRecord{
+			Nickname: nickname, FirstName: "Test", LastName: "User",
+			Email: nickname + "@example.com", Password: "Password1", Age: 30, Gender: "male",
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.sessions.Create(nickname+"-session", nickname, time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := server.users.SetRoleByNickname("carol", account.RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+
+	deletePost := func(sessionID string) int {
+		request := httptest.NewRequest(http.MethodPost, "/deletePost", strings.NewReader(`{"post_id":1}`))
+		withSession(t, server, request, sessionID)
+		recorder := httptest.NewRecorder()
+		server.SessionMiddleware(http.HandlerFunc(server.DeletePostHandler)).ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	if code := deletePost("bob-session"); code != http.StatusForbidden {
+		t.Fatalf("member deleting another user's post got %d, want 403", code)
+	}
+	if code := deletePost("carol-session"); code != http.StatusNoContent {
+		t.Fatalf("moderator deleting a post got %d, want 204", code)
+	}
+	if code := deletePost("carol-session"); code != http.StatusNotFound {
+		t.Fatalf("deleting a missing post got %d, want 404", code)
+	}
+}
+
+func TestAuthorizedRejectsMissingPermission(t *testing.T) {
+	server, _ := newProfileTestServer(t)
+	if _, err := server.sessions.Create("alice-session", "alice", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	handler := server.Authorized(account.PermissionManageRoles, server.SetUserRoleHandler)
+
+	request := httptest.NewRequest(http.MethodPost, "/admin/users/role", strings.NewReader(`{"user_id":2,"role":"admin"}`))
+	withSession(t, server, request, "alice-session")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("member got %d, want 403", recorder.Code)
+	}
+}
+
+func TestSessionMiddlewareRequiresCSRFTokenForUnsafeMethods(t *testing.T) {
+	server, _ := newProfileTestServer(t)
+	if _, err := server.sessions.Create("alice-session", "alice", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	handler := server.SessionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(method, token string) int {
+		request := httptest.NewRequest(method, "/createPost", nil)
+		request.AddCookie(&http.Cookie{Name: "session_token", Value: "alice-session"})
+		if token != "" {
+			request.Header.Set("X-CSRF-Token", token)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	if code := serve(http.MethodPost, ""); code != http.StatusForbidden {
+		t.Fatalf("POST without token got %d, want 403", code)
+	}
+	if code := serve(http.MethodPost, "forged"); code != http.StatusForbidden {
+		t.Fatalf("POST with a wrong token got %d, want 403", code)
+	}
+	if code := serve(http.MethodGet, ""); code != http.StatusNoContent {
+		t.Fatalf("GET without token got %d, want 204", code)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/logged", nil)
+	request.AddCookie(&http.Cookie{Name: "session_token", Value: "alice-session"})
+	recorder := httptest.NewRecorder()
+	server.LoggedHandler(recorder, request)
+	var logged map[string]string
+	if err := json.NewDecoder(recorder.Body).Decode(&logged); err != nil {
+		t.Fatal(err)
+	}
+	if code := serve(http.MethodPost, logged["csrf_token"]); code != http.StatusNoContent {
+		t.Fatalf("POST with the token from /logged got %d, want 204", code)
+	}
+}
@@ -0,0 +1,131 @@
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+
+	_ "image/gif"
+	_ "image/jpeg"
+)
+
+const (
+	FullSize      = 256
+	ThumbnailSize = 64
+	MaxDimension  = 4096
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrImageTooLarge     = errors.New("image dimensions are too large")
+)
+
+var allowedFormats = map[string]bool{"jpeg": true, "png": true, "gif": true}
+
+// Images holds the re-encoded avatar. Both images are fresh PNG encodings of
+// the decoded pixels, so EXIF and other metadata from the upload are dropped.
+type Images struct {
+	Full      []byte
+	Thumbnail []byte
+}
+
+// Process decodes an uploaded image, crops it to a centered square and
+// produces the full-size avatar and its thumbnail.
+func Process(data []byte) (Images, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || !allowedFormats[format] {
+		return Images{}, ErrUnsupportedFormat
+	}
+	if config.Width > MaxDimension || config.Height > MaxDimension {
+		return Images{}, ErrImageTooLarge
+	}
+
+	source, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Images{}, fmt.Errorf("decode image: %w", err)
+	}
+	square := cropSquare(source)
+
+	var images Images
+	if images.Full, err = encodePNG(resize(square, FullSize)); err != nil {
+		return Images{}, err
+	}
+	if images.Thumbnail, err = encodePNG(resize(square, ThumbnailSize)); err != nil {
+		return Images{}, err
+	}
+	return images, nil
+}
+
+func cropSquare(source image.Image) *image.RGBA {
+	bounds := source.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	origin := image.Point{
+		X: bounds.Min.X + (bounds.Dx()-side)/2,
+		Y: bounds.Min.Y + (bounds.Dy()-side)/2,
+	}
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), source, origin, draw.Src)
+	return square
+}
+
+// resize scales a square image with a box filter: every destination pixel is
+// the average of the source pixels it covers.
+func resize(source *image.RGBA, size int) *image.RGBA {
+	sourceSize := source.Bounds().Dx()
+	destination := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0 := y * sourceSize / size
+		y1 := max((y+1)*sourceSize/size, y0+1)
+		for x := 0; x < size; x++ {
+			x0 := x * sourceSize / size
+			x1 := max((x+1)*sourceSize/size, x0+1)
+
+			var r, g, b, a, count int
+			for sy := y0; sy < y1; sy++ {
+				offset := source.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(source.Pix[offset])
+					g += int(source.Pix[offset+1])
+					b += int(source.Pix[offset+2])
+					a += int(source.Pix[offset+3])
+					offset += 4
+					count++
+				}
+			}
+			offset := destination.PixOffset(x, y)
+			destination.Pix[offset] = uint8(r / count)
+			destination.Pix[offset+1] = uint8(g / count)
+			destination.Pix[offset+2] = uint8(b / count)
+			destination.Pix[offset+3] = uint8(a / count)
+		}
+	}
+	return destination
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, img); err != nil {
+		return nil, fmt.Errorf("encode avatar: %w", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// ReadLimited reads at most limit bytes and reports whether the input was
+// longer than that.
+func ReadLimited(reader io.Reader, limit int64) ([]byte, bool, error) {
+	data, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(data)) > limit {
+		return nil, true, nil
+	}
+	return data, false, nil
+}
@@ -0,0 +1,47 @@
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+func TestProcessProducesSquarePNGs(t *testing.T) {
+	source := image.NewRGBA(image.Rect(0, 0, 300, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 300; x++ {
+			source.Set(x, y, color.RGBA{R: 200, G: 40, B: 40, A: 255})
+		}
+	}
+	var upload bytes.Buffer
+	if err := jpeg.Encode(&upload, source, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	images, err := Process(upload.Bytes())
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	for name, data := range map[string][]byte{"full": images.Full, "thumbnail": images.Thumbnail} {
+		config, format, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil || format != "png" {
+			t.Fatalf("%s: got format %q, err %v, want png", name, format, err)
+		}
+		want := FullSize
+		if name == "thumbnail" {
+			want = ThumbnailSize
+		}
+		if config.Width != want || config.Height != want {
+			t.Fatalf("%s: got %dx%d, want %dx%d", name, config.Width, config.Height, want, want)
+		}
+	}
+}
+
+func TestProcessRejectsNonImages(t *testing.T) {
+	if _, err := Process([]byte("<svg></svg>")); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("got %v, want ErrUnsupportedFormat", err)
+	}
+}
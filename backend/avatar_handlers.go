@@ -0,0 +1,177 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/avatar"
+	"real-time-forum/backend/forum"
+	"real-time-forum/backend/storage"
+)
+
+const (
+	avatarMaxUploadSize = 2 << 20
+	avatarKeyPrefix     = "avatars/"
+)
+
+var avatarContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// avatarURL maps a stored avatar key to its public URL. Lists use the
+// thumbnail; profile pages use the full-size image.
+func avatarURL(avatarKey string, thumbnail bool) string {
+	if avatarKey == "" {
+		return ""
+	}
+	if thumbnail {
+		return "/media/" + avatarKeyPrefix + avatarKey + "_thumb.png"
+	}
+	return "/media/" + avatarKeyPrefix + avatarKey + ".png"
+}
+
+func withPostAvatars(posts []forum.Post) []forum.Post {
+	for i := range posts {
+		posts[i].Avatar = avatarURL(posts[i].AvatarKey, true)
+	}
+	return posts
+}
+
+func withCommentAvatars(comments []forum.Comment) []forum.Comment {
+	for i := range comments {
+		comments[i].Avatar = avatarURL(comments[i].AvatarKey, true)
+	}
+	return comments
+}
+
+func (S *Server) UploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if S.users == nil || S.blobs == nil {
+		http.Error(w, "Avatar storage is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, avatarMaxUploadSize+64<<10)
+	file, header, err := r.FormFile("avatar")
+	if err != nil {
+		http.Error(w, "Avatar file is required (max 2 MB)", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, tooLarge, err := avatar.ReadLimited(file, avatarMaxUploadSize)
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if tooLarge {
+		http.Error(w, "Avatar must be at most 2 MB", http.StatusRequestEntityTooLarge)
+		return
+	}
+	declaredType := strings.TrimSpace(strings.Split(header.Header.Get("Content-Type"), ";")[0])
+	if !avatarContentTypes[declaredType] || !avatarContentTypes[http.DetectContentType(data)] {
+		http.Error(w, "Avatar must be a JPEG, PNG, or GIF image", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	images, err := avatar.Process(data)
+	if errors.Is(err, avatar.ErrUnsupportedFormat) || errors.Is(err, avatar.ErrImageTooLarge) {
+		http.Error(w, "Avatar must be a JPEG, PNG, or GIF image up to 4096x4096 pixels", http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Invalid image", http.StatusBadRequest)
+		return
+	}
+
+	token, _, err := account.GenerateToken("")
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	avatarKey := token[:22]
+	if err := S.blobs.Put(avatarKeyPrefix+avatarKey+".png", bytes.NewReader(images.Full)); err != nil {
+		http.Error(w, "Failed to store avatar", http.StatusInternalServerError)
+		return
+	}
+	if err := S.blobs.Put(avatarKeyPrefix+avatarKey+"_thumb.png", bytes.NewReader(images.Thumbnail)); err != nil {
+		S.deleteAvatarBlobs(avatarKey)
+		http.Error(w, "Failed to store avatar", http.StatusInternalServerError)
+		return
+	}
+
+	previous, err := S.users.SetAvatar(identity.UserID, avatarKey)
+	if err != nil {
+		S.deleteAvatarBlobs(avatarKey)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if previous != "" {
+		S.deleteAvatarBlobs(previous)
+	}
+	if S.hub != nil {
+		S.broadcastUserStatusChange()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"avatar_url":    avatarURL(avatarKey, false),
+		"thumbnail_url": avatarURL(avatarKey, true),
+	})
+}
+
+func (S *Server) deleteAvatarBlobs(avatarKey string) {
+	for _, key := range []string{avatarKeyPrefix + avatarKey + ".png", avatarKeyPrefix + avatarKey + "_thumb.png"} {
+		if err := S.blobs.Delete(key); err != nil {
+			log.Printf("failed to delete avatar blob %s: %v", key, err)
+		}
+	}
+}
+
+func (S *Server) AvatarFileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	if S.blobs == nil {
+		http.Error(w, "Avatar storage is not initialized", http.StatusInternalServerError)
+		return
+	}
+	file := r.PathValue("file")
+	if !strings.HasSuffix(file, ".png") {
+		http.NotFound(w, r)
+		return
+	}
+
+	blob, err := S.blobs.Open(avatarKeyPrefix + file)
+	if errors.Is(err, storage.ErrBlobNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	io.Copy(w, blob)
+}
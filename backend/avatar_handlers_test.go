@@ -0,0 +1,75 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"real-time-forum/backend/forum"
+	"real-time-forum/backend/storage"
+)
+
+func TestUploadAvatarStoresProcessedImages(t *testing.T) {
+	server, _ := newProfileTestServer(t)
+	blobs, err := storage.NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.blobs = blobs
+	if _, err := server.sessions.Create("alice-session", "alice", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	var upload bytes.Buffer
+	if err := png.Encode(&upload, image.NewRGBA(image.Rect(0, 0, 120, 80))); err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {`form-data; name="avatar"; filename="me.png"`},
+		"Content-Type":        {"image/png"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(upload.Bytes())
+	writer.Close()
+
+	request := httptest.NewRequest(http.MethodPost, "/profile/avatar", &body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	withSession(t, server, request, "alice-session")
+	recorder := httptest.NewRecorder()
+	server.SessionMiddleware(http.HandlerFunc(server.UploadAvatarHandler)).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response map[string]string
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/media/avatars/{file}", server.AvatarFileHandler)
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, response["thumbnail_url"], nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d serving %s, want 200", recorder.Code, response["thumbnail_url"])
+	}
+	config, err := png.DecodeConfig(recorder.Body)
+	if err != nil || config.Width != 64 {
+		t.Fatalf("unexpected thumbnail: %#v, %v", config, err)
+	}
+
+	posts, err := server.forum.ListPosts(forum.ListOptions{})
+	if err != nil || len(posts) != 1 || withPostAvatars(posts)[0].Avatar != response["thumbnail_url"] {
+		t.Fatalf("post does not carry the author avatar: %#v, %v", posts, err)
+	}
+}
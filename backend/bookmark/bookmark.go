@@ -0,0 +1,238 @@
+package bookmark
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Target types a user can bookmark.
+const (
+	TargetPost    = "post"
+	TargetComment = "comment"
+	TargetMessage = "message"
+)
+
+const (
+	MaxNoteLength   = 500
+	MaxFolderLength = 50
+
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+var ErrInvalidBookmark = errors.New("bookmark target, note, or folder is invalid")
+
+// Bookmark is a saved post, comment, or chat message with the user's note
+// and folder. Title and PostID are set for posts and comments; Author is who
+// wrote the saved content.
+type Bookmark struct {
+	ID         int64     `json:"id"`
+	TargetType string    `json:"target_type"`
+	TargetID   int64     `json:"target_id"`
+	PostID     int64     `json:"post_id,omitempty"`
+	Title      string    `json:"title,omitempty"`
+	Content    string    `json:"content"`
+	Author     string    `json:"author"`
+	Note       string    `json:"note"`
+	Folder     string    `json:"folder"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Filter narrows List. Folder matches case-insensitively and Search matches
+// the note, the post title, or the saved content. Empty fields match all.
+type Filter struct {
+	Folder     string
+	TargetType string
+	Search     string
+	Limit      int
+	Offset     int
+}
+
+// Folder is a folder name with the number of bookmarks in it. Bookmarks
+// without a folder are counted under the empty name.
+type Folder struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+func validTarget(targetType string, targetID int64) bool {
+	switch targetType {
+	case TargetPost, TargetComment, TargetMessage:
+		return targetID > 0
+	}
+	return false
+}
+
+// Save bookmarks the target, or replaces the note and folder of an existing
+// bookmark. Whether the user may see the target is checked by the caller.
+func (r *Repository) Save(userID int64, targetType string, targetID int64, note, folder string, now time.Time) error {
+	note, folder = strings.TrimSpace(note), strings.TrimSpace(folder)
+	if !validTarget(targetType, targetID) ||
+		len([]rune(note)) > MaxNoteLength || len([]rune(folder)) > MaxFolderLength {
+		return ErrInvalidBookmark
+	}
+	_, err := r.db.Exec(`
+		INSERT INTO bookmarks (user_id, target_type, target_id, note, folder, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id, target_type, target_id) DO UPDATE SET
+			note = excluded.note, folder = excluded.folder, updated_at = excluded.updated_at`,
+		userID, targetType, targetID, note, folder, now.UTC(), now.UTC())
+	return err
+}
+
+// Delete removes a bookmark. Removing one that does not exist is not an
+// error.
+func (r *Repository) Delete(userID int64, targetType string, targetID int64) error {
+	if !validTarget(targetType, targetID) {
+		return ErrInvalidBookmark
+	}
+	_, err := r.db.Exec("DELETE FROM bookmarks WHERE user_id = ? AND target_type = ? AND target_id = ?",
+		userID, targetType, targetID)
+	return err
+}
+
+// visibleBookmarks joins each bookmark to its target. Targets that were
+// deleted or hidden by a moderator join nothing, which targetVisible then
+// filters out. It takes the post, comment, and message target types as
+// arguments.
+const (
+	visibleBookmarks = `
+		FROM bookmarks
+		LEFT JOIN posts ON bookmarks.target_type = ? AND posts.id = bookmarks.target_id
+		     AND posts.hidden_at IS NULL
+		LEFT JOIN comments ON bookmarks.target_type = ? AND comments.id = bookmarks.target_id
+		     AND comments.hidden_at IS NULL
+		LEFT JOIN posts AS comment_posts ON comment_posts.id = comments.post_id
+		     AND comment_posts.hidden_at IS NULL
+		LEFT JOIN messages ON bookmarks.target_type = ? AND messages.id = bookmarks.target_id
+		     AND messages.hidden_at IS NULL`
+	targetVisible = "(posts.id IS NOT NULL OR comment_posts.id IS NOT NULL OR messages.id IS NOT NULL)"
+)
+
+// List returns the user's bookmarks, newest first. Bookmarks of content that
+// was deleted or hidden by a moderator are left out.
+func (r *Repository) List(userID int64, filter Filter) ([]Bookmark, error) {
+	if filter.TargetType != "" && !validTarget(filter.TargetType, 1) {
+		return nil, ErrInvalidBookmark
+	}
+	if filter.Offset < 0 {
+		return nil, ErrInvalidBookmark
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultListLimit
+	}
+	if filter.Limit > maxListLimit {
+		filter.Limit = maxListLimit
+	}
+	folder := strings.TrimSpace(filter.Folder)
+	search := strings.TrimSpace(filter.Search)
+	pattern := "%" + escapeLike(search) + "%"
+
+	rows, err := r.db.Query(`
+		SELECT bookmarks.id, bookmarks.target_type, bookmarks.target_id,
+		       COALESCE(posts.id, comment_posts.id, 0), COALESCE(posts.title, comment_posts.title, ''),
+		       COALESCE(posts.content, comments.content, messages.content, ''), COALESCE(authors.nickname, ''),
+		       bookmarks.note, bookmarks.folder, bookmarks.created_at, bookmarks.updated_at
+		`+visibleBookmarks+`
+		LEFT JOIN users AS authors ON authors.id = COALESCE(posts.user_id, comments.user_id, messages.sender_id)
+		WHERE bookmarks.user_id = ?
+		  AND `+targetVisible+`
+		  AND (? = '' OR bookmarks.target_type = ?)
+		  AND (? = '' OR bookmarks.folder = ?)
+		  AND (? = '' OR bookmarks.note LIKE ? ESCAPE '\'
+		       OR COALESCE(posts.title, comment_posts.title, '') LIKE ? ESCAPE '\'
+		       OR COALESCE(posts.content, comments.content, messages.content, '') LIKE ? ESCAPE '\')
+		ORDER BY bookmarks.id DESC
+		LIMIT ? OFFSET ?`,
+		TargetPost, TargetComment, TargetMessage, userID,
+		filter.TargetType, filter.TargetType,
+		folder, folder,
+		search, pattern, pattern, pattern,
+		filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bookmarks := []Bookmark{}
+	for rows.Next() {
+		var bookmark Bookmark
+		if err := rows.Scan(&bookmark.ID, &bookmark.TargetType, &bookmark.TargetID,
+			&bookmark.PostID, &bookmark.Title, &bookmark.Content, &bookmark.Author,
+			&bookmark.Note, &bookmark.Folder, &bookmark.CreatedAt, &bookmark.UpdatedAt); err != nil {
+			return nil, err
+		}
+		bookmarks = append(bookmarks, bookmark)
+	}
+	return bookmarks, rows.Err()
+}
+
+// Folders lists the user's folders by name with their bookmark counts. Like
+// List, it leaves out bookmarks of deleted and hidden content.
+func (r *Repository) Folders(userID int64) ([]Folder, error) {
+	rows, err := r.db.Query(`
+		SELECT bookmarks.folder, COUNT(*)
+		`+visibleBookmarks+`
+		WHERE bookmarks.user_id = ? AND `+targetVisible+`
+		GROUP BY bookmarks.folder
+		ORDER BY bookmarks.folder`,
+		TargetPost, TargetComment, TargetMessage, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	folders := []Folder{}
+	for rows.Next() {
+		var folder Folder
+		if err := rows.Scan(&folder.Name, &folder.Count); err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+	return folders, rows.Err()
+}
+
+// Bookmarked reports which of the listed targets the user has bookmarked.
+func (r *Repository) Bookmarked(userID int64, targetType string, targetIDs []int64) (map[int64]bool, error) {
+	bookmarked := make(map[int64]bool)
+	if len(targetIDs) == 0 {
+		return bookmarked, nil
+	}
+	args := []interface{}{userID, targetType}
+	for _, id := range targetIDs {
+		args = append(args, id)
+	}
+	rows, err := r.db.Query(`
+		SELECT target_id FROM bookmarks
+		WHERE user_id = ? AND target_type = ?
+		  AND target_id IN (?`+strings.Repeat(", ?", len(targetIDs)-1)+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var targetID int64
+		if err := rows.Scan(&targetID); err != nil {
+			return nil, err
+		}
+		bookmarked[targetID] = true
+	}
+	return bookmarked, rows.Err()
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
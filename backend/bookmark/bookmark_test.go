@@ -0,0 +1,214 @@
+package bookmark
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func newBookmarkTestRepository(t *testing.T) *Repository {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:bookmark-test?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(`
+		DROP TABLE IF EXISTS users;
+		DROP TABLE IF EXISTS posts;
+		DROP TABLE IF EXISTS comments;
+		DROP TABLE IF EXISTS messages;
+		DROP TABLE IF EXISTS bookmarks;
+		CREATE TABLE users (id INTEGER PRIMARY KEY, nickname TEXT NOT NULL);
+		CREATE TABLE posts (
+			id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, title TEXT NOT NULL,
+			content TEXT NOT NULL, hidden_at DATETIME
+		);
+		CREATE TABLE comments (
+			id INTEGER PRIMARY KEY, post_id INTEGER NOT NULL, user_id INTEGER NOT NULL,
+			content TEXT NOT NULL, hidden_at DATETIME
+		);
+		CREATE TABLE messages (
+			id INTEGER PRIMARY KEY, sender_id INTEGER NOT NULL, content TEXT NOT NULL, hidden_at DATETIME
+		);
+		CREATE TABLE bookmarks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			target_type TEXT NOT NULL,
+			target_id INTEGER NOT NULL,
+			note TEXT NOT NULL DEFAULT '',
+			folder TEXT NOT NULL DEFAULT '' COLLATE NOCASE,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL,
+			UNIQUE(user_id, target_type, target_id)
+		);
+		INSERT INTO users (id, nickname) VALUES (1, 'alice'), (2, 'bob');
+		INSERT INTO posts (id, user_id, title, content) VALUES
+			(1, 2, 'Go generics', 'Type parameters in practice'),
+			(2, 2, 'Gardening', 'Tomatoes need sun');
+		INSERT INTO comments (id, post_id, user_id, content) VALUES (1, 1, 2, 'Constraints are interfaces');
+		INSERT INTO messages (id, sender_id, content) VALUES (1, 2, 'Lunch at noon? 100% sure');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewRepository(db)
+}
+
+func targets(bookmarks []Bookmark) []string {
+	saved := make([]string, len(bookmarks))
+	for i, bookmark := range bookmarks {
+		saved[i] = bookmark.TargetType + ":" + bookmark.Title + ":" + bookmark.Content
+	}
+	return saved
+}
+
+func TestSaveUpdatesTheNoteAndFolderOfAnExistingBookmark(t *testing.T) {
+	repository := newBookmarkTestRepository(t)
+	created := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	if err := repository.Save(1, TargetPost, 1, "read later", "Code", created); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.Save(1, TargetPost, 1, "  read tonight  ", " Reading ", created.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	bookmarks, err := repository.List(1, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bookmarks) != 1 {
+		t.Fatalf("got %d bookmarks, want the one updated in place", len(bookmarks))
+	}
+	bookmark := bookmarks[0]
+	if bookmark.Note != "read tonight" || bookmark.Folder != "Reading" || bookmark.Author != "bob" || bookmark.PostID != 1 {
+		t.Fatalf("unexpected bookmark: %+v", bookmark)
+	}
+	if !bookmark.CreatedAt.Equal(created) || !bookmark.UpdatedAt.Equal(created.Add(time.Hour)) {
+		t.Fatalf("created %s and updated %s; want the first save and the second", bookmark.CreatedAt, bookmark.UpdatedAt)
+	}
+
+	long := make([]rune, MaxNoteLength+1)
+	for i := range long {
+		long[i] = 'x'
+	}
+	if err := repository.Save(1, TargetPost, 1, string(long), "", created); !errors.Is(err, ErrInvalidBookmark) {
+		t.Fatalf("too long note got %v, want ErrInvalidBookmark", err)
+	}
+	if err := repository.Save(1, "user", 1, "", "", created); !errors.Is(err, ErrInvalidBookmark) {
+		t.Fatalf("unknown target type got %v, want ErrInvalidBookmark", err)
+	}
+}
+
+func TestListFiltersByFolderTypeAndSearch(t *testing.T) {
+	repository := newBookmarkTestRepository(t)
+	now := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+	for _, save := range []struct {
+		targetType string
+		targetID   int64
+		note       string
+		folder     string
+	}{
+		{TargetPost, 1, "", "Code"},
+		{TargetComment, 1, "good answer", "code"},
+		{TargetMessage, 1, "", ""},
+		{TargetPost, 2, "for the garden", "Home"},
+	} {
+		if err := repository.Save(1, save.targetType, save.targetID, save.note, save.folder, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repository.Save(2, TargetPost, 1, "", "Code", now); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"everything newest first", Filter{}, []string{
+			"post:Gardening:Tomatoes need sun",
+			"message::Lunch at noon? 100% sure",
+			"comment:Go generics:Constraints are interfaces",
+			"post:Go generics:Type parameters in practice",
+		}},
+		{"folder ignores case", Filter{Folder: "CODE"}, []string{
+			"comment:Go generics:Constraints are interfaces",
+			"post:Go generics:Type parameters in practice",
+		}},
+		{"target type", Filter{TargetType: TargetMessage}, []string{"message::Lunch at noon? 100% sure"}},
+		{"search matches the note", Filter{Search: "garden"}, []string{"post:Gardening:Tomatoes need sun"}},
+		{"search matches a comment's post title", Filter{Search: "generics", TargetType: TargetComment}, []string{
+			"comment:Go generics:Constraints are interfaces",
+		}},
+		{"search matches content", Filter{Search: "interfaces"}, []string{"comment:Go generics:Constraints are interfaces"}},
+		{"search escapes LIKE wildcards", Filter{Search: "100%"}, []string{"message::Lunch at noon? 100% sure"}},
+		{"a literal underscore matches nothing", Filter{Search: "_"}, []string{}},
+		{"limit and offset", Filter{Limit: 1, Offset: 1}, []string{"message::Lunch at noon? 100% sure"}},
+	}
+	for _, test := range tests {
+		bookmarks, err := repository.List(1, test.filter)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := targets(bookmarks); !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+	if _, err := repository.List(1, Filter{TargetType: "user"}); !errors.Is(err, ErrInvalidBookmark) {
+		t.Fatalf("unknown target type got %v, want ErrInvalidBookmark", err)
+	}
+}
+
+func TestListAndFoldersLeaveOutDeletedAndHiddenTargets(t *testing.T) {
+	repository := newBookmarkTestRepository(t)
+	now := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+	for _, save := range []struct {
+		targetType string
+		targetID   int64
+		folder     string
+	}{
+		{TargetPost, 1, "Code"},
+		{TargetComment, 1, "Code"},
+		{TargetPost, 2, "Home"},
+		{TargetMessage, 1, "Home"},
+		{TargetPost, 99, "Gone"},
+	} {
+		if err := repository.Save(1, save.targetType, save.targetID, "", save.folder, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Hiding post 1 also hides the comment on it; post 99 never existed.
+	if _, err := repository.db.Exec(`
+		UPDATE posts SET hidden_at = ? WHERE id = 1;
+		UPDATE messages SET hidden_at = ? WHERE id = 1;`, now, now); err != nil {
+		t.Fatal(err)
+	}
+
+	bookmarks, err := repository.List(1, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := targets(bookmarks); !reflect.DeepEqual(got, []string{"post:Gardening:Tomatoes need sun"}) {
+		t.Fatalf("List got %q, want only the visible post", got)
+	}
+	folders, err := repository.Folders(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Folder{{Name: "Home", Count: 1}}; !reflect.DeepEqual(folders, want) {
+		t.Fatalf("Folders got %+v, want %+v", folders, want)
+	}
+
+	bookmarked, err := repository.Bookmarked(1, TargetPost, []int64{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bookmarked[1] || !bookmarked[2] || bookmarked[3] {
+		t.Fatalf("Bookmarked got %v, want posts 1 and 2", bookmarked)
+	}
+}
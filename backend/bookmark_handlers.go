@@ -0,0 +1,204 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/bookmark"
+	"real-time-forum/backend/chat"
+	"real-time-forum/backend/forum"
+)
+
+// withPostBookmarks marks the posts the user has bookmarked. A failure is
+// logged and the posts are returned unmarked.
+func (S *Server) withPostBookmarks(userID int64, posts []forum.Post) []forum.Post {
+	if S.bookmarks == nil {
+		return posts
+	}
+	ids := make([]int64, len(posts))
+	for i, post := range posts {
+		ids[i] = int64(post.ID)
+	}
+	bookmarked, err := S.bookmarks.Bookmarked(userID, bookmark.TargetPost, ids)
+	if err != nil {
+		log.Printf("failed to load bookmarks of user %d: %v", userID, err)
+		return posts
+	}
+	for i := range posts {
+		posts[i].Bookmarked = bookmarked[int64(posts[i].ID)]
+	}
+	return posts
+}
+
+// ListBookmarksHandler returns the user's bookmarks, filtered by folder,
+// target_type, and a q search of notes, titles, and content.
+func (S *Server) ListBookmarksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if S.bookmarks == nil {
+		http.Error(w, "Bookmark repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := bookmark.Filter{
+		Folder:     query.Get("folder"),
+		TargetType: query.Get("target_type"),
+		Search:     query.Get("q"),
+	}
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{
+		{name: "limit", value: &filter.Limit},
+		{name: "offset", value: &filter.Offset},
+	} {
+		if raw := query.Get(param.name); raw != "" {
+			number, err := strconv.Atoi(raw)
+			if err != nil || number < 0 {
+				http.Error(w, "Invalid "+param.name, http.StatusBadRequest)
+				return
+			}
+			*param.value = number
+		}
+	}
+
+	bookmarks, err := S.bookmarks.List(identity.UserID, filter)
+	if errors.Is(err, bookmark.ErrInvalidBookmark) {
+		http.Error(w, "Target type must be post, comment, or message", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch bookmarks", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"bookmarks": bookmarks})
+}
+
+func (S *Server) ListBookmarkFoldersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if S.bookmarks == nil {
+		http.Error(w, "Bookmark repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+	folders, err := S.bookmarks.Folders(identity.UserID)
+	if err != nil {
+		http.Error(w, "Failed to fetch bookmark folders", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"folders": folders})
+}
+
+// SaveBookmarkHandler bookmarks a post, comment, or chat message, or changes
+// the note and folder of an existing bookmark. Only the two participants can
+// bookmark a chat message.
+func (S *Server) SaveBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		TargetType string `json:"target_type"`
+		TargetID   int64  `json:"target_id"`
+		Note       string `json:"note"`
+		Folder     string `json:"folder"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	switch request.TargetType {
+	case bookmark.TargetPost, bookmark.TargetComment, bookmark.TargetMessage:
+	default:
+		http.Error(w, "Target type must be post, comment, or message", http.StatusBadRequest)
+		return
+	}
+	if S.forum == nil || S.chat == nil || S.bookmarks == nil {
+		http.Error(w, "Bookmark repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	_, err := S.reportTargetOwner(identity.UserID, request.TargetType, request.TargetID)
+	if errors.Is(err, forum.ErrPostNotFound) || errors.Is(err, forum.ErrCommentNotFound) || errors.Is(err, chat.ErrMessageNotFound) {
+		http.Error(w, "Content not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	err = S.bookmarks.Save(identity.UserID, request.TargetType, request.TargetID, request.Note, request.Folder, time.Now())
+	if errors.Is(err, bookmark.ErrInvalidBookmark) {
+		http.Error(w, "Notes are limited to 500 characters and folder names to 50", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to save bookmark", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (S *Server) DeleteBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		TargetType string `json:"target_type"`
+		TargetID   int64  `json:"target_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if S.bookmarks == nil {
+		http.Error(w, "Bookmark repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+	err := S.bookmarks.Delete(identity.UserID, request.TargetType, request.TargetID)
+	if errors.Is(err, bookmark.ErrInvalidBookmark) {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete bookmark", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,148 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"real-time-forum/backend/bookmark"
+	"real-time-forum/backend/chat"
+	"real-time-forum/backend/forum"
+)
+
+func TestBookmarksAreSavedSearchedAndFlaggedOnPosts(t *testing.T) {
+	server := newModerationTestServer(t)
+	server.chat = chat.NewRepository(server.db)
+	server.bookmarks = bookmark.NewRepository(server.db)
+	if _, err := server.forum.CreateComment(1, 0, 1, "Check the changelog first"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	if _, err := server.db.Exec(`
+		INSERT INTO messages (sender_id, receiver_id, content, timestamp) VALUES (1, 2, 'The wiki link', ?), (1, 3, 'Private to carol', ?)`,
+		now, now); err != nil {
+		t.Fatal(err)
+	}
+	call := func(method, path, body string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		t.Helper()
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		withSession(t, server, request, "bob-session")
+		recorder := httptest.NewRecorder()
+		server.SessionMiddleware(handler).ServeHTTP(recorder, request)
+		return recorder
+	}
+	list := func(query string) []bookmark.Bookmark {
+		t.Helper()
+		recorder := call(http.MethodGet, "/bookmarks?"+query, "", server.ListBookmarksHandler)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("list %q got %d: %s", query, recorder.Code, recorder.Body.String())
+		}
+		var response struct {
+			Bookmarks []bookmark.Bookmark `json:"bookmarks"`
+		}
+		if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		return response.Bookmarks
+	}
+
+	for _, body := range []string{
+		`{"target_type":"post","target_id":1,"note":"Sale: 50% off","folder":"Reading"}`,
+		`{"target_type":"comment","target_id":1,"folder":"reading"}`,
+		`{"target_type":"message","target_id":1,"note":"wiki"}`,
+	} {
+		if recorder := call(http.MethodPost, "/bookmarks/save", body, server.SaveBookmarkHandler); recorder.Code != http.StatusNoContent {
+			t.Fatalf("save %s got %d: %s", body, recorder.Code, recorder.Body.String())
+		}
+	}
+	if recorder := call(http.MethodPost, "/bookmarks/save", `{"target_type":"message","target_id":2}`, server.SaveBookmarkHandler); recorder.Code != http.StatusNotFound {
+		t.Fatalf("bookmarking someone else's message got %d, want 404", recorder.Code)
+	}
+
+	if got := list(""); len(got) != 3 || got[0].TargetType != "message" || got[0].Author != "alice" {
+		t.Fatalf("all bookmarks = %+v", got)
+	}
+	if got := list("folder=READING"); len(got) != 2 || got[0].PostID != 1 || got[0].Title != "Hello" {
+		t.Fatalf("folder bookmarks = %+v", got)
+	}
+	if got := list("q=50%25"); len(got) != 1 || got[0].TargetType != "post" {
+		t.Fatalf("searching 50%% = %+v", got)
+	}
+	if got := list("q=changelog"); len(got) != 1 || got[0].TargetType != "comment" {
+		t.Fatalf("searching comment content = %+v", got)
+	}
+
+	recorder := call(http.MethodGet, "/bookmarks/folders", "", server.ListBookmarkFoldersHandler)
+	var folders struct {
+		Folders []bookmark.Folder `json:"folders"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&folders); err != nil {
+		t.Fatal(err)
+	}
+	if len(folders.Folders) != 2 || folders.Folders[0].Count != 1 || folders.Folders[1].Count != 2 {
+		t.Fatalf("folders = %+v", folders.Folders)
+	}
+
+	posts := func(sessionID string) []forum.Post {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodGet, "/posts", nil)
+		withSession(t, server, request, sessionID)
+		recorder := httptest.NewRecorder()
+		server.WithScope("", server.GetPostsHandler).ServeHTTP(recorder, request)
+		var posts []forum.Post
+		if err := json.NewDecoder(recorder.Body).Decode(&posts); err != nil {
+			t.Fatal(err)
+		}
+		return posts
+	}
+	if got := posts("bob-session"); len(got) != 1 || !got[0].Bookmarked {
+		t.Fatalf("bob's posts = %+v, want post 1 bookmarked", got)
+	}
+	if got := posts("carol-session"); len(got) != 1 || got[0].Bookmarked {
+		t.Fatalf("carol's posts = %+v, want no bookmark flag", got)
+	}
+
+	if recorder := call(http.MethodPost, "/bookmarks/delete", `{"target_type":"comment","target_id":1}`, server.DeleteBookmarkHandler); recorder.Code != http.StatusNoContent {
+		t.Fatalf("delete got %d", recorder.Code)
+	}
+	if err := server.forum.HidePost(1, now); err != nil {
+		t.Fatal(err)
+	}
+	if got := list(""); len(got) != 1 || got[0].TargetType != "message" {
+		t.Fatalf("after deleting and hiding = %+v, want only the message", got)
+	}
+}
+
+func TestDeletingContentDeletesItsBookmarks(t *testing.T) {
+	server := newModerationTestServer(t)
+	server.bookmarks = bookmark.NewRepository(server.db)
+	commentID, err := server.forum.CreateComment(1, 0, 2, "A comment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, target := range []struct {
+		targetType string
+		targetID   int64
+	}{{bookmark.TargetPost, 1}, {bookmark.TargetComment, commentID}} {
+		if err := server.bookmarks.Save(2, target.targetType, target.targetID, "", "", now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := server.forum.DeleteComment(int(commentID)); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, server, "SELECT COUNT(*) FROM bookmarks WHERE target_type = 'comment'"); n != 0 {
+		t.Fatalf("%d bookmarks still point at the deleted comment", n)
+	}
+	if err := server.forum.DeletePost(1); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, server, "SELECT COUNT(*) FROM bookmarks"); n != 0 {
+		t.Fatalf("%d bookmarks still point at the deleted post", n)
+	}
+}
@@ -1,5 +1,7 @@
 package chat
 
+import "real-time-forum/backend/notification"
+
 type Message struct {
 	ID         int
 	SenderID   int64
@@ -8,11 +10,15 @@ type Message struct {
 	To         string
 	Content    string
 	Timestamp  string
+	// Delivery is the receiver's notification preference for the message.
+	// It is only set by Service.SendMessage.
+	Delivery notification.Delivery
 }
 
 type Conversation struct {
 	UserID          int64
 	Nickname        string
+	AvatarKey       string
 	LastMessage     string
 	LastInteraction string
 }
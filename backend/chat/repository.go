@@ -1,6 +1,12 @@
 package chat
 
-import "database/sql"
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrMessageNotFound = errors.New("message not found")
 
 type Repository struct {
 	db *sql.DB
@@ -10,9 +16,16 @@ func NewRepository(db *sql.DB) *Repository {
 	return &Repository{db: db}
 }
 
+// UserIDByNickname also accepts a nickname the user has since changed, so
+// clients that still show the old one keep reaching the same person.
 func (r *Repository) UserIDByNickname(nickname string) (int64, error) {
 	var userID int64
-	err := r.db.QueryRow("SELECT id FROM users WHERE nickname = ?", nickname).Scan(&userID)
+	err := r.db.QueryRow(`
+		SELECT id FROM users
+		WHERE id = COALESCE(
+			(SELECT id FROM users WHERE nickname = ?),
+			(SELECT user_id FROM nickname_history WHERE old_nickname = ? ORDER BY changed_at DESC LIMIT 1)
+		) AND deleted_at IS NULL`, nickname, nickname).Scan(&userID)
 	return userID, err
 }
 
@@ -30,15 +43,15 @@ func (r *Repository) ListConversations(currentUserID int64) ([]Conversation, err
 				MAX(timestamp) AS last_interaction,
 				content
 			FROM messages
-			WHERE sender_id = ? OR receiver_id = ?
+			WHERE (sender_id = ? OR receiver_id = ?) AND hidden_at IS NULL
 			GROUP BY user_id
 		)
-		SELECT users.id, users.nickname,
+		SELECT users.id, users.nickname, COALESCE(users.avatar_key, ''),
 		       COALESCE(latest_interaction.content, ''),
 		       COALESCE(latest_interaction.last_interaction, '')
 		FROM users
 		LEFT JOIN latest_interaction ON latest_interaction.user_id = users.id
-		WHERE users.id != ?
+		WHERE users.id != ? AND users.deleted_at IS NULL
 		ORDER BY latest_interaction.last_interaction DESC, users.nickname`,
 		currentUserID, currentUserID, currentUserID, currentUserID)
 	if err != nil {
@@ -52,6 +65,7 @@ func (r *Repository) ListConversations(currentUserID int64) ([]Conversation, err
 		if err := rows.Scan(
 			&conversation.UserID,
 			&conversation.Nickname,
+			&conversation.AvatarKey,
 			&conversation.LastMessage,
 			&conversation.LastInteraction,
 		); err != nil {
@@ -90,6 +104,7 @@ func (r *Repository) ListHistory(from, to string, beforeID, offset int) ([]Messa
 			WHERE ((sender_id = (SELECT id FROM users WHERE nickname = ?) AND receiver_id = (SELECT id FROM users WHERE nickname = ?))
 			   OR (sender_id = (SELECT id FROM users WHERE nickname = ?) AND receiver_id = (SELECT id FROM users WHERE nickname = ?)))
 			  AND messages.id < ?
+			  AND messages.hidden_at IS NULL
 			ORDER BY messages.id DESC
 			LIMIT 10`, from, to, to, from, beforeID)
 	} else {
@@ -98,8 +113,9 @@ func (r *Repository) ListHistory(from, to string, beforeID, offset int) ([]Messa
 			FROM messages
 			JOIN users sender ON sender.id = messages.sender_id
 			JOIN users receiver ON receiver.id = messages.receiver_id
-			WHERE (sender_id = (SELECT id FROM users WHERE nickname = ?) AND receiver_id = (SELECT id FROM users WHERE nickname = ?))
-			   OR (sender_id = (SELECT id FROM users WHERE nickname = ?) AND receiver_id = (SELECT id FROM users WHERE nickname = ?))
+			WHERE ((sender_id = (SELECT id FROM users WHERE nickname = ?) AND receiver_id = (SELECT id FROM users WHERE nickname = ?))
+			   OR (sender_id = (SELECT id FROM users WHERE nickname = ?) AND receiver_id = (SELECT id FROM users WHERE nickname = ?)))
+			  AND messages.hidden_at IS NULL
 			ORDER BY messages.id DESC
 			LIMIT 10 OFFSET ?`, from, to, to, from, offset)
 	}
@@ -121,3 +137,23 @@ func (r *Repository) ListHistory(from, to string, beforeID, offset int) ([]Messa
 	}
 	return messages, nil
 }
+
+func (r *Repository) MessageParticipants(messageID int64) (int64, int64, error) {
+	var senderID, receiverID int64
+	err := r.db.QueryRow("SELECT sender_id, receiver_id FROM messages WHERE id = ?", messageID).Scan(&senderID, &receiverID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, ErrMessageNotFound
+	}
+	return senderID, receiverID, err
+}
+
+func (r *Repository) HideMessage(messageID int64, now time.Time) error {
+	result, err := r.db.Exec("UPDATE messages SET hidden_at = ? WHERE id = ?", now.UTC(), messageID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrMessageNotFound
+	}
+	return nil
+}
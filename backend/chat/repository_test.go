@@ -21,7 +21,8 @@ func TestListHistoryWithJoinedUsers(t *testing.T) {
 			sender_id INTEGER NOT NULL,
 			receiver_id INTEGER NOT NULL,
 			content TEXT NOT NULL,
-			timestamp TEXT NOT NULL
+			timestamp TEXT NOT NULL,
+			hidden_at DATETIME
 		);
 		INSERT INTO users (id, nickname) VALUES (1, 'User1'), (2, 'User2');
 		INSERT INTO messages (sender_id, receiver_id, content, timestamp)
@@ -52,13 +53,14 @@ func TestListConversationsUsesUserIDs(t *testing.T) {
 	defer db.Close()
 
 	_, err = db.Exec(`
-		CREATE TABLE users (id INTEGER PRIMARY KEY, nickname TEXT UNIQUE);
+		CREATE TABLE users (id INTEGER PRIMARY KEY, nickname TEXT UNIQUE, avatar_key TEXT, deleted_at DATETIME);
 		CREATE TABLE messages (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			sender_id INTEGER NOT NULL,
 			receiver_id INTEGER NOT NULL,
 			content TEXT NOT NULL,
-			timestamp TEXT NOT NULL
+			timestamp TEXT NOT NULL,
+			hidden_at DATETIME
 		);
 		INSERT INTO users (id, nickname) VALUES (1, 'alice'), (2, 'bob'), (3, 'carol');
 		INSERT INTO messages (sender_id, receiver_id, content, timestamp)
@@ -37,9 +37,21 @@ func (s *Service) SendMessage(senderID int64, receiver, content string) (Message
 		return Message{}, ErrInvalidContent
 	}
 	receiverID, err := s.repository.UserIDByNickname(receiver)
-	if err != nil {
+	if err != nil || receiverID == senderID {
 		return Message{}, ErrInvalidRecipient
 	}
+	receiver, err = s.repository.UserByID(receiverID)
+	if err != nil {
+		return Message{}, err
+	}
+
+	// A muted conversation, or messages turned off, still delivers and
+	// counts the message as unread. The delivery only tells the caller not
+	// to alert the receiver.
+	delivery, err := s.notifications.Delivery(receiverID, notification.TypeMessage, senderID, time.Now())
+	if err != nil {
+		return Message{}, err
+	}
 
 	tx, err := s.db.Begin()
 	if err != nil {
@@ -66,5 +78,6 @@ func (s *Service) SendMessage(senderID int64, receiver, content string) (Message
 	}
 
 	message.Content = content
+	message.Delivery = delivery
 	return message, nil
 }
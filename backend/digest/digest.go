@@ -0,0 +1,225 @@
+package digest
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"html"
+	"time"
+
+	"real-time-forum/backend/mention"
+	"real-time-forum/backend/notification"
+)
+
+// MaxItems caps how many notifications one digest lists; the rest are
+// summed up in Digest.More.
+const MaxItems = 20
+
+// Recipient is a user who has been away long enough to get a digest.
+// LastSeen and LastSent are zero when the user was never seen since the
+// digest was introduced, or never sent one.
+//
+// Nicknames, emails, and titles are stored HTML-escaped. Due and Compile
+// unescape them, so the digest holds plain text that the templates escape
+// once.
+type Recipient struct {
+	UserID   int64
+	Nickname string
+	Email    string
+	LastSeen time.Time
+	LastSent time.Time
+}
+
+// Since is the start of the period the digest covers: the last visit, or the
+// last digest when one was sent after it.
+func (r Recipient) Since() time.Time {
+	if r.LastSent.After(r.LastSeen) {
+		return r.LastSent
+	}
+	return r.LastSeen
+}
+
+// FirstOfAbsence reports whether no digest has been sent since the last
+// visit. Unread chat counts alone are only worth one email per absence.
+func (r Recipient) FirstOfAbsence() bool {
+	return r.LastSent.IsZero() || !r.LastSent.After(r.LastSeen)
+}
+
+// Conversation is the unread count of one chat partner.
+type Conversation struct {
+	Sender string
+	Count  int
+}
+
+// Item is one feed notification in the digest. PostTitle is empty when the
+// notification is not about a post or the post is gone.
+type Item struct {
+	ID         int64
+	Type       notification.Type
+	Actor      string
+	SourceType string
+	PostTitle  string
+	CreatedAt  time.Time
+}
+
+type Digest struct {
+	Recipient     Recipient
+	Conversations []Conversation
+	Items         []Item
+	More          int
+}
+
+func (d Digest) UnreadMessages() int {
+	total := 0
+	for _, conversation := range d.Conversations {
+		total += conversation.Count
+	}
+	return total
+}
+
+func (d Digest) Empty() bool {
+	return len(d.Conversations) == 0 && len(d.Items) == 0
+}
+
+type Repository struct {
+	db     *sql.DB
+	secret []byte
+}
+
+// NewRepository signs unsubscribe links with secret, so it must stay the
+// same across restarts for old emails to keep working.
+func NewRepository(db *sql.DB, secret []byte) *Repository {
+	return &Repository{db: db, secret: secret}
+}
+
+// Due lists the users with an email address who have not visited, and have
+// not been sent a digest, since cutoff and have not unsubscribed.
+func (r *Repository) Due(cutoff time.Time) ([]Recipient, error) {
+	rows, err := r.db.Query(`
+		SELECT users.id, users.nickname, users.email, users.last_seen_at, email_digests.last_sent_at
+		FROM users
+		LEFT JOIN email_digests ON email_digests.user_id = users.id
+		WHERE users.deleted_at IS NULL AND COALESCE(users.email, '') != ''
+		  AND email_digests.unsubscribed_at IS NULL
+		  AND (users.last_seen_at IS NULL OR users.last_seen_at <= ?)
+		  AND (email_digests.last_sent_at IS NULL OR email_digests.last_sent_at <= ?)
+		ORDER BY users.id`, cutoff.UTC(), cutoff.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []Recipient
+	for rows.Next() {
+		var recipient Recipient
+		var lastSeen, lastSent sql.NullTime
+		if err := rows.Scan(&recipient.UserID, &recipient.Nickname, &recipient.Email, &lastSeen, &lastSent); err != nil {
+			return nil, err
+		}
+		recipient.Nickname, recipient.Email = html.UnescapeString(recipient.Nickname), html.UnescapeString(recipient.Email)
+		recipient.LastSeen, recipient.LastSent = lastSeen.Time, lastSent.Time
+		recipients = append(recipients, recipient)
+	}
+	return recipients, rows.Err()
+}
+
+// Compile collects the recipient's unread chat counts and the unread replies
+// and mentions, plus any type they routed to the email digest, created since
+// Recipient.Since.
+func (r *Repository) Compile(recipient Recipient) (Digest, error) {
+	digest := Digest{Recipient: recipient}
+
+	rows, err := r.db.Query(`
+		SELECT users.nickname, notifications.unread_messages
+		FROM notifications
+		JOIN users ON users.id = notifications.sender_id
+		WHERE notifications.receiver_id = ? AND notifications.unread_messages > 0
+		  AND users.deleted_at IS NULL
+		ORDER BY notifications.unread_messages DESC, users.nickname`, recipient.UserID)
+	if err != nil {
+		return Digest{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var conversation Conversation
+		if err := rows.Scan(&conversation.Sender, &conversation.Count); err != nil {
+			return Digest{}, err
+		}
+		conversation.Sender = html.UnescapeString(conversation.Sender)
+		digest.Conversations = append(digest.Conversations, conversation)
+	}
+	if err := rows.Err(); err != nil {
+		return Digest{}, err
+	}
+
+	items, err := r.db.Query(`
+		SELECT user_notifications.id, user_notifications.type, COALESCE(actors.nickname, ''),
+		       user_notifications.payload, COALESCE(posts.title, ''), user_notifications.created_at
+		FROM user_notifications
+		LEFT JOIN users AS actors ON actors.id = user_notifications.actor_id
+		LEFT JOIN posts ON posts.id = json_extract(user_notifications.payload, '$.post_id')
+		WHERE user_notifications.user_id = ? AND user_notifications.read_at IS NULL
+		  AND user_notifications.created_at > ?
+		  AND (user_notifications.type IN (?, ?) OR user_notifications.channel = ?)
+		ORDER BY user_notifications.id`,
+		recipient.UserID, recipient.Since().UTC(),
+		notification.TypeReply, notification.TypeMention, notification.ChannelEmailDigest)
+	if err != nil {
+		return Digest{}, err
+	}
+	defer items.Close()
+	for items.Next() {
+		var item Item
+		var payload string
+		if err := items.Scan(&item.ID, &item.Type, &item.Actor, &payload, &item.PostTitle, &item.CreatedAt); err != nil {
+			return Digest{}, err
+		}
+		if len(digest.Items) == MaxItems {
+			digest.More++
+			continue
+		}
+		var source struct {
+			SourceType string `json:"source_type"`
+		}
+		if err := json.Unmarshal([]byte(payload), &source); err == nil {
+			item.SourceType = source.SourceType
+		}
+		item.Actor, item.PostTitle = html.UnescapeString(item.Actor), html.UnescapeString(item.PostTitle)
+		if item.SourceType == mention.SourceMessage {
+			item.PostTitle = ""
+		}
+		digest.Items = append(digest.Items, item)
+	}
+	return digest, items.Err()
+}
+
+// MarkSent starts the next digest period at now.
+func (r *Repository) MarkSent(userID int64, now time.Time) error {
+	_, err := r.db.Exec(`
+		INSERT INTO email_digests (user_id, last_sent_at) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET last_sent_at = excluded.last_sent_at`, userID, now.UTC())
+	return err
+}
+
+func (r *Repository) Subscribed(userID int64) (bool, error) {
+	var unsubscribedAt sql.NullTime
+	err := r.db.QueryRow("SELECT unsubscribed_at FROM email_digests WHERE user_id = ?", userID).Scan(&unsubscribedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !unsubscribedAt.Valid, nil
+}
+
+func (r *Repository) SetSubscribed(userID int64, subscribed bool, now time.Time) error {
+	var unsubscribedAt sql.NullTime
+	if !subscribed {
+		unsubscribedAt = sql.NullTime{Time: now.UTC(), Valid: true}
+	}
+	_, err := r.db.Exec(`
+		INSERT INTO email_digests (user_id, unsubscribed_at) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET unsubscribed_at = excluded.unsubscribed_at`, userID, unsubscribedAt)
+	return err
+}
@@ -0,0 +1,55 @@
+package digest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"real-time-forum/backend/mention"
+	"real-time-forum/backend/notification"
+)
+
+func TestRenderEscapesUserContentInTheHTMLPart(t *testing.T) {
+	digest := Digest{
+		Recipient:     Recipient{Nickname: "alice", Email: "alice@example.com"},
+		Conversations: []Conversation{{Sender: "bob", Count: 2}, {Sender: "carol", Count: 1}},
+		Items: []Item{
+			{Type: notification.TypeReply, Actor: "carol", PostTitle: "<b>Hello</b>"},
+			{Type: notification.TypeMention, Actor: "bob", SourceType: mention.SourceMessage},
+		},
+	}
+	message, err := Render(digest, "http://forum.test/", "http://forum.test/notifications/unsubscribe?token=1.abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.To != "alice@example.com" || message.Subject != "You have 3 unread messages and 2 new notifications on the forum" {
+		t.Fatalf("unexpected envelope: %q %q", message.To, message.Subject)
+	}
+	if !strings.Contains(message.Text, "carol replied to your comment on “<b>Hello</b>”") ||
+		!strings.Contains(message.Text, "bob mentioned you in a chat message") {
+		t.Fatalf("text part is missing items:\n%s", message.Text)
+	}
+	if strings.Contains(message.HTML, "<b>Hello</b>") || !strings.Contains(message.HTML, "&lt;b&gt;Hello&lt;/b&gt;") {
+		t.Fatalf("HTML part does not escape the post title:\n%s", message.HTML)
+	}
+	if !strings.Contains(message.HTML, `href="http://forum.test/notifications/unsubscribe?token=1.abc"`) {
+		t.Fatalf("HTML part is missing the unsubscribe link:\n%s", message.HTML)
+	}
+}
+
+func TestUnsubscribeTokensAreSignedPerUser(t *testing.T) {
+	repository := NewRepository(nil, []byte("test-key"))
+	alice, bob := repository.UnsubscribeToken(1), repository.UnsubscribeToken(2)
+	forged := "2" + alice[strings.Index(alice, "."):]
+	if forged == bob {
+		t.Fatal("users share a signature")
+	}
+	for _, token := range []string{forged, "1", "1.", "x." + alice[2:]} {
+		if _, err := repository.Unsubscribe(token, time.Now()); err != ErrInvalidUnsubscribeToken {
+			t.Fatalf("Unsubscribe(%q) = %v, want ErrInvalidUnsubscribeToken", token, err)
+		}
+	}
+	if other := NewRepository(nil, []byte("other-key")).UnsubscribeToken(1); other == alice {
+		t.Fatal("tokens do not depend on the key")
+	}
+}
@@ -0,0 +1,105 @@
+package digest
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"strconv"
+	texttemplate "text/template"
+
+	"real-time-forum/backend/mail"
+	"real-time-forum/backend/mention"
+	"real-time-forum/backend/notification"
+)
+
+//go:embed templates
+var templates embed.FS
+
+var (
+	htmlTemplate        = htmltemplate.Must(htmltemplate.ParseFS(templates, "templates/digest.html"))
+	unsubscribeTemplate = htmltemplate.Must(htmltemplate.ParseFS(templates, "templates/unsubscribe.html"))
+	textTemplate        = texttemplate.Must(texttemplate.ParseFS(templates, "templates/digest.txt"))
+)
+
+// Summary is the line an item gets in the email.
+func (i Item) Summary() string {
+	actor := i.Actor
+	if actor == "" {
+		actor = "Someone"
+	}
+	on := ""
+	if i.PostTitle != "" {
+		on = " on “" + i.PostTitle + "”"
+	}
+	switch i.Type {
+	case notification.TypePost:
+		return actor + " published a new post" + on
+	case notification.TypeReply:
+		return actor + " replied to your comment" + on
+	case notification.TypeComment:
+		return actor + " commented on your post" + on
+	case notification.TypeMention:
+		if i.SourceType == mention.SourceMessage {
+			return actor + " mentioned you in a chat message"
+		}
+		return actor + " mentioned you" + on
+	case notification.TypeModeration:
+		return "A moderator took action on your account"
+	}
+	return actor + " sent you a " + string(i.Type) + " notification"
+}
+
+// Render builds the email for a digest. The HTML part is rendered with
+// html/template, so nicknames and post titles are escaped.
+func Render(digest Digest, forumURL, unsubscribeURL string) (mail.Message, error) {
+	data := struct {
+		Digest         Digest
+		ForumURL       string
+		UnsubscribeURL string
+	}{digest, forumURL, unsubscribeURL}
+
+	var text, html bytes.Buffer
+	if err := textTemplate.Execute(&text, data); err != nil {
+		return mail.Message{}, fmt.Errorf("render digest text: %w", err)
+	}
+	if err := htmlTemplate.Execute(&html, data); err != nil {
+		return mail.Message{}, fmt.Errorf("render digest html: %w", err)
+	}
+	return mail.Message{
+		To:              digest.Recipient.Email,
+		Subject:         subject(digest),
+		Text:            text.String(),
+		HTML:            html.String(),
+		ListUnsubscribe: unsubscribeURL,
+	}, nil
+}
+
+// RenderUnsubscribePage writes the page the unsubscribe link opens. Until
+// done it only asks for confirmation with a form that posts to actionURL,
+// so mail scanners that fetch the link do not unsubscribe anyone.
+func RenderUnsubscribePage(w io.Writer, actionURL string, done bool) error {
+	return unsubscribeTemplate.Execute(w, struct {
+		ActionURL string
+		Done      bool
+	}{actionURL, done})
+}
+
+func subject(digest Digest) string {
+	messages, items := digest.UnreadMessages(), len(digest.Items)+digest.More
+	switch {
+	case messages > 0 && items > 0:
+		return fmt.Sprintf("You have %s and %s on the forum", plural(messages, "unread message"), plural(items, "new notification"))
+	case messages > 0:
+		return fmt.Sprintf("You have %s on the forum", plural(messages, "unread message"))
+	}
+	return fmt.Sprintf("You have %s on the forum", plural(items, "new notification"))
+}
+
+func plural(count int, noun string) string {
+	if count == 1 {
+		return "1 " + noun
+	}
+	return strconv.Itoa(count) + " " + noun + "s"
+}
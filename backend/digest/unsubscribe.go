@@ -0,0 +1,50 @@
+package digest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidUnsubscribeToken = errors.New("unsubscribe link is invalid")
+
+// UnsubscribeToken returns the token of the user's unsubscribe link, of the
+// form id.signature. It does not expire, so that the link in an old digest
+// still works, and it needs no session, so that it works from any mail
+// client.
+func (r *Repository) UnsubscribeToken(userID int64) string {
+	id := strconv.FormatInt(userID, 10)
+	return id + "." + r.sign(id)
+}
+
+// CheckUnsubscribeToken returns the user the token was issued to.
+func (r *Repository) CheckUnsubscribeToken(token string) (int64, error) {
+	id, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(r.sign(id))) {
+		return 0, ErrInvalidUnsubscribeToken
+	}
+	userID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidUnsubscribeToken
+	}
+	return userID, nil
+}
+
+// Unsubscribe turns the digest off for the user the token was issued to.
+func (r *Repository) Unsubscribe(token string, now time.Time) (int64, error) {
+	userID, err := r.CheckUnsubscribeToken(token)
+	if err != nil {
+		return 0, err
+	}
+	return userID, r.SetSubscribed(userID, false, now)
+}
+
+func (r *Repository) sign(id string) string {
+	mac := hmac.New(sha256.New, r.secret)
+	mac.Write([]byte("digest-unsubscribe:" + id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,128 @@
+package backend
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"real-time-forum/backend/digest"
+	"real-time-forum/backend/notification"
+)
+
+const (
+	// digestInterval is how often the job looks for users due a digest.
+	digestInterval = time.Hour
+	// digestAfter is how long a user must be away before the first digest,
+	// and the least time between two digests.
+	digestAfter = 24 * time.Hour
+)
+
+// runEmailDigests sends the email digests that are due, once at startup and
+// then every digestInterval until stop is closed.
+func (S *Server) runEmailDigests(stop <-chan struct{}) {
+	ticker := time.NewTicker(digestInterval)
+	defer ticker.Stop()
+	for {
+		S.sendEmailDigests(time.Now())
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// sendEmailDigests emails every user who has been away for digestAfter a
+// summary of what they missed. A digest goes out when there are new replies
+// or mentions since the last visit or digest, or, once per absence, when
+// there are only unread messages. Suspended users get none, and neither do
+// users with a tab open: last_seen_at only moves when a connection opens or
+// closes, so it looks stale for someone who has stayed connected all day.
+func (S *Server) sendEmailDigests(now time.Time) {
+	if S.digests == nil || S.mailer == nil {
+		return
+	}
+	recipients, err := S.digests.Due(now.Add(-digestAfter))
+	if err != nil {
+		log.Printf("failed to list users due an email digest: %v", err)
+		return
+	}
+	for _, recipient := range recipients {
+		if (S.hub != nil && len(S.hub.ClientsForUser(recipient.UserID)) > 0) || S.mentionBlocked(recipient.UserID, now) {
+			continue
+		}
+		compiled, err := S.digests.Compile(recipient)
+		if err != nil {
+			log.Printf("failed to compile the email digest of user %d: %v", recipient.UserID, err)
+			continue
+		}
+		if S.notifications != nil {
+			delivery, err := S.notifications.Delivery(recipient.UserID, notification.TypeMessage, 0, now)
+			if err == nil && delivery.Channel == notification.ChannelOff {
+				compiled.Conversations = nil
+			}
+		}
+		if len(compiled.Items) == 0 && (len(compiled.Conversations) == 0 || !recipient.FirstOfAbsence()) {
+			continue
+		}
+
+		unsubscribeURL := S.config.PublicURL + "/notifications/unsubscribe?token=" +
+			url.QueryEscape(S.digests.UnsubscribeToken(recipient.UserID))
+		message, err := digest.Render(compiled, S.config.PublicURL+"/", unsubscribeURL)
+		if err != nil {
+			log.Printf("failed to render the email digest of user %d: %v", recipient.UserID, err)
+			continue
+		}
+		if err := S.mailer.Send(message); err != nil {
+			log.Printf("failed to send the email digest of user %d: %v", recipient.UserID, err)
+			continue
+		}
+		if err := S.digests.MarkSent(recipient.UserID, now); err != nil {
+			log.Printf("failed to record the email digest of user %d: %v", recipient.UserID, err)
+		}
+	}
+}
+
+// UnsubscribeDigestHandler turns the email digest off from the signed link
+// in the email. It needs no session so it works from any mail client;
+// signing back in and enabling email_digest in the notification preferences
+// turns it on again. A GET only shows a confirmation form, because mail
+// scanners and link previews fetch links; the POST from that form or from
+// a mail client's RFC 8058 one-click button unsubscribes.
+func (S *Server) UnsubscribeDigestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "Missing token", http.StatusBadRequest)
+		return
+	}
+	if S.digests == nil {
+		http.Error(w, "Digest repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	var err error
+	if r.Method == http.MethodPost {
+		_, err = S.digests.Unsubscribe(token, time.Now())
+	} else {
+		_, err = S.digests.CheckUnsubscribeToken(token)
+	}
+	if errors.Is(err, digest.ErrInvalidUnsubscribeToken) {
+		http.Error(w, "Unsubscribe link is invalid", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	actionURL := "/notifications/unsubscribe?token=" + url.QueryEscape(token)
+	if err := digest.RenderUnsubscribePage(w, actionURL, r.Method == http.MethodPost); err != nil {
+		log.Printf("failed to render the unsubscribe page: %v", err)
+	}
+}
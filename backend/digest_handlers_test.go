@@ -0,0 +1,143 @@
+package backend
+
+import (
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"real-time-forum/backend/chat"
+	"real-time-forum/backend/digest"
+	"real-time-forum/backend/notification"
+)
+
+func TestEmailDigestSummarizesUnreadActivityUntilUnsubscribed(t *testing.T) {
+	server := newModerationTestServer(t)
+	mailer := server.mailer.(*recordingMailer)
+	server.chat = chat.NewRepository(server.db)
+	server.notifications = notification.NewRepository(server.db)
+	server.chatService = chat.NewService(server.db, server.chat, server.notifications)
+	server.digests = digest.NewRepository(server.db, []byte("test-key"))
+
+	lastVisit := time.Now().Add(-48 * time.Hour)
+	for userID := int64(1); userID <= 3; userID++ {
+		if err := server.users.MarkSeen(userID, lastVisit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bob := &Client{ID: "bob-client", UserID: 2, Username: "bob", Send: make(chan interface{}, 8)}
+	server.hub.Register(bob)
+	server.handleChatMessage(bob, Message{To: "alice", Content: "hello", Type: "chat_message"})
+	server.handleChatMessage(bob, Message{To: "alice", Content: "still there?", Type: "chat_message"})
+	server.notify(1, notification.TypeReply, 3, map[string]interface{}{"post_id": 1, "comment_id": 2})
+
+	now := time.Now()
+	server.sendEmailDigests(now)
+	if len(mailer.messages) != 1 {
+		t.Fatalf("sent %d digests, want one for alice", len(mailer.messages))
+	}
+	message := mailer.messages[0]
+	if message.To != "alice@example.com" || !strings.Contains(message.Text, "bob: 2") ||
+		!strings.Contains(message.Text, "carol replied to your comment on “Hello”") {
+		t.Fatalf("unexpected digest to %s:\n%s", message.To, message.Text)
+	}
+
+	server.sendEmailDigests(now.Add(time.Hour))
+	server.sendEmailDigests(now.Add(25 * time.Hour))
+	if len(mailer.messages) != 1 {
+		t.Fatal("unread messages alone should be sent once per absence")
+	}
+
+	link, err := url.Parse(strings.TrimSpace(message.Text[strings.Index(message.Text, "http://forum.test/notifications/unsubscribe"):]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := link.Query().Get("token")
+	recorder := httptest.NewRecorder()
+	server.UnsubscribeDigestHandler(recorder, httptest.NewRequest(http.MethodGet, "/notifications/unsubscribe?token=2"+token[1:], nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("another user's signature got %d, want 400", recorder.Code)
+	}
+	if message.ListUnsubscribe != link.String() {
+		t.Fatalf("List-Unsubscribe is %q, want the link in the body", message.ListUnsubscribe)
+	}
+	recorder = httptest.NewRecorder()
+	server.UnsubscribeDigestHandler(recorder, httptest.NewRequest(http.MethodGet, "/notifications/unsubscribe?token="+url.QueryEscape(token), nil))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `method="post"`) {
+		t.Fatalf("opening the link got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if subscribed, err := server.digests.Subscribed(1); err != nil || !subscribed {
+		t.Fatalf("opening the link unsubscribed alice (%v)", err)
+	}
+	recorder = httptest.NewRecorder()
+	server.UnsubscribeDigestHandler(recorder, httptest.NewRequest(http.MethodPost, "/notifications/unsubscribe?token="+url.QueryEscape(token),
+		strings.NewReader("List-Unsubscribe=One-Click")))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("unsubscribe got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	server.notify(1, notification.TypeMention, 3, map[string]interface{}{"post_id": 1})
+	server.sendEmailDigests(now.Add(49 * time.Hour))
+	if len(mailer.messages) != 1 {
+		t.Fatal("an unsubscribed user should get no digest")
+	}
+}
+
+func TestEmailDigestShowsStoredTitlesEscapedOnce(t *testing.T) {
+	server := newModerationTestServer(t)
+	mailer := server.mailer.(*recordingMailer)
+	server.notifications = notification.NewRepository(server.db)
+	server.digests = digest.NewRepository(server.db, []byte("test-key"))
+	if err := server.users.MarkSeen(1, time.Now().Add(-48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// Titles are stored escaped, as CreatePostHandler saves them.
+	postID, err := server.forum.CreatePost(2, html.EscapeString("Q&A: <tags> in posts"), "Content", "general")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.notify(1, notification.TypeReply, 3, map[string]interface{}{"post_id": postID, "comment_id": 1})
+
+	server.sendEmailDigests(time.Now())
+	if len(mailer.messages) != 1 {
+		t.Fatalf("sent %d digests, want one for alice", len(mailer.messages))
+	}
+	message := mailer.messages[0]
+	if !strings.Contains(message.Text, "carol replied to your comment on “Q&A: <tags> in posts”") {
+		t.Fatalf("text part does not show the plain title:\n%s", message.Text)
+	}
+	if !strings.Contains(message.HTML, "“Q&amp;A: &lt;tags&gt; in posts”") || strings.Contains(message.HTML, "&amp;amp;") {
+		t.Fatalf("HTML part does not escape the title exactly once:\n%s", message.HTML)
+	}
+}
+
+func TestEmailDigestSkipsConnectedUsers(t *testing.T) {
+	server := newModerationTestServer(t)
+	mailer := server.mailer.(*recordingMailer)
+	server.notifications = notification.NewRepository(server.db)
+	server.digests = digest.NewRepository(server.db, []byte("test-key"))
+	if err := server.users.MarkSeen(1, time.Now().Add(-48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	server.notify(1, notification.TypeReply, 3, map[string]interface{}{"post_id": 1, "comment_id": 2})
+
+	// alice connected two days ago and never closed the tab.
+	alice := &Client{ID: "alice-client", UserID: 1, Username: "alice", Send: make(chan interface{}, 8)}
+	server.hub.Register(alice)
+	server.sendEmailDigests(time.Now())
+	if len(mailer.messages) != 0 {
+		t.Fatalf("sent %d digests to a connected user", len(mailer.messages))
+	}
+
+	server.hub.Unregister(alice)
+	if err := server.users.MarkSeen(1, time.Now().Add(-48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	server.sendEmailDigests(time.Now())
+	if len(mailer.messages) != 1 {
+		t.Fatalf("sent %d digests after alice left, want 1", len(mailer.messages))
+	}
+}
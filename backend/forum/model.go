@@ -1,18 +1,39 @@
 package forum
 
+import "real-time-forum/backend/mention"
+
 type Post struct {
-	ID        int    `json:"id"`
-	Title     string `json:"title"`
-	Content   string `json:"content"`
-	Category  string `json:"category"`
-	CreatedAt string `json:"created_at"`
-	Author    string `json:"author"`
+	ID        int            `json:"id"`
+	Title     string         `json:"title"`
+	Content   string         `json:"content"`
+	Category  string         `json:"category"`
+	CreatedAt string         `json:"created_at"`
+	Author    string         `json:"author"`
+	AvatarKey string         `json:"-"`
+	Avatar    string         `json:"author_avatar,omitempty"`
+	Mentions  []mention.Span `json:"mentions,omitempty"`
+	// CommentCount, ReaderCount, and ViewCount are totals; ReaderCount
+	// counts the distinct members who opened the post and ViewCount how
+	// often it was opened. UnreadComments and Unopened are for the user who
+	// asked for the listing; Unopened means they never opened the post.
+	CommentCount   int    `json:"comment_count"`
+	ReaderCount    int    `json:"reader_count"`
+	ViewCount      int    `json:"view_count"`
+	UnreadComments int    `json:"unread_comments"`
+	Unopened       bool   `json:"unopened"`
+	LastActivityAt string `json:"last_activity_at"`
+	// Bookmarked is set for the user who asked for the listing.
+	Bookmarked bool `json:"bookmarked"`
 }
 
 type Comment struct {
-	ID        int    `json:"id"`
-	PostID    int    `json:"post_id"`
-	Content   string `json:"content"`
-	CreatedAt string `json:"created_at"`
-	Author    string `json:"author"`
+	ID        int            `json:"id"`
+	PostID    int            `json:"post_id"`
+	ParentID  int            `json:"parent_id,omitempty"`
+	Content   string         `json:"content"`
+	CreatedAt string         `json:"created_at"`
+	Author    string         `json:"author"`
+	AvatarKey string         `json:"-"`
+	Avatar    string         `json:"author_avatar,omitempty"`
+	Mentions  []mention.Span `json:"mentions,omitempty"`
 }
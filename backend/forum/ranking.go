@@ -0,0 +1,55 @@
+package forum
+
+import "database/sql"
+
+// Feed rankings read scores cached on the posts row, so sorting needs no
+// aggregation. refreshScores recomputes them, and the time of the latest
+// visible comment, whenever a post is created, a comment is added, hidden,
+// or deleted, or the post gains a reader.
+//
+// Engagement is the number of distinct commenters plus the number of
+// readers, so a post needs attention from many people rather than many
+// comments from a few; reactions will add to it once posts have them.
+// top_score is the engagement itself. hot_score follows the usual
+// log-plus-age formula: every tenfold increase in engagement is worth
+// hotGravity seconds of recency. A newer post therefore outranks an older
+// one with the same engagement, which decays old posts without rescoring
+// them as time passes.
+const (
+	// hotEpoch is 2024-01-01 UTC, keeping scores small.
+	hotEpoch   = 1704067200
+	hotGravity = 45000.0
+)
+
+// The counts are refreshed first, because the right-hand side of an UPDATE
+// sees the row as it was before the statement.
+const refreshCountsQuery = `
+	UPDATE posts
+	SET comment_count = (SELECT COUNT(*) FROM comments
+	                     WHERE comments.post_id = posts.id AND comments.hidden_at IS NULL),
+	    commenter_count = (SELECT COUNT(DISTINCT comments.user_id) FROM comments
+	                       WHERE comments.post_id = posts.id AND comments.hidden_at IS NULL),
+	    last_activity_at = strftime('%Y-%m-%dT%H:%M:%SZ', COALESCE(
+	        (SELECT MAX(comments.created_at) FROM comments
+	         WHERE comments.post_id = posts.id AND comments.hidden_at IS NULL),
+	        posts.created_at))
+	WHERE posts.id = ?`
+
+const refreshScoresQuery = `
+	UPDATE posts
+	SET top_score = commenter_count + reader_count,
+	    hot_score = log10(max(commenter_count + reader_count, 1))
+	                + (unixepoch(created_at) - ?) / ?
+	WHERE id = ?`
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func refreshScores(db execer, postID int64) error {
+	if _, err := db.Exec(refreshCountsQuery, postID); err != nil {
+		return err
+	}
+	_, err := db.Exec(refreshScoresQuery, hotEpoch, hotGravity, postID)
+	return err
+}
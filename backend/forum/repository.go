@@ -4,10 +4,52 @@ import (
 	"database/sql"
 	"errors"
 	"strings"
+	"time"
 )
 
 var ErrPostNotFound = errors.New("post not found")
+var ErrCommentNotFound = errors.New("comment not found")
 var ErrInvalidPost = errors.New("post title, content, and category are required")
+var ErrInvalidSort = errors.New("unknown post sort order")
+
+// Orders ListPosts can sort by. SortActivity puts the posts with the latest
+// comment first; a post without comments counts from its creation. SortHot,
+// SortTop, and SortDiscussed use the cached scores described in ranking.go:
+// hot is engagement weighed against age, top is total engagement, and
+// discussed is the number of distinct commenters.
+const (
+	SortNewest    = "newest"
+	SortActivity  = "activity"
+	SortHot       = "hot"
+	SortTop       = "top"
+	SortDiscussed = "discussed"
+)
+
+var sortOrders = map[string]string{
+	SortNewest:    "posts.created_at DESC, posts.id DESC",
+	SortActivity:  "posts.last_activity_at DESC, posts.id DESC",
+	SortHot:       "posts.hot_score DESC, posts.id DESC",
+	SortTop:       "posts.top_score DESC, posts.created_at DESC, posts.id DESC",
+	SortDiscussed: "posts.commenter_count DESC, posts.comment_count DESC, posts.id DESC",
+}
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+// ListOptions selects the order of ListPosts and whose read state is
+// returned. ViewerID 0 leaves the per-user fields empty. A non-zero Since
+// keeps only posts created from then on, for rankings over a period. Limit
+// and Offset select a page; a zero Limit returns the first 50 posts and at
+// most 200 are returned at once.
+type ListOptions struct {
+	ViewerID int64
+	Sort     string
+	Since    time.Time
+	Limit    int
+	Offset   int
+}
 
 type Repository struct {
 	db *sql.DB
@@ -17,31 +59,83 @@ func NewRepository(db *sql.DB) *Repository {
 	return &Repository{db: db}
 }
 
-func (r *Repository) CreatePost(userNickname, title, content, category string) error {
-	if strings.TrimSpace(userNickname) == "" || strings.TrimSpace(title) == "" ||
+// CreatePost and CreateComment take the author's ID rather than nickname,
+// which can change between the session lookup and the insert.
+func (r *Repository) CreatePost(userID int64, title, content, category string) (int64, error) {
+	if userID < 1 || strings.TrimSpace(title) == "" ||
 		strings.TrimSpace(content) == "" || strings.TrimSpace(category) == "" {
-		return ErrInvalidPost
+		return 0, ErrInvalidPost
 	}
 
-	var userID int64
-	if err := r.db.QueryRow("SELECT id FROM users WHERE nickname = ?", userNickname).Scan(&userID); err != nil {
-		return err
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
 	}
+	defer tx.Rollback()
 
-	_, err := r.db.Exec(`
+	result, err := tx.Exec(`
 		INSERT INTO posts (user_id, title, content, category)
 		VALUES (?, ?, ?, ?)`,
 		userID, title, content, category)
-	return err
+	if err != nil {
+		return 0, err
+	}
+	postID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := refreshScores(tx, postID); err != nil {
+		return 0, err
+	}
+	return postID, tx.Commit()
 }
 
-func (r *Repository) ListPosts() ([]Post, error) {
+// ListPosts returns a page of the visible posts with their comment and reader
+// counts. For a viewer it also counts the comments by others they have not
+// read and marks the posts they never opened as unopened. The page is selected
+// before the read state is looked up, so only its posts are counted.
+func (r *Repository) ListPosts(options ListOptions) ([]Post, error) {
+	if options.Sort == "" {
+		options.Sort = SortNewest
+	}
+	order, ok := sortOrders[options.Sort]
+	if !ok {
+		return nil, ErrInvalidSort
+	}
+	if options.Limit <= 0 {
+		options.Limit = defaultListLimit
+	}
+	if options.Limit > maxListLimit {
+		options.Limit = maxListLimit
+	}
+	if options.Offset < 0 {
+		options.Offset = 0
+	}
+	var since int64
+	if !options.Since.IsZero() {
+		since = options.Since.Unix()
+	}
 	rows, err := r.db.Query(`
 		SELECT posts.id, posts.title, posts.content, posts.category,
-		       posts.created_at, users.nickname
-		FROM posts
-		JOIN users ON posts.user_id = users.id
-		ORDER BY posts.created_at DESC`)
+		       posts.created_at, posts.user_id, posts.nickname, posts.avatar_key,
+		       posts.comment_count, posts.reader_count, posts.view_count,
+		       post_reads.post_id IS NOT NULL,
+		       (SELECT COUNT(*) FROM comments
+		        WHERE ? > 0 AND comments.post_id = posts.id AND comments.hidden_at IS NULL
+		          AND comments.user_id != ? AND comments.id > COALESCE(post_reads.last_read_comment_id, 0)),
+		       posts.last_activity_at
+		FROM (
+			SELECT posts.*, users.nickname, COALESCE(users.avatar_key, '') AS avatar_key
+			FROM posts
+			JOIN users ON posts.user_id = users.id
+			WHERE posts.hidden_at IS NULL
+			  AND (? = 0 OR unixepoch(posts.created_at) >= ?)
+			ORDER BY `+order+`
+			LIMIT ? OFFSET ?
+		) AS posts
+		LEFT JOIN post_reads ON post_reads.post_id = posts.id AND post_reads.user_id = ?
+		ORDER BY `+order,
+		options.ViewerID, options.ViewerID, since, since, options.Limit, options.Offset, options.ViewerID)
 	if err != nil {
 		return nil, err
 	}
@@ -50,9 +144,14 @@ func (r *Repository) ListPosts() ([]Post, error) {
 	var posts []Post
 	for rows.Next() {
 		var post Post
-		if err := rows.Scan(&post.ID, &post.Title, &post.Content, &post.Category, &post.CreatedAt, &post.Author); err != nil {
+		var authorID int64
+		var read bool
+		if err := rows.Scan(&post.ID, &post.Title, &post.Content, &post.Category, &post.CreatedAt,
+			&authorID, &post.Author, &post.AvatarKey, &post.CommentCount, &post.ReaderCount,
+			&post.ViewCount, &read, &post.UnreadComments, &post.LastActivityAt); err != nil {
 			return nil, err
 		}
+		post.Unopened = options.ViewerID > 0 && !read && authorID != options.ViewerID
 		posts = append(posts, post)
 	}
 	if err := rows.Err(); err != nil {
@@ -61,29 +160,98 @@ func (r *Repository) ListPosts() ([]Post, error) {
 	return posts, nil
 }
 
-func (r *Repository) CreateComment(postID int, userNickname, content string) error {
+// AuthorStats returns how many posts and comments the user has written.
+func (r *Repository) AuthorStats(userID int64) (int, int, error) {
+	var posts, comments int
+	err := r.db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM posts WHERE user_id = ? AND hidden_at IS NULL),
+		       (SELECT COUNT(*) FROM comments WHERE user_id = ? AND hidden_at IS NULL)`, userID, userID).Scan(&posts, &comments)
+	return posts, comments, err
+}
+
+func (r *Repository) ListPostsByUser(userID int64, limit int) ([]Post, error) {
+	rows, err := r.db.Query(`
+		SELECT posts.id, posts.title, posts.content, posts.category,
+		       posts.created_at, users.nickname, COALESCE(users.avatar_key, '')
+		FROM posts
+		JOIN users ON posts.user_id = users.id
+		WHERE posts.user_id = ? AND posts.hidden_at IS NULL
+		ORDER BY posts.created_at DESC
+		LIMIT ?`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		var post Post
+		if err := rows.Scan(&post.ID, &post.Title, &post.Content, &post.Category, &post.CreatedAt, &post.Author, &post.AvatarKey); err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+// CreateComment returns the new comment's ID. parentID is 0 for a top-level
+// comment; otherwise it must be a visible comment on the same post.
+func (r *Repository) CreateComment(postID, parentID int, userID int64, content string) (int64, error) {
 	var exists int
-	if err := r.db.QueryRow("SELECT COUNT(*) FROM posts WHERE id = ?", postID).Scan(&exists); err != nil {
-		return err
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM posts WHERE id = ? AND hidden_at IS NULL", postID).Scan(&exists); err != nil {
+		return 0, err
 	}
 	if exists == 0 {
-		return ErrPostNotFound
+		return 0, ErrPostNotFound
+	}
+
+	var parent sql.NullInt64
+	if parentID != 0 {
+		err := r.db.QueryRow(`
+			SELECT COUNT(*) FROM comments
+			WHERE id = ? AND post_id = ? AND hidden_at IS NULL`, parentID, postID).Scan(&exists)
+		if err != nil {
+			return 0, err
+		}
+		if exists == 0 {
+			return 0, ErrCommentNotFound
+		}
+		parent = sql.NullInt64{Int64: int64(parentID), Valid: true}
 	}
 
-	_, err := r.db.Exec(`
-		INSERT INTO comments (post_id, user_id, content)
-		VALUES (?, (SELECT id FROM users WHERE nickname = ?), ?)`,
-		postID, userNickname, content)
-	return err
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO comments (post_id, parent_id, user_id, content)
+		VALUES (?, ?, ?, ?)`,
+		postID, parent, userID, content)
+	if err != nil {
+		return 0, err
+	}
+	commentID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := refreshScores(tx, int64(postID)); err != nil {
+		return 0, err
+	}
+	return commentID, tx.Commit()
 }
 
+// ListComments returns the visible comments of a post. A hidden post has
+// none, so hiding a post also hides its comments.
 func (r *Repository) ListComments(postID string) ([]Comment, error) {
 	rows, err := r.db.Query(`
-		SELECT comments.id, comments.post_id, comments.content,
-		       comments.created_at, users.nickname
+		SELECT comments.id, comments.post_id, COALESCE(comments.parent_id, 0), comments.content,
+		       comments.created_at, users.nickname, COALESCE(users.avatar_key, '')
 		FROM comments
+		JOIN posts ON comments.post_id = posts.id AND posts.hidden_at IS NULL
 		JOIN users ON comments.user_id = users.id
-		WHERE comments.post_id = ?
+		WHERE comments.post_id = ? AND comments.hidden_at IS NULL
 		ORDER BY comments.created_at ASC`, postID)
 	if err != nil {
 		return nil, err
@@ -93,7 +261,7 @@ func (r *Repository) ListComments(postID string) ([]Comment, error) {
 	var comments []Comment
 	for rows.Next() {
 		var comment Comment
-		if err := rows.Scan(&comment.ID, &comment.PostID, &comment.Content, &comment.CreatedAt, &comment.Author); err != nil {
+		if err := rows.Scan(&comment.ID, &comment.PostID, &comment.ParentID, &comment.Content, &comment.CreatedAt, &comment.Author, &comment.AvatarKey); err != nil {
 			return nil, err
 		}
 		comments = append(comments, comment)
@@ -103,3 +271,196 @@ func (r *Repository) ListComments(postID string) ([]Comment, error) {
 	}
 	return comments, nil
 }
+
+func (r *Repository) PostAuthorID(postID int) (int64, error) {
+	var userID int64
+	err := r.db.QueryRow("SELECT user_id FROM posts WHERE id = ?", postID).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrPostNotFound
+	}
+	return userID, err
+}
+
+func (r *Repository) PostTitle(postID int) (string, error) {
+	var title string
+	err := r.db.QueryRow("SELECT title FROM posts WHERE id = ?", postID).Scan(&title)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrPostNotFound
+	}
+	return title, err
+}
+
+func (r *Repository) CommentAuthorID(commentID int) (int64, error) {
+	var userID int64
+	err := r.db.QueryRow("SELECT user_id FROM comments WHERE id = ?", commentID).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrCommentNotFound
+	}
+	return userID, err
+}
+
+// DeletePost removes a post together with its comments.
+func (r *Repository) DeletePost(postID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteComments(tx, "post_id = ?", postID); err != nil {
+		return err
+	}
+	for _, statement := range []string{
+		"DELETE FROM reports WHERE status = 'open' AND target_type = 'post' AND target_id = ?",
+		"DELETE FROM user_notifications WHERE type != 'moderation' AND json_extract(payload, '$.post_id') = ?",
+		"DELETE FROM mentions WHERE source_type = 'post' AND source_id = ?",
+		"DELETE FROM subscriptions WHERE target_type = 'post' AND target_id = ?",
+		"DELETE FROM bookmarks WHERE target_type = 'post' AND target_id = ?",
+		"DELETE FROM post_reads WHERE post_id = ?",
+	} {
+		if _, err := tx.Exec(statement, postID); err != nil {
+			return err
+		}
+	}
+	result, err := tx.Exec("DELETE FROM posts WHERE id = ?", postID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrPostNotFound
+	}
+	return tx.Commit()
+}
+
+// deleteComments deletes the comments matching where, together with the
+// rows that refer to them. Replies that are not deleted with them become
+// top-level comments.
+func deleteComments(tx *sql.Tx, where string, arg interface{}) error {
+	selected := "SELECT id FROM comments WHERE " + where
+	for _, statement := range []string{
+		"DELETE FROM reports WHERE status = 'open' AND target_type = 'comment' AND target_id IN (" + selected + ")",
+		"DELETE FROM user_notifications WHERE type != 'moderation' AND json_extract(payload, '$.comment_id') IN (" + selected + ")",
+		"DELETE FROM mentions WHERE source_type = 'comment' AND source_id IN (" + selected + ")",
+		"DELETE FROM bookmarks WHERE target_type = 'comment' AND target_id IN (" + selected + ")",
+		"UPDATE comments SET parent_id = NULL WHERE parent_id IN (" + selected + ")",
+		"DELETE FROM comments WHERE " + where,
+	} {
+		if _, err := tx.Exec(statement, arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HidePost removes a post from listings without deleting it, so moderators
+// keep the evidence behind a report.
+func (r *Repository) HidePost(postID int, now time.Time) error {
+	result, err := r.db.Exec("UPDATE posts SET hidden_at = ? WHERE id = ?", now.UTC(), postID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrPostNotFound
+	}
+	return nil
+}
+
+func (r *Repository) HideComment(commentID int, now time.Time) error {
+	return r.changeComment(commentID, func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE comments SET hidden_at = ? WHERE id = ?", now.UTC(), commentID)
+		return err
+	})
+}
+
+func (r *Repository) DeleteComment(commentID int) error {
+	return r.changeComment(commentID, func(tx *sql.Tx) error {
+		return deleteComments(tx, "id = ?", commentID)
+	})
+}
+
+// changeComment runs change on a comment and refreshes the cached scores of
+// its post in the same transaction.
+func (r *Repository) changeComment(commentID int, change func(tx *sql.Tx) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var postID int64
+	err = tx.QueryRow("SELECT post_id FROM comments WHERE id = ?", commentID).Scan(&postID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrCommentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := change(tx); err != nil {
+		return err
+	}
+	if err := refreshScores(tx, postID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MarkRead records that the user opened the post and read its comments up to
+// commentID, or up to the latest comment when commentID is 0. The read
+// position never moves back. The post's reader count, and with it its
+// scores, grows the first time each user opens it, and opened counts a view; it is false when comments of
+// a post that is already open are reloaded.
+func (r *Repository) MarkRead(userID int64, postID int, commentID int64, opened bool, now time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var latest sql.NullInt64
+	err = tx.QueryRow(`
+		SELECT (SELECT MAX(id) FROM comments WHERE post_id = posts.id)
+		FROM posts
+		WHERE id = ? AND hidden_at IS NULL`, postID).Scan(&latest)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrPostNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if commentID <= 0 || commentID > latest.Int64 {
+		commentID = latest.Int64
+	}
+	result, err := tx.Exec(`
+		INSERT INTO post_reads (user_id, post_id, last_read_comment_id, read_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id, post_id) DO NOTHING`,
+		userID, postID, commentID, now.UTC())
+	if err != nil {
+		return err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted > 0 {
+		_, err = tx.Exec("UPDATE posts SET reader_count = reader_count + 1 WHERE id = ?", postID)
+		if err == nil {
+			err = refreshScores(tx, int64(postID))
+		}
+	} else {
+		_, err = tx.Exec(`
+			UPDATE post_reads
+			SET last_read_comment_id = MAX(last_read_comment_id, ?), read_at = ?
+			WHERE user_id = ? AND post_id = ?`,
+			commentID, now.UTC(), userID, postID)
+	}
+	if err != nil {
+		return err
+	}
+	if opened {
+		if _, err := tx.Exec("UPDATE posts SET view_count = view_count + 1 WHERE id = ?", postID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -2,14 +2,19 @@ package backend
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"html"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"real-time-forum/backend/account"
+	"real-time-forum/backend/audit"
 	"real-time-forum/backend/forum"
+	"real-time-forum/backend/mention"
 )
 
 func (S *Server) GetNotifications(w http.ResponseWriter, r *http.Request) {
@@ -63,11 +68,16 @@ func (S *Server) MarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Notification repository is not initialized", http.StatusInternalServerError)
 		return
 	}
-	err = S.notifications.MarkRead(identity.UserID, request.Sender)
+	cleared, err := S.notifications.MarkRead(identity.UserID, request.Sender)
 	if err != nil {
 		http.Error(w, "Failed to mark notifications as read", http.StatusInternalServerError)
 		return
 	}
+	if cleared && S.users != nil {
+		if senderID, sender, err := S.users.ResolveNickname(request.Sender); err == nil {
+			S.pushUnread(identity.UserID, senderID, sender)
+		}
+	}
 
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
@@ -98,8 +108,7 @@ func (S *Server) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !isValidPassword(user.Password) {
-		http.Error(w, "Password must be at least 8 characters with uppercase, lowercase, and number", http.StatusBadRequest)
+	if !S.acceptablePassword(w, user.Password, user.Nickname, user.Email) {
 		return
 	}
 
@@ -118,7 +127,7 @@ func (S *Server) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if user.Gender != "male" && user.Gender != "female" {
+	if !isValidGender(user.Gender) {
 		http.Error(w, "Invalid gender", http.StatusBadRequest)
 		return
 	}
@@ -145,6 +154,12 @@ func (S *Server) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		renderErrorPage(w, r, "Unable to create account", http.StatusInternalServerError)
 		return
 	}
+	event := audit.Event{Action: audit.ActionRegister, ActorNickname: user.Nickname}
+	if credentials, err := S.users.CredentialsByIdentifier(user.Nickname); err == nil {
+		event.ActorID = credentials.UserID
+		event.ActorNickname = credentials.Nickname
+	}
+	S.recordAudit(r, event)
 }
 
 // Modified LoginHandler - broadcast status change after successful login
@@ -168,34 +183,183 @@ func (S *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Account repository is not initialized", http.StatusInternalServerError)
 		return
 	}
-	hashedPassword, nickname, err := S.users.PasswordByIdentifier(user.Identifier)
+	if S.throttle == nil {
+		http.Error(w, "Login throttle is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	ip := clientIP(r)
+	credentials, err := S.users.CredentialsByIdentifier(user.Identifier)
+	if err != nil && !errors.Is(err, account.ErrUserNotFound) {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	accountKey := loginThrottleKey(user.Identifier, credentials.UserID)
+
+	lockedUntil, err := S.loginLockedUntil(accountKey, ip)
 	if err != nil {
-		renderErrorPage(w, r, "User not found", http.StatusNotFound)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !lockedUntil.IsZero() {
+		writeLoginLocked(w, lockedUntil)
 		return
 	}
 
-	if err := CheckPassword(hashedPassword, user.Password); err != nil {
-		renderErrorPage(w, r, "Incorrect password", http.StatusUnauthorized)
+	failure := audit.Event{
+		Action:     audit.ActionLoginFailed,
+		TargetType: audit.TargetUser,
+		TargetID:   credentials.UserID,
+		IP:         ip,
+		Details:    "identifier=" + user.Identifier,
+	}
+	if credentials.UserID == 0 {
+		S.users.CheckDummyPassword(user.Password)
+		S.recordAudit(r, failure)
+		S.recordLoginFailure(w, accountKey, ip)
+		return
+	}
+	if !S.checkPassword(credentials.UserID, credentials.PasswordHash, user.Password) {
+		S.recordAudit(r, failure)
+		S.recordLoginFailure(w, accountKey, ip)
 		return
 	}
+	if err := S.throttle.Reset(account.ThrottleScopeAccount, accountKey); err != nil {
+		log.Printf("failed to reset login throttle: %v", err)
+	}
+	if S.rejectSuspended(w, credentials.UserID) {
+		failure.Details += " reason=suspended"
+		S.recordAudit(r, failure)
+		return
+	}
+	nickname := credentials.Nickname
 
-	S.MakeToken(w, nickname)
+	csrfToken := S.MakeToken(w, nickname)
+	if csrfToken == "" {
+		return
+	}
+	S.recordAudit(r, audit.Event{
+		Action: audit.ActionLogin, ActorID: credentials.UserID, ActorNickname: nickname, IP: ip,
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
-		"username": nickname,
+		"username":   nickname,
+		"csrf_token": csrfToken,
 	})
 
 	S.broadcastUserStatusChange()
 }
 
+// loginUser signs in a user who proved their identity without a password. It
+// starts a session through MakeToken and returns the nickname and the CSRF
+// token, or writes an error response and returns an empty token.
+func (S *Server) loginUser(w http.ResponseWriter, r *http.Request, userID int64, method string) (string, string) {
+	if S.rejectSuspended(w, userID) {
+		S.recordAudit(r, audit.Event{
+			Action: audit.ActionLoginFailed, TargetType: audit.TargetUser, TargetID: userID,
+			IP: clientIP(r), Details: "method=" + method + " reason=suspended",
+		})
+		return "", ""
+	}
+	profile, err := S.users.PublicProfile(userID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return "", ""
+	}
+	csrfToken := S.MakeToken(w, profile.Nickname)
+	if csrfToken == "" {
+		return "", ""
+	}
+	S.recordAudit(r, audit.Event{
+		Action: audit.ActionLogin, ActorID: userID, ActorNickname: profile.Nickname, IP: clientIP(r), Details: "method=" + method,
+	})
+	S.broadcastUserStatusChange()
+	return profile.Nickname, csrfToken
+}
+
+// loginThrottleKey keys existing accounts by ID so the email and nickname
+// share one counter; unknown identifiers are throttled the same way.
+func loginThrottleKey(identifier string, userID int64) string {
+	if userID != 0 {
+		return "user:" + strconv.FormatInt(userID, 10)
+	}
+	return "identifier:" + strings.ToLower(strings.TrimSpace(identifier))
+}
+
+func (S *Server) loginLockedUntil(accountKey, ip string) (time.Time, error) {
+	now := time.Now().UTC()
+	accountLock, err := S.throttle.LockedUntil(account.ThrottleScopeAccount, accountKey, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	ipLock, err := S.throttle.LockedUntil(account.ThrottleScopeIP, ip, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if ipLock.After(accountLock) {
+		return ipLock, nil
+	}
+	return accountLock, nil
+}
+
+// recordLoginFailure counts the failure against both the account and the
+// client IP and writes the same response for unknown users and bad passwords.
+func (S *Server) recordLoginFailure(w http.ResponseWriter, accountKey, ip string) {
+	if lockedUntil := S.countLoginFailure(accountKey, ip); !lockedUntil.IsZero() {
+		writeLoginLocked(w, lockedUntil)
+		return
+	}
+	http.Error(w, "Invalid email/nickname or password", http.StatusUnauthorized)
+}
+
+// countLoginFailure records a failed password against the account and the
+// client IP and returns when the lockout it caused ends, or the zero time.
+func (S *Server) countLoginFailure(accountKey, ip string) time.Time {
+	now := time.Now().UTC()
+	var lockedUntil time.Time
+	for _, attempt := range []struct {
+		scope  string
+		key    string
+		policy account.ThrottlePolicy
+	}{
+		{scope: account.ThrottleScopeAccount, key: accountKey, policy: account.LoginAccountPolicy},
+		{scope: account.ThrottleScopeIP, key: ip, policy: account.LoginIPPolicy},
+	} {
+		lockout, err := S.throttle.RecordFailure(attempt.scope, attempt.key, ip, attempt.policy, now)
+		if err != nil {
+			log.Printf("failed to record login failure: %v", err)
+			continue
+		}
+		if lockout != nil {
+			log.Printf("login locked: scope=%s key=%s failures=%d until=%s", lockout.Scope, lockout.Key, lockout.Failures, lockout.LockedUntil.Format(time.RFC3339))
+			S.recordAudit(nil, audit.Event{
+				Action: audit.ActionLoginLocked,
+				IP:     ip,
+				Details: fmt.Sprintf("scope=%s key=%s failures=%d until=%s",
+					lockout.Scope, lockout.Key, lockout.Failures, lockout.LockedUntil.Format(time.RFC3339)),
+			})
+			if lockout.LockedUntil.After(lockedUntil) {
+				lockedUntil = lockout.LockedUntil
+			}
+		}
+	}
+	return lockedUntil
+}
+
+func writeLoginLocked(w http.ResponseWriter, lockedUntil time.Time) {
+	retryAfter := int(time.Until(lockedUntil).Seconds()) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	http.Error(w, "Too many failed login attempts. Try again later.", http.StatusTooManyRequests)
+}
+
 func (S *Server) CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Redirect(w, r, "/404", http.StatusSeeOther)
 		return
 	}
 
-	nickname, _, err := S.CheckSession(r)
+	identity, err := S.CheckSessionIdentity(r)
 	if err != nil {
 		http.Error(w, "Unauthorized - Invalid session", http.StatusUnauthorized)
 		return
@@ -234,20 +398,77 @@ func (S *Server) CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Forum repository is not initialized", http.StatusInternalServerError)
 		return
 	}
-	err = S.forum.CreatePost(
-		html.EscapeString(nickname),
-		html.EscapeString(post.Title),
-		html.EscapeString(post.Content),
-		html.EscapeString(post.Category),
-	)
+	title, content := html.EscapeString(post.Title), html.EscapeString(post.Content)
+	postID, err := S.forum.CreatePost(identity.UserID, title, content, html.EscapeString(post.Category))
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	S.recordMentions(mention.SourcePost, postID, identity.UserID, content, nil, map[string]interface{}{
+		"post_id":    postID,
+		"post_title": title,
+	})
+	S.notifyNewPost(identity.UserID, identity.Nickname, postID, title, html.EscapeString(post.Category))
 
 	w.WriteHeader(http.StatusCreated)
 }
 
+func (S *Server) DeletePostHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var request struct {
+		PostID int `json:"post_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if S.forum == nil {
+		http.Error(w, "Forum repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	authorID, err := S.forum.PostAuthorID(request.PostID)
+	if errors.Is(err, forum.ErrPostNotFound) {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !canActOn(identity, authorID, account.PermissionDeleteAnyPost) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if err := S.forum.DeletePost(request.PostID); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	event := actorEvent(identity, audit.ActionPostDeleted)
+	event.TargetType, event.TargetID = audit.TargetPost, int64(request.PostID)
+	event.Details = "author_id=" + strconv.FormatInt(authorID, 10)
+	S.recordAudit(r, event)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// feedPeriods are the windows the top and discussed rankings accept. "all"
+// ranks every post.
+var feedPeriods = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"year":  365 * 24 * time.Hour,
+	"all":   0,
+}
+
 func (S *Server) GetPostsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Redirect(w, r, "/404", http.StatusSeeOther)
@@ -258,16 +479,99 @@ func (S *Server) GetPostsHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Forum repository is not initialized", http.StatusInternalServerError)
 		return
 	}
-	posts, err := S.forum.ListPosts()
+	identity, ok := account.IdentityFromContext(r.Context())
+	query := r.URL.Query()
+	options := forum.ListOptions{Sort: query.Get("sort")}
+	if ok {
+		options.ViewerID = identity.UserID
+	}
+	if period := query.Get("period"); period != "" {
+		if options.Sort != forum.SortTop && options.Sort != forum.SortDiscussed {
+			http.Error(w, "Period only applies to the top and discussed sorts", http.StatusBadRequest)
+			return
+		}
+		length, known := feedPeriods[period]
+		if !known {
+			http.Error(w, "Period must be day, week, month, year, or all", http.StatusBadRequest)
+			return
+		}
+		if length > 0 {
+			options.Since = time.Now().Add(-length)
+		}
+	}
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{
+		{name: "limit", value: &options.Limit},
+		{name: "offset", value: &options.Offset},
+	} {
+		if raw := query.Get(param.name); raw != "" {
+			number, err := strconv.Atoi(raw)
+			if err != nil || number < 0 {
+				http.Error(w, "Invalid "+param.name, http.StatusBadRequest)
+				return
+			}
+			*param.value = number
+		}
+	}
+	posts, err := S.forum.ListPosts(options)
+	if errors.Is(err, forum.ErrInvalidSort) {
+		http.Error(w, "Sort must be newest, activity, hot, top, or discussed", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	posts = S.withPostMentions(withPostAvatars(posts))
+	if ok {
+		posts = S.withPostBookmarks(identity.UserID, posts)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(posts)
 }
 
+// MarkPostReadHandler records that the user read a post's comments up to
+// comment_id, or up to the latest one when it is omitted. opened counts a
+// view; the page leaves it out when it reloads the comments of an open post.
+func (S *Server) MarkPostReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		PostID    int   `json:"post_id"`
+		CommentID int64 `json:"comment_id"`
+		Opened    bool  `json:"opened"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if S.forum == nil {
+		http.Error(w, "Forum repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+	err := S.forum.MarkRead(identity.UserID, request.PostID, request.CommentID, request.Opened, time.Now())
+	if errors.Is(err, forum.ErrPostNotFound) {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (S *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Redirect(w, r, "/404", http.StatusSeeOther)
@@ -287,6 +591,7 @@ func (S *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Error deleting session", http.StatusInternalServerError)
 		return
 	}
+	S.recordAudit(r, actorEvent(identity, audit.ActionLogout))
 
 	for _, session := range S.hub.ClientsForUser(identity.UserID) {
 		if session.SessionID == identity.SessionID {
@@ -323,14 +628,16 @@ func (S *Server) LoggedHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/404", http.StatusSeeOther)
 		return
 	}
-	username, _, err := S.CheckSession(r)
+	identity, err := S.CheckSessionIdentity(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
-		"username": username,
+		"username":   identity.Nickname,
+		"role":       string(identity.Role),
+		"csrf_token": identity.CSRFToken,
 	})
 }
 
@@ -339,7 +646,7 @@ func (S *Server) CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/404", http.StatusSeeOther)
 		return
 	}
-	nickname, _, err := S.CheckSession(r)
+	identity, err := S.CheckSessionIdentity(r)
 	if err != nil {
 		http.Error(w, "Unauthorized - Invalid session", http.StatusUnauthorized)
 		return
@@ -366,18 +673,74 @@ func (S *Server) CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Forum repository is not initialized", http.StatusInternalServerError)
 		return
 	}
-	err = S.forum.CreateComment(comment.PostID, html.EscapeString(nickname), html.EscapeString(comment.Content))
+	content := html.EscapeString(comment.Content)
+	commentID, err := S.forum.CreateComment(comment.PostID, comment.ParentID, identity.UserID, content)
 	if err != nil {
 		if err == forum.ErrPostNotFound {
 			http.Error(w, "Post not found", http.StatusBadRequest)
 			return
 		}
+		if err == forum.ErrCommentNotFound {
+			http.Error(w, "Parent comment not found", http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	S.notifyComment(identity.UserID, comment.PostID, comment.ParentID, commentID)
+	S.recordMentions(mention.SourceComment, commentID, identity.UserID, content, nil, map[string]interface{}{
+		"post_id":    comment.PostID,
+		"comment_id": commentID,
+	})
 	w.WriteHeader(http.StatusCreated)
 }
 
+func (S *Server) DeleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var request struct {
+		CommentID int `json:"comment_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if S.forum == nil {
+		http.Error(w, "Forum repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	authorID, err := S.forum.CommentAuthorID(request.CommentID)
+	if errors.Is(err, forum.ErrCommentNotFound) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !canActOn(identity, authorID, account.PermissionDeleteAnyComment) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if err := S.forum.DeleteComment(request.CommentID); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	event := actorEvent(identity, audit.ActionCommentDeleted)
+	event.TargetType, event.TargetID = audit.TargetComment, int64(request.CommentID)
+	event.Details = "author_id=" + strconv.FormatInt(authorID, 10)
+	S.recordAudit(r, event)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (S *Server) GetCommentsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Redirect(w, r, "/404", http.StatusSeeOther)
@@ -398,7 +761,7 @@ func (S *Server) GetCommentsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(comments)
+	json.NewEncoder(w).Encode(S.withCommentMentions(withCommentAvatars(comments)))
 }
 
 func (s *Server) GetMessagesHandler(w http.ResponseWriter, r *http.Request) {
@@ -438,6 +801,12 @@ func (s *Server) GetMessagesHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Chat repository is not initialized", http.StatusInternalServerError)
 		return
 	}
+	if s.users != nil {
+		// The other side may have been renamed since the client loaded its list.
+		if _, current, err := s.users.ResolveNickname(to); err == nil {
+			to = current
+		}
+	}
 	messagesFromRepository, err := s.chat.ListHistory(from, to, beforeID, offset)
 	if err != nil {
 		http.Error(w, "DB error", http.StatusInternalServerError)
@@ -455,5 +824,5 @@ func (s *Server) GetMessagesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(messages)
+	json.NewEncoder(w).Encode(s.withMessageMentions(messages))
 }
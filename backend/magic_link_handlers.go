@@ -0,0 +1,169 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/audit"
+	"real-time-forum/backend/mail"
+)
+
+const magicLinkLifetime = 15 * time.Minute
+
+// RequestMagicLinkHandler mails a sign-in link to the account with the given
+// email. The account is looked up and the mail sent after the response, which
+// is the same whether or not the account exists, so neither the body nor the
+// response time tells who is registered.
+func (S *Server) RequestMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	var request struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	request.Email = strings.TrimSpace(request.Email)
+	if !isValidEmail(request.Email) {
+		http.Error(w, "Invalid email format", http.StatusBadRequest)
+		return
+	}
+	if S.users == nil || S.throttle == nil || S.magicLinks == nil || S.mailer == nil {
+		http.Error(w, "Magic link login is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	if !S.allowMagicLinkRequest(w, strings.ToLower(request.Email), clientIP(r)) {
+		return
+	}
+
+	S.linkMails.Add(1)
+	go func() {
+		defer S.linkMails.Done()
+		S.sendMagicLink(request.Email, clientIP(r))
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "sent"})
+}
+
+// allowMagicLinkRequest counts the request against the email and the client
+// IP, and writes a 429 when either is over its limit.
+func (S *Server) allowMagicLinkRequest(w http.ResponseWriter, email, ip string) bool {
+	now := time.Now().UTC()
+	for _, attempt := range []struct {
+		scope  string
+		key    string
+		policy account.ThrottlePolicy
+	}{
+		{scope: account.ThrottleScopeMagicLinkEmail, key: email, policy: account.MagicLinkEmailPolicy},
+		{scope: account.ThrottleScopeMagicLinkIP, key: ip, policy: account.MagicLinkIPPolicy},
+	} {
+		lockedUntil, err := S.throttle.LockedUntil(attempt.scope, attempt.key, now)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return false
+		}
+		if !lockedUntil.IsZero() {
+			retryAfter := int(time.Until(lockedUntil).Seconds()) + 1
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			http.Error(w, "Too many sign-in links requested. Try again later.", http.StatusTooManyRequests)
+			return false
+		}
+	}
+	if _, err := S.throttle.RecordFailure(account.ThrottleScopeMagicLinkEmail, email, ip, account.MagicLinkEmailPolicy, now); err != nil {
+		log.Printf("failed to record magic link request: %v", err)
+	}
+	if _, err := S.throttle.RecordFailure(account.ThrottleScopeMagicLinkIP, ip, ip, account.MagicLinkIPPolicy, now); err != nil {
+		log.Printf("failed to record magic link request: %v", err)
+	}
+	return true
+}
+
+// sendMagicLink mails a sign-in link when the email belongs to an account.
+// It runs after the response has been written, so it logs failures and does
+// nothing for unknown addresses.
+func (S *Server) sendMagicLink(email, ip string) {
+	credentials, err := S.users.CredentialsByIdentifier(email)
+	if errors.Is(err, account.ErrUserNotFound) {
+		return
+	}
+	if err != nil {
+		log.Printf("failed to look up magic link email: %v", err)
+		return
+	}
+	token, err := S.magicLinks.Issue(credentials.UserID, time.Now(), magicLinkLifetime)
+	if err != nil {
+		log.Printf("failed to issue magic link for user %d: %v", credentials.UserID, err)
+		return
+	}
+	// The token travels in the fragment, which browsers do not send to the
+	// server and link scanners do not act on; the page posts it to verify.
+	link := S.config.PublicURL + "/#magic-link=" + url.QueryEscape(token)
+	err = S.mailer.Send(mail.Message{
+		To:      email,
+		Subject: "Your forum sign-in link",
+		Text: "Hi " + credentials.Nickname + ",\n\n" +
+			"Open this link within 15 minutes to sign in. It works once:\n" + link + "\n\n" +
+			"If you did not ask to sign in, you can ignore this message.\n",
+	})
+	if err != nil {
+		log.Printf("failed to send magic link: %v", err)
+		return
+	}
+	S.recordAudit(nil, audit.Event{
+		Action: audit.ActionMagicLinkSent, TargetType: audit.TargetUser, TargetID: credentials.UserID,
+		IP: ip,
+	})
+}
+
+// VerifyMagicLinkHandler exchanges a token for a session, answering like
+// LoginHandler.
+func (S *Server) VerifyMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	var request struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Token == "" {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if S.users == nil || S.magicLinks == nil {
+		http.Error(w, "Magic link login is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	userID, err := S.magicLinks.Redeem(request.Token, time.Now())
+	if errors.Is(err, account.ErrMagicLinkInvalid) {
+		http.Error(w, "Sign-in link is invalid, expired, or already used", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	nickname, csrfToken := S.loginUser(w, r, userID, "magic_link")
+	if csrfToken == "" {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"username":   nickname,
+		"csrf_token": csrfToken,
+	})
+}
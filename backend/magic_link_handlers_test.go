@@ -0,0 +1,137 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/mail"
+)
+
+func newMagicLinkTestServer(t *testing.T) (*Server, *recordingMailer) {
+	t.Helper()
+	server, mailer := newProfileTestServer(t)
+	server.throttle = account.NewThrottleRepository(server.db)
+	server.magicLinks = account.NewMagicLinkRepository(server.db, []byte("test secret"))
+	return server, mailer
+}
+
+func requestMagicLink(server *Server, email, ip string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/auth/magic-link", strings.NewReader(`{"email":"`+email+`"}`))
+	request.RemoteAddr = ip + ":40000"
+	recorder := httptest.NewRecorder()
+	server.RequestMagicLinkHandler(recorder, request)
+	server.linkMails.Wait()
+	return recorder
+}
+
+func verifyMagicLink(server *Server, token string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]string{"token": token})
+	recorder := httptest.NewRecorder()
+	server.VerifyMagicLinkHandler(recorder, httptest.NewRequest(http.MethodPost, "/auth/magic-link/verify", strings.NewReader(string(body))))
+	return recorder
+}
+
+func TestMagicLinkSignsInOnce(t *testing.T) {
+	server, mailer := newMagicLinkTestServer(t)
+
+	if recorder := requestMagicLink(server, "alice@example.com", "192.0.2.1"); recorder.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want 202: %s", recorder.Code, recorder.Body.String())
+	}
+	if len(mailer.messages) != 1 || mailer.messages[0].To != "alice@example.com" {
+		t.Fatalf("unexpected mail: %#v", mailer.messages)
+	}
+	_, fragment, found := strings.Cut(mailer.messages[0].Text, "http://forum.test/#magic-link=")
+	if !found {
+		t.Fatalf("mail has no sign-in link:\n%s", mailer.messages[0].Text)
+	}
+	token, err := url.QueryUnescape(strings.Fields(fragment)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := verifyMagicLink(server, token)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", recorder.Code, recorder.Body.String())
+	}
+	var response map[string]string
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response["username"] != "alice" || response["csrf_token"] == "" {
+		t.Fatalf("unexpected response: %#v", response)
+	}
+	if _, err := server.sessions.FindValid(responseCookie(t, recorder, "session_token").Value); err != nil {
+		t.Fatalf("magic link did not start a session: %v", err)
+	}
+
+	if recorder := verifyMagicLink(server, token); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("reused link got %d, want 401", recorder.Code)
+	}
+}
+
+func TestMagicLinkDoesNotRevealUnknownEmails(t *testing.T) {
+	server, mailer := newMagicLinkTestServer(t)
+
+	if recorder := requestMagicLink(server, "nobody@example.com", "192.0.2.1"); recorder.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want 202", recorder.Code)
+	}
+	if len(mailer.messages) != 0 {
+		t.Fatalf("mail was sent for an unknown address: %#v", mailer.messages)
+	}
+}
+
+func TestMagicLinkRequestsAreRateLimited(t *testing.T) {
+	server, mailer := newMagicLinkTestServer(t)
+
+	for i := 0; i < account.MagicLinkEmailPolicy.FreeAttempts; i++ {
+		if recorder := requestMagicLink(server, "alice@example.com", "192.0.2.1"); recorder.Code != http.StatusAccepted {
+			t.Fatalf("request %d got status %d, want 202", i+1, recorder.Code)
+		}
+	}
+	recorder := requestMagicLink(server, "ALICE@example.com", "198.51.100.7")
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") == "" {
+		t.Fatalf("request over the email limit got %d, want 429 with Retry-After", recorder.Code)
+	}
+	if len(mailer.messages) != account.MagicLinkEmailPolicy.FreeAttempts {
+		t.Fatalf("sent %d mails, want %d", len(mailer.messages), account.MagicLinkEmailPolicy.FreeAttempts)
+	}
+
+	for i := 0; i < account.MagicLinkIPPolicy.FreeAttempts; i++ {
+		requestMagicLink(server, "someone"+string(rune('a'+i))+"@example.com", "203.0.113.9")
+	}
+	if recorder := requestMagicLink(server, "fresh@example.com", "203.0.113.9"); recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the IP limit got %d, want 429", recorder.Code)
+	}
+}
+
+// blockingMailer holds every Send until release is closed.
+type blockingMailer struct {
+	release chan struct{}
+}
+
+func (m *blockingMailer) Send(mail.Message) error {
+	<-m.release
+	return nil
+}
+
+func TestMagicLinkAnswersBeforeTheMailIsSent(t *testing.T) {
+	server, _ := newMagicLinkTestServer(t)
+	mailer := &blockingMailer{release: make(chan struct{})}
+	server.mailer = mailer
+
+	// A known address answers without waiting for the mail server, like an
+	// unknown one, so the response time does not reveal the account.
+	request := httptest.NewRequest(http.MethodPost, "/auth/magic-link", strings.NewReader(`{"email":"alice@example.com"}`))
+	recorder := httptest.NewRecorder()
+	server.RequestMagicLinkHandler(recorder, request)
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want 202: %s", recorder.Code, recorder.Body.String())
+	}
+	close(mailer.release)
+	server.linkMails.Wait()
+}
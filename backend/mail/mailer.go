@@ -0,0 +1,113 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+)
+
+// Message is a single outgoing email. HTML is optional; when it is set the
+// message is sent as multipart/alternative with Text as the fallback part.
+//
+// ListUnsubscribe is an optional URL that unsubscribes the recipient
+// when posted to. It is sent as List-Unsubscribe with RFC 8058 one-click
+// List-Unsubscribe-Post, so mail clients can offer their own button.
+type Message struct {
+	To              string
+	Subject         string
+	Text            string
+	HTML            string
+	ListUnsubscribe string
+}
+
+// Mailer delivers email. Features depend on this interface so the transport
+// can be swapped for tests or for a different provider.
+type Mailer interface {
+	Send(message Message) error
+}
+
+// LogMailer writes messages to the application log instead of sending them.
+// It is used in development when no SMTP server is configured.
+type LogMailer struct{}
+
+func (LogMailer) Send(message Message) error {
+	log.Printf("mail to=%s subject=%q\n%s", message.To, message.Subject, message.Text)
+	return nil
+}
+
+type SMTPMailer struct {
+	Address  string
+	Username string
+	Password string
+	From     string
+}
+
+func (m SMTPMailer) Send(message Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host := m.Address
+		if index := strings.LastIndex(host, ":"); index >= 0 {
+			host = host[:index]
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	body, err := Encode(m.From, message)
+	if err != nil {
+		return err
+	}
+	if err := smtp.SendMail(m.Address, auth, m.From, []string{message.To}, body); err != nil {
+		return fmt.Errorf("send mail: %w", err)
+	}
+	return nil
+}
+
+// Encode renders the message as an RFC 5322 document.
+func Encode(from string, message Message) ([]byte, error) {
+	if strings.ContainsAny(message.To+message.Subject+message.ListUnsubscribe+from, "\r\n") {
+		return nil, fmt.Errorf("mail headers must not contain line breaks")
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "From: %s\r\n", from)
+	fmt.Fprintf(&builder, "To: %s\r\n", message.To)
+	fmt.Fprintf(&builder, "Subject: %s\r\n", message.Subject)
+	if message.ListUnsubscribe != "" {
+		fmt.Fprintf(&builder, "List-Unsubscribe: <%s>\r\n", message.ListUnsubscribe)
+		builder.WriteString("List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n")
+	}
+	builder.WriteString("MIME-Version: 1.0\r\n")
+
+	if message.HTML == "" {
+		builder.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+		builder.WriteString(message.Text)
+		return []byte(builder.String()), nil
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{contentType: "text/plain; charset=UTF-8", content: message.Text},
+		{contentType: "text/html; charset=UTF-8", content: message.HTML},
+	} {
+		partWriter, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := partWriter.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(&builder, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", writer.Boundary())
+	builder.Write(body.Bytes())
+	return []byte(builder.String()), nil
+}
@@ -0,0 +1,78 @@
+package mention
+
+import (
+	"unicode"
+	"unicode/utf16"
+)
+
+// Source types a mention can appear in.
+const (
+	SourcePost    = "post"
+	SourceComment = "comment"
+	SourceMessage = "message"
+)
+
+// MaxPerSource caps how many users one post, comment, or message can
+// mention; further mentions are left as plain text.
+const MaxPerSource = 10
+
+const (
+	minNicknameLength = 3
+	maxNicknameLength = 20
+)
+
+// Candidate is an @nickname found in text that has not been resolved to an
+// account yet. Start and End bound "@nickname" in UTF-16 code units, the
+// way JavaScript indexes strings.
+type Candidate struct {
+	Nickname string
+	Start    int
+	End      int
+}
+
+// Span is a resolved mention. Nickname is the user's current nickname, which
+// differs from the text when the mention used a nickname the user has since
+// changed.
+type Span struct {
+	UserID   int64  `json:"user_id"`
+	Nickname string `json:"nickname"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+}
+
+// Parse finds @nickname tokens. The @ must not follow a letter, digit, or
+// underscore, so email addresses are not mistaken for mentions, and the
+// nickname must be 3-20 letters, digits, or underscores like a real one.
+func Parse(content string) []Candidate {
+	var candidates []Candidate
+	runes := []rune(content)
+	offset := 0
+	for i := 0; i < len(runes); i++ {
+		start := offset
+		offset += utf16.RuneLen(runes[i])
+		if runes[i] != '@' || (i > 0 && isNicknameRune(runes[i-1])) {
+			continue
+		}
+		end := i + 1
+		for end < len(runes) && isNicknameRune(runes[end]) && runes[end] < unicode.MaxASCII {
+			end++
+		}
+		length := end - i - 1
+		if length < minNicknameLength || length > maxNicknameLength ||
+			(end < len(runes) && isNicknameRune(runes[end])) {
+			continue
+		}
+		candidates = append(candidates, Candidate{
+			Nickname: string(runes[i+1 : end]),
+			Start:    start,
+			End:      start + 1 + length,
+		})
+		offset += length
+		i = end - 1
+	}
+	return candidates
+}
+
+func isNicknameRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,19 @@
+package mention
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFindsMentionsWithUTF16Offsets(t *testing.T) {
+	got := Parse("@alice, café @bob_2! mail me at carol@example.com or @ab and @" +
+		"averyveryverylongnickname. 👋 @dave")
+	want := []Candidate{
+		{Nickname: "alice", Start: 0, End: 6},
+		{Nickname: "bob_2", Start: 13, End: 19},
+		{Nickname: "dave", Start: 92, End: 97},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Parse = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,72 @@
+package mention
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+// Save records the resolved mentions of one post, comment, or message.
+func (r *Repository) Save(sourceType string, sourceID, authorID int64, spans []Span, now time.Time) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, span := range spans {
+		if _, err := tx.Exec(`
+			INSERT INTO mentions (source_type, source_id, author_id, mentioned_user_id, start_offset, end_offset, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			sourceType, sourceID, authorID, span.UserID, span.Start, span.End, now.UTC()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ForSources returns the mentions of each listed source, keyed by source ID,
+// with the mentioned users' current nicknames. Mentions of deleted accounts
+// are left out.
+func (r *Repository) ForSources(sourceType string, sourceIDs []int64) (map[int64][]Span, error) {
+	spans := make(map[int64][]Span)
+	if len(sourceIDs) == 0 {
+		return spans, nil
+	}
+	args := []interface{}{sourceType}
+	for _, id := range sourceIDs {
+		args = append(args, id)
+	}
+	rows, err := r.db.Query(`
+		SELECT mentions.source_id, mentions.mentioned_user_id, users.nickname,
+		       mentions.start_offset, mentions.end_offset
+		FROM mentions
+		JOIN users ON users.id = mentions.mentioned_user_id
+		WHERE mentions.source_type = ? AND users.deleted_at IS NULL
+		  AND mentions.source_id IN (?`+strings.Repeat(", ?", len(sourceIDs)-1)+`)
+		ORDER BY mentions.source_id, mentions.start_offset`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sourceID int64
+		var span Span
+		if err := rows.Scan(&sourceID, &span.UserID, &span.Nickname, &span.Start, &span.End); err != nil {
+			return nil, err
+		}
+		spans[sourceID] = append(spans[sourceID], span)
+	}
+	return spans, rows.Err()
+}
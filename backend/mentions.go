@@ -0,0 +1,134 @@
+package backend
+
+import (
+	"log"
+	"time"
+
+	"real-time-forum/backend/forum"
+	"real-time-forum/backend/mention"
+	"real-time-forum/backend/notification"
+)
+
+// resolveMentions turns the @nicknames in content into spans, following
+// renames. Unknown nicknames and deleted, suspended, or banned accounts are
+// skipped, and only the first mention.MaxPerSource users are kept.
+func (S *Server) resolveMentions(content string, now time.Time) []mention.Span {
+	if S.users == nil {
+		return nil
+	}
+	var spans []mention.Span
+	resolved := make(map[string]mention.Span)
+	skipped := make(map[string]bool)
+	users := make(map[int64]bool)
+	for _, candidate := range mention.Parse(content) {
+		if skipped[candidate.Nickname] {
+			continue
+		}
+		span, ok := resolved[candidate.Nickname]
+		if !ok {
+			userID, current, err := S.users.ResolveNickname(candidate.Nickname)
+			if err != nil || S.mentionBlocked(userID, now) ||
+				(!users[userID] && len(users) >= mention.MaxPerSource) {
+				skipped[candidate.Nickname] = true
+				continue
+			}
+			span = mention.Span{UserID: userID, Nickname: current}
+			resolved[candidate.Nickname] = span
+			users[userID] = true
+		}
+		span.Start, span.End = candidate.Start, candidate.End
+		spans = append(spans, span)
+	}
+	return spans
+}
+
+func (S *Server) mentionBlocked(userID int64, now time.Time) bool {
+	if S.moderation == nil {
+		return false
+	}
+	_, err := S.moderation.ActiveSuspension(userID, now)
+	return err == nil
+}
+
+// recordMentions stores the mentions in a new post, comment, or message and
+// notifies each mentioned user once. canRead limits the notifications to
+// users who can see the source, so a chat message does not leak to a third
+// party; nil means everyone can. It returns the spans for the response.
+func (S *Server) recordMentions(sourceType string, sourceID, authorID int64, content string, canRead func(int64) bool, payload map[string]interface{}) []mention.Span {
+	if S.mentions == nil {
+		return nil
+	}
+	now := time.Now()
+	spans := S.resolveMentions(content, now)
+	if err := S.mentions.Save(sourceType, sourceID, authorID, spans, now); err != nil {
+		log.Printf("failed to save mentions in %s %d: %v", sourceType, sourceID, err)
+		return nil
+	}
+
+	payload["source_type"] = sourceType
+	payload["source_id"] = sourceID
+	// A mention in a chat message is muted along with the conversation.
+	var conversationWith int64
+	if sourceType == mention.SourceMessage {
+		conversationWith = authorID
+	}
+	notified := make(map[int64]bool)
+	for _, span := range spans {
+		if notified[span.UserID] || (canRead != nil && !canRead(span.UserID)) {
+			continue
+		}
+		notified[span.UserID] = true
+		S.notifyConversation(span.UserID, notification.TypeMention, authorID, conversationWith, payload)
+	}
+	return spans
+}
+
+// mentionsFor loads the stored mentions of the listed sources. A failure is
+// logged and the content is returned without mention spans.
+func (S *Server) mentionsFor(sourceType string, sourceIDs []int64) map[int64][]mention.Span {
+	if S.mentions == nil {
+		return nil
+	}
+	spans, err := S.mentions.ForSources(sourceType, sourceIDs)
+	if err != nil {
+		log.Printf("failed to load %s mentions: %v", sourceType, err)
+		return nil
+	}
+	return spans
+}
+
+func (S *Server) withPostMentions(posts []forum.Post) []forum.Post {
+	ids := make([]int64, len(posts))
+	for i, post := range posts {
+		ids[i] = int64(post.ID)
+	}
+	spans := S.mentionsFor(mention.SourcePost, ids)
+	for i := range posts {
+		posts[i].Mentions = spans[int64(posts[i].ID)]
+	}
+	return posts
+}
+
+func (S *Server) withCommentMentions(comments []forum.Comment) []forum.Comment {
+	ids := make([]int64, len(comments))
+	for i, comment := range comments {
+		ids[i] = int64(comment.ID)
+	}
+	spans := S.mentionsFor(mention.SourceComment, ids)
+	for i := range comments {
+		comments[i].Mentions = spans[int64(comments[i].ID)]
+	}
+	return comments
+}
+
+func (S *Server) withMessageMentions(messages []Message) []Message {
+	ids := make([]int64, len(messages))
+	for i, message := range messages {
+		ids[i] = int64(message.ID)
+	}
+	spans := S.mentionsFor(mention.SourceMessage, ids)
+	for i := range messages {
+		messages[i].Mentions = spans[int64(messages[i].ID)]
+	}
+	return messages
+}
@@ -0,0 +1,125 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"real-time-forum/backend/chat"
+	"real-time-forum/backend/forum"
+	"real-time-forum/backend/mention"
+	"real-time-forum/backend/moderation"
+	"real-time-forum/backend/notification"
+)
+
+func TestMentionsResolveNotifyAndSkipBlockedUsers(t *testing.T) {
+	server := newModerationTestServer(t)
+	server.chat = chat.NewRepository(server.db)
+	server.notifications = notification.NewRepository(server.db)
+	server.mentions = mention.NewRepository(server.db)
+	server.chatService = chat.NewService(server.db, server.chat, server.notifications)
+	if _, err := server.users.ChangeNickname(2, "robert", time.Now(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.moderation.Suspend(moderation.Suspension{UserID: 3, ModeratorID: 1, Reason: "spam", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.sessions.Create("alice-session", "alice", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	bob := &Client{ID: "bob-client", UserID: 2, Username: "robert", Send: make(chan interface{}, 8)}
+	server.hub.Register(bob)
+
+	request := httptest.NewRequest(http.MethodPost, "/createPost", strings.NewReader(
+		`{"title":"Release notes","content":"Thanks @bob and @carol, ping @nobody at x@alice.dev","category":"General"}`))
+	withSession(t, server, request, "alice-session")
+	recorder := httptest.NewRecorder()
+	server.SessionMiddleware(http.HandlerFunc(server.CreatePostHandler)).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want 201: %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	server.GetPostsHandler(recorder, httptest.NewRequest(http.MethodGet, "/posts", nil))
+	var posts []forum.Post
+	if err := json.NewDecoder(recorder.Body).Decode(&posts); err != nil {
+		t.Fatal(err)
+	}
+	var mentions []mention.Span
+	for _, post := range posts {
+		if post.Title == "Release notes" {
+			mentions = post.Mentions
+		}
+	}
+	if len(mentions) != 1 || mentions[0] != (mention.Span{UserID: 2, Nickname: "robert", Start: 7, End: 11}) {
+		t.Fatalf("post mentions = %+v, want only the renamed bob", mentions)
+	}
+	event, ok := (<-bob.Send).(map[string]interface{})
+	if !ok || event["notification"].(notification.Notification).Type != notification.TypeMention {
+		t.Fatalf("bob got %#v, want a mention notification", event)
+	}
+	carolNotifications, err := server.notifications.List(3, notification.Page{})
+	if err != nil || len(carolNotifications) != 0 {
+		t.Fatalf("suspended carol got %v, %v; want no notifications", carolNotifications, err)
+	}
+
+	// A chat message only notifies mentioned users who can read it.
+	if err := server.moderation.Lift(3, 1, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	alice := &Client{ID: "alice-client", UserID: 1, Username: "alice", Send: make(chan interface{}, 8)}
+	server.hub.Register(alice)
+	server.handleChatMessage(alice, Message{To: "robert", Content: "@carol @robert see this", Type: "chat_message"})
+	var live Message
+	for len(alice.Send) > 0 {
+		if message, ok := (<-alice.Send).(Message); ok {
+			live = message
+		}
+	}
+	if len(live.Mentions) != 2 || live.Mentions[1] != (mention.Span{UserID: 2, Nickname: "robert", Start: 7, End: 14}) {
+		t.Fatalf("live message mentions = %+v", live.Mentions)
+	}
+	carolNotifications, err = server.notifications.List(3, notification.Page{})
+	if err != nil || len(carolNotifications) != 0 {
+		t.Fatalf("carol got %v, %v for a chat message she is not part of", carolNotifications, err)
+	}
+	bobNotifications, err := server.notifications.List(2, notification.Page{})
+	if err != nil || len(bobNotifications) != 2 {
+		t.Fatalf("bob has %v, %v; want mentions in the post and the message", bobNotifications, err)
+	}
+}
+
+func TestDeletingContentDeletesItsMentions(t *testing.T) {
+	server := newModerationTestServer(t)
+	commentID, err := server.forum.CreateComment(1, 0, 2, "@carol look")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	for _, source := range []struct {
+		sourceType string
+		sourceID   int64
+	}{{"post", 1}, {"comment", commentID}} {
+		if _, err := server.db.Exec(`
+			INSERT INTO mentions (source_type, source_id, author_id, mentioned_user_id, start_offset, end_offset, created_at)
+			VALUES (?, ?, 1, 3, 0, 6, ?)`, source.sourceType, source.sourceID, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := server.forum.DeleteComment(int(commentID)); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, server, "SELECT COUNT(*) FROM mentions WHERE source_type = 'comment'"); n != 0 {
+		t.Fatalf("%d mentions still point at the deleted comment", n)
+	}
+	if err := server.forum.DeletePost(1); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, server, "SELECT COUNT(*) FROM mentions"); n != 0 {
+		t.Fatalf("%d mentions still point at the deleted post", n)
+	}
+}
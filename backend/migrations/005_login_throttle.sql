@@ -0,0 +1,21 @@
+CREATE TABLE login_throttle (
+    scope TEXT NOT NULL,
+    throttle_key TEXT NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at DATETIME NOT NULL,
+    locked_until DATETIME,
+    PRIMARY KEY (scope, throttle_key)
+);
+
+CREATE TABLE login_lockouts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    scope TEXT NOT NULL,
+    throttle_key TEXT NOT NULL,
+    failures INTEGER NOT NULL,
+    ip TEXT,
+    locked_until DATETIME NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_login_lockouts_key
+    ON login_lockouts(scope, throttle_key, created_at DESC);
@@ -0,0 +1,33 @@
+ALTER TABLE users ADD COLUMN created_at DATETIME;
+
+UPDATE users
+SET created_at = COALESCE(
+    (SELECT MIN(created_at) FROM posts WHERE posts.user_id = users.id),
+    (SELECT MIN(created_at) FROM comments WHERE comments.user_id = users.id),
+    CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER users_set_created_at
+AFTER INSERT ON users
+WHEN NEW.created_at IS NULL
+BEGIN
+    UPDATE users SET created_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
+
+CREATE TABLE email_changes (
+    token_hash TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    new_email TEXT NOT NULL,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_email_changes_user_id
+    ON email_changes(user_id);
+
+CREATE INDEX idx_posts_user_id
+    ON posts(user_id, created_at DESC);
+
+CREATE INDEX idx_comments_user_id
+    ON comments(user_id);
@@ -0,0 +1,16 @@
+CREATE TABLE user_suspensions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    moderator_id INTEGER NOT NULL,
+    reason TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME,
+    lifted_at DATETIME,
+    lifted_by INTEGER,
+    FOREIGN KEY(user_id) REFERENCES users(id),
+    FOREIGN KEY(moderator_id) REFERENCES users(id),
+    FOREIGN KEY(lifted_by) REFERENCES users(id)
+);
+
+CREATE INDEX idx_user_suspensions_user_id
+    ON user_suspensions(user_id, lifted_at, expires_at);
@@ -0,0 +1,40 @@
+CREATE TABLE reports (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    reporter_id INTEGER NOT NULL,
+    target_type TEXT NOT NULL CHECK (target_type IN ('post', 'comment', 'message')),
+    target_id INTEGER NOT NULL,
+    target_user_id INTEGER NOT NULL,
+    reason TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'actioned', 'dismissed')),
+    created_at DATETIME NOT NULL,
+    resolved_by INTEGER,
+    resolved_at DATETIME,
+    resolution_action TEXT,
+    resolution_note TEXT,
+    FOREIGN KEY(reporter_id) REFERENCES users(id),
+    FOREIGN KEY(target_user_id) REFERENCES users(id),
+    FOREIGN KEY(resolved_by) REFERENCES users(id)
+);
+
+CREATE INDEX idx_reports_status_created_at
+    ON reports(status, created_at DESC);
+
+CREATE UNIQUE INDEX idx_reports_open_per_reporter
+    ON reports(reporter_id, target_type, target_id)
+    WHERE status = 'open';
+
+CREATE TABLE user_warnings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    moderator_id INTEGER NOT NULL,
+    report_id INTEGER,
+    reason TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id),
+    FOREIGN KEY(moderator_id) REFERENCES users(id),
+    FOREIGN KEY(report_id) REFERENCES reports(id)
+);
+
+ALTER TABLE posts ADD COLUMN hidden_at DATETIME;
+ALTER TABLE comments ADD COLUMN hidden_at DATETIME;
+ALTER TABLE messages ADD COLUMN hidden_at DATETIME;
@@ -0,0 +1,3 @@
+ALTER TABLE sessions ADD COLUMN csrf_token TEXT NOT NULL DEFAULT '';
+
+DELETE FROM sessions;
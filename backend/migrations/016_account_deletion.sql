@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN deletion_scheduled_at DATETIME;
+ALTER TABLE users ADD COLUMN deleted_at DATETIME;
+
+CREATE INDEX idx_users_deletion_scheduled_at
+    ON users(deletion_scheduled_at)
+    WHERE deletion_scheduled_at IS NOT NULL AND deleted_at IS NULL;
@@ -0,0 +1,16 @@
+ALTER TABLE users ADD COLUMN nickname_changed_at DATETIME;
+
+CREATE TABLE nickname_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    old_nickname TEXT NOT NULL,
+    new_nickname TEXT NOT NULL,
+    changed_at DATETIME NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_nickname_history_old_nickname
+    ON nickname_history(old_nickname, changed_at DESC);
+
+CREATE INDEX idx_nickname_history_user_id
+    ON nickname_history(user_id, changed_at DESC);
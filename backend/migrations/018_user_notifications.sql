@@ -0,0 +1,19 @@
+ALTER TABLE comments ADD COLUMN parent_id INTEGER REFERENCES comments(id);
+
+CREATE TABLE user_notifications (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    type TEXT NOT NULL,
+    actor_id INTEGER,
+    payload TEXT NOT NULL DEFAULT '{}',
+    created_at DATETIME NOT NULL,
+    read_at DATETIME,
+    FOREIGN KEY(user_id) REFERENCES users(id),
+    FOREIGN KEY(actor_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_user_notifications_user_id
+    ON user_notifications(user_id, id DESC);
+
+CREATE INDEX idx_comments_parent_id
+    ON comments(parent_id);
@@ -0,0 +1,18 @@
+CREATE TABLE mentions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    source_type TEXT NOT NULL,
+    source_id INTEGER NOT NULL,
+    author_id INTEGER NOT NULL,
+    mentioned_user_id INTEGER NOT NULL,
+    start_offset INTEGER NOT NULL,
+    end_offset INTEGER NOT NULL,
+    created_at DATETIME NOT NULL,
+    FOREIGN KEY(author_id) REFERENCES users(id),
+    FOREIGN KEY(mentioned_user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_mentions_source
+    ON mentions(source_type, source_id);
+
+CREATE INDEX idx_mentions_mentioned_user_id
+    ON mentions(mentioned_user_id, created_at DESC);
@@ -0,0 +1,27 @@
+ALTER TABLE user_notifications ADD COLUMN channel TEXT NOT NULL DEFAULT 'in_app';
+
+CREATE TABLE notification_preferences (
+    user_id INTEGER NOT NULL,
+    type TEXT NOT NULL,
+    channel TEXT NOT NULL,
+    PRIMARY KEY(user_id, type),
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE quiet_hours (
+    user_id INTEGER PRIMARY KEY,
+    start_time TEXT NOT NULL,
+    end_time TEXT NOT NULL,
+    timezone TEXT NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE conversation_mutes (
+    user_id INTEGER NOT NULL,
+    other_user_id INTEGER NOT NULL,
+    muted_until DATETIME,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY(user_id, other_user_id),
+    FOREIGN KEY(user_id) REFERENCES users(id),
+    FOREIGN KEY(other_user_id) REFERENCES users(id)
+);
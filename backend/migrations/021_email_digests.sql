@@ -0,0 +1,8 @@
+ALTER TABLE users ADD COLUMN last_seen_at DATETIME;
+
+CREATE TABLE email_digests (
+    user_id INTEGER PRIMARY KEY,
+    last_sent_at DATETIME,
+    unsubscribed_at DATETIME,
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
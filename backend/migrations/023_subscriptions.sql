@@ -0,0 +1,18 @@
+CREATE TABLE subscriptions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    target_type TEXT NOT NULL,
+    target_id INTEGER NOT NULL DEFAULT 0,
+    category TEXT NOT NULL DEFAULT '' COLLATE NOCASE,
+    created_at DATETIME NOT NULL,
+    UNIQUE(user_id, target_type, target_id, category),
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_subscriptions_target
+    ON subscriptions(target_type, target_id, category);
+
+INSERT INTO subscriptions (user_id, target_type, target_id, created_at)
+SELECT user_id, 'post', id, COALESCE(created_at, CURRENT_TIMESTAMP)
+FROM posts
+WHERE user_id IS NOT NULL;
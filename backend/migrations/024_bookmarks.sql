@@ -0,0 +1,14 @@
+CREATE TABLE bookmarks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    target_type TEXT NOT NULL,
+    target_id INTEGER NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    folder TEXT NOT NULL DEFAULT '' COLLATE NOCASE,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    UNIQUE(user_id, target_type, target_id),
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_bookmarks_user_folder ON bookmarks(user_id, folder);
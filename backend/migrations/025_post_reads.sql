@@ -0,0 +1,11 @@
+CREATE TABLE post_reads (
+    user_id INTEGER NOT NULL,
+    post_id INTEGER NOT NULL,
+    last_read_comment_id INTEGER NOT NULL DEFAULT 0,
+    read_at DATETIME NOT NULL,
+    PRIMARY KEY(user_id, post_id),
+    FOREIGN KEY(user_id) REFERENCES users(id),
+    FOREIGN KEY(post_id) REFERENCES posts(id)
+);
+
+CREATE INDEX idx_post_reads_post ON post_reads(post_id);
@@ -0,0 +1,15 @@
+ALTER TABLE posts ADD COLUMN comment_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN commenter_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN hot_score REAL NOT NULL DEFAULT 0;
+
+UPDATE posts
+SET comment_count = (SELECT COUNT(*) FROM comments WHERE comments.post_id = posts.id AND comments.hidden_at IS NULL),
+    commenter_count = (SELECT COUNT(DISTINCT comments.user_id) FROM comments
+                       WHERE comments.post_id = posts.id AND comments.hidden_at IS NULL),
+    hot_score = log10(max((SELECT COUNT(*) FROM comments
+                           WHERE comments.post_id = posts.id AND comments.hidden_at IS NULL), 1))
+                + (unixepoch(posts.created_at) - 1704067200) / 45000.0;
+
+CREATE INDEX idx_posts_hot_score ON posts(hot_score DESC);
+CREATE INDEX idx_posts_comment_count ON posts(comment_count DESC, created_at DESC);
+CREATE INDEX idx_posts_commenter_count ON posts(commenter_count DESC, comment_count DESC);
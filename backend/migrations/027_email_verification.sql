@@ -0,0 +1,12 @@
+ALTER TABLE users ADD COLUMN email_verified_at DATETIME;
+
+CREATE TABLE oidc_pending_links (
+    token_hash TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    email TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
@@ -0,0 +1,11 @@
+ALTER TABLE posts ADD COLUMN last_activity_at TEXT NOT NULL DEFAULT '';
+ALTER TABLE posts ADD COLUMN reader_count INTEGER NOT NULL DEFAULT 0;
+
+UPDATE posts
+SET last_activity_at = strftime('%Y-%m-%dT%H:%M:%SZ', COALESCE(
+        (SELECT MAX(comments.created_at) FROM comments
+         WHERE comments.post_id = posts.id AND comments.hidden_at IS NULL),
+        posts.created_at)),
+    reader_count = (SELECT COUNT(*) FROM post_reads WHERE post_reads.post_id = posts.id);
+
+CREATE INDEX idx_posts_last_activity ON posts(last_activity_at DESC, id DESC);
@@ -0,0 +1,3 @@
+ALTER TABLE posts ADD COLUMN view_count INTEGER NOT NULL DEFAULT 0;
+
+UPDATE posts SET view_count = reader_count;
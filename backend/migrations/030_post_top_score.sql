@@ -0,0 +1,9 @@
+ALTER TABLE posts ADD COLUMN top_score INTEGER NOT NULL DEFAULT 0;
+
+UPDATE posts
+SET top_score = commenter_count + reader_count,
+    hot_score = log10(max(commenter_count + reader_count, 1))
+                + (unixepoch(created_at) - 1704067200) / 45000.0;
+
+DROP INDEX idx_posts_comment_count;
+CREATE INDEX idx_posts_top_score ON posts(top_score DESC, created_at DESC);
@@ -0,0 +1,192 @@
+package moderation
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrReportNotFound     = errors.New("report not found")
+	ErrReportNotOpen      = errors.New("report is already resolved")
+	ErrDuplicateReport    = errors.New("report already open for this content")
+	ErrInvalidReportQuery = errors.New("invalid report filter")
+)
+
+const (
+	TargetPost    = "post"
+	TargetComment = "comment"
+	TargetMessage = "message"
+
+	StatusOpen      = "open"
+	StatusActioned  = "actioned"
+	StatusDismissed = "dismissed"
+
+	ActionHide    = "hide"
+	ActionWarn    = "warn"
+	ActionSuspend = "suspend"
+	ActionDismiss = "dismiss"
+)
+
+func ValidTargetType(targetType string) bool {
+	return targetType == TargetPost || targetType == TargetComment || targetType == TargetMessage
+}
+
+func ValidStatus(status string) bool {
+	return status == StatusOpen || status == StatusActioned || status == StatusDismissed
+}
+
+type Report struct {
+	ID               int64      `json:"id"`
+	ReporterID       int64      `json:"reporter_id"`
+	TargetType       string     `json:"target_type"`
+	TargetID         int64      `json:"target_id"`
+	TargetUserID     int64      `json:"target_user_id"`
+	Reason           string     `json:"reason"`
+	Status           string     `json:"status"`
+	CreatedAt        time.Time  `json:"created_at"`
+	ResolvedBy       *int64     `json:"resolved_by,omitempty"`
+	ResolvedAt       *time.Time `json:"resolved_at,omitempty"`
+	ResolutionAction string     `json:"resolution_action,omitempty"`
+	ResolutionNote   string     `json:"resolution_note,omitempty"`
+}
+
+type ReportFilter struct {
+	Status     string
+	TargetType string
+	Limit      int
+	Offset     int
+}
+
+func (r *Repository) CreateReport(report Report) (Report, error) {
+	report.Status = StatusOpen
+	report.CreatedAt = report.CreatedAt.UTC()
+	result, err := r.db.Exec(`
+		INSERT INTO reports (reporter_id, target_type, target_id, target_user_id, reason, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		report.ReporterID, report.TargetType, report.TargetID, report.TargetUserID,
+		report.Reason, report.Status, report.CreatedAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return Report{}, ErrDuplicateReport
+		}
+		return Report{}, err
+	}
+	report.ID, err = result.LastInsertId()
+	return report, err
+}
+
+const reportColumns = `
+	id, reporter_id, target_type, target_id, target_user_id, reason, status, created_at,
+	resolved_by, resolved_at, COALESCE(resolution_action, ''), COALESCE(resolution_note, '')`
+
+func scanReport(scanner interface{ Scan(...interface{}) error }) (Report, error) {
+	var report Report
+	var resolvedBy sql.NullInt64
+	var resolvedAt sql.NullTime
+	err := scanner.Scan(
+		&report.ID, &report.ReporterID, &report.TargetType, &report.TargetID, &report.TargetUserID,
+		&report.Reason, &report.Status, &report.CreatedAt,
+		&resolvedBy, &resolvedAt, &report.ResolutionAction, &report.ResolutionNote,
+	)
+	if err != nil {
+		return Report{}, err
+	}
+	if resolvedBy.Valid {
+		report.ResolvedBy = &resolvedBy.Int64
+	}
+	if resolvedAt.Valid {
+		report.ResolvedAt = &resolvedAt.Time
+	}
+	return report, nil
+}
+
+func (r *Repository) Report(reportID int64) (Report, error) {
+	report, err := scanReport(r.db.QueryRow("SELECT "+reportColumns+" FROM reports WHERE id = ?", reportID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Report{}, ErrReportNotFound
+	}
+	return report, err
+}
+
+// ListReports returns reports newest first. Empty filter fields match
+// everything.
+func (r *Repository) ListReports(filter ReportFilter) ([]Report, error) {
+	if filter.Status != "" && !ValidStatus(filter.Status) {
+		return nil, ErrInvalidReportQuery
+	}
+	if filter.TargetType != "" && !ValidTargetType(filter.TargetType) {
+		return nil, ErrInvalidReportQuery
+	}
+	if filter.Limit <= 0 || filter.Limit > 100 {
+		filter.Limit = 50
+	}
+
+	rows, err := r.db.Query(`
+		SELECT `+reportColumns+`
+		FROM reports
+		WHERE (? = '' OR status = ?) AND (? = '' OR target_type = ?)
+		ORDER BY created_at DESC, id DESC
+		LIMIT ? OFFSET ?`,
+		filter.Status, filter.Status, filter.TargetType, filter.TargetType, filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []Report{}
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+// ResolveReport closes an open report. Other open reports about the same
+// content are closed with the same outcome.
+func (r *Repository) ResolveReport(reportID, moderatorID int64, action, note string, now time.Time) (Report, error) {
+	status := StatusActioned
+	if action == ActionDismiss {
+		status = StatusDismissed
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return Report{}, err
+	}
+	defer tx.Rollback()
+
+	report, err := scanReport(tx.QueryRow("SELECT "+reportColumns+" FROM reports WHERE id = ?", reportID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Report{}, ErrReportNotFound
+	}
+	if err != nil {
+		return Report{}, err
+	}
+	if report.Status != StatusOpen {
+		return Report{}, ErrReportNotOpen
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE reports
+		SET status = ?, resolved_by = ?, resolved_at = ?, resolution_action = ?, resolution_note = ?
+		WHERE status = 'open' AND target_type = ? AND target_id = ?`,
+		status, moderatorID, now.UTC(), action, note, report.TargetType, report.TargetID); err != nil {
+		return Report{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Report{}, err
+	}
+	return r.Report(reportID)
+}
+
+func (r *Repository) AddWarning(userID, moderatorID, reportID int64, reason string, now time.Time) error {
+	_, err := r.db.Exec(`
+		INSERT INTO user_warnings (user_id, moderator_id, report_id, reason, created_at)
+		VALUES (?, ?, ?, ?, ?)`, userID, moderatorID, reportID, reason, now.UTC())
+	return err
+}
@@ -0,0 +1,86 @@
+package moderation
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func newReportTestRepository(t *testing.T) *Repository {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:report-test?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(`
+		DROP TABLE IF EXISTS reports;
+		CREATE TABLE reports (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reporter_id INTEGER NOT NULL,
+			target_type TEXT NOT NULL,
+			target_id INTEGER NOT NULL,
+			target_user_id INTEGER NOT NULL,
+			reason TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'open',
+			created_at DATETIME NOT NULL,
+			resolved_by INTEGER,
+			resolved_at DATETIME,
+			resolution_action TEXT,
+			resolution_note TEXT
+		);
+		CREATE UNIQUE INDEX idx_reports_open_per_reporter
+			ON reports(reporter_id, target_type, target_id)
+			WHERE status = 'open';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewRepository(db)
+}
+
+func TestResolveReportClosesEveryOpenReportForTarget(t *testing.T) {
+	repository := newReportTestRepository(t)
+	now := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	first, err := repository.CreateReport(Report{
+		ReporterID: 2, TargetType: TargetPost, TargetID: 7, TargetUserID: 1, Reason: "spam", CreatedAt: now,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repository.CreateReport(Report{
+		ReporterID: 2, TargetType: TargetPost, TargetID: 7, TargetUserID: 1, Reason: "spam again", CreatedAt: now,
+	}); !errors.Is(err, ErrDuplicateReport) {
+		t.Fatalf("got %v, want ErrDuplicateReport", err)
+	}
+	if _, err := repository.CreateReport(Report{
+		ReporterID: 3, TargetType: TargetPost, TargetID: 7, TargetUserID: 1, Reason: "abusive", CreatedAt: now.Add(time.Minute),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	resolved, err := repository.ResolveReport(first.ID, 4, ActionHide, "", now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Status != StatusActioned || resolved.ResolvedBy == nil || *resolved.ResolvedBy != 4 {
+		t.Fatalf("unexpected resolved report: %#v", resolved)
+	}
+	if _, err := repository.ResolveReport(first.ID, 4, ActionDismiss, "", now); !errors.Is(err, ErrReportNotOpen) {
+		t.Fatalf("got %v, want ErrReportNotOpen", err)
+	}
+
+	open, err := repository.ListReports(ReportFilter{Status: StatusOpen})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(open) != 0 {
+		t.Fatalf("got %d open reports, want 0", len(open))
+	}
+	if _, err := repository.ListReports(ReportFilter{Status: "closed"}); !errors.Is(err, ErrInvalidReportQuery) {
+		t.Fatalf("got %v, want ErrInvalidReportQuery", err)
+	}
+}
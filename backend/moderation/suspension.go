@@ -0,0 +1,94 @@
+package moderation
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrNotSuspended = errors.New("user is not suspended")
+
+// Suspension blocks an account until ExpiresAt. A nil ExpiresAt is a
+// permanent ban.
+type Suspension struct {
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"user_id"`
+	ModeratorID int64      `json:"moderator_id"`
+	Reason      string     `json:"reason"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+func (s Suspension) Permanent() bool {
+	return s.ExpiresAt == nil
+}
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+func (r *Repository) Suspend(suspension Suspension) (Suspension, error) {
+	suspension.CreatedAt = suspension.CreatedAt.UTC()
+	var expiresAt sql.NullTime
+	if suspension.ExpiresAt != nil {
+		utc := suspension.ExpiresAt.UTC()
+		suspension.ExpiresAt = &utc
+		expiresAt = sql.NullTime{Time: utc, Valid: true}
+	}
+
+	result, err := r.db.Exec(`
+		INSERT INTO user_suspensions (user_id, moderator_id, reason, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		suspension.UserID, suspension.ModeratorID, suspension.Reason, suspension.CreatedAt, expiresAt)
+	if err != nil {
+		return Suspension{}, err
+	}
+	suspension.ID, err = result.LastInsertId()
+	return suspension, err
+}
+
+// ActiveSuspension returns the suspension that blocks the user the longest,
+// or ErrNotSuspended.
+func (r *Repository) ActiveSuspension(userID int64, now time.Time) (Suspension, error) {
+	var suspension Suspension
+	var expiresAt sql.NullTime
+	err := r.db.QueryRow(`
+		SELECT id, user_id, moderator_id, reason, created_at, expires_at
+		FROM user_suspensions
+		WHERE user_id = ? AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)
+		ORDER BY expires_at IS NULL DESC, expires_at DESC
+		LIMIT 1`, userID, now.UTC()).Scan(
+		&suspension.ID, &suspension.UserID, &suspension.ModeratorID,
+		&suspension.Reason, &suspension.CreatedAt, &expiresAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Suspension{}, ErrNotSuspended
+	}
+	if err != nil {
+		return Suspension{}, err
+	}
+	if expiresAt.Valid {
+		suspension.ExpiresAt = &expiresAt.Time
+	}
+	return suspension, nil
+}
+
+// Lift ends every active suspension of the user.
+func (r *Repository) Lift(userID, liftedBy int64, now time.Time) error {
+	result, err := r.db.Exec(`
+		UPDATE user_suspensions
+		SET lifted_at = ?, lifted_by = ?
+		WHERE user_id = ? AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)`,
+		now.UTC(), liftedBy, userID, now.UTC())
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrNotSuspended
+	}
+	return nil
+}
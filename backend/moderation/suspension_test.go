@@ -0,0 +1,69 @@
+package moderation
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func newSuspensionTestRepository(t *testing.T) *Repository {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:suspension-test?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(`
+		DROP TABLE IF EXISTS user_suspensions;
+		CREATE TABLE user_suspensions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			moderator_id INTEGER NOT NULL,
+			reason TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME,
+			lifted_at DATETIME,
+			lifted_by INTEGER
+		);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewRepository(db)
+}
+
+func TestActiveSuspensionHonoursExpiryAndLift(t *testing.T) {
+	repository := newSuspensionTestRepository(t)
+	now := time.Date(2026, 8, 1, 12, 0, 0, 0, time.UTC)
+	expiresAt := now.Add(time.Hour)
+
+	if _, err := repository.Suspend(Suspension{
+		UserID: 2, ModeratorID: 1, Reason: "spam", CreatedAt: now, ExpiresAt: &expiresAt,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	active, err := repository.ActiveSuspension(2, now.Add(time.Minute))
+	if err != nil || active.Reason != "spam" || active.Permanent() || !active.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("unexpected active suspension: %#v, %v", active, err)
+	}
+	if _, err := repository.ActiveSuspension(2, now.Add(2*time.Hour)); !errors.Is(err, ErrNotSuspended) {
+		t.Fatalf("expired suspension returned %v, want ErrNotSuspended", err)
+	}
+
+	if _, err := repository.Suspend(Suspension{UserID: 3, ModeratorID: 1, Reason: "abuse", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	active, err = repository.ActiveSuspension(3, now.AddDate(10, 0, 0))
+	if err != nil || !active.Permanent() {
+		t.Fatalf("ban should stay active: %#v, %v", active, err)
+	}
+	if err := repository.Lift(3, 1, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repository.ActiveSuspension(3, now); !errors.Is(err, ErrNotSuspended) {
+		t.Fatalf("lifted ban returned %v, want ErrNotSuspended", err)
+	}
+}
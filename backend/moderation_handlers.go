@@ -0,0 +1,241 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/audit"
+	"real-time-forum/backend/moderation"
+	"real-time-forum/backend/notification"
+)
+
+const maxSuspensionHours = 24 * 365
+
+// validSuspensionLength checks the length of a requested suspension. A
+// permanent ban has to be asked for with permanent; a missing duration is
+// rejected rather than read as one.
+func validSuspensionLength(w http.ResponseWriter, durationHours int, permanent bool) bool {
+	if permanent && durationHours != 0 {
+		http.Error(w, "A permanent ban takes no duration", http.StatusBadRequest)
+		return false
+	}
+	if !permanent && (durationHours < 1 || durationHours > maxSuspensionHours) {
+		http.Error(w, "Duration must be 1 to 8760 hours, or set permanent for a ban", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// rejectSuspended writes a 403 with the suspension details and returns true
+// when the user is currently suspended or banned.
+func (S *Server) rejectSuspended(w http.ResponseWriter, userID int64) bool {
+	if S.moderation == nil {
+		return false
+	}
+	suspension, err := S.moderation.ActiveSuspension(userID, time.Now())
+	if errors.Is(err, moderation.ErrNotSuspended) {
+		return false
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return true
+	}
+
+	response := map[string]interface{}{
+		"error":     "Account suspended",
+		"reason":    suspension.Reason,
+		"permanent": suspension.Permanent(),
+	}
+	if suspension.Permanent() {
+		response["message"] = "This account has been banned."
+	} else {
+		response["suspended_until"] = suspension.ExpiresAt.Format(time.RFC3339)
+		response["message"] = "This account is suspended until " + suspension.ExpiresAt.Format(time.RFC1123) + "."
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(response)
+	return true
+}
+
+// revokeUserSessions deletes every session of the user and closes their
+// WebSocket connections, telling each client why. It returns how many
+// sessions were deleted.
+func (S *Server) revokeUserSessions(userID int64, message string) int {
+	if S.sessions == nil {
+		return 0
+	}
+	sessionIDs, err := S.sessions.DeleteForUser(userID)
+	if err != nil {
+		log.Printf("failed to revoke sessions for user %d: %v", userID, err)
+		return 0
+	}
+	if S.hub == nil {
+		return len(sessionIDs)
+	}
+	S.hub.SendToUser(userID, map[string]string{
+		"event":   "logout",
+		"message": message,
+	})
+	for _, sessionID := range sessionIDs {
+		S.hub.DisconnectSession(userID, sessionID)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		S.broadcastUserStatusChange()
+	}()
+	return len(sessionIDs)
+}
+
+// canModerate checks that the target exists and ranks below the moderator.
+func (S *Server) canModerate(w http.ResponseWriter, moderator account.Identity, targetID int64) bool {
+	if targetID == moderator.UserID {
+		http.Error(w, "You cannot moderate your own account", http.StatusBadRequest)
+		return false
+	}
+	targetRole, err := S.users.Role(targetID)
+	if errors.Is(err, account.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	if !moderator.Role.Outranks(targetRole) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// suspendUser records the suspension and immediately ends the user's sessions.
+// Zero hours is a permanent ban; callers check with validSuspensionLength
+// that the moderator asked for one.
+func (S *Server) suspendUser(r *http.Request, moderator account.Identity, userID int64, reason string, hours int) (moderation.Suspension, error) {
+	suspension := moderation.Suspension{
+		UserID:      userID,
+		ModeratorID: moderator.UserID,
+		Reason:      reason,
+		CreatedAt:   time.Now(),
+	}
+	if hours > 0 {
+		expiresAt := suspension.CreatedAt.Add(time.Duration(hours) * time.Hour)
+		suspension.ExpiresAt = &expiresAt
+	}
+	suspension, err := S.moderation.Suspend(suspension)
+	if err != nil {
+		return moderation.Suspension{}, err
+	}
+	S.notify(userID, notification.TypeModeration, 0, map[string]interface{}{
+		"action":     moderation.ActionSuspend,
+		"note":       reason,
+		"expires_at": suspension.ExpiresAt,
+	})
+	revoked := S.revokeUserSessions(userID, "Account suspended")
+
+	event := actorEvent(moderator, audit.ActionUserSuspended)
+	event.TargetType, event.TargetID = audit.TargetUser, userID
+	event.Details = fmt.Sprintf("duration_hours=%d reason=%s", hours, reason)
+	S.recordAudit(r, event)
+	event.Action = audit.ActionSessionsRevoked
+	event.Details = fmt.Sprintf("count=%d reason=suspension", revoked)
+	S.recordAudit(r, event)
+	return suspension, nil
+}
+
+func (S *Server) SuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		UserID        int64  `json:"user_id"`
+		Reason        string `json:"reason"`
+		DurationHours int    `json:"duration_hours"`
+		Permanent     bool   `json:"permanent"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	request.Reason = strings.TrimSpace(request.Reason)
+	if !isValidTextLength(request.Reason, 3, 500) {
+		http.Error(w, "Reason must be 3-500 characters", http.StatusBadRequest)
+		return
+	}
+	if !validSuspensionLength(w, request.DurationHours, request.Permanent) {
+		return
+	}
+	if S.users == nil || S.moderation == nil {
+		http.Error(w, "Moderation repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+	if !S.canModerate(w, identity, request.UserID) {
+		return
+	}
+
+	suspension, err := S.suspendUser(r, identity, request.UserID, request.Reason, request.DurationHours)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(suspension)
+}
+
+func (S *Server) UnsuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		UserID int64 `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if S.users == nil || S.moderation == nil {
+		http.Error(w, "Moderation repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+	if !S.canModerate(w, identity, request.UserID) {
+		return
+	}
+
+	err := S.moderation.Lift(request.UserID, identity.UserID, time.Now())
+	if errors.Is(err, moderation.ErrNotSuspended) {
+		http.Error(w, "User is not suspended", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	event := actorEvent(identity, audit.ActionUserUnsuspended)
+	event.TargetType, event.TargetID = audit.TargetUser, request.UserID
+	S.recordAudit(r, event)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
@@ -0,0 +1,121 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/moderation"
+)
+
+func newModerationTestServer(t *testing.T) *Server {
+	t.Helper()
+	server, _ := newProfileTestServer(t)
+	server.throttle = account.NewThrottleRepository(server.db)
+	server.moderation = moderation.NewRepository(server.db)
+	for _, nickname := range []string{"bob", "carol"} {
+		if err := server.users.Create(account.UserRecord{
+			Nickname: nickname, FirstName: "Test", LastName: "User",
+			Email: nickname + "@example.com", Password: "Password1", Age: 30, Gender: "male",
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.sessions.Create(nickname+"-session", nickname, time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := server.users.SetRoleByNickname("carol", account.RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestSuspendUserRevokesSessionsAndBlocksLogin(t *testing.T) {
+	server := newModerationTestServer(t)
+	bob := &Client{ID: "bob-client", UserID: 2, SessionID: "bob-session", Send: make(chan interface{}, 4)}
+	server.hub.Register(bob)
+
+	request := httptest.NewRequest(http.MethodPost, "/moderation/suspend",
+		strings.NewReader(`{"user_id":2,"reason":"spamming links","duration_hours":48}`))
+	withSession(t, server, request, "carol-session")
+	recorder := httptest.NewRecorder()
+	server.Authorized(account.PermissionSuspendUsers, server.SuspendUserHandler).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want 201: %s", recorder.Code, recorder.Body.String())
+	}
+
+	if server.hub.IsOnline(2) {
+		t.Fatal("suspended user's WebSocket clients should be disconnected")
+	}
+	if _, err := server.sessions.FindValid("bob-session"); err == nil {
+		t.Fatal("suspended user's sessions should be deleted")
+	}
+
+	recorder = httptest.NewRecorder()
+	server.LoginHandler(recorder, httptest.NewRequest(http.MethodPost, "/login",
+		strings.NewReader(`{"identifier":"bob","password":"Password1"}`)))
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("suspended login got %d, want 403", recorder.Code)
+	}
+	var body map[string]interface{}
+	if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["suspended_until"] == nil || body["reason"] != "spamming links" {
+		t.Fatalf("unexpected suspension response: %#v", body)
+	}
+}
+
+func TestModeratorCannotSuspendPeers(t *testing.T) {
+	server := newModerationTestServer(t)
+	if err := server.users.SetRoleByNickname("bob", account.RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/moderation/suspend",
+		strings.NewReader(`{"user_id":2,"reason":"disagreement","duration_hours":1}`))
+	withSession(t, server, request, "carol-session")
+	recorder := httptest.NewRecorder()
+	server.Authorized(account.PermissionSuspendUsers, server.SuspendUserHandler).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("got status %d, want 403", recorder.Code)
+	}
+}
+
+func TestSuspensionNeedsADurationOrAnExplicitBan(t *testing.T) {
+	server := newModerationTestServer(t)
+	suspend := func(body string) int {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodPost, "/moderation/suspend", strings.NewReader(body))
+		withSession(t, server, request, "carol-session")
+		recorder := httptest.NewRecorder()
+		server.Authorized(account.PermissionSuspendUsers, server.SuspendUserHandler).ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	for _, body := range []string{
+		`{"user_id":2,"reason":"spamming links"}`,
+		`{"user_id":2,"reason":"spamming links","duration_hours":0}`,
+		`{"user_id":2,"reason":"spamming links","duration_hours":9000}`,
+		`{"user_id":2,"reason":"spamming links","duration_hours":24,"permanent":true}`,
+	} {
+		if code := suspend(body); code != http.StatusBadRequest {
+			t.Fatalf("%s got %d, want 400", body, code)
+		}
+	}
+	if _, err := server.moderation.ActiveSuspension(2, time.Now()); err == nil {
+		t.Fatal("a rejected request suspended the user")
+	}
+
+	if code := suspend(`{"user_id":2,"reason":"spamming links","permanent":true}`); code != http.StatusCreated {
+		t.Fatalf("explicit ban got %d, want 201", code)
+	}
+	suspension, err := server.moderation.ActiveSuspension(2, time.Now())
+	if err != nil || suspension.ExpiresAt != nil {
+		t.Fatalf("ban = %+v, %v; want an active suspension without an expiry", suspension, err)
+	}
+}
@@ -0,0 +1,152 @@
+package notification
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Type says what a notification is about; the payload shape depends on it.
+type Type string
+
+const (
+	TypePost       Type = "post"
+	TypeComment    Type = "comment"
+	TypeReply      Type = "reply"
+	TypeMention    Type = "mention"
+	TypeReaction   Type = "reaction"
+	TypeModeration Type = "moderation"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// Notification is one entry in a user's notification feed. Actor is the
+// nickname of the user who caused it, empty for system notifications.
+type Notification struct {
+	ID        int64           `json:"id"`
+	Type      Type            `json:"type"`
+	ActorID   int64           `json:"actor_id,omitempty"`
+	Actor     string          `json:"actor,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+	Channel   Channel         `json:"channel"`
+	CreatedAt time.Time       `json:"created_at"`
+	ReadAt    *time.Time      `json:"read_at,omitempty"`
+}
+
+// Page selects notifications older than BeforeID, newest first. A zero
+// BeforeID starts from the newest notification.
+type Page struct {
+	BeforeID   int64
+	Limit      int
+	UnreadOnly bool
+}
+
+// Create stores a notification for userID. actorID is 0 for notifications
+// nobody in particular caused; payload is encoded as JSON. channel records
+// the user's preference at the time, so that later changes do not pull old
+// notifications into a digest.
+func (r *Repository) Create(userID int64, kind Type, actorID int64, payload any, channel Channel, now time.Time) (Notification, error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return Notification{}, fmt.Errorf("encode %s notification payload: %w", kind, err)
+	}
+	var actor sql.NullInt64
+	if actorID != 0 {
+		actor = sql.NullInt64{Int64: actorID, Valid: true}
+	}
+	result, err := r.db.Exec(`
+		INSERT INTO user_notifications (user_id, type, actor_id, payload, channel, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, userID, kind, actor, string(encoded), channel, now.UTC())
+	if err != nil {
+		return Notification{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Notification{}, err
+	}
+	return scanNotification(r.db.QueryRow(selectNotifications+" WHERE user_notifications.id = ?", id))
+}
+
+// List returns one page of the user's notifications, newest first.
+func (r *Repository) List(userID int64, page Page) ([]Notification, error) {
+	if page.Limit <= 0 {
+		page.Limit = DefaultPageSize
+	}
+	if page.Limit > MaxPageSize {
+		page.Limit = MaxPageSize
+	}
+	query := selectNotifications + " WHERE user_notifications.user_id = ?"
+	args := []any{userID}
+	if page.BeforeID > 0 {
+		query += " AND user_notifications.id < ?"
+		args = append(args, page.BeforeID)
+	}
+	if page.UnreadOnly {
+		query += " AND user_notifications.read_at IS NULL"
+	}
+	query += " ORDER BY user_notifications.id DESC LIMIT ?"
+	args = append(args, page.Limit)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		notification, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, rows.Err()
+}
+
+// MarkNotificationsRead marks the given notifications of userID as read, or
+// all of them when ids is empty. It returns how many were marked.
+func (r *Repository) MarkNotificationsRead(userID int64, ids []int64, now time.Time) (int64, error) {
+	query := "UPDATE user_notifications SET read_at = ? WHERE user_id = ? AND read_at IS NULL"
+	args := []any{now.UTC(), userID}
+	if len(ids) > 0 {
+		query += " AND id IN (?" + strings.Repeat(", ?", len(ids)-1) + ")"
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	result, err := r.db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const selectNotifications = `
+	SELECT user_notifications.id, user_notifications.type,
+	       COALESCE(user_notifications.actor_id, 0), COALESCE(users.nickname, ''),
+	       user_notifications.payload, user_notifications.channel,
+	       user_notifications.created_at, user_notifications.read_at
+	FROM user_notifications
+	LEFT JOIN users ON users.id = user_notifications.actor_id`
+
+func scanNotification(scanner interface{ Scan(...interface{}) error }) (Notification, error) {
+	var notification Notification
+	var payload string
+	var readAt sql.NullTime
+	err := scanner.Scan(&notification.ID, &notification.Type, &notification.ActorID, &notification.Actor,
+		&payload, &notification.Channel, &notification.CreatedAt, &readAt)
+	if err != nil {
+		return Notification{}, err
+	}
+	notification.Payload = json.RawMessage(payload)
+	if readAt.Valid {
+		notification.ReadAt = &readAt.Time
+	}
+	return notification, nil
+}
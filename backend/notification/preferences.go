@@ -0,0 +1,266 @@
+package notification
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TypeMessage is the preference key for chat messages. Messages are counted
+// by the unread counters rather than stored in the feed.
+const TypeMessage Type = "message"
+
+// Types lists every notification type a user can set a preference for.
+var Types = []Type{TypeMessage, TypePost, TypeComment, TypeReply, TypeMention, TypeReaction, TypeModeration}
+
+// Channel says how a notification type reaches the user. In-app
+// notifications are stored and pushed live; email digest ones are also
+// collected into the periodic email; off ones are dropped.
+type Channel string
+
+const (
+	ChannelInApp       Channel = "in_app"
+	ChannelEmailDigest Channel = "email_digest"
+	ChannelOff         Channel = "off"
+)
+
+var (
+	ErrInvalidPreference = errors.New("invalid notification preference")
+	ErrInvalidQuietHours = errors.New("quiet hours need distinct HH:MM start and end times and an IANA time zone")
+)
+
+// QuietHours is a daily window, in the user's time zone, during which
+// notifications are stored but not pushed. The window may cross midnight.
+type QuietHours struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	TimeZone string `json:"timezone"`
+}
+
+// Contains reports whether now falls inside the window.
+func (q QuietHours) Contains(now time.Time) bool {
+	location, err := time.LoadLocation(q.TimeZone)
+	if err != nil {
+		return false
+	}
+	start, startErr := time.Parse("15:04", q.Start)
+	end, endErr := time.Parse("15:04", q.End)
+	if startErr != nil || endErr != nil {
+		return false
+	}
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	from, to := start.Hour()*60+start.Minute(), end.Hour()*60+end.Minute()
+	if from < to {
+		return minute >= from && minute < to
+	}
+	return minute >= from || minute < to
+}
+
+// Validate reports ErrInvalidQuietHours unless both times are HH:MM, they
+// differ, and the time zone is known.
+func (q QuietHours) Validate() error {
+	start, startErr := time.Parse("15:04", q.Start)
+	end, endErr := time.Parse("15:04", q.End)
+	if startErr != nil || endErr != nil || start.Equal(end) || q.TimeZone == "" {
+		return ErrInvalidQuietHours
+	}
+	if _, err := time.LoadLocation(q.TimeZone); err != nil {
+		return ErrInvalidQuietHours
+	}
+	return nil
+}
+
+type MutedConversation struct {
+	UserID   int64      `json:"user_id"`
+	Nickname string     `json:"nickname"`
+	Until    *time.Time `json:"until,omitempty"`
+}
+
+// Preferences is everything a user has set, with defaults filled in for the
+// types they have not touched.
+type Preferences struct {
+	Channels   map[Type]Channel    `json:"channels"`
+	QuietHours *QuietHours         `json:"quiet_hours"`
+	Muted      []MutedConversation `json:"muted_conversations"`
+}
+
+// Delivery is what the recipient's preferences say about one notification.
+// Quiet means it is inside quiet hours: store it, but do not alert.
+type Delivery struct {
+	Channel Channel
+	Quiet   bool
+}
+
+func (r *Repository) Preferences(userID int64, now time.Time) (Preferences, error) {
+	preferences := Preferences{Channels: make(map[Type]Channel), Muted: []MutedConversation{}}
+	for _, kind := range Types {
+		preferences.Channels[kind] = ChannelInApp
+	}
+
+	rows, err := r.db.Query("SELECT type, channel FROM notification_preferences WHERE user_id = ?", userID)
+	if err != nil {
+		return Preferences{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind Type
+		var channel Channel
+		if err := rows.Scan(&kind, &channel); err != nil {
+			return Preferences{}, err
+		}
+		preferences.Channels[kind] = channel
+	}
+	if err := rows.Err(); err != nil {
+		return Preferences{}, err
+	}
+
+	quietHours, err := r.quietHours(userID)
+	if err != nil {
+		return Preferences{}, err
+	}
+	preferences.QuietHours = quietHours
+
+	mutes, err := r.db.Query(`
+		SELECT conversation_mutes.other_user_id, users.nickname, conversation_mutes.muted_until
+		FROM conversation_mutes
+		JOIN users ON users.id = conversation_mutes.other_user_id
+		WHERE conversation_mutes.user_id = ?
+		  AND (conversation_mutes.muted_until IS NULL OR conversation_mutes.muted_until > ?)
+		ORDER BY users.nickname`, userID, now.UTC())
+	if err != nil {
+		return Preferences{}, err
+	}
+	defer mutes.Close()
+	for mutes.Next() {
+		var muted MutedConversation
+		var until sql.NullTime
+		if err := mutes.Scan(&muted.UserID, &muted.Nickname, &until); err != nil {
+			return Preferences{}, err
+		}
+		if until.Valid {
+			muted.Until = &until.Time
+		}
+		preferences.Muted = append(preferences.Muted, muted)
+	}
+	return preferences, mutes.Err()
+}
+
+// SetChannels changes how the listed notification types are delivered.
+// Every entry is checked before any is saved, and they are saved in one
+// transaction. Moderation notices cannot be turned off.
+func (r *Repository) SetChannels(userID int64, channels map[Type]Channel) error {
+	for kind, channel := range channels {
+		if !knownType(kind) || (channel != ChannelInApp && channel != ChannelEmailDigest && channel != ChannelOff) ||
+			(kind == TypeModeration && channel == ChannelOff) {
+			return ErrInvalidPreference
+		}
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for kind, channel := range channels {
+		if _, err := tx.Exec(`
+			INSERT INTO notification_preferences (user_id, type, channel) VALUES (?, ?, ?)
+			ON CONFLICT(user_id, type) DO UPDATE SET channel = excluded.channel`, userID, kind, channel); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SetQuietHours replaces the user's quiet hours; nil removes them.
+func (r *Repository) SetQuietHours(userID int64, quietHours *QuietHours) error {
+	if quietHours == nil {
+		_, err := r.db.Exec("DELETE FROM quiet_hours WHERE user_id = ?", userID)
+		return err
+	}
+	if err := quietHours.Validate(); err != nil {
+		return err
+	}
+	_, err := r.db.Exec(`
+		INSERT INTO quiet_hours (user_id, start_time, end_time, timezone) VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			start_time = excluded.start_time, end_time = excluded.end_time, timezone = excluded.timezone`,
+		userID, quietHours.Start, quietHours.End, quietHours.TimeZone)
+	return err
+}
+
+// MuteConversation silences the conversation with otherUserID until the
+// given time, or until unmuted when until is nil.
+func (r *Repository) MuteConversation(userID, otherUserID int64, until *time.Time, now time.Time) error {
+	var mutedUntil sql.NullTime
+	if until != nil {
+		mutedUntil = sql.NullTime{Time: until.UTC(), Valid: true}
+	}
+	_, err := r.db.Exec(`
+		INSERT INTO conversation_mutes (user_id, other_user_id, muted_until, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id, other_user_id) DO UPDATE SET muted_until = excluded.muted_until`,
+		userID, otherUserID, mutedUntil, now.UTC())
+	return err
+}
+
+func (r *Repository) UnmuteConversation(userID, otherUserID int64) error {
+	_, err := r.db.Exec("DELETE FROM conversation_mutes WHERE user_id = ? AND other_user_id = ?", userID, otherUserID)
+	return err
+}
+
+// Delivery looks up how a notification of the given type should reach
+// userID. otherUserID is the other participant when the notification comes
+// from a chat conversation, which turns it off while that conversation is
+// muted; it is 0 otherwise.
+func (r *Repository) Delivery(userID int64, kind Type, otherUserID int64, now time.Time) (Delivery, error) {
+	delivery := Delivery{Channel: ChannelInApp}
+	err := r.db.QueryRow("SELECT channel FROM notification_preferences WHERE user_id = ? AND type = ?",
+		userID, kind).Scan(&delivery.Channel)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Delivery{}, fmt.Errorf("load %s preference: %w", kind, err)
+	}
+
+	if otherUserID != 0 {
+		var muted int
+		err := r.db.QueryRow(`
+			SELECT COUNT(*) FROM conversation_mutes
+			WHERE user_id = ? AND other_user_id = ? AND (muted_until IS NULL OR muted_until > ?)`,
+			userID, otherUserID, now.UTC()).Scan(&muted)
+		if err != nil {
+			return Delivery{}, fmt.Errorf("load conversation mute: %w", err)
+		}
+		if muted > 0 {
+			delivery.Channel = ChannelOff
+		}
+	}
+
+	quietHours, err := r.quietHours(userID)
+	if err != nil {
+		return Delivery{}, err
+	}
+	delivery.Quiet = quietHours != nil && quietHours.Contains(now)
+	return delivery, nil
+}
+
+func (r *Repository) quietHours(userID int64) (*QuietHours, error) {
+	var quietHours QuietHours
+	err := r.db.QueryRow("SELECT start_time, end_time, timezone FROM quiet_hours WHERE user_id = ?", userID).
+		Scan(&quietHours.Start, &quietHours.End, &quietHours.TimeZone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load quiet hours: %w", err)
+	}
+	return &quietHours, nil
+}
+
+func knownType(kind Type) bool {
+	for _, known := range Types {
+		if kind == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package notification
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietHoursCrossMidnightInTheUsersTimeZone(t *testing.T) {
+	quietHours := QuietHours{Start: "22:00", End: "07:00", TimeZone: "America/New_York"}
+	tests := []struct {
+		utc  string
+		want bool
+	}{
+		{utc: "2026-07-01T01:30:00Z", want: false}, // 21:30 in New York
+		{utc: "2026-07-01T02:00:00Z", want: true},  // 22:00
+		{utc: "2026-07-01T10:59:00Z", want: true},  // 06:59
+		{utc: "2026-07-01T11:00:00Z", want: false}, // 07:00
+		{utc: "2026-01-15T02:30:00Z", want: false}, // 21:30 in winter, UTC-5
+		{utc: "2026-01-15T03:30:00Z", want: true},  // 22:30 in winter
+	}
+	for _, test := range tests {
+		now, err := time.Parse(time.RFC3339, test.utc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := quietHours.Contains(now); got != test.want {
+			t.Fatalf("Contains(%s) = %v, want %v", test.utc, got, test.want)
+		}
+	}
+}
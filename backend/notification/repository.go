@@ -58,11 +58,32 @@ func (r *Repository) ListUnread(receiverID int64) (map[string]int, error) {
 	return notifications, rows.Err()
 }
 
-func (r *Repository) MarkRead(receiverID int64, senderNickname string) error {
-	_, err := r.db.Exec(`
+// UnreadCounts returns how many messages from senderID the receiver has not
+// read, and the receiver's unread total across all senders.
+func (r *Repository) UnreadCounts(receiverID, senderID int64) (int, int, error) {
+	var count, total int
+	err := r.db.QueryRow(`
+		SELECT COALESCE(SUM(CASE WHEN sender_id = ? THEN unread_messages END), 0),
+		       COALESCE(SUM(unread_messages), 0)
+		FROM notifications
+		WHERE receiver_id = ?`, senderID, receiverID).Scan(&count, &total)
+	return count, total, err
+}
+
+// MarkRead follows nickname changes, like chat.Repository.UserIDByNickname.
+// It reports whether there was anything unread to clear.
+func (r *Repository) MarkRead(receiverID int64, senderNickname string) (bool, error) {
+	result, err := r.db.Exec(`
 		UPDATE notifications
 		SET unread_messages = 0
-		WHERE receiver_id = ?
-		  AND sender_id = (SELECT id FROM users WHERE nickname = ?)`, receiverID, senderNickname)
-	return err
+		WHERE receiver_id = ? AND unread_messages > 0
+		  AND sender_id = COALESCE(
+			(SELECT id FROM users WHERE nickname = ?),
+			(SELECT user_id FROM nickname_history WHERE old_nickname = ? ORDER BY changed_at DESC LIMIT 1)
+		  )`, receiverID, senderNickname, senderNickname)
+	if err != nil {
+		return false, err
+	}
+	cleared, err := result.RowsAffected()
+	return cleared > 0, err
 }
@@ -0,0 +1,369 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/notification"
+)
+
+const maxMuteHours = 24 * 365
+
+// notify stores a notification for userID and pushes it to the user's open
+// connections, as far as the user's preferences allow. Users are never
+// notified of their own actions. A failure is logged rather than failing the
+// request that caused the notification.
+func (S *Server) notify(userID int64, kind notification.Type, actorID int64, payload interface{}) {
+	S.notifyConversation(userID, kind, actorID, 0, payload)
+}
+
+// notifyConversation is notify for notifications that come from a chat
+// conversation with otherUserID, which the recipient may have muted.
+func (S *Server) notifyConversation(userID int64, kind notification.Type, actorID, otherUserID int64, payload interface{}) {
+	if S.notifications == nil || userID == 0 || userID == actorID {
+		return
+	}
+	now := time.Now()
+	delivery, err := S.notifications.Delivery(userID, kind, otherUserID, now)
+	if err != nil {
+		log.Printf("failed to load notification preferences of user %d: %v", userID, err)
+		delivery = notification.Delivery{Channel: notification.ChannelInApp}
+	}
+	if delivery.Channel == notification.ChannelOff {
+		return
+	}
+	created, err := S.notifications.Create(userID, kind, actorID, payload, delivery.Channel, now)
+	if err != nil {
+		log.Printf("failed to notify user %d of %s: %v", userID, kind, err)
+		return
+	}
+	if S.hub != nil && !delivery.Quiet {
+		S.hub.SendToUser(userID, map[string]interface{}{
+			"event":        "notification",
+			"notification": created,
+		})
+	}
+}
+
+// pushUnread sends the receiver's unread count for one sender, and their
+// total, to every connection of the receiver. It runs after each change to a
+// counter so that all of the receiver's tabs show the same badges.
+func (S *Server) pushUnread(receiverID, senderID int64, sender string) {
+	if S.notifications == nil || S.hub == nil {
+		return
+	}
+	count, total, err := S.notifications.UnreadCounts(receiverID, senderID)
+	if err != nil {
+		log.Printf("failed to count unread messages for user %d: %v", receiverID, err)
+		return
+	}
+	S.hub.SendToUser(receiverID, map[string]interface{}{
+		"event":  "unread_changed",
+		"sender": sender,
+		"count":  count,
+		"total":  total,
+	})
+}
+
+// notifyComment tells the author of the parent comment about a reply, and
+// everyone following the post about the new comment, with a notification and
+// a live comment_created event. Someone who gets the reply is not also told
+// about the comment.
+func (S *Server) notifyComment(actorID int64, postID, parentID int, commentID int64) {
+	title, err := S.forum.PostTitle(postID)
+	if err != nil {
+		log.Printf("failed to look up title of post %d: %v", postID, err)
+		return
+	}
+	payload := map[string]interface{}{
+		"post_id":    postID,
+		"post_title": title,
+		"comment_id": commentID,
+	}
+
+	var parentAuthorID int64
+	if parentID != 0 {
+		payload["parent_comment_id"] = parentID
+		parentAuthorID, err = S.forum.CommentAuthorID(parentID)
+		if err != nil {
+			log.Printf("failed to look up author of comment %d: %v", parentID, err)
+		} else {
+			S.notify(parentAuthorID, notification.TypeReply, actorID, payload)
+		}
+	}
+
+	if S.subscriptions == nil {
+		return
+	}
+	subscribers, err := S.subscriptions.PostSubscribers(int64(postID))
+	if err != nil {
+		log.Printf("failed to list subscribers of post %d: %v", postID, err)
+		return
+	}
+	event := map[string]interface{}{
+		"event":      "comment_created",
+		"post_id":    postID,
+		"comment_id": commentID,
+		"parent_id":  parentID,
+	}
+	for _, userID := range subscribers {
+		if userID == actorID {
+			continue
+		}
+		if userID != parentAuthorID {
+			S.notify(userID, notification.TypeComment, actorID, payload)
+		}
+		if S.hub != nil {
+			S.hub.SendToUser(userID, event)
+		}
+	}
+}
+
+// ListNotificationFeed returns the user's typed notifications, newest
+// first. Pass the smallest id seen as before_id to fetch the next page.
+func (S *Server) ListNotificationFeed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if S.notifications == nil {
+		http.Error(w, "Notification repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	page := notification.Page{UnreadOnly: r.URL.Query().Get("unread") == "true"}
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > notification.MaxPageSize {
+			http.Error(w, "Limit must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+		page.Limit = limit
+	}
+	if value := r.URL.Query().Get("before_id"); value != "" {
+		beforeID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || beforeID < 1 {
+			http.Error(w, "Invalid before_id", http.StatusBadRequest)
+			return
+		}
+		page.BeforeID = beforeID
+	}
+
+	notifications, err := S.notifications.List(identity.UserID, page)
+	if err != nil {
+		http.Error(w, "Failed to fetch notifications", http.StatusInternalServerError)
+		return
+	}
+	response := map[string]interface{}{"notifications": notifications}
+	if page.Limit == 0 {
+		page.Limit = notification.DefaultPageSize
+	}
+	if len(notifications) == page.Limit {
+		response["next_before_id"] = notifications[len(notifications)-1].ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// MarkNotificationFeedRead marks the listed notifications as read, or all of
+// the user's notifications when ids is empty.
+func (S *Server) MarkNotificationFeedRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		IDs []int64 `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if len(request.IDs) > notification.MaxPageSize {
+		http.Error(w, "At most 100 notifications can be marked at once", http.StatusBadRequest)
+		return
+	}
+	if S.notifications == nil {
+		http.Error(w, "Notification repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	marked, err := S.notifications.MarkNotificationsRead(identity.UserID, request.IDs, time.Now())
+	if err != nil {
+		http.Error(w, "Failed to mark notifications as read", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"marked": marked})
+}
+
+func (S *Server) NotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if S.notifications == nil {
+		http.Error(w, "Notification repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		// quiet_hours is raw so that an explicit null, which removes the
+		// quiet hours, can be told apart from leaving them out.
+		var request struct {
+			Channels    map[notification.Type]notification.Channel `json:"channels"`
+			QuietHours  json.RawMessage                            `json:"quiet_hours"`
+			EmailDigest *bool                                      `json:"email_digest"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		// Quiet hours are checked before the channels are saved, so a
+		// rejected request changes nothing.
+		var quietHours *notification.QuietHours
+		if len(request.QuietHours) > 0 {
+			if err := json.Unmarshal(request.QuietHours, &quietHours); err != nil {
+				http.Error(w, "Bad Request", http.StatusBadRequest)
+				return
+			}
+			if quietHours != nil && quietHours.Validate() != nil {
+				http.Error(w, "Quiet hours need HH:MM start and end times that differ and an IANA time zone such as Europe/Paris", http.StatusBadRequest)
+				return
+			}
+		}
+		err := S.notifications.SetChannels(identity.UserID, request.Channels)
+		if errors.Is(err, notification.ErrInvalidPreference) {
+			http.Error(w, "Channel must be in_app, email_digest, or off for a known type; moderation cannot be off", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if len(request.QuietHours) > 0 {
+			if err := S.notifications.SetQuietHours(identity.UserID, quietHours); err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+		if request.EmailDigest != nil && S.digests != nil {
+			if err := S.digests.SetSubscribed(identity.UserID, *request.EmailDigest, time.Now()); err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	S.writeNotificationPreferences(w, identity.UserID)
+}
+
+// MuteConversationHandler mutes or unmutes the conversation with a user.
+// A muted conversation still delivers messages but does not count them as
+// unread or notify about mentions in them.
+func (S *Server) MuteConversationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Nickname string `json:"nickname"`
+		Muted    bool   `json:"muted"`
+		Hours    int    `json:"hours"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if request.Hours < 0 || request.Hours > maxMuteHours {
+		http.Error(w, "Hours must be 0 (until unmuted) to 8760", http.StatusBadRequest)
+		return
+	}
+	if S.notifications == nil || S.users == nil {
+		http.Error(w, "Notification repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+	otherUserID, _, err := S.users.ResolveNickname(request.Nickname)
+	if errors.Is(err, account.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if otherUserID == identity.UserID {
+		http.Error(w, "You cannot mute a conversation with yourself", http.StatusBadRequest)
+		return
+	}
+
+	if request.Muted {
+		now := time.Now()
+		var until *time.Time
+		if request.Hours > 0 {
+			expiresAt := now.Add(time.Duration(request.Hours) * time.Hour)
+			until = &expiresAt
+		}
+		err = S.notifications.MuteConversation(identity.UserID, otherUserID, until, now)
+	} else {
+		err = S.notifications.UnmuteConversation(identity.UserID, otherUserID)
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	S.writeNotificationPreferences(w, identity.UserID)
+}
+
+// writeNotificationPreferences also reports whether the user gets the email
+// digest, which the unsubscribe link turns off.
+func (S *Server) writeNotificationPreferences(w http.ResponseWriter, userID int64) {
+	preferences, err := S.notifications.Preferences(userID, time.Now())
+	if err != nil {
+		http.Error(w, "Failed to fetch notification preferences", http.StatusInternalServerError)
+		return
+	}
+	emailDigest := false
+	if S.digests != nil {
+		emailDigest, err = S.digests.Subscribed(userID)
+		if err != nil {
+			http.Error(w, "Failed to fetch notification preferences", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		notification.Preferences
+		EmailDigest bool `json:"email_digest"`
+	}{preferences, emailDigest})
+}
@@ -0,0 +1,252 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/chat"
+	"real-time-forum/backend/notification"
+	"real-time-forum/backend/subscription"
+)
+
+func TestCommentsAndRepliesNotifyAuthorsLive(t *testing.T) {
+	server := newModerationTestServer(t)
+	server.notifications = notification.NewRepository(server.db)
+	server.subscriptions = subscription.NewRepository(server.db)
+	// The fixture post bypasses CreatePostHandler, which subscribes its author.
+	if err := server.subscriptions.Subscribe(1, subscription.Post(1), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	alice := &Client{ID: "alice-client", UserID: 1, Send: make(chan interface{}, 8)}
+	bob := &Client{ID: "bob-client", UserID: 2, Send: make(chan interface{}, 4)}
+	server.hub.Register(alice)
+	server.hub.Register(bob)
+
+	comment := func(sessionID, body string) {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodPost, "/createComment", strings.NewReader(body))
+		withSession(t, server, request, sessionID)
+		recorder := httptest.NewRecorder()
+		server.SessionMiddleware(http.HandlerFunc(server.CreateCommentHandler)).ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusCreated {
+			t.Fatalf("got status %d, want 201: %s", recorder.Code, recorder.Body.String())
+		}
+	}
+	comment("bob-session", `{"post_id":1,"content":"Nice post"}`)
+	comment("carol-session", `{"post_id":1,"parent_id":1,"content":"Agreed, bob"}`)
+
+	event, ok := (<-bob.Send).(map[string]interface{})
+	if !ok || event["event"] != "notification" {
+		t.Fatalf("bob got %#v, want a notification event", event)
+	}
+	if pushed := event["notification"].(notification.Notification); pushed.Type != notification.TypeReply || pushed.Actor != "carol" {
+		t.Fatalf("bob got %+v, want a reply from carol", pushed)
+	}
+	notifications, created := 0, 0
+	for len(alice.Send) > 0 {
+		switch (<-alice.Send).(map[string]interface{})["event"] {
+		case "notification":
+			notifications++
+		case "comment_created":
+			created++
+		}
+	}
+	if notifications != 2 || created != 2 {
+		t.Fatalf("post author got %d notifications and %d comment_created events, want 2 of each", notifications, created)
+	}
+
+	if _, err := server.sessions.Create("alice-session", "alice", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	request := httptest.NewRequest(http.MethodGet, "/notifications/feed?limit=1", nil)
+	withSession(t, server, request, "alice-session")
+	recorder := httptest.NewRecorder()
+	server.WithScope(account.ScopeNotificationsRead, server.ListNotificationFeed).ServeHTTP(recorder, request)
+	var page struct {
+		Notifications []notification.Notification `json:"notifications"`
+		NextBeforeID  int64                       `json:"next_before_id"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Notifications) != 1 || page.Notifications[0].Actor != "carol" || page.NextBeforeID == 0 {
+		t.Fatalf("first page = %+v", page)
+	}
+
+	request = httptest.NewRequest(http.MethodPost, "/notifications/feed/read", strings.NewReader(`{"ids":[]}`))
+	withSession(t, server, request, "alice-session")
+	recorder = httptest.NewRecorder()
+	server.SessionMiddleware(http.HandlerFunc(server.MarkNotificationFeedRead)).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK || strings.TrimSpace(recorder.Body.String()) != `{"marked":2}` {
+		t.Fatalf("mark all read got %d %s, want 200 with 2 marked", recorder.Code, recorder.Body.String())
+	}
+	unread, err := server.notifications.List(1, notification.Page{UnreadOnly: true})
+	if err != nil || len(unread) != 0 {
+		t.Fatalf("unread after marking = %v, %v; want none", unread, err)
+	}
+}
+
+func TestUnreadChangesArePushedToEveryTabOfTheReader(t *testing.T) {
+	server := newModerationTestServer(t)
+	server.chat = chat.NewRepository(server.db)
+	server.notifications = notification.NewRepository(server.db)
+	server.chatService = chat.NewService(server.db, server.chat, server.notifications)
+	alice := &Client{ID: "alice-client", UserID: 1, Username: "alice", Send: make(chan interface{}, 8)}
+	bobTabs := []*Client{
+		{ID: "bob-tab-1", UserID: 2, Username: "bob", SessionID: "bob-session", Send: make(chan interface{}, 8)},
+		{ID: "bob-tab-2", UserID: 2, Username: "bob", SessionID: "bob-session", Send: make(chan interface{}, 8)},
+	}
+	server.hub.Register(alice)
+	for _, tab := range bobTabs {
+		server.hub.Register(tab)
+	}
+
+	unreadEvent := func(client *Client) map[string]interface{} {
+		t.Helper()
+		for len(client.Send) > 0 {
+			if event, ok := (<-client.Send).(map[string]interface{}); ok && event["event"] == "unread_changed" {
+				return event
+			}
+		}
+		t.Fatalf("%s received no unread_changed event", client.ID)
+		return nil
+	}
+
+	server.handleChatMessage(alice, Message{To: "bob", Content: "hello", Type: "chat_message"})
+	server.handleChatMessage(alice, Message{To: "bob", Content: "are you there?", Type: "chat_message"})
+	for _, tab := range bobTabs {
+		first, second := unreadEvent(tab), unreadEvent(tab)
+		if first["sender"] != "alice" || first["count"] != 1 || second["count"] != 2 || second["total"] != 2 {
+			t.Fatalf("%s got %v then %v, want counts 1 and 2 from alice", tab.ID, first, second)
+		}
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/notifications/mark-read", strings.NewReader(`{"sender":"alice"}`))
+	withSession(t, server, request, "bob-session")
+	recorder := httptest.NewRecorder()
+	server.SessionMiddleware(http.HandlerFunc(server.MarkNotificationsRead)).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", recorder.Code)
+	}
+	for _, tab := range bobTabs {
+		if event := unreadEvent(tab); event["count"] != 0 || event["total"] != 0 {
+			t.Fatalf("%s got %v after marking read, want 0 of 0", tab.ID, event)
+		}
+	}
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest(http.MethodPost, "/notifications/mark-read", strings.NewReader(`{"sender":"alice"}`))
+	withSession(t, server, request, "bob-session")
+	server.SessionMiddleware(http.HandlerFunc(server.MarkNotificationsRead)).ServeHTTP(recorder, request)
+	if len(bobTabs[0].Send) != 0 {
+		t.Fatal("marking an already read conversation should not push an event")
+	}
+}
+
+func TestNotificationPreferencesFilterAndSilenceDelivery(t *testing.T) {
+	server := newModerationTestServer(t)
+	server.notifications = notification.NewRepository(server.db)
+	bob := &Client{ID: "bob-client", UserID: 2, Send: make(chan interface{}, 4)}
+	server.hub.Register(bob)
+
+	update := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodPost, "/notifications/preferences", strings.NewReader(body))
+		withSession(t, server, request, "bob-session")
+		recorder := httptest.NewRecorder()
+		server.SessionMiddleware(http.HandlerFunc(server.NotificationPreferencesHandler)).ServeHTTP(recorder, request)
+		return recorder
+	}
+	if recorder := update(`{"channels":{"moderation":"off"}}`); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("turning moderation off got %d, want 400", recorder.Code)
+	}
+	if recorder := update(`{"quiet_hours":{"start":"22:00","end":"07:00","timezone":"Mars/Olympus"}}`); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("unknown time zone got %d, want 400", recorder.Code)
+	}
+	if recorder := update(`{"channels":{"mention":"off","moderation":"off","reply":"off"}}`); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("mixed valid and invalid channels got %d, want 400", recorder.Code)
+	}
+	if recorder := update(`{"channels":{"mention":"off"},"quiet_hours":{"start":"22:00","end":"22:00","timezone":"UTC"}}`); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("invalid quiet hours got %d, want 400", recorder.Code)
+	}
+	if rejected, err := server.notifications.Preferences(2, time.Now()); err != nil ||
+		rejected.Channels[notification.TypeMention] != notification.ChannelInApp ||
+		rejected.Channels[notification.TypeReply] != notification.ChannelInApp {
+		t.Fatalf("rejected requests saved channels: %+v, %v", rejected.Channels, err)
+	}
+	recorder := update(`{"channels":{"mention":"off","reply":"email_digest"}}`)
+	var preferences notification.Preferences
+	if err := json.NewDecoder(recorder.Body).Decode(&preferences); err != nil {
+		t.Fatal(err)
+	}
+	if preferences.Channels[notification.TypeMention] != notification.ChannelOff ||
+		preferences.Channels[notification.TypeReply] != notification.ChannelEmailDigest ||
+		preferences.Channels[notification.TypeComment] != notification.ChannelInApp {
+		t.Fatalf("unexpected preferences: %+v", preferences.Channels)
+	}
+
+	server.notify(2, notification.TypeMention, 1, map[string]interface{}{})
+	server.notify(2, notification.TypeReply, 1, map[string]interface{}{})
+	stored, err := server.notifications.List(2, notification.Page{})
+	if err != nil || len(stored) != 1 || stored[0].Channel != notification.ChannelEmailDigest {
+		t.Fatalf("stored %+v, %v; want only the reply, marked for the digest", stored, err)
+	}
+	if len(bob.Send) != 1 {
+		t.Fatalf("bob got %d live notifications, want 1", len(bob.Send))
+	}
+	<-bob.Send
+
+	now := time.Now().UTC()
+	start, end := now.Add(-time.Hour).Format("15:04"), now.Add(time.Hour).Format("15:04")
+	if recorder := update(`{"quiet_hours":{"start":"` + start + `","end":"` + end + `","timezone":"UTC"}}`); recorder.Code != http.StatusOK {
+		t.Fatalf("setting quiet hours got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	server.notify(2, notification.TypeComment, 1, map[string]interface{}{})
+	if len(bob.Send) != 0 {
+		t.Fatal("quiet hours should hold back the live push")
+	}
+	if stored, err := server.notifications.List(2, notification.Page{}); err != nil || len(stored) != 2 {
+		t.Fatalf("quiet hours should still store the notification, got %d, %v", len(stored), err)
+	}
+}
+
+func TestDeletingContentDeletesItsNotifications(t *testing.T) {
+	server := newModerationTestServer(t)
+	commentID, err := server.forum.CreateComment(1, 0, 2, "A comment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	for _, statement := range []string{
+		"INSERT INTO user_notifications (user_id, type, actor_id, payload, created_at) VALUES (1, 'comment', 2, json_object('post_id', 1, 'comment_id', ?), ?)",
+		"INSERT INTO user_notifications (user_id, type, payload, created_at) VALUES (2, 'moderation', json_object('action', 'hide', 'target_type', 'comment', 'comment_id', ?), ?)",
+	} {
+		if _, err := server.db.Exec(statement, commentID, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := server.db.Exec("INSERT INTO user_notifications (user_id, type, actor_id, payload, created_at) VALUES (2, 'post', 1, json_object('post_id', 1), ?)", now); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.forum.DeleteComment(int(commentID)); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, server, "SELECT COUNT(*) FROM user_notifications WHERE type = 'comment'"); n != 0 {
+		t.Fatalf("%d notifications still point at the deleted comment", n)
+	}
+	if err := server.forum.DeletePost(1); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, server, "SELECT COUNT(*) FROM user_notifications WHERE type = 'post'"); n != 0 {
+		t.Fatalf("%d notifications still point at the deleted post", n)
+	}
+	if n := countRows(t, server, "SELECT COUNT(*) FROM user_notifications WHERE type = 'moderation'"); n != 1 {
+		t.Fatal("the moderation notice should stay as moderation history")
+	}
+}
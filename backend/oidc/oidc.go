@@ -0,0 +1,405 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidIDToken = errors.New("invalid ID token")
+	ErrProvider       = errors.New("identity provider error")
+)
+
+// clockSkew is how far the provider's clock may differ from ours when
+// checking exp and iat.
+const clockSkew = time.Minute
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+// Metadata is the part of the discovery document the client needs.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Claims are the ID token claims used for sign-in and provisioning.
+type Claims struct {
+	Issuer            string
+	Subject           string
+	Audience          []string
+	AuthorizedParty   string
+	Expiry            time.Time
+	IssuedAt          time.Time
+	Nonce             string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	GivenName         string
+	FamilyName        string
+	PreferredUsername string
+}
+
+// rawClaims is the JWT payload. aud may be a string or a list, and some
+// providers send email_verified as the string "true".
+type rawClaims struct {
+	Issuer            string          `json:"iss"`
+	Subject           string          `json:"sub"`
+	Audience          json.RawMessage `json:"aud"`
+	AuthorizedParty   string          `json:"azp"`
+	Expiry            int64           `json:"exp"`
+	IssuedAt          int64           `json:"iat"`
+	Nonce             string          `json:"nonce"`
+	Email             string          `json:"email"`
+	EmailVerified     json.RawMessage `json:"email_verified"`
+	Name              string          `json:"name"`
+	GivenName         string          `json:"given_name"`
+	FamilyName        string          `json:"family_name"`
+	PreferredUsername string          `json:"preferred_username"`
+}
+
+func (raw rawClaims) claims() (Claims, error) {
+	claims := Claims{
+		Issuer:            raw.Issuer,
+		Subject:           raw.Subject,
+		AuthorizedParty:   raw.AuthorizedParty,
+		Expiry:            time.Unix(raw.Expiry, 0),
+		IssuedAt:          time.Unix(raw.IssuedAt, 0),
+		Nonce:             raw.Nonce,
+		Email:             raw.Email,
+		EmailVerified:     strings.Trim(string(raw.EmailVerified), `"`) == "true",
+		Name:              raw.Name,
+		GivenName:         raw.GivenName,
+		FamilyName:        raw.FamilyName,
+		PreferredUsername: raw.PreferredUsername,
+	}
+	var single string
+	if err := json.Unmarshal(raw.Audience, &single); err == nil {
+		claims.Audience = []string{single}
+		return claims, nil
+	}
+	if err := json.Unmarshal(raw.Audience, &claims.Audience); err != nil {
+		return Claims{}, errors.New("aud must be a string or a list")
+	}
+	return claims, nil
+}
+
+// Client runs the authorization code flow with PKCE against one issuer. The
+// discovery document and signing keys are fetched on first use and cached.
+type Client struct {
+	config     Config
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	metadata *Metadata
+	keys     map[string]*rsa.PublicKey
+}
+
+func NewClient(config Config, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Client{config: config, httpClient: httpClient}
+}
+
+// AuthCodeURL returns the provider URL the browser is sent to.
+func (c *Client) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	metadata, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.config.ClientID},
+		"redirect_uri":          {c.config.RedirectURL},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange trades the authorization code for tokens and returns the raw ID
+// token. The caller must verify it with VerifyIDToken.
+func (c *Client) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	metadata, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+		"client_id":     {c.config.ClientID},
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if c.config.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(c.config.ClientID), url.QueryEscape(c.config.ClientSecret))
+	}
+
+	var response struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := c.doJSON(request, &response)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK || response.Error != "" {
+		return "", fmt.Errorf("%w: token endpoint returned %d %s %s", ErrProvider, status, response.Error, response.ErrorDescription)
+	}
+	if response.IDToken == "" {
+		return "", fmt.Errorf("%w: token response has no id_token", ErrProvider)
+	}
+	return response.IDToken, nil
+}
+
+// VerifyIDToken checks the RS256 signature against the provider's keys and
+// validates issuer, audience, expiry, and nonce.
+func (c *Client) VerifyIDToken(ctx context.Context, rawIDToken, nonce string, now time.Time) (Claims, error) {
+	metadata, err := c.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: malformed token", ErrInvalidIDToken)
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, fmt.Errorf("%w: header: %v", ErrInvalidIDToken, err)
+	}
+	if header.Algorithm != "RS256" {
+		return Claims{}, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidIDToken, header.Algorithm)
+	}
+	key, err := c.signingKey(ctx, metadata, header.KeyID)
+	if err != nil {
+		return Claims{}, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: signature encoding", ErrInvalidIDToken)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return Claims{}, fmt.Errorf("%w: bad signature", ErrInvalidIDToken)
+	}
+
+	var raw rawClaims
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return Claims{}, fmt.Errorf("%w: claims: %v", ErrInvalidIDToken, err)
+	}
+	claims, err := raw.claims()
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	switch {
+	case claims.Issuer != metadata.Issuer:
+		return Claims{}, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	case !contains(claims.Audience, c.config.ClientID):
+		return Claims{}, fmt.Errorf("%w: token was issued for another client", ErrInvalidIDToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != c.config.ClientID:
+		return Claims{}, fmt.Errorf("%w: unexpected authorized party", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return Claims{}, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	case now.After(claims.Expiry.Add(clockSkew)):
+		return Claims{}, fmt.Errorf("%w: token expired", ErrInvalidIDToken)
+	case claims.IssuedAt.After(now.Add(clockSkew)):
+		return Claims{}, fmt.Errorf("%w: token issued in the future", ErrInvalidIDToken)
+	case nonce == "" || claims.Nonce != nonce:
+		return Claims{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+func (c *Client) discover(ctx context.Context) (Metadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.metadata != nil {
+		return *c.metadata, nil
+	}
+
+	endpoint := strings.TrimRight(c.config.Issuer, "/") + "/.well-known/openid-configuration"
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return Metadata{}, err
+	}
+	var metadata Metadata
+	status, err := c.doJSON(request, &metadata)
+	if err != nil {
+		return Metadata{}, err
+	}
+	if status != http.StatusOK {
+		return Metadata{}, fmt.Errorf("%w: discovery returned %d", ErrProvider, status)
+	}
+	if metadata.Issuer != c.config.Issuer {
+		return Metadata{}, fmt.Errorf("%w: discovery issuer %q does not match %q", ErrProvider, metadata.Issuer, c.config.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return Metadata{}, fmt.Errorf("%w: discovery document is incomplete", ErrProvider)
+	}
+	c.metadata = &metadata
+	return metadata, nil
+}
+
+// signingKey returns the key for kid, refetching the key set once when the
+// provider has rotated keys since the last fetch.
+func (c *Client) signingKey(ctx context.Context, metadata Metadata, keyID string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	key, ok := c.lookupKey(keyID)
+	c.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, metadata.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			Use     string `json:"use"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+	status, err := c.doJSON(request, &set)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("%w: JWKS returned %d", ErrProvider, status)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.KeyType != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		modulus, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			continue
+		}
+		exponent, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(exponent) == 0 || len(exponent) > 4 {
+			continue
+		}
+		keys[jwk.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(modulus),
+			E: int(new(big.Int).SetBytes(exponent).Int64()),
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keys = keys
+	if key, ok := c.lookupKey(keyID); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidIDToken, keyID)
+}
+
+// lookupKey must be called with c.mu held. A token without kid is accepted
+// only when the provider publishes a single key.
+func (c *Client) lookupKey(keyID string) (*rsa.PublicKey, bool) {
+	if keyID == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	key, ok := c.keys[keyID]
+	return key, ok
+}
+
+func (c *Client) doJSON(request *http.Request, target interface{}) (int, error) {
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrProvider, err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrProvider, err)
+	}
+	if err := json.Unmarshal(body, target); err != nil && response.StatusCode == http.StatusOK {
+		return 0, fmt.Errorf("%w: invalid JSON from %s", ErrProvider, request.URL.Path)
+	}
+	return response.StatusCode, nil
+}
+
+// NewCodeVerifier returns a PKCE code verifier (RFC 7636, 43 characters).
+func NewCodeVerifier() (string, error) {
+	return RandomString()
+}
+
+// CodeChallenge derives the S256 challenge sent in the authorization request.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// RandomString returns 32 random bytes, base64url encoded, for state, nonce,
+// and PKCE values.
+func RandomString() (string, error) {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+func decodeSegment(segment string, target interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+func contains(values []string, want string) bool {
+	for _, value := range values {
+		if value == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"real-time-forum/backend/oidc"
+	"real-time-forum/backend/oidc/oidctest"
+)
+
+func TestAuthorizationCodeFlowWithPKCE(t *testing.T) {
+	provider := oidctest.NewProvider("forum", "secret")
+	defer provider.Close()
+	provider.SetUser(oidctest.User{Subject: "user-1", Email: "alice@example.com", EmailVerified: true})
+
+	client := oidc.NewClient(oidc.Config{
+		Issuer: provider.Issuer, ClientID: "forum", ClientSecret: "secret",
+		RedirectURL: "http://forum.test/auth/oidc/callback",
+	}, nil)
+	ctx := context.Background()
+	verifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, err := client.AuthCodeURL(ctx, "state-1", "nonce-1", oidc.CodeChallenge(verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect, err := provider.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redirect.Query().Get("state") != "state-1" {
+		t.Fatalf("state was not returned: %s", redirect)
+	}
+	code := redirect.Query().Get("code")
+
+	if _, err := client.Exchange(ctx, code, "wrong-verifier-wrong-verifier-wrong-verifier"); !errors.Is(err, oidc.ErrProvider) {
+		t.Fatalf("exchange with a wrong verifier got %v, want ErrProvider", err)
+	}
+
+	redirect, err = provider.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idToken, err := client.Exchange(ctx, redirect.Query().Get("code"), verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := client.VerifyIDToken(ctx, idToken, "nonce-1", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "user-1" || claims.Email != "alice@example.com" || !claims.EmailVerified {
+		t.Fatalf("unexpected claims: %#v", claims)
+	}
+	if _, err := client.VerifyIDToken(ctx, idToken, "other-nonce", time.Now()); !errors.Is(err, oidc.ErrInvalidIDToken) {
+		t.Fatalf("nonce mismatch got %v, want ErrInvalidIDToken", err)
+	}
+}
+
+func TestVerifyIDTokenRejectsBadClaims(t *testing.T) {
+	provider := oidctest.NewProvider("forum", "secret")
+	defer provider.Close()
+	client := oidc.NewClient(oidc.Config{Issuer: provider.Issuer, ClientID: "forum"}, nil)
+	now := time.Now()
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": provider.Issuer, "sub": "user-1", "aud": "forum", "nonce": "n",
+			"iat": now.Unix(), "exp": now.Add(time.Minute).Unix(),
+		}
+	}
+
+	tests := map[string]func(map[string]interface{}){
+		"other audience": func(c map[string]interface{}) { c["aud"] = "someone-else" },
+		"other issuer":   func(c map[string]interface{}) { c["iss"] = "https://evil.example" },
+		"expired":        func(c map[string]interface{}) { c["exp"] = now.Add(-time.Hour).Unix() },
+		"no subject":     func(c map[string]interface{}) { c["sub"] = "" },
+	}
+	for name, mutate := range tests {
+		claims := valid()
+		mutate(claims)
+		if _, err := client.VerifyIDToken(context.Background(), provider.Sign(claims), "n", now); !errors.Is(err, oidc.ErrInvalidIDToken) {
+			t.Fatalf("%s: got %v, want ErrInvalidIDToken", name, err)
+		}
+	}
+
+	token := provider.Sign(valid())
+	tampered := token[:len(token)-4] + "AAAA"
+	if _, err := client.VerifyIDToken(context.Background(), tampered, "n", now); !errors.Is(err, oidc.ErrInvalidIDToken) {
+		t.Fatalf("tampered signature got %v, want ErrInvalidIDToken", err)
+	}
+	if _, err := client.VerifyIDToken(context.Background(), token, "n", now); err != nil {
+		t.Fatalf("valid token got %v", err)
+	}
+}
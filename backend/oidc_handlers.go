@@ -0,0 +1,458 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/audit"
+	"real-time-forum/backend/oidc"
+)
+
+const (
+	oidcStateCookie  = "oidc_state"
+	oidcSignupCookie = "oidc_signup"
+	oidcLinkCookie   = "oidc_link"
+	oidcStateTTL     = 10 * time.Minute
+	oidcSignupTTL    = 30 * time.Minute
+	oidcLinkTTL      = 10 * time.Minute
+)
+
+var nicknameUnsafeChars = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+// OIDCConfigHandler tells the login page whether to offer single sign-on.
+func (S *Server) OIDCConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"enabled": S.oidc != nil})
+}
+
+// OIDCLoginHandler starts the authorization code flow. The state is stored
+// hashed with its nonce and PKCE verifier, and also set in a cookie so the
+// callback only completes in the browser that started it.
+func (S *Server) OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	if S.oidc == nil || S.oidcAccounts == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	verifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	loginState := account.LoginState{Nonce: nonce, CodeVerifier: verifier}
+	if err := S.oidcAccounts.SaveLoginState(state, loginState, now, now.Add(oidcStateTTL)); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	authURL, err := S.oidc.AuthCodeURL(r.Context(), state, nonce, oidc.CodeChallenge(verifier))
+	if err != nil {
+		log.Printf("OIDC discovery failed: %v", err)
+		http.Error(w, "Identity provider is unavailable", http.StatusBadGateway)
+		return
+	}
+
+	S.setOIDCCookie(w, oidcStateCookie, state, oidcStateTTL)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallbackHandler finishes the flow. A known identity signs in. An email
+// that matches an existing user is linked right away only when both the
+// provider and the forum have verified it; otherwise the user confirms the
+// link with their password first. Anyone else is sent to pick a nickname for
+// a new account.
+func (S *Server) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	if S.oidc == nil || S.oidcAccounts == nil || S.users == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	if providerError := query.Get("error"); providerError != "" {
+		http.Error(w, "Sign-in was cancelled or denied: "+providerError, http.StatusUnauthorized)
+		return
+	}
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || cookie.Value != state {
+		http.Error(w, "Invalid sign-in state", http.StatusBadRequest)
+		return
+	}
+	S.setOIDCCookie(w, oidcStateCookie, "", -1)
+
+	now := time.Now()
+	loginState, err := S.oidcAccounts.TakeLoginState(state, now)
+	if errors.Is(err, account.ErrLoginStateNotFound) {
+		http.Error(w, "Sign-in expired, please try again", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	rawIDToken, err := S.oidc.Exchange(r.Context(), query.Get("code"), loginState.CodeVerifier)
+	if err != nil {
+		log.Printf("OIDC code exchange failed: %v", err)
+		http.Error(w, "Identity provider rejected the sign-in", http.StatusBadGateway)
+		return
+	}
+	claims, err := S.oidc.VerifyIDToken(r.Context(), rawIDToken, loginState.Nonce, now)
+	if err != nil {
+		log.Printf("OIDC ID token rejected: %v", err)
+		http.Error(w, "Invalid ID token", http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := S.oidcAccounts.UserIDByIdentity(claims.Issuer, claims.Subject)
+	if errors.Is(err, account.ErrUserNotFound) && claims.Email != "" {
+		var emailVerified bool
+		userID, emailVerified, err = S.oidcAccounts.UserIDByEmail(claims.Email)
+		if err == nil && !(emailVerified && claims.EmailVerified) {
+			S.startOIDCLink(w, r, userID, claims, now)
+			return
+		}
+		if err == nil {
+			err = S.oidcAccounts.Link(userID, claims.Issuer, claims.Subject, claims.Email, now)
+		}
+	}
+	if errors.Is(err, account.ErrUserNotFound) {
+		S.startOIDCSignup(w, r, claims, now)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if _, csrfToken := S.loginUser(w, r, userID, "oidc"); csrfToken == "" {
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func (S *Server) startOIDCSignup(w http.ResponseWriter, r *http.Request, claims oidc.Claims, now time.Time) {
+	if !claims.EmailVerified || !isValidEmail(claims.Email) {
+		http.Error(w, "Your identity provider did not share a verified email address", http.StatusForbidden)
+		return
+	}
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName, lastName, _ = strings.Cut(strings.TrimSpace(claims.Name), " ")
+	}
+	token, err := S.oidcAccounts.SavePendingSignup(account.PendingSignup{
+		Issuer:            claims.Issuer,
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		FirstName:         truncateRunes(strings.TrimSpace(firstName), 50),
+		LastName:          truncateRunes(strings.TrimSpace(lastName), 50),
+		SuggestedNickname: suggestNickname(claims),
+	}, now, now.Add(oidcSignupTTL))
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	S.setOIDCCookie(w, oidcSignupCookie, token, oidcSignupTTL)
+	http.Redirect(w, r, "/#oidc-signup", http.StatusFound)
+}
+
+// startOIDCLink parks an identity whose email matches userID until the
+// owner of that account confirms it, so a provider account created with
+// someone else's email cannot take over their forum account.
+func (S *Server) startOIDCLink(w http.ResponseWriter, r *http.Request, userID int64, claims oidc.Claims, now time.Time) {
+	token, err := S.oidcAccounts.SavePendingLink(account.PendingLink{
+		UserID:  userID,
+		Issuer:  claims.Issuer,
+		Subject: claims.Subject,
+		Email:   claims.Email,
+	}, now, now.Add(oidcLinkTTL))
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	S.setOIDCCookie(w, oidcLinkCookie, token, oidcLinkTTL)
+	http.Redirect(w, r, "/#oidc-link", http.StatusFound)
+}
+
+// OIDCPendingLinkHandler returns the email and the nickname of the account
+// the identity would be linked to.
+func (S *Server) OIDCPendingLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	if S.oidcAccounts == nil {
+		http.Error(w, "OIDC repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+	cookie, err := r.Cookie(oidcLinkCookie)
+	if err != nil {
+		http.Error(w, "No pending link", http.StatusNotFound)
+		return
+	}
+	link, err := S.oidcAccounts.PendingLink(cookie.Value, time.Now())
+	if errors.Is(err, account.ErrPendingLinkNotFound) {
+		http.Error(w, "No pending link", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(link)
+}
+
+// OIDCConfirmLinkHandler links the pending identity once the user signs in
+// with the password of the matching account, then starts a session. Wrong
+// passwords count against the same lockout as the login form.
+func (S *Server) OIDCConfirmLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	var request struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Password == "" {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if S.oidcAccounts == nil || S.users == nil || S.throttle == nil {
+		http.Error(w, "OIDC repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+	cookie, err := r.Cookie(oidcLinkCookie)
+	if err != nil {
+		http.Error(w, "No pending link", http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	link, err := S.oidcAccounts.PendingLink(cookie.Value, now)
+	if errors.Is(err, account.ErrPendingLinkNotFound) {
+		http.Error(w, "Link expired, please sign in again", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	hashedPassword, err := S.users.PasswordHash(link.UserID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if hashedPassword == "" {
+		http.Error(w, "This account has no password. Sign in with an email link first to verify your email.", http.StatusConflict)
+		return
+	}
+	ip := clientIP(r)
+	accountKey := loginThrottleKey("", link.UserID)
+	lockedUntil, err := S.loginLockedUntil(accountKey, ip)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !lockedUntil.IsZero() {
+		writeLoginLocked(w, lockedUntil)
+		return
+	}
+	if !S.checkPassword(link.UserID, hashedPassword, request.Password) {
+		S.recordAudit(r, audit.Event{
+			Action: audit.ActionLoginFailed, TargetType: audit.TargetUser, TargetID: link.UserID,
+			IP: ip, Details: "method=oidc-link",
+		})
+		S.recordLoginFailure(w, accountKey, ip)
+		return
+	}
+	if err := S.throttle.Reset(account.ThrottleScopeAccount, accountKey); err != nil {
+		log.Printf("failed to reset login throttle: %v", err)
+	}
+
+	userID, err := S.oidcAccounts.CompleteLink(cookie.Value, now)
+	if errors.Is(err, account.ErrPendingLinkNotFound) {
+		http.Error(w, "Link expired, please sign in again", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	S.setOIDCCookie(w, oidcLinkCookie, "", -1)
+
+	nickname, csrfToken := S.loginUser(w, r, userID, "oidc")
+	if csrfToken == "" {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"username":   nickname,
+		"csrf_token": csrfToken,
+	})
+}
+
+// OIDCPendingSignupHandler returns the provider details and a suggested
+// nickname for the signup form.
+func (S *Server) OIDCPendingSignupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	if S.oidcAccounts == nil {
+		http.Error(w, "OIDC repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+	cookie, err := r.Cookie(oidcSignupCookie)
+	if err != nil {
+		http.Error(w, "No pending signup", http.StatusNotFound)
+		return
+	}
+	signup, err := S.oidcAccounts.PendingSignup(cookie.Value, time.Now())
+	if errors.Is(err, account.ErrPendingSignupNotFound) {
+		http.Error(w, "No pending signup", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(signup)
+}
+
+// OIDCCompleteSignupHandler creates the account with the chosen nickname and
+// signs it in. The pending signup cookie is SameSite=Strict, so this POST
+// cannot be triggered from another site.
+func (S *Server) OIDCCompleteSignupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	var request struct {
+		Nickname string `json:"nickname"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if !isValidNickname(request.Nickname) {
+		http.Error(w, "Invalid nickname: must be 3-20 characters, alphanumeric and underscore only", http.StatusBadRequest)
+		return
+	}
+	if S.oidcAccounts == nil || S.users == nil {
+		http.Error(w, "OIDC repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+	cookie, err := r.Cookie(oidcSignupCookie)
+	if err != nil {
+		http.Error(w, "No pending signup", http.StatusNotFound)
+		return
+	}
+
+	userID, err := S.oidcAccounts.CompleteSignup(cookie.Value, request.Nickname, time.Now())
+	switch {
+	case errors.Is(err, account.ErrPendingSignupNotFound):
+		http.Error(w, "Signup expired, please sign in again", http.StatusNotFound)
+		return
+	case errors.Is(err, account.ErrNicknameTaken):
+		http.Error(w, "Nickname already exists", http.StatusConflict)
+		return
+	case errors.Is(err, account.ErrEmailTaken):
+		http.Error(w, "Email already exists", http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	S.setOIDCCookie(w, oidcSignupCookie, "", -1)
+	S.recordAudit(r, audit.Event{
+		Action: audit.ActionRegister, ActorID: userID, ActorNickname: request.Nickname, Details: "method=oidc",
+	})
+
+	_, csrfToken := S.loginUser(w, r, userID, "oidc")
+	if csrfToken == "" {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"username":   request.Nickname,
+		"csrf_token": csrfToken,
+	})
+}
+
+// setOIDCCookie scopes the flow cookies to /auth/oidc. The state cookie is
+// Lax because the provider redirects back with a cross-site top-level GET.
+func (S *Server) setOIDCCookie(w http.ResponseWriter, name, value string, ttl time.Duration) {
+	sameSite := http.SameSiteStrictMode
+	if name == oidcStateCookie {
+		sameSite = http.SameSiteLaxMode
+	}
+	maxAge := int(ttl.Seconds())
+	if ttl < 0 {
+		maxAge = -1
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/auth/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		SameSite: sameSite,
+		Secure:   S.config.SecureCookies(),
+	})
+}
+
+// suggestNickname derives a valid nickname from the provider's username or
+// the email's local part. The user can change it before the account exists.
+func suggestNickname(claims oidc.Claims) string {
+	candidate := claims.PreferredUsername
+	if candidate == "" {
+		candidate, _, _ = strings.Cut(claims.Email, "@")
+	}
+	candidate = strings.Trim(nicknameUnsafeChars.ReplaceAllString(candidate, "_"), "_")
+	if len(candidate) > 20 {
+		candidate = candidate[:20]
+	}
+	if len(candidate) < 3 {
+		return ""
+	}
+	return candidate
+}
+
+func truncateRunes(value string, limit int) string {
+	runes := []rune(value)
+	if len(runes) > limit {
+		return string(runes[:limit])
+	}
+	return value
+}
@@ -0,0 +1,245 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/oidc"
+	"real-time-forum/backend/oidc/oidctest"
+)
+
+func newOIDCTestServer(t *testing.T) (*Server, *oidctest.Provider) {
+	t.Helper()
+	server, _ := newProfileTestServer(t)
+	provider := oidctest.NewProvider("forum", "client-secret")
+	t.Cleanup(provider.Close)
+	server.config.OIDC = oidc.Config{
+		Issuer:       provider.Issuer,
+		ClientID:     "forum",
+		ClientSecret: "client-secret",
+		RedirectURL:  "http://forum.test/auth/oidc/callback",
+	}
+	server.oidc = server.config.OIDCClient()
+	server.oidcAccounts = account.NewOIDCRepository(server.db)
+	server.throttle = account.NewThrottleRepository(server.db)
+	return server, provider
+}
+
+// signInWithOIDC runs the browser side of the flow: start the login, let the
+// provider approve it, and return the forum's response to the callback.
+func signInWithOIDC(t *testing.T, server *Server, provider *oidctest.Provider) *httptest.ResponseRecorder {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	server.OIDCLoginHandler(recorder, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+	if recorder.Code != http.StatusFound {
+		t.Fatalf("login got status %d, want 302: %s", recorder.Code, recorder.Body.String())
+	}
+	stateCookie := responseCookie(t, recorder, oidcStateCookie)
+
+	redirect, err := provider.Authorize(recorder.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redirect.Path != "/auth/oidc/callback" {
+		t.Fatalf("provider redirected to %s", redirect)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, redirect.RequestURI(), nil)
+	request.AddCookie(stateCookie)
+	recorder = httptest.NewRecorder()
+	server.OIDCCallbackHandler(recorder, request)
+	return recorder
+}
+
+func responseCookie(t *testing.T, recorder *httptest.ResponseRecorder, name string) *http.Cookie {
+	t.Helper()
+	for _, cookie := range recorder.Result().Cookies() {
+		if cookie.Name == name && cookie.Value != "" {
+			return cookie
+		}
+	}
+	t.Fatalf("response did not set the %s cookie", name)
+	return nil
+}
+
+func TestOIDCLinksExistingUserByVerifiedEmail(t *testing.T) {
+	server, provider := newOIDCTestServer(t)
+	if _, err := server.db.Exec("UPDATE users SET email_verified_at = CURRENT_TIMESTAMP WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	provider.SetUser(oidctest.User{Subject: "employee-17", Email: "Alice@Example.com", EmailVerified: true})
+
+	recorder := signInWithOIDC(t, server, provider)
+	if recorder.Code != http.StatusFound || recorder.Header().Get("Location") != "/" {
+		t.Fatalf("got %d to %q, want a redirect home: %s", recorder.Code, recorder.Header().Get("Location"), recorder.Body.String())
+	}
+	identity, err := server.sessions.FindValid(responseCookie(t, recorder, "session_token").Value)
+	if err != nil || identity.UserID != 1 {
+		t.Fatalf("session belongs to %#v (%v), want alice", identity, err)
+	}
+
+	// The link is by subject now, so a changed email still signs in as alice.
+	provider.SetUser(oidctest.User{Subject: "employee-17", Email: "alice@new.example", EmailVerified: true})
+	recorder = signInWithOIDC(t, server, provider)
+	if recorder.Code != http.StatusFound || recorder.Header().Get("Location") != "/" {
+		t.Fatalf("linked identity got %d to %q", recorder.Code, recorder.Header().Get("Location"))
+	}
+}
+
+func TestOIDCDoesNotLinkUnverifiedEmail(t *testing.T) {
+	server, provider := newOIDCTestServer(t)
+	if _, err := server.db.Exec("UPDATE users SET email_verified_at = CURRENT_TIMESTAMP WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	provider.SetUser(oidctest.User{Subject: "intruder", Email: "alice@example.com", EmailVerified: false})
+
+	recorder := signInWithOIDC(t, server, provider)
+	if recorder.Code != http.StatusFound || recorder.Header().Get("Location") != "/#oidc-link" {
+		t.Fatalf("got %d to %q, want the link confirmation", recorder.Code, recorder.Header().Get("Location"))
+	}
+	if _, err := server.oidcAccounts.UserIDByIdentity(provider.Issuer, "intruder"); err == nil {
+		t.Fatal("unverified email was linked to alice")
+	}
+}
+
+func TestOIDCLinkToUnverifiedAccountNeedsPassword(t *testing.T) {
+	server, provider := newOIDCTestServer(t)
+	provider.SetUser(oidctest.User{Subject: "employee-17", Email: "alice@example.com", EmailVerified: true})
+
+	recorder := signInWithOIDC(t, server, provider)
+	if recorder.Code != http.StatusFound || recorder.Header().Get("Location") != "/#oidc-link" {
+		t.Fatalf("got %d to %q, want the link confirmation", recorder.Code, recorder.Header().Get("Location"))
+	}
+	for _, cookie := range recorder.Result().Cookies() {
+		if cookie.Name == "session_token" && cookie.Value != "" {
+			t.Fatal("callback signed in to an account whose email is not verified")
+		}
+	}
+	linkCookie := responseCookie(t, recorder, oidcLinkCookie)
+
+	request := httptest.NewRequest(http.MethodGet, "/auth/oidc/link", nil)
+	request.AddCookie(linkCookie)
+	recorder = httptest.NewRecorder()
+	server.OIDCPendingLinkHandler(recorder, request)
+	var pending account.PendingLink
+	if err := json.NewDecoder(recorder.Body).Decode(&pending); err != nil {
+		t.Fatal(err)
+	}
+	if pending.Nickname != "alice" || pending.Email != "alice@example.com" {
+		t.Fatalf("unexpected pending link: %#v", pending)
+	}
+
+	confirm := func(password string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/auth/oidc/link/confirm",
+			strings.NewReader(`{"password":"`+password+`"}`))
+		request.AddCookie(linkCookie)
+		recorder := httptest.NewRecorder()
+		server.OIDCConfirmLinkHandler(recorder, request)
+		return recorder
+	}
+	if recorder := confirm("Wrong1234"); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password got %d, want 401", recorder.Code)
+	}
+	if _, err := server.oidcAccounts.UserIDByIdentity(provider.Issuer, "employee-17"); err == nil {
+		t.Fatal("identity was linked without the password")
+	}
+	recorder = confirm("Password1")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", recorder.Code, recorder.Body.String())
+	}
+	if userID, err := server.oidcAccounts.UserIDByIdentity(provider.Issuer, "employee-17"); err != nil || userID != 1 {
+		t.Fatalf("identity linked to %d (%v), want alice", userID, err)
+	}
+	if recorder := confirm("Password1"); recorder.Code != http.StatusNotFound {
+		t.Fatalf("reused link got %d, want 404", recorder.Code)
+	}
+
+	recorder = signInWithOIDC(t, server, provider)
+	if recorder.Code != http.StatusFound || recorder.Header().Get("Location") != "/" {
+		t.Fatalf("linked identity got %d to %q", recorder.Code, recorder.Header().Get("Location"))
+	}
+}
+
+func TestOIDCProvisionsNewUserWithChosenNickname(t *testing.T) {
+	server, provider := newOIDCTestServer(t)
+	provider.SetUser(oidctest.User{
+		Subject: "employee-42", Email: "bob@corp.example", EmailVerified: true,
+		GivenName: "Bob", FamilyName: "Builder", PreferredUsername: "bob.builder",
+	})
+
+	recorder := signInWithOIDC(t, server, provider)
+	if recorder.Code != http.StatusFound || recorder.Header().Get("Location") != "/#oidc-signup" {
+		t.Fatalf("got %d to %q, want the signup page", recorder.Code, recorder.Header().Get("Location"))
+	}
+	signupCookie := responseCookie(t, recorder, oidcSignupCookie)
+
+	request := httptest.NewRequest(http.MethodGet, "/auth/oidc/signup", nil)
+	request.AddCookie(signupCookie)
+	recorder = httptest.NewRecorder()
+	server.OIDCPendingSignupHandler(recorder, request)
+	var pending account.PendingSignup
+	if err := json.NewDecoder(recorder.Body).Decode(&pending); err != nil {
+		t.Fatal(err)
+	}
+	if pending.SuggestedNickname != "bob_builder" || pending.Email != "bob@corp.example" || pending.FirstName != "Bob" {
+		t.Fatalf("unexpected pending signup: %#v", pending)
+	}
+
+	complete := func(nickname string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/auth/oidc/signup/complete",
+			strings.NewReader(`{"nickname":"`+nickname+`"}`))
+		request.AddCookie(signupCookie)
+		recorder := httptest.NewRecorder()
+		server.OIDCCompleteSignupHandler(recorder, request)
+		return recorder
+	}
+	if recorder := complete("alice"); recorder.Code != http.StatusConflict {
+		t.Fatalf("taken nickname got %d, want 409", recorder.Code)
+	}
+	recorder = complete("bob_builder")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", recorder.Code, recorder.Body.String())
+	}
+	var response map[string]string
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response["username"] != "bob_builder" || response["csrf_token"] == "" {
+		t.Fatalf("unexpected response: %#v", response)
+	}
+	if recorder := complete("bob_again"); recorder.Code != http.StatusNotFound {
+		t.Fatalf("reused signup got %d, want 404", recorder.Code)
+	}
+
+	recorder = signInWithOIDC(t, server, provider)
+	identity, err := server.sessions.FindValid(responseCookie(t, recorder, "session_token").Value)
+	if err != nil || identity.Nickname != "bob_builder" {
+		t.Fatalf("second sign-in got %#v (%v), want bob_builder", identity, err)
+	}
+}
+
+func TestOIDCCallbackRejectsMismatchedState(t *testing.T) {
+	server, provider := newOIDCTestServer(t)
+	provider.SetUser(oidctest.User{Subject: "employee-17", Email: "alice@example.com", EmailVerified: true})
+
+	recorder := httptest.NewRecorder()
+	server.OIDCLoginHandler(recorder, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+	redirect, err := provider.Authorize(recorder.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An attacker's callback URL replayed in the victim's browser carries a
+	// state that does not match the victim's cookie.
+	request := httptest.NewRequest(http.MethodGet, redirect.RequestURI(), nil)
+	request.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: "someone-elses-state"})
+	recorder = httptest.NewRecorder()
+	server.OIDCCallbackHandler(recorder, request)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want 400", recorder.Code)
+	}
+}
@@ -0,0 +1,123 @@
+package password
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// PrefixLength is how many hex characters of a SHA-1 hash a range query
+// reveals, as in the Pwned Passwords API.
+const PrefixLength = 5
+
+var ErrInvalidPrefix = errors.New("breach range prefix must be 5 hex characters")
+
+// BreachCorpus answers k-anonymity range queries: given the first
+// PrefixLength characters of an uppercase SHA-1 hex digest, it returns the
+// remaining characters of every breached password hash with that prefix.
+// The full hash of the password being checked is never handed over, so an
+// implementation backed by a remote service learns only the prefix, which
+// hundreds of breached passwords share.
+type BreachCorpus interface {
+	Range(prefix string) ([]string, error)
+}
+
+// FileCorpus reads an offline copy of the Pwned Passwords SHA-1 list ordered
+// by hash: one "HASH:COUNT" line per password. The file is searched in place,
+// so it does not have to fit in memory.
+type FileCorpus struct {
+	file *os.File
+	size int64
+}
+
+func OpenFileCorpus(path string) (*FileCorpus, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &FileCorpus{file: file, size: info.Size()}, nil
+}
+
+func (c *FileCorpus) Close() error {
+	return c.file.Close()
+}
+
+// Range binary searches for the first line whose hash is not below the
+// prefix and collects the lines that share it.
+func (c *FileCorpus) Range(prefix string) ([]string, error) {
+	prefix = strings.ToUpper(prefix)
+	if len(prefix) != PrefixLength || strings.Trim(prefix, "0123456789ABCDEF") != "" {
+		return nil, ErrInvalidPrefix
+	}
+
+	low, high := int64(0), c.size
+	for low < high {
+		middle := low + (high-low)/2
+		start, line, err := c.lineAtOrAfter(middle)
+		if err != nil {
+			return nil, err
+		}
+		if start >= c.size || strings.ToUpper(line) >= prefix {
+			high = middle
+		} else {
+			low = start + 1
+		}
+	}
+
+	start, _, err := c.lineAtOrAfter(low)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(io.NewSectionReader(c.file, start, c.size-start))
+	var suffixes []string
+	for {
+		line, err := reader.ReadString('\n')
+		hash, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+		hash = strings.ToUpper(hash)
+		if !strings.HasPrefix(hash, prefix) {
+			break
+		}
+		suffixes = append(suffixes, hash[PrefixLength:])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read breach corpus: %w", err)
+		}
+	}
+	return suffixes, nil
+}
+
+// lineAtOrAfter returns the first line that starts at or after offset,
+// together with its start. At the end of the file the start is c.size.
+func (c *FileCorpus) lineAtOrAfter(offset int64) (int64, string, error) {
+	start := offset
+	if offset > 0 {
+		reader := bufio.NewReader(io.NewSectionReader(c.file, offset-1, c.size-offset+1))
+		skipped, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return c.size, "", nil
+		}
+		if err != nil {
+			return 0, "", fmt.Errorf("read breach corpus: %w", err)
+		}
+		start = offset - 1 + int64(len(skipped))
+	}
+	if start >= c.size {
+		return c.size, "", nil
+	}
+	reader := bufio.NewReader(io.NewSectionReader(c.file, start, c.size-start))
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, "", fmt.Errorf("read breach corpus: %w", err)
+	}
+	return start, strings.TrimSpace(line), nil
+}
@@ -0,0 +1,66 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testParams keeps the tests fast; production uses DefaultParams.
+var testParams = Params{Memory: 1024, Iterations: 1, Parallelism: 1}
+
+func TestHashVerifiesAndKeepsLongPasswords(t *testing.T) {
+	hasher := NewHasher(testParams)
+	long := strings.Repeat("a", 80)
+
+	encoded, err := hasher.Hash(long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("unexpected hash format: %s", encoded)
+	}
+	if needsRehash, err := hasher.Verify(encoded, long); err != nil || needsRehash {
+		t.Fatalf("Verify = %v, %v; want match without rehash", needsRehash, err)
+	}
+	// bcrypt ignores everything after 72 bytes; Argon2id must not.
+	if _, err := hasher.Verify(encoded, long[:72]+"b"+long[73:]); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("password differing after byte 72 got %v, want ErrMismatch", err)
+	}
+}
+
+func TestVerifyFlagsOutdatedHashesForRehash(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("Password1"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher := NewHasher(testParams)
+	if needsRehash, err := hasher.Verify(string(legacy), "Password1"); err != nil || !needsRehash {
+		t.Fatalf("bcrypt Verify = %v, %v; want match with rehash", needsRehash, err)
+	}
+	if _, err := hasher.Verify(string(legacy), "Password2"); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("wrong bcrypt password got %v, want ErrMismatch", err)
+	}
+
+	weaker, err := NewHasher(Params{Memory: 512, Iterations: 1, Parallelism: 1}).Hash("Password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if needsRehash, err := hasher.Verify(weaker, "Password1"); err != nil || !needsRehash {
+		t.Fatalf("outdated Argon2id Verify = %v, %v; want match with rehash", needsRehash, err)
+	}
+}
+
+func TestVerifyRejectsEmptyAndUnknownHashes(t *testing.T) {
+	hasher := NewHasher(testParams)
+	if _, err := hasher.Verify("", ""); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("empty hash got %v, want ErrMismatch", err)
+	}
+	for _, encoded := range []string{"plaintext", "$argon2id$v=19$m=0,t=1,p=1$c2FsdA$a2V5", "$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$a2V5"} {
+		if _, err := hasher.Verify(encoded, "plaintext"); !errors.Is(err, ErrUnsupportedHash) {
+			t.Fatalf("Verify(%q) got %v, want ErrUnsupportedHash", encoded, err)
+		}
+	}
+}
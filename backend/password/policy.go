@@ -0,0 +1,155 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	MinLength = 8
+	MaxLength = 128
+)
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+var commonPasswords = loadCommonPasswords(commonPasswordList)
+
+// Violation is a password rejected by the policy. Message is written for the
+// person choosing the password.
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+func (v *Violation) Error() string {
+	return "password policy: " + v.Rule
+}
+
+var (
+	ErrTooShort = &Violation{Rule: "length", Message: fmt.Sprintf("Password must be at least %d characters", MinLength)}
+	ErrTooLong  = &Violation{Rule: "length", Message: fmt.Sprintf("Password must be at most %d characters", MaxLength)}
+	ErrCommon   = &Violation{Rule: "common", Message: "This password is too common and easy to guess. Choose something less predictable"}
+	ErrBreached = &Violation{Rule: "breached", Message: "This password has appeared in a data breach. Choose a different one"}
+	ErrPersonal = &Violation{Rule: "personal", Message: "Password must not contain or resemble your nickname or email address"}
+)
+
+// Policy decides whether a new password is acceptable. Breaches is optional;
+// without it only the bundled common-password list is consulted.
+type Policy struct {
+	Breaches BreachCorpus
+}
+
+// Check returns a *Violation for an unacceptable password, or another error
+// when the breach corpus cannot be read. personal holds the nickname, email,
+// and similar values the password must not resemble.
+func (p *Policy) Check(password string, personal ...string) error {
+	length := utf8.RuneCountInString(password)
+	if length < MinLength {
+		return ErrTooShort
+	}
+	if length > MaxLength {
+		return ErrTooLong
+	}
+
+	lowered := strings.ToLower(password)
+	base := baseWord(password)
+	if commonPasswords[lowered] || commonPasswords[base] {
+		return ErrCommon
+	}
+	for _, value := range personal {
+		if resembles(lowered, base, value) {
+			return ErrPersonal
+		}
+	}
+
+	if p == nil || p.Breaches == nil {
+		return nil
+	}
+	sum := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	suffixes, err := p.Breaches.Range(digest[:PrefixLength])
+	if err != nil {
+		return fmt.Errorf("query breach corpus: %w", err)
+	}
+	for _, suffix := range suffixes {
+		if suffix == digest[PrefixLength:] {
+			return ErrBreached
+		}
+	}
+	return nil
+}
+
+// baseWord lowercases the password, undoes common character substitutions,
+// and trims the digits and symbols people add around a word, so that
+// "P@ssw0rd2024!" becomes "password".
+func baseWord(password string) string {
+	replacer := strings.NewReplacer("@", "a", "4", "a", "3", "e", "1", "i", "!", "i", "0", "o", "$", "s", "5", "s", "7", "t")
+	trimmed := strings.TrimFunc(strings.ToLower(password), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	// Substitutions are undone after trimming, so "1" in "dragon1" is treated
+	// as a suffix digit rather than as an "i".
+	return replacer.Replace(trimmed)
+}
+
+// resembles reports whether the password contains the value, or its base
+// word is within two edits of it. Email addresses are compared by their
+// local part and by each word of it.
+func resembles(lowered, base, value string) bool {
+	value = strings.ToLower(strings.TrimSpace(value))
+	candidates := []string{value}
+	if local, _, found := strings.Cut(value, "@"); found {
+		candidates = append([]string{local}, strings.FieldsFunc(local, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})...)
+	}
+	for _, candidate := range candidates {
+		if utf8.RuneCountInString(candidate) < 4 {
+			continue
+		}
+		if strings.Contains(lowered, candidate) || strings.Contains(base, candidate) ||
+			levenshtein(base, candidate) <= 2 {
+			return true
+		}
+	}
+	return false
+}
+
+func levenshtein(a, b string) int {
+	first, second := []rune(a), []rune(b)
+	previous := make([]int, len(second)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(first); i++ {
+		current := make([]int, len(second)+1)
+		current[0] = i
+		for j := 1; j <= len(second); j++ {
+			cost := 1
+			if first[i-1] == second[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(second)]
+}
+
+func loadCommonPasswords(list string) map[string]bool {
+	passwords := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			passwords[strings.ToLower(line)] = true
+		}
+	}
+	return passwords
+}
@@ -0,0 +1,93 @@
+package password
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPolicyRejectsCommonAndPersonalPasswords(t *testing.T) {
+	policy := &Policy{}
+	tests := []struct {
+		password string
+		want     error
+	}{
+		{password: "Short1", want: ErrTooShort},
+		{password: strings.Repeat("Ab1", 43), want: ErrTooLong},
+		{password: "Password1", want: ErrCommon},
+		{password: "P@ssw0rd2024!", want: ErrCommon},
+		{password: "12345678", want: ErrCommon},
+		{password: "Alice2024!", want: ErrPersonal},
+		{password: "xXsmithXx99", want: ErrPersonal},
+		{password: "Tidal-Cobalt-Lantern7", want: nil},
+	}
+	for _, test := range tests {
+		err := policy.Check(test.password, "alice", "jane.smith@example.com")
+		if !errors.Is(err, test.want) {
+			t.Fatalf("Check(%q) = %v, want %v", test.password, err, test.want)
+		}
+	}
+
+	var violation *Violation
+	if err := policy.Check("Password1"); !errors.As(err, &violation) || violation.Message == "" {
+		t.Fatalf("violation %v has no message for the user", err)
+	}
+}
+
+func TestPolicyQueriesBreachCorpusByPrefixOnly(t *testing.T) {
+	breached := []string{"Tidal-Cobalt-Lantern7", "Quiet-Harbor-Maple3", "Violet-Engine-Sparrow8"}
+	var lines []string
+	for _, password := range breached {
+		sum := sha1.Sum([]byte(password))
+		lines = append(lines, strings.ToUpper(hex.EncodeToString(sum[:]))+":3")
+	}
+	for i := 0; i < 2000; i++ {
+		sum := sha1.Sum([]byte(fmt.Sprintf("filler-%d", i)))
+		lines = append(lines, strings.ToUpper(hex.EncodeToString(sum[:]))+":1")
+	}
+	sort.Strings(lines)
+	path := filepath.Join(t.TempDir(), "pwned-passwords-sha1-ordered-by-hash.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	corpus, err := OpenFileCorpus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer corpus.Close()
+
+	recorder := &prefixRecorder{corpus: corpus}
+	policy := &Policy{Breaches: recorder}
+	for _, password := range breached {
+		if err := policy.Check(password); !errors.Is(err, ErrBreached) {
+			t.Fatalf("Check(%q) = %v, want ErrBreached", password, err)
+		}
+	}
+	if err := policy.Check("Amber-Falcon-Ridge4"); err != nil {
+		t.Fatalf("unbreached password rejected: %v", err)
+	}
+	for _, prefix := range recorder.prefixes {
+		if len(prefix) != PrefixLength {
+			t.Fatalf("corpus was queried with %q, want a %d-character prefix", prefix, PrefixLength)
+		}
+	}
+	if _, err := corpus.Range("XYZ"); !errors.Is(err, ErrInvalidPrefix) {
+		t.Fatalf("invalid prefix got %v, want ErrInvalidPrefix", err)
+	}
+}
+
+type prefixRecorder struct {
+	corpus   BreachCorpus
+	prefixes []string
+}
+
+func (r *prefixRecorder) Range(prefix string) ([]string, error) {
+	r.prefixes = append(r.prefixes, prefix)
+	return r.corpus.Range(prefix)
+}
@@ -0,0 +1,119 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"real-time-forum/backend/forum"
+)
+
+func TestPostListingsTrackReadsAndSortByActivity(t *testing.T) {
+	server := newModerationTestServer(t)
+	if _, err := server.forum.CreatePost(2, "Bob's post", "Newer but quiet", "General"); err != nil {
+		t.Fatal(err)
+	}
+	for _, comment := range []struct {
+		userID  int64
+		content string
+	}{{1, "Bumping my own post"}, {3, "Moderator here"}} {
+		if _, err := server.forum.CreateComment(1, 0, comment.userID, comment.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The cached activity times are moved back along with the dates they
+	// were computed from.
+	for _, statement := range []string{
+		"UPDATE posts SET created_at = '2026-01-01 10:00:00', last_activity_at = '2026-01-03T10:00:00Z' WHERE id = 1",
+		"UPDATE posts SET created_at = '2026-01-02 10:00:00', last_activity_at = '2026-01-02T10:00:00Z' WHERE id = 2",
+		"UPDATE comments SET created_at = '2026-01-03 10:00:00'",
+	} {
+		if _, err := server.db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list := func(query string, wantStatus int) []forum.Post {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodGet, "/posts?"+query, nil)
+		withSession(t, server, request, "bob-session")
+		recorder := httptest.NewRecorder()
+		server.WithScope("", server.GetPostsHandler).ServeHTTP(recorder, request)
+		if recorder.Code != wantStatus {
+			t.Fatalf("GET /posts?%s got %d, want %d: %s", query, recorder.Code, wantStatus, recorder.Body.String())
+		}
+		var posts []forum.Post
+		if wantStatus == http.StatusOK {
+			if err := json.NewDecoder(recorder.Body).Decode(&posts); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return posts
+	}
+	markRead := func(body string) int {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodPost, "/posts/read", strings.NewReader(body))
+		withSession(t, server, request, "bob-session")
+		recorder := httptest.NewRecorder()
+		server.SessionMiddleware(http.HandlerFunc(server.MarkPostReadHandler)).ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	posts := list("", http.StatusOK)
+	if len(posts) != 2 || posts[0].ID != 2 || posts[1].ID != 1 {
+		t.Fatalf("newest first = %+v", posts)
+	}
+	if hello := posts[1]; !hello.Unopened || hello.CommentCount != 2 || hello.UnreadComments != 2 || hello.ReaderCount != 0 {
+		t.Fatalf("unopened post = %+v, want unopened with 2 unread comments", hello)
+	}
+	if posts[0].Unopened {
+		t.Fatal("bob's own post is marked unopened for him")
+	}
+
+	posts = list("sort=activity", http.StatusOK)
+	if posts[0].ID != 1 || posts[0].LastActivityAt != "2026-01-03T10:00:00Z" || posts[1].LastActivityAt != "2026-01-02T10:00:00Z" {
+		t.Fatalf("by activity = %+v", posts)
+	}
+	list("sort=oldest", http.StatusBadRequest)
+
+	if code := markRead(`{"post_id":1,"comment_id":1,"opened":true}`); code != http.StatusNoContent {
+		t.Fatalf("mark read got %d", code)
+	}
+	if hello := list("sort=activity", http.StatusOK)[0]; hello.Unopened || hello.UnreadComments != 1 || hello.ReaderCount != 1 || hello.ViewCount != 1 {
+		t.Fatalf("after reading the first comment = %+v", hello)
+	}
+	markRead(`{"post_id":1}`)
+	markRead(`{"post_id":1,"comment_id":1,"opened":true}`)
+	if hello := list("sort=activity", http.StatusOK)[0]; hello.UnreadComments != 0 || hello.ReaderCount != 1 {
+		t.Fatalf("the read position moved back or the reader was counted twice: %+v", hello)
+	}
+	if hello := list("sort=activity", http.StatusOK)[0]; hello.ViewCount != 2 {
+		t.Fatalf("view_count = %d, want 2: each open counts and reloading the comments does not", hello.ViewCount)
+	}
+	if code := markRead(`{"post_id":99}`); code != http.StatusNotFound {
+		t.Fatalf("marking a missing post read got %d, want 404", code)
+	}
+
+	if _, err := server.forum.CreateComment(2, 0, 1, "A reply to Bob"); err != nil {
+		t.Fatal(err)
+	}
+	if quiet := list("sort=activity", http.StatusOK)[0]; quiet.ID != 2 || quiet.LastActivityAt <= "2026-01-03T10:00:00Z" {
+		t.Fatalf("a new comment did not move its post up: %+v", quiet)
+	}
+}
+
+func TestDeletingAPostDeletesItsReadPositions(t *testing.T) {
+	server := newModerationTestServer(t)
+	if err := server.forum.MarkRead(2, 1, 0, true, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.forum.DeletePost(1); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, server, "SELECT COUNT(*) FROM post_reads"); n != 0 {
+		t.Fatalf("%d read positions are left for the deleted post", n)
+	}
+}
@@ -0,0 +1,157 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"real-time-forum/backend/forum"
+)
+
+func TestPostRankingsUseCachedScores(t *testing.T) {
+	server := newModerationTestServer(t)
+	for _, post := range []struct {
+		userID int64
+		title  string
+	}{{2, "Bob's post"}, {3, "Carol's post"}} {
+		if _, err := server.forum.CreatePost(post.userID, post.title, "Some content", "General"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for postID, age := range map[int]time.Duration{1: 10 * 24 * time.Hour, 2: 2 * 24 * time.Hour, 3: time.Hour} {
+		created := time.Now().UTC().Add(-age).Format("2006-01-02 15:04:05")
+		if _, err := server.db.Exec("UPDATE posts SET created_at = ? WHERE id = ?", created, postID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	comment := func(postID int, userID int64) int {
+		t.Helper()
+		id, err := server.forum.CreateComment(postID, 0, userID, "A comment")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return int(id)
+	}
+	// Post 1 is old with many comments from two people, post 2 is newer with
+	// three commenters, and post 3 is new and its only comment was deleted.
+	var hidden []int
+	for _, userID := range []int64{2, 2, 2, 3, 3} {
+		hidden = append(hidden, comment(1, userID))
+	}
+	for _, userID := range []int64{1, 2, 3} {
+		comment(2, userID)
+	}
+	if err := server.forum.DeleteComment(comment(3, 1)); err != nil {
+		t.Fatal(err)
+	}
+	// Alice and Bob read post 1, which puts it on top by engagement while
+	// post 2 still has the most commenters.
+	read := func(userID int64) {
+		t.Helper()
+		if err := server.forum.MarkRead(userID, 1, 0, true, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read(1)
+	read(2)
+
+	ids := func(query string) []int {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodGet, "/posts?"+query, nil)
+		withSession(t, server, request, "bob-session")
+		recorder := httptest.NewRecorder()
+		server.WithScope("", server.GetPostsHandler).ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("GET /posts?%s got %d: %s", query, recorder.Code, recorder.Body.String())
+		}
+		var posts []forum.Post
+		if err := json.NewDecoder(recorder.Body).Decode(&posts); err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]int, len(posts))
+		for i, post := range posts {
+			ids[i] = post.ID
+		}
+		return ids
+	}
+	for query, want := range map[string][]int{
+		"sort=hot":                  {3, 2, 1},
+		"sort=top":                  {1, 2, 3},
+		"sort=top&period=week":      {2, 3},
+		"sort=discussed":            {2, 1, 3},
+		"sort=discussed&period=day": {3},
+		"sort=discussed&period=all": {2, 1, 3},
+		"sort=newest":               {3, 2, 1},
+		"sort=top&limit=2":          {1, 2},
+		"sort=top&limit=2&offset=1": {2, 3},
+		"sort=newest&offset=3":      {},
+	} {
+		if got := ids(query); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %v, want %v", query, got, want)
+		}
+	}
+
+	// Hiding Carol's comments takes her out of the commenters, and reading
+	// the post adds her back as a reader.
+	for _, commentID := range hidden[3:] {
+		if err := server.forum.HideComment(commentID, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := ids("sort=top"); !reflect.DeepEqual(got, []int{2, 1, 3}) {
+		t.Fatalf("top after hiding Carol's comments = %v, want the tie broken by the newer post", got)
+	}
+	read(3)
+	if got := ids("sort=top"); !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Fatalf("top after Carol read post 1 = %v, want it back on top", got)
+	}
+
+	for _, query := range []string{"sort=hot&period=week", "sort=top&period=decade", "sort=best", "limit=-1", "offset=two"} {
+		request := httptest.NewRequest(http.MethodGet, "/posts?"+query, nil)
+		withSession(t, server, request, "bob-session")
+		recorder := httptest.NewRecorder()
+		server.WithScope("", server.GetPostsHandler).ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s got %d, want 400", query, recorder.Code)
+		}
+	}
+}
+
+// countRows runs a COUNT query for tests that check what a change left
+// behind.
+func countRows(t *testing.T, server *Server, query string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := server.db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestDeletingACommentMakesItsRepliesTopLevel(t *testing.T) {
+	server := newModerationTestServer(t)
+	parentID, err := server.forum.CreateComment(1, 0, 2, "Parent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replyID, err := server.forum.CreateComment(1, int(parentID), 3, "Reply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.forum.DeleteComment(int(parentID)); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, server, "SELECT COUNT(*) FROM comments WHERE id = ? AND parent_id IS NULL", replyID); n != 1 {
+		t.Fatal("the reply should become a top-level comment")
+	}
+
+	if err := server.forum.DeletePost(1); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, server, "SELECT COUNT(*) FROM comments WHERE post_id = 1"); n != 0 {
+		t.Fatalf("%d comments of the deleted post are left", n)
+	}
+}
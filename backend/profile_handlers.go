@@ -0,0 +1,454 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/audit"
+	"real-time-forum/backend/forum"
+	"real-time-forum/backend/mail"
+)
+
+const (
+	profileRecentPosts     = 5
+	emailChangeLifetime    = 24 * time.Hour
+	nicknameChangeCooldown = 30 * 24 * time.Hour
+)
+
+type UserProfileResponse struct {
+	account.PublicProfile
+	PostCount    int          `json:"post_count"`
+	CommentCount int          `json:"comment_count"`
+	RecentPosts  []forum.Post `json:"recent_posts"`
+}
+
+func (S *Server) GetUserProfileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || userID < 1 {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if S.users == nil || S.forum == nil {
+		http.Error(w, "Repositories are not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	profile, err := S.users.PublicProfile(userID)
+	if errors.Is(err, account.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	postCount, commentCount, err := S.forum.AuthorStats(userID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	recentPosts, err := S.forum.ListPostsByUser(userID, profileRecentPosts)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	profile.Avatar = avatarURL(profile.AvatarKey, false)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UserProfileResponse{
+		PublicProfile: profile,
+		PostCount:     postCount,
+		CommentCount:  commentCount,
+		RecentPosts:   withPostAvatars(recentPosts),
+	})
+}
+
+func (S *Server) GetProfileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if S.users == nil {
+		http.Error(w, "Account repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+	profile, err := S.users.Profile(identity.UserID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	profile.Avatar = avatarURL(profile.AvatarKey, false)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+func (S *Server) UpdateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request User
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if !isValidTextLength(request.FirstName, 1, 50) {
+		http.Error(w, "First name must be 1-50 characters", http.StatusBadRequest)
+		return
+	}
+	if !isValidTextLength(request.LastName, 1, 50) {
+		http.Error(w, "Last name must be 1-50 characters", http.StatusBadRequest)
+		return
+	}
+	if !isValidAge(request.Age) {
+		http.Error(w, "Invalid age: must be between 13 and 120", http.StatusBadRequest)
+		return
+	}
+	if !isValidGender(request.Gender) {
+		http.Error(w, "Invalid gender", http.StatusBadRequest)
+		return
+	}
+
+	if S.users == nil {
+		http.Error(w, "Account repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+	err := S.users.UpdateProfile(identity.UserID, account.ProfileUpdate{
+		FirstName: request.FirstName, LastName: request.LastName,
+		Age: request.Age, Gender: request.Gender,
+	})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// UserByNicknameHandler redirects /u/{nickname} to the user's profile. Old
+// nicknames resolve to the account that used them, so links shared before a
+// rename keep working.
+func (S *Server) UserByNicknameHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	if S.users == nil {
+		http.Error(w, "Account repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+	userID, _, err := S.users.ResolveNickname(r.PathValue("nickname"))
+	if errors.Is(err, account.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/users/"+strconv.FormatInt(userID, 10), http.StatusFound)
+}
+
+// ChangeNicknameHandler renames the user at most once per
+// nicknameChangeCooldown and tells every connected client, so chat lists and
+// open conversations switch to the new nickname without a reload.
+func (S *Server) ChangeNicknameHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Nickname string `json:"nickname"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if !isValidNickname(request.Nickname) {
+		http.Error(w, "Invalid nickname: must be 3-20 characters, alphanumeric and underscore only", http.StatusBadRequest)
+		return
+	}
+	if S.users == nil {
+		http.Error(w, "Account repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	oldNickname, err := S.users.ChangeNickname(identity.UserID, request.Nickname, now, nicknameChangeCooldown)
+	switch {
+	case errors.Is(err, account.ErrNicknameUnchanged):
+		http.Error(w, "That is already your nickname", http.StatusBadRequest)
+		return
+	case errors.Is(err, account.ErrNicknameTaken):
+		http.Error(w, "Nickname already exists", http.StatusConflict)
+		return
+	case errors.Is(err, account.ErrNicknameChangeTooSoon):
+		if next, err := S.users.NextNicknameChange(identity.UserID, now, nicknameChangeCooldown); err == nil && !next.IsZero() {
+			w.Header().Set("Retry-After", strconv.Itoa(int(next.Sub(now).Seconds())+1))
+		}
+		http.Error(w, "You can only change your nickname once every 30 days", http.StatusTooManyRequests)
+		return
+	case err != nil:
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	event := actorEvent(identity, audit.ActionNicknameChanged)
+	event.TargetType, event.TargetID = audit.TargetUser, identity.UserID
+	event.Details = "from=" + oldNickname + " to=" + request.Nickname
+	S.recordAudit(r, event)
+
+	if S.hub != nil {
+		S.hub.RenameUser(identity.UserID, request.Nickname)
+		S.hub.Broadcast(map[string]interface{}{
+			"event":        "nickname_changed",
+			"user_id":      identity.UserID,
+			"old_nickname": oldNickname,
+			"nickname":     request.Nickname,
+		})
+		S.broadcastUserStatusChange()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":       "success",
+		"old_nickname": oldNickname,
+		"nickname":     request.Nickname,
+	})
+}
+
+// ChangeEmailHandler does not change the address directly. It stores the new
+// address as pending and mails a verification link to it.
+func (S *Server) ChangeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if !isValidEmail(request.Email) {
+		http.Error(w, "Invalid email format", http.StatusBadRequest)
+		return
+	}
+	if S.users == nil || S.mailer == nil {
+		http.Error(w, "Account repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+	if !S.verifyCurrentPassword(w, r, identity.UserID, request.Password) {
+		return
+	}
+
+	taken, err := S.users.EmailTaken(request.Email, identity.UserID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if taken {
+		http.Error(w, "Email already exists", http.StatusConflict)
+		return
+	}
+
+	token, tokenHash, err := account.GenerateToken("")
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := S.users.RequestEmailChange(identity.UserID, request.Email, tokenHash, time.Now().Add(emailChangeLifetime)); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	link := S.config.PublicURL + "/profile/email/verify?token=" + url.QueryEscape(token)
+	err = S.mailer.Send(mail.Message{
+		To:      request.Email,
+		Subject: "Confirm your new forum email address",
+		Text: "Hi " + identity.Nickname + ",\n\n" +
+			"Open this link within 24 hours to confirm your new email address:\n" + link + "\n\n" +
+			"If you did not request this change, you can ignore this message.\n",
+	})
+	if err != nil {
+		log.Printf("failed to send email verification: %v", err)
+		http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "verification_sent"})
+}
+
+func (S *Server) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "Missing token", http.StatusBadRequest)
+		return
+	}
+	if S.users == nil {
+		http.Error(w, "Account repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	_, err := S.users.ConfirmEmailChange(account.HashToken(token), time.Now())
+	switch {
+	case errors.Is(err, account.ErrEmailChangeNotFound):
+		http.Error(w, "Verification link is invalid or expired", http.StatusBadRequest)
+		return
+	case errors.Is(err, account.ErrEmailTaken):
+		http.Error(w, "Email already exists", http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// ChangePasswordHandler requires the current password and signs out every
+// other session of the account once the new password is stored.
+func (S *Server) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if S.users == nil || S.sessions == nil {
+		http.Error(w, "Account repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+	profile, err := S.users.Profile(identity.UserID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !S.acceptablePassword(w, request.NewPassword, profile.Nickname, profile.Email) {
+		return
+	}
+	if !S.verifyCurrentPassword(w, r, identity.UserID, request.CurrentPassword) {
+		return
+	}
+
+	revokedTokens, err := S.users.UpdatePassword(identity.UserID, request.NewPassword, time.Now())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	revoked, err := S.sessions.DeleteForUserExcept(identity.UserID, identity.SessionID)
+	if err != nil {
+		log.Printf("failed to revoke sessions after password change: %v", err)
+	}
+	for _, sessionID := range revoked {
+		S.hub.DisconnectSession(identity.UserID, sessionID)
+	}
+	event := actorEvent(identity, audit.ActionSessionsRevoked)
+	event.TargetType, event.TargetID = audit.TargetUser, identity.UserID
+	event.Details = fmt.Sprintf("count=%d reason=password_change", len(revoked))
+	S.recordAudit(r, event)
+	if revokedTokens > 0 {
+		event.Action = audit.ActionTokenRevoked
+		event.Details = fmt.Sprintf("count=%d reason=password_change", revokedTokens)
+		S.recordAudit(r, event)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// verifyCurrentPassword checks the password a signed-in user re-enters to
+// confirm a sensitive change. Wrong passwords count against the login
+// throttle for the account and the client IP, so a stolen session cannot be
+// used to guess the password without limit.
+func (S *Server) verifyCurrentPassword(w http.ResponseWriter, r *http.Request, userID int64, password string) bool {
+	if S.throttle == nil {
+		http.Error(w, "Login throttle is not initialized", http.StatusInternalServerError)
+		return false
+	}
+	ip := clientIP(r)
+	accountKey := loginThrottleKey("", userID)
+	lockedUntil, err := S.loginLockedUntil(accountKey, ip)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	if !lockedUntil.IsZero() {
+		writeLoginLocked(w, lockedUntil)
+		return false
+	}
+	hashedPassword, err := S.users.PasswordHash(userID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	if password == "" || !S.checkPassword(userID, hashedPassword, password) {
+		S.recordAudit(r, audit.Event{
+			Action: audit.ActionLoginFailed, TargetType: audit.TargetUser, TargetID: userID,
+			IP: ip, Details: "method=current-password",
+		})
+		if lockedUntil := S.countLoginFailure(accountKey, ip); !lockedUntil.IsZero() {
+			writeLoginLocked(w, lockedUntil)
+			return false
+		}
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return false
+	}
+	if err := S.throttle.Reset(account.ThrottleScopeAccount, accountKey); err != nil {
+		log.Printf("failed to reset login throttle: %v", err)
+	}
+	return true
+}
@@ -0,0 +1,274 @@
+package backend
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	_ "modernc.org/sqlite"
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/chat"
+	"real-time-forum/backend/forum"
+	"real-time-forum/backend/mail"
+)
+
+type recordingMailer struct {
+	messages []mail.Message
+}
+
+func (m *recordingMailer) Send(message mail.Message) error {
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func newProfileTestServer(t *testing.T) (*Server, *recordingMailer) {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "forum.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := runMigrations(db); err != nil {
+		t.Fatal(err)
+	}
+
+	users := account.NewUserRepository(db, nil)
+	if err := users.Create(account.UserRecord{
+		Nickname: "alice", FirstName: "Alice", LastName: "Example",
+		Email: "alice@example.com", Password: "Password1", Age: 30, Gender: "female",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	mailer := &recordingMailer{}
+	server := &Server{
+		db:       db,
+		hub:      NewHub(),
+		users:    users,
+		sessions: account.NewSessionRepository(db),
+		throttle: account.NewThrottleRepository(db),
+		forum:    forum.NewRepository(db),
+		mailer:   mailer,
+		config:   Config{PublicURL: "http://forum.test"},
+	}
+	if _, err := server.forum.CreatePost(1, "Hello", "First post content", "general"); err != nil {
+		t.Fatal(err)
+	}
+	return server, mailer
+}
+
+// withSession authenticates the request like the browser does: the session
+// cookie plus the session's CSRF token header.
+func withSession(t *testing.T, server *Server, request *http.Request, sessionID string) {
+	t.Helper()
+	identity, err := server.sessions.FindValid(sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.AddCookie(&http.Cookie{Name: "session_token", Value: sessionID})
+	request.Header.Set("X-CSRF-Token", identity.CSRFToken)
+}
+
+func TestGetUserProfileHandlerReturnsPublicFields(t *testing.T) {
+	server, _ := newProfileTestServer(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/{id}", server.GetUserProfileHandler)
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", recorder.Code, recorder.Body.String())
+	}
+	if strings.Contains(recorder.Body.String(), "alice@example.com") {
+		t.Fatal("public profile must not expose the email address")
+	}
+
+	var profile UserProfileResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile.Nickname != "alice" || profile.PostCount != 1 || len(profile.RecentPosts) != 1 || profile.JoinedAt == "" {
+		t.Fatalf("unexpected profile: %#v", profile)
+	}
+
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/users/99", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("got status %d for unknown user, want 404", recorder.Code)
+	}
+}
+
+func TestChangeEmailRequiresVerification(t *testing.T) {
+	server, mailer := newProfileTestServer(t)
+	if _, err := server.sessions.Create("alice-session", "alice", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/profile/email",
+		strings.NewReader(`{"email":"new@example.com","password":"Password1"}`))
+	withSession(t, server, request, "alice-session")
+	recorder := httptest.NewRecorder()
+	server.SessionMiddleware(http.HandlerFunc(server.ChangeEmailHandler)).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want 202: %s", recorder.Code, recorder.Body.String())
+	}
+	if len(mailer.messages) != 1 || mailer.messages[0].To != "new@example.com" {
+		t.Fatalf("unexpected mail: %#v", mailer.messages)
+	}
+
+	profile, err := server.users.Profile(1)
+	if err != nil || profile.Email != "alice@example.com" {
+		t.Fatalf("email changed before verification: %#v, %v", profile, err)
+	}
+
+	text := mailer.messages[0].Text
+	link := text[strings.Index(text, "http://forum.test"):]
+	link = strings.TrimSpace(link[:strings.Index(link, "\n")])
+	recorder = httptest.NewRecorder()
+	server.VerifyEmailHandler(recorder, httptest.NewRequest(http.MethodGet, link, nil))
+	if recorder.Code != http.StatusSeeOther {
+		t.Fatalf("got verification status %d, want 303: %s", recorder.Code, recorder.Body.String())
+	}
+	profile, err = server.users.Profile(1)
+	if err != nil || profile.Email != "new@example.com" {
+		t.Fatalf("email not changed after verification: %#v, %v", profile, err)
+	}
+}
+
+func TestChangeNicknamePushesRenameAndKeepsOldLinks(t *testing.T) {
+	server, _ := newProfileTestServer(t)
+	server.chat = chat.NewRepository(server.db)
+	if _, err := server.sessions.Create("alice-session", "alice", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	client := &Client{ID: "alice-client", UserID: 1, Username: "alice", SessionID: "alice-session", Send: make(chan interface{}, 4)}
+	server.hub.Register(client)
+
+	request := httptest.NewRequest(http.MethodPost, "/profile/nickname", strings.NewReader(`{"nickname":"alicia"}`))
+	withSession(t, server, request, "alice-session")
+	recorder := httptest.NewRecorder()
+	server.SessionMiddleware(http.HandlerFunc(server.ChangeNicknameHandler)).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", recorder.Code, recorder.Body.String())
+	}
+	if client.Nickname() != "alicia" {
+		t.Fatalf("connected client still named %q", client.Nickname())
+	}
+	event, ok := (<-client.Send).(map[string]interface{})
+	if !ok || event["event"] != "nickname_changed" || event["old_nickname"] != "alice" || event["nickname"] != "alicia" {
+		t.Fatalf("unexpected first event: %#v", event)
+	}
+
+	request = httptest.NewRequest(http.MethodPost, "/profile/nickname", strings.NewReader(`{"nickname":"ally"}`))
+	withSession(t, server, request, "alice-session")
+	recorder = httptest.NewRecorder()
+	server.SessionMiddleware(http.HandlerFunc(server.ChangeNicknameHandler)).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") == "" {
+		t.Fatalf("got status %d for a rename within the cooldown, want 429 with Retry-After", recorder.Code)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/u/{nickname}", server.UserByNicknameHandler)
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/u/alice", nil))
+	if recorder.Code != http.StatusFound || recorder.Header().Get("Location") != "/users/1" {
+		t.Fatalf("old nickname got %d to %q, want 302 to /users/1", recorder.Code, recorder.Header().Get("Location"))
+	}
+}
+
+func TestLoginRehashesLegacyBcryptPassword(t *testing.T) {
+	server, _ := newProfileTestServer(t)
+	legacy, err := bcrypt.GenerateFromPassword([]byte("Password1"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.db.Exec("UPDATE users SET password = ? WHERE id = 1", string(legacy)); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.LoginHandler(recorder, httptest.NewRequest(http.MethodPost, "/login",
+		strings.NewReader(`{"identifier":"alice","password":"Password1"}`)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", recorder.Code, recorder.Body.String())
+	}
+	stored, err := server.users.PasswordHash(1)
+	if err != nil || !strings.HasPrefix(stored, "$argon2id$") {
+		t.Fatalf("stored hash after login = %q, %v; want Argon2id", stored, err)
+	}
+	if needsRehash, err := server.users.CheckPassword(stored, "Password1"); err != nil || needsRehash {
+		t.Fatalf("rehashed password check = %v, %v", needsRehash, err)
+	}
+}
+
+func TestPasswordPolicyRejectsCommonAndPersonalPasswords(t *testing.T) {
+	server, _ := newProfileTestServer(t)
+	server.chat = chat.NewRepository(server.db)
+
+	recorder := httptest.NewRecorder()
+	server.RegisterHandler(recorder, httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(
+		`{"nickname":"bob","first_name":"Bob","last_name":"Builder","email":"bob@example.com","password":"Password1","age":30,"gender":"male"}`)))
+	if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "too common") {
+		t.Fatalf("common password at registration got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	if _, err := server.sessions.Create("alice-session", "alice", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		newPassword string
+		want        int
+	}{
+		{newPassword: "Alice-1990", want: http.StatusBadRequest},
+		{newPassword: "Tidal-Cobalt-Lantern7", want: http.StatusOK},
+	} {
+		request := httptest.NewRequest(http.MethodPost, "/profile/password", strings.NewReader(
+			`{"current_password":"Password1","new_password":"`+test.newPassword+`"}`))
+		withSession(t, server, request, "alice-session")
+		recorder := httptest.NewRecorder()
+		server.SessionMiddleware(http.HandlerFunc(server.ChangePasswordHandler)).ServeHTTP(recorder, request)
+		if recorder.Code != test.want {
+			t.Fatalf("new password %q got %d, want %d: %s", test.newPassword, recorder.Code, test.want, recorder.Body.String())
+		}
+	}
+}
+
+func TestCurrentPasswordChecksAreThrottled(t *testing.T) {
+	server, _ := newProfileTestServer(t)
+	if _, err := server.sessions.Create("alice-session", "alice", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	changePassword := func(current string) *httptest.ResponseRecorder {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodPost, "/profile/password", strings.NewReader(
+			`{"current_password":"`+current+`","new_password":"Tidal-Cobalt-Lantern7"}`))
+		withSession(t, server, request, "alice-session")
+		recorder := httptest.NewRecorder()
+		server.SessionMiddleware(http.HandlerFunc(server.ChangePasswordHandler)).ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	for attempt := 1; attempt < account.LoginAccountPolicy.FreeAttempts; attempt++ {
+		if recorder := changePassword("Guess1234"); recorder.Code != http.StatusForbidden {
+			t.Fatalf("wrong guess %d got %d, want 403", attempt, recorder.Code)
+		}
+	}
+	if recorder := changePassword("Guess1234"); recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("the last free guess got %d, want 429", recorder.Code)
+	}
+	if recorder := changePassword("Password1"); recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") == "" {
+		t.Fatalf("the right password while locked got %d, want 429 with Retry-After", recorder.Code)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.LoginHandler(recorder, httptest.NewRequest(http.MethodPost, "/login",
+		strings.NewReader(`{"identifier":"alice","password":"Password1"}`)))
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("login during the lockout got %d, want 429", recorder.Code)
+	}
+}
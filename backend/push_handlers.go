@@ -0,0 +1,156 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/webpush"
+)
+
+const (
+	// pushTTL is how long the push service keeps a message for a browser
+	// that is offline.
+	pushTTL = 24 * time.Hour
+	// pushPreviewLength caps the message text shown in the notification, in
+	// characters.
+	pushPreviewLength = 140
+)
+
+// pushOffline sends a chat message to every push subscription of the
+// recipient. Subscriptions the push service reports gone are deleted.
+func (S *Server) pushOffline(recipientID int64, msg Message) {
+	if S.pushes == nil || S.pushSender == nil {
+		return
+	}
+	now := time.Now()
+	subscriptions, err := S.pushes.ForUser(recipientID, now)
+	if err != nil {
+		log.Printf("failed to list push subscriptions of user %d: %v", recipientID, err)
+		return
+	}
+	if len(subscriptions) == 0 {
+		return
+	}
+
+	preview := []rune(msg.Content)
+	if len(preview) > pushPreviewLength {
+		preview = append(preview[:pushPreviewLength-1], '…')
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"type":      "chat_message",
+		"id":        msg.ID,
+		"from":      msg.From,
+		"content":   string(preview),
+		"timestamp": msg.Timestamp,
+	})
+	if err != nil {
+		log.Printf("failed to encode push message: %v", err)
+		return
+	}
+
+	for _, subscription := range subscriptions {
+		err := S.pushSender.Send(subscription, payload, pushTTL, now)
+		if errors.Is(err, webpush.ErrSubscriptionGone) {
+			if err := S.pushes.DeleteByID(subscription.ID); err != nil {
+				log.Printf("failed to delete expired push subscription %d: %v", subscription.ID, err)
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("failed to push message to user %d: %v", recipientID, err)
+		}
+	}
+}
+
+// PushKeyHandler returns the VAPID public key the browser subscribes with.
+func (S *Server) PushKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	if S.pushSender == nil {
+		http.Error(w, "Web Push is not configured", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"public_key": S.pushSender.Keys.PublicKey()})
+}
+
+// PushSubscribeHandler stores the browser's push subscription for the
+// current session. The body is the JSON form of a PushSubscription.
+func (S *Server) PushSubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Endpoint string `json:"endpoint"`
+		Keys     struct {
+			P256dh string `json:"p256dh"`
+			Auth   string `json:"auth"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	subscription := webpush.Subscription{
+		UserID:    identity.UserID,
+		SessionID: identity.SessionID,
+		Endpoint:  request.Endpoint,
+		P256dh:    request.Keys.P256dh,
+		Auth:      request.Keys.Auth,
+	}
+	if err := subscription.Validate(); err != nil {
+		http.Error(w, "Subscription needs an https endpoint and valid p256dh and auth keys", http.StatusBadRequest)
+		return
+	}
+	if S.pushes == nil {
+		http.Error(w, "Push subscription repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+	if err := S.pushes.Save(subscription, time.Now()); err != nil {
+		http.Error(w, "Failed to save push subscription", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (S *Server) PushUnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Endpoint string `json:"endpoint"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Endpoint == "" {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if S.pushes == nil {
+		http.Error(w, "Push subscription repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+	if err := S.pushes.Delete(identity.UserID, request.Endpoint); err != nil {
+		http.Error(w, "Failed to delete push subscription", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,85 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"real-time-forum/backend/chat"
+	"real-time-forum/backend/notification"
+	"real-time-forum/backend/webpush"
+	"real-time-forum/backend/webpush/webpushtest"
+)
+
+func TestOfflineRecipientsGetChatMessagesByWebPush(t *testing.T) {
+	server := newModerationTestServer(t)
+	server.chat = chat.NewRepository(server.db)
+	server.notifications = notification.NewRepository(server.db)
+	server.chatService = chat.NewService(server.db, server.chat, server.notifications)
+	service := webpushtest.NewService()
+	defer service.Close()
+	server.pushes = webpush.NewRepository(server.db)
+	keys, err := server.pushes.Keys("", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.pushSender = &webpush.Sender{Keys: keys, Subject: "mailto:forum@example.com", Client: service.Client()}
+
+	if _, err := server.sessions.Create("alice-session", "alice", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	endpoint, p256dh, auth := service.Subscribe("alice-laptop")
+	subscribe := func(body string) int {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodPost, "/push/subscribe", strings.NewReader(body))
+		withSession(t, server, request, "alice-session")
+		recorder := httptest.NewRecorder()
+		server.SessionMiddleware(http.HandlerFunc(server.PushSubscribeHandler)).ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	if code := subscribe(`{"endpoint":"http://push.example.com/1","keys":{"p256dh":"` + p256dh + `","auth":"` + auth + `"}}`); code != http.StatusBadRequest {
+		t.Fatalf("plain http endpoint got %d, want 400", code)
+	}
+	if code := subscribe(`{"endpoint":"` + endpoint + `","keys":{"p256dh":"` + p256dh + `","auth":"` + auth + `"}}`); code != http.StatusCreated {
+		t.Fatalf("subscribe got %d, want 201", code)
+	}
+
+	bob := &Client{ID: "bob-client", UserID: 2, Username: "bob", Send: make(chan interface{}, 16)}
+	server.hub.Register(bob)
+	server.handleChatMessage(bob, Message{To: "alice", Content: "are you around?", Type: "chat_message"})
+	select {
+	case push := <-service.Pushes:
+		var payload map[string]interface{}
+		if err := json.Unmarshal(push.Payload, &payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload["from"] != "bob" || payload["content"] != "are you around?" || push.TTL != "86400" {
+			t.Fatalf("push = %s with TTL %s", push.Payload, push.TTL)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("offline recipient got no push")
+	}
+
+	alice := &Client{ID: "alice-client", UserID: 1, Username: "alice", Send: make(chan interface{}, 16)}
+	server.hub.Register(alice)
+	server.handleChatMessage(bob, Message{To: "alice", Content: "there you are", Type: "chat_message"})
+	server.hub.Unregister(alice)
+	if err := server.notifications.MuteConversation(1, 2, nil, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	server.handleChatMessage(bob, Message{To: "alice", Content: "muted", Type: "chat_message"})
+	select {
+	case push := <-service.Pushes:
+		t.Fatalf("got a push for an online or muted recipient: %s", push.Payload)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	service.Expire("alice-laptop")
+	server.pushOffline(1, Message{From: "bob", Content: "gone?"})
+	if subscriptions, err := server.pushes.ForUser(1, time.Now()); err != nil || len(subscriptions) != 0 {
+		t.Fatalf("expired subscription still stored: %v, %v", subscriptions, err)
+	}
+}
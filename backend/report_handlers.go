@@ -0,0 +1,307 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/audit"
+	"real-time-forum/backend/chat"
+	"real-time-forum/backend/forum"
+	"real-time-forum/backend/moderation"
+	"real-time-forum/backend/notification"
+)
+
+// reportTargetOwner finds who wrote the reported content. Chat messages can
+// only be reported by one of the two participants.
+func (S *Server) reportTargetOwner(reporterID int64, targetType string, targetID int64) (int64, error) {
+	switch targetType {
+	case moderation.TargetPost:
+		return S.forum.PostAuthorID(int(targetID))
+	case moderation.TargetComment:
+		return S.forum.CommentAuthorID(int(targetID))
+	default:
+		senderID, receiverID, err := S.chat.MessageParticipants(targetID)
+		if err != nil {
+			return 0, err
+		}
+		if reporterID != senderID && reporterID != receiverID {
+			return 0, chat.ErrMessageNotFound
+		}
+		return senderID, nil
+	}
+}
+
+func (S *Server) CreateReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		TargetType string `json:"target_type"`
+		TargetID   int64  `json:"target_id"`
+		Reason     string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if !moderation.ValidTargetType(request.TargetType) {
+		http.Error(w, "Target type must be post, comment, or message", http.StatusBadRequest)
+		return
+	}
+	request.Reason = strings.TrimSpace(request.Reason)
+	if !isValidTextLength(request.Reason, 3, 500) {
+		http.Error(w, "Reason must be 3-500 characters", http.StatusBadRequest)
+		return
+	}
+	if S.forum == nil || S.chat == nil || S.moderation == nil {
+		http.Error(w, "Moderation repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	ownerID, err := S.reportTargetOwner(identity.UserID, request.TargetType, request.TargetID)
+	if errors.Is(err, forum.ErrPostNotFound) || errors.Is(err, forum.ErrCommentNotFound) || errors.Is(err, chat.ErrMessageNotFound) {
+		http.Error(w, "Content not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if ownerID == identity.UserID {
+		http.Error(w, "You cannot report your own content", http.StatusBadRequest)
+		return
+	}
+
+	report, err := S.moderation.CreateReport(moderation.Report{
+		ReporterID:   identity.UserID,
+		TargetType:   request.TargetType,
+		TargetID:     request.TargetID,
+		TargetUserID: ownerID,
+		Reason:       request.Reason,
+		CreatedAt:    time.Now(),
+	})
+	if errors.Is(err, moderation.ErrDuplicateReport) {
+		http.Error(w, "You have already reported this content", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	S.sendToPermitted(account.PermissionReviewReports, map[string]interface{}{
+		"event":  "report_created",
+		"report": report,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(report)
+}
+
+// sendToPermitted pushes the message to every connected user whose role
+// grants permission. Roles are read from the database, not cached on the
+// connections, so a promotion or demotion applies to the next message.
+func (S *Server) sendToPermitted(permission account.Permission, message interface{}) {
+	if S.hub == nil || S.users == nil {
+		return
+	}
+	for _, userID := range S.hub.UserIDs() {
+		role, err := S.users.Role(userID)
+		if err != nil {
+			if !errors.Is(err, account.ErrUserNotFound) {
+				log.Printf("failed to load role of user %d: %v", userID, err)
+			}
+			continue
+		}
+		if role.Can(permission) {
+			S.hub.SendToUser(userID, message)
+		}
+	}
+}
+
+func (S *Server) ListReportsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	if S.moderation == nil {
+		http.Error(w, "Moderation repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := moderation.ReportFilter{
+		Status:     query.Get("status"),
+		TargetType: query.Get("target_type"),
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+	if value := query.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+		filter.Offset = offset
+	}
+
+	reports, err := S.moderation.ListReports(filter)
+	if errors.Is(err, moderation.ErrInvalidReportQuery) {
+		http.Error(w, "Invalid status or target type", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reports)
+}
+
+// ResolveReportHandler applies the moderator's decision to the reported
+// content or its author, then closes every open report about that content.
+func (S *Server) ResolveReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		ReportID      int64  `json:"report_id"`
+		Action        string `json:"action"`
+		Note          string `json:"note"`
+		DurationHours int    `json:"duration_hours"`
+		Permanent     bool   `json:"permanent"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	request.Note = strings.TrimSpace(request.Note)
+	switch request.Action {
+	case moderation.ActionHide, moderation.ActionDismiss:
+	case moderation.ActionWarn, moderation.ActionSuspend:
+		if !isValidTextLength(request.Note, 3, 500) {
+			http.Error(w, "Note must be 3-500 characters", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Action must be hide, warn, suspend, or dismiss", http.StatusBadRequest)
+		return
+	}
+	if request.Action == moderation.ActionSuspend && !validSuspensionLength(w, request.DurationHours, request.Permanent) {
+		return
+	}
+	if S.users == nil || S.forum == nil || S.chat == nil || S.moderation == nil {
+		http.Error(w, "Moderation repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	report, err := S.moderation.Report(request.ReportID)
+	if errors.Is(err, moderation.ErrReportNotFound) {
+		http.Error(w, "Report not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if report.Status != moderation.StatusOpen {
+		http.Error(w, "Report is already resolved", http.StatusConflict)
+		return
+	}
+	if (request.Action == moderation.ActionWarn || request.Action == moderation.ActionSuspend) &&
+		!S.canModerate(w, identity, report.TargetUserID) {
+		return
+	}
+
+	now := time.Now()
+	switch request.Action {
+	case moderation.ActionHide:
+		err = S.hideReportedContent(report, now)
+		if err == nil {
+			S.notify(report.TargetUserID, notification.TypeModeration, 0, map[string]interface{}{
+				"action":      request.Action,
+				"target_type": report.TargetType,
+				"target_id":   report.TargetID,
+				"note":        request.Note,
+			})
+		}
+	case moderation.ActionWarn:
+		err = S.moderation.AddWarning(report.TargetUserID, identity.UserID, report.ID, request.Note, now)
+		if err == nil && S.hub != nil {
+			S.hub.SendToUser(report.TargetUserID, map[string]string{
+				"event":   "moderation_warning",
+				"message": request.Note,
+			})
+		}
+		if err == nil {
+			S.notify(report.TargetUserID, notification.TypeModeration, 0, map[string]interface{}{
+				"action": request.Action,
+				"note":   request.Note,
+			})
+		}
+	case moderation.ActionSuspend:
+		_, err = S.suspendUser(r, identity, report.TargetUserID, request.Note, request.DurationHours)
+	}
+	if err != nil && !errors.Is(err, forum.ErrPostNotFound) && !errors.Is(err, forum.ErrCommentNotFound) && !errors.Is(err, chat.ErrMessageNotFound) {
+		log.Printf("failed to apply %s to report %d: %v", request.Action, report.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	report, err = S.moderation.ResolveReport(report.ID, identity.UserID, request.Action, request.Note, now)
+	if errors.Is(err, moderation.ErrReportNotOpen) {
+		http.Error(w, "Report is already resolved", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	event := actorEvent(identity, audit.ActionReportResolved)
+	event.TargetType, event.TargetID = audit.TargetReport, report.ID
+	event.Details = fmt.Sprintf("action=%s content=%s:%d author_id=%d note=%s",
+		request.Action, report.TargetType, report.TargetID, report.TargetUserID, request.Note)
+	S.recordAudit(r, event)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func (S *Server) hideReportedContent(report moderation.Report, now time.Time) error {
+	switch report.TargetType {
+	case moderation.TargetPost:
+		return S.forum.HidePost(int(report.TargetID), now)
+	case moderation.TargetComment:
+		return S.forum.HideComment(int(report.TargetID), now)
+	default:
+		return S.chat.HideMessage(report.TargetID, now)
+	}
+}
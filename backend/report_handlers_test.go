@@ -0,0 +1,205 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/chat"
+	"real-time-forum/backend/forum"
+	"real-time-forum/backend/moderation"
+)
+
+func TestReportIsQueuedPushedAndResolvedByHiding(t *testing.T) {
+	server := newModerationTestServer(t)
+	server.chat = chat.NewRepository(server.db)
+	carol := &Client{ID: "carol-client", UserID: 3, Send: make(chan interface{}, 4)}
+	alice := &Client{ID: "alice-client", UserID: 1, Send: make(chan interface{}, 4)}
+	server.hub.Register(carol)
+	server.hub.Register(alice)
+	if _, err := server.forum.CreateComment(1, 0, 2, "Agreed, buy now"); err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/reports",
+		strings.NewReader(`{"target_type":"post","target_id":1,"reason":"spam link"}`))
+	withSession(t, server, request, "bob-session")
+	recorder := httptest.NewRecorder()
+	server.SessionMiddleware(http.HandlerFunc(server.CreateReportHandler)).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want 201: %s", recorder.Code, recorder.Body.String())
+	}
+	var report moderation.Report
+	if err := json.NewDecoder(recorder.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if report.TargetUserID != 1 {
+		t.Fatalf("got target user %d, want 1", report.TargetUserID)
+	}
+	if len(carol.Send) != 1 {
+		t.Fatal("online moderator should receive report_created")
+	}
+	if len(alice.Send) != 0 {
+		t.Fatal("members should not receive report events")
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/moderation/reports?status=open", nil)
+	withSession(t, server, request, "bob-session")
+	recorder = httptest.NewRecorder()
+	server.Authorized(account.PermissionReviewReports, server.ListReportsHandler).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("member listing reports got %d, want 403", recorder.Code)
+	}
+
+	request = httptest.NewRequest(http.MethodPost, "/moderation/reports/resolve",
+		strings.NewReader(`{"report_id":1,"action":"hide"}`))
+	withSession(t, server, request, "carol-session")
+	recorder = httptest.NewRecorder()
+	server.Authorized(account.PermissionReviewReports, server.ResolveReportHandler).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", recorder.Code, recorder.Body.String())
+	}
+
+	posts, err := server.forum.ListPosts(forum.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 0 {
+		t.Fatalf("hidden post should not be listed, got %d posts", len(posts))
+	}
+	recorder = httptest.NewRecorder()
+	server.GetCommentsHandler(recorder, httptest.NewRequest(http.MethodGet, "/comments?post_id=1", nil))
+	var comments []forum.Comment
+	if err := json.NewDecoder(recorder.Body).Decode(&comments); err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) != 0 {
+		t.Fatalf("comments of the hidden post are still readable: %+v", comments)
+	}
+}
+
+func TestReportRejectsOwnContent(t *testing.T) {
+	server := newModerationTestServer(t)
+	server.chat = chat.NewRepository(server.db)
+	if _, err := server.sessions.Create("alice-session", "alice", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/reports",
+		strings.NewReader(`{"target_type":"post","target_id":1,"reason":"testing"}`))
+	withSession(t, server, request, "alice-session")
+	recorder := httptest.NewRecorder()
+	server.SessionMiddleware(http.HandlerFunc(server.CreateReportHandler)).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want 400", recorder.Code)
+	}
+}
+
+func TestDeletingContentDeletesItsOpenReports(t *testing.T) {
+	server := newModerationTestServer(t)
+	commentID, err := server.forum.CreateComment(1, 0, 2, "A comment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	for _, report := range []struct {
+		targetType string
+		targetID   int64
+		status     string
+	}{
+		{"post", 1, "open"},
+		{"comment", commentID, "open"},
+		{"comment", commentID, "actioned"},
+	} {
+		if _, err := server.db.Exec(`
+			INSERT INTO reports (reporter_id, target_type, target_id, target_user_id, reason, status, created_at)
+			VALUES (3, ?, ?, 1, 'spam', ?, ?)`, report.targetType, report.targetID, report.status, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := server.forum.DeleteComment(int(commentID)); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, server, "SELECT COUNT(*) FROM reports WHERE target_type = 'comment' AND status = 'open'"); n != 0 {
+		t.Fatalf("%d open reports still point at the deleted comment", n)
+	}
+	if n := countRows(t, server, "SELECT COUNT(*) FROM reports WHERE status = 'actioned'"); n != 1 {
+		t.Fatal("the resolved report should stay as moderation history")
+	}
+	if err := server.forum.DeletePost(1); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, server, "SELECT COUNT(*) FROM reports WHERE target_type = 'post'"); n != 0 {
+		t.Fatalf("%d open reports still point at the deleted post", n)
+	}
+}
+
+func TestResolvingWithSuspendNeedsADurationOrAnExplicitBan(t *testing.T) {
+	server := newModerationTestServer(t)
+	server.chat = chat.NewRepository(server.db)
+	if _, err := server.moderation.CreateReport(moderation.Report{
+		ReporterID: 2, TargetType: moderation.TargetPost, TargetID: 1, TargetUserID: 1,
+		Reason: "spam link", CreatedAt: time.Now(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	resolve := func(body string) int {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodPost, "/moderation/reports/resolve", strings.NewReader(body))
+		withSession(t, server, request, "carol-session")
+		recorder := httptest.NewRecorder()
+		server.Authorized(account.PermissionReviewReports, server.ResolveReportHandler).ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	if code := resolve(`{"report_id":1,"action":"suspend","note":"repeated spam"}`); code != http.StatusBadRequest {
+		t.Fatalf("suspend without a duration got %d, want 400", code)
+	}
+	if _, err := server.moderation.ActiveSuspension(1, time.Now()); err == nil {
+		t.Fatal("suspend without a duration banned the author")
+	}
+	if code := resolve(`{"report_id":1,"action":"suspend","note":"repeated spam","duration_hours":24}`); code != http.StatusOK {
+		t.Fatalf("suspend for a day got %d, want 200", code)
+	}
+	suspension, err := server.moderation.ActiveSuspension(1, time.Now())
+	if err != nil || suspension.ExpiresAt == nil {
+		t.Fatalf("suspension = %+v, %v; want one that expires", suspension, err)
+	}
+}
+
+func TestReportEventsFollowRoleChangesWithoutReconnecting(t *testing.T) {
+	server := newModerationTestServer(t)
+	server.chat = chat.NewRepository(server.db)
+	carol := &Client{ID: "carol-client", UserID: 3, Send: make(chan interface{}, 4)}
+	alice := &Client{ID: "alice-client", UserID: 1, Send: make(chan interface{}, 4)}
+	server.hub.Register(carol)
+	server.hub.Register(alice)
+
+	// carol connected as a moderator and alice as a member; then they swap.
+	if err := server.users.SetRoleByNickname("carol", account.RoleMember); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.users.SetRoleByNickname("alice", account.RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/reports",
+		strings.NewReader(`{"target_type":"post","target_id":1,"reason":"spam link"}`))
+	withSession(t, server, request, "bob-session")
+	recorder := httptest.NewRecorder()
+	server.SessionMiddleware(http.HandlerFunc(server.CreateReportHandler)).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want 201: %s", recorder.Code, recorder.Body.String())
+	}
+	if len(carol.Send) != 0 {
+		t.Fatal("a demoted moderator still receives report_created")
+	}
+	if len(alice.Send) != 1 {
+		t.Fatal("a promoted moderator should receive report_created")
+	}
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLocalBlobStoreRoundTrip(t *testing.T) {
+	store, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Put("avatars/a.png", strings.NewReader("image")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	reader, err := store.Open("avatars/a.png")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "image" {
+		t.Fatalf("got %q, want %q", data, "image")
+	}
+
+	if err := store.Delete("avatars/a.png"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := store.Open("avatars/a.png"); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("Open after delete returned %v, want ErrBlobNotFound", err)
+	}
+}
+
+func TestLocalBlobStoreRejectsTraversal(t *testing.T) {
+	store, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"../secret", "/etc/passwd", "avatars/../../x", "avatars/.hidden", ""} {
+		if err := store.Put(key, strings.NewReader("x")); !errors.Is(err, ErrInvalidKey) {
+			t.Fatalf("Put(%q) returned %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package subscription
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Target types a user can subscribe to.
+const (
+	TargetPost     = "post"
+	TargetCategory = "category"
+	TargetAuthor   = "author"
+)
+
+var ErrInvalidTarget = errors.New("subscription target is invalid")
+
+// Target is what a subscription follows: a post or an author by ID, or a
+// category by name. Categories match case-insensitively.
+type Target struct {
+	Type     string
+	ID       int64
+	Category string
+}
+
+func Post(postID int64) Target        { return Target{Type: TargetPost, ID: postID} }
+func Author(userID int64) Target      { return Target{Type: TargetAuthor, ID: userID} }
+func Category(category string) Target { return Target{Type: TargetCategory, Category: category} }
+
+func (t Target) valid() bool {
+	switch t.Type {
+	case TargetPost, TargetAuthor:
+		return t.ID > 0 && t.Category == ""
+	case TargetCategory:
+		return t.ID == 0 && t.Category != ""
+	}
+	return false
+}
+
+// Subscription is one followed target with a label for display: the post
+// title or the author's current nickname. Posts and authors that are gone
+// are left out of listings.
+type Subscription struct {
+	TargetType string    `json:"target_type"`
+	PostID     int64     `json:"post_id,omitempty"`
+	PostTitle  string    `json:"post_title,omitempty"`
+	UserID     int64     `json:"user_id,omitempty"`
+	Nickname   string    `json:"nickname,omitempty"`
+	Category   string    `json:"category,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+// Subscribe follows the target. Subscribing twice is not an error.
+func (r *Repository) Subscribe(userID int64, target Target, now time.Time) error {
+	if !target.valid() {
+		return ErrInvalidTarget
+	}
+	_, err := r.db.Exec(`
+		INSERT INTO subscriptions (user_id, target_type, target_id, category, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id, target_type, target_id, category) DO NOTHING`,
+		userID, target.Type, target.ID, target.Category, now.UTC())
+	return err
+}
+
+func (r *Repository) Unsubscribe(userID int64, target Target) error {
+	if !target.valid() {
+		return ErrInvalidTarget
+	}
+	_, err := r.db.Exec(`
+		DELETE FROM subscriptions
+		WHERE user_id = ? AND target_type = ? AND target_id = ? AND category = ?`,
+		userID, target.Type, target.ID, target.Category)
+	return err
+}
+
+// List returns the user's subscriptions, newest first.
+func (r *Repository) List(userID int64) ([]Subscription, error) {
+	rows, err := r.db.Query(`
+		SELECT subscriptions.target_type, subscriptions.target_id, subscriptions.category,
+		       COALESCE(posts.title, ''), COALESCE(users.nickname, ''), subscriptions.created_at
+		FROM subscriptions
+		LEFT JOIN posts ON subscriptions.target_type = ? AND posts.id = subscriptions.target_id
+		LEFT JOIN users ON subscriptions.target_type = ? AND users.id = subscriptions.target_id
+		WHERE subscriptions.user_id = ?
+		  AND (subscriptions.target_type = ?
+		       OR (subscriptions.target_type = ? AND posts.id IS NOT NULL AND posts.hidden_at IS NULL)
+		       OR (subscriptions.target_type = ? AND users.id IS NOT NULL AND users.deleted_at IS NULL))
+		ORDER BY subscriptions.id DESC`,
+		TargetPost, TargetAuthor, userID, TargetCategory, TargetPost, TargetAuthor)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []Subscription{}
+	for rows.Next() {
+		var subscription Subscription
+		var targetID int64
+		var title, nickname string
+		if err := rows.Scan(&subscription.TargetType, &targetID, &subscription.Category,
+			&title, &nickname, &subscription.CreatedAt); err != nil {
+			return nil, err
+		}
+		switch subscription.TargetType {
+		case TargetPost:
+			subscription.PostID, subscription.PostTitle = targetID, title
+		case TargetAuthor:
+			subscription.UserID, subscription.Nickname = targetID, nickname
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, rows.Err()
+}
+
+// PostSubscribers lists the users following a post.
+func (r *Repository) PostSubscribers(postID int64) ([]int64, error) {
+	return r.userIDs(`
+		SELECT user_id FROM subscriptions
+		WHERE target_type = ? AND target_id = ?
+		ORDER BY user_id`, TargetPost, postID)
+}
+
+// NewPostSubscribers lists the users following the category or the author
+// of a new post, each once.
+func (r *Repository) NewPostSubscribers(authorID int64, category string) ([]int64, error) {
+	return r.userIDs(`
+		SELECT DISTINCT user_id FROM subscriptions
+		WHERE (target_type = ? AND category = ?) OR (target_type = ? AND target_id = ?)
+		ORDER BY user_id`, TargetCategory, category, TargetAuthor, authorID)
+}
+
+func (r *Repository) userIDs(query string, args ...interface{}) ([]int64, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
@@ -0,0 +1,125 @@
+package subscription
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func newSubscriptionTestRepository(t *testing.T) *Repository {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:subscription-test?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(`
+		DROP TABLE IF EXISTS users;
+		DROP TABLE IF EXISTS posts;
+		DROP TABLE IF EXISTS subscriptions;
+		CREATE TABLE users (id INTEGER PRIMARY KEY, nickname TEXT NOT NULL, deleted_at DATETIME);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, title TEXT NOT NULL, hidden_at DATETIME);
+		CREATE TABLE subscriptions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			target_type TEXT NOT NULL,
+			target_id INTEGER NOT NULL DEFAULT 0,
+			category TEXT NOT NULL DEFAULT '' COLLATE NOCASE,
+			created_at DATETIME NOT NULL,
+			UNIQUE(user_id, target_type, target_id, category)
+		);
+		INSERT INTO users (id, nickname) VALUES (1, 'alice'), (2, 'bob'), (3, 'carol');
+		INSERT INTO posts (id, user_id, title) VALUES (1, 2, 'Welcome'), (2, 3, 'Rules');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewRepository(db)
+}
+
+func TestListLabelsTargetsAndLeavesOutOrphans(t *testing.T) {
+	repository := newSubscriptionTestRepository(t)
+	now := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+	for _, target := range []Target{Post(1), Author(2), Category("Go"), Post(2), Author(3), Post(99)} {
+		if err := repository.Subscribe(1, target, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repository.Subscribe(1, Post(1), now.Add(time.Hour)); err != nil {
+		t.Fatalf("subscribing twice got %v, want no error", err)
+	}
+	// Post 2 is hidden, carol deleted her account, and post 99 never existed.
+	if _, err := repository.db.Exec(`
+		UPDATE posts SET hidden_at = ? WHERE id = 2;
+		UPDATE users SET deleted_at = ? WHERE id = 3;`, now, now); err != nil {
+		t.Fatal(err)
+	}
+
+	subscriptions, err := repository.List(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Subscription{
+		{TargetType: TargetCategory, Category: "Go", CreatedAt: now},
+		{TargetType: TargetAuthor, UserID: 2, Nickname: "bob", CreatedAt: now},
+		{TargetType: TargetPost, PostID: 1, PostTitle: "Welcome", CreatedAt: now},
+	}
+	if !reflect.DeepEqual(subscriptions, want) {
+		t.Fatalf("List got %+v, want %+v", subscriptions, want)
+	}
+
+	if err := repository.Unsubscribe(1, Category("go")); err != nil {
+		t.Fatal(err)
+	}
+	if subscriptions, err := repository.List(1); err != nil || len(subscriptions) != 2 {
+		t.Fatalf("after unsubscribing with another case got %d subscriptions (%v), want 2", len(subscriptions), err)
+	}
+}
+
+func TestSubscribersOfPostsCategoriesAndAuthors(t *testing.T) {
+	repository := newSubscriptionTestRepository(t)
+	now := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+	for _, subscribe := range []struct {
+		userID int64
+		target Target
+	}{
+		{1, Post(1)},
+		{3, Post(1)},
+		{1, Category("go")},
+		{1, Author(2)},
+		{3, Author(2)},
+		{2, Category("Rust")},
+	} {
+		if err := repository.Subscribe(subscribe.userID, subscribe.target, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	postSubscribers, err := repository.PostSubscribers(1)
+	if err != nil || !reflect.DeepEqual(postSubscribers, []int64{1, 3}) {
+		t.Fatalf("PostSubscribers = %v, %v; want [1 3]", postSubscribers, err)
+	}
+	// alice follows both the category and the author but is listed once.
+	newPostSubscribers, err := repository.NewPostSubscribers(2, "Go")
+	if err != nil || !reflect.DeepEqual(newPostSubscribers, []int64{1, 3}) {
+		t.Fatalf("NewPostSubscribers = %v, %v; want [1 3]", newPostSubscribers, err)
+	}
+}
+
+func TestSubscribeRejectsInvalidTargets(t *testing.T) {
+	repository := newSubscriptionTestRepository(t)
+	for _, target := range []Target{
+		{Type: TargetPost},
+		{Type: TargetAuthor, ID: 2, Category: "Go"},
+		{Type: TargetCategory, ID: 1, Category: "Go"},
+		{Type: TargetCategory},
+		{Type: "tag", Category: "Go"},
+	} {
+		if err := repository.Subscribe(1, target, time.Now()); !errors.Is(err, ErrInvalidTarget) {
+			t.Fatalf("Subscribe(%+v) got %v, want ErrInvalidTarget", target, err)
+		}
+	}
+}
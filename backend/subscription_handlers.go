@@ -0,0 +1,177 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"html"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"real-time-forum/backend/account"
+	"real-time-forum/backend/forum"
+	"real-time-forum/backend/notification"
+	"real-time-forum/backend/subscription"
+)
+
+// notifyNewPost subscribes the author to their post and tells everyone
+// following its category or its author, with a notification and a live
+// post_created event.
+func (S *Server) notifyNewPost(authorID int64, author string, postID int64, title, category string) {
+	if S.subscriptions == nil {
+		return
+	}
+	if err := S.subscriptions.Subscribe(authorID, subscription.Post(postID), time.Now()); err != nil {
+		log.Printf("failed to subscribe user %d to post %d: %v", authorID, postID, err)
+	}
+
+	subscribers, err := S.subscriptions.NewPostSubscribers(authorID, category)
+	if err != nil {
+		log.Printf("failed to list subscribers of post %d: %v", postID, err)
+		return
+	}
+	payload := map[string]interface{}{
+		"post_id":    postID,
+		"post_title": title,
+		"category":   category,
+	}
+	event := map[string]interface{}{
+		"event": "post_created",
+		"post": map[string]interface{}{
+			"id":       postID,
+			"title":    title,
+			"category": category,
+			"author":   author,
+		},
+	}
+	for _, userID := range subscribers {
+		if userID == authorID {
+			continue
+		}
+		S.notify(userID, notification.TypePost, authorID, payload)
+		if S.hub != nil {
+			S.hub.SendToUser(userID, event)
+		}
+	}
+}
+
+func (S *Server) ListSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if S.subscriptions == nil {
+		http.Error(w, "Subscription repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+	S.writeSubscriptions(w, identity.UserID)
+}
+
+// SubscribeHandler follows a post (post_id), a category (category), or an
+// author (nickname, following renames).
+func (S *Server) SubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	S.changeSubscription(w, r, true)
+}
+
+func (S *Server) UnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	S.changeSubscription(w, r, false)
+}
+
+func (S *Server) changeSubscription(w http.ResponseWriter, r *http.Request, subscribe bool) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/404", http.StatusSeeOther)
+		return
+	}
+	identity, ok := account.IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		TargetType string `json:"target_type"`
+		PostID     int64  `json:"post_id"`
+		Category   string `json:"category"`
+		Nickname   string `json:"nickname"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if S.subscriptions == nil || S.forum == nil || S.users == nil {
+		http.Error(w, "Subscription repository is not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	var target subscription.Target
+	switch request.TargetType {
+	case subscription.TargetPost:
+		if subscribe {
+			if _, err := S.forum.PostAuthorID(int(request.PostID)); errors.Is(err, forum.ErrPostNotFound) {
+				http.Error(w, "Post not found", http.StatusNotFound)
+				return
+			} else if err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+		target = subscription.Post(request.PostID)
+	case subscription.TargetCategory:
+		if !isValidTextLength(request.Category, 3, 50) {
+			http.Error(w, "Category must be 3-50 characters", http.StatusBadRequest)
+			return
+		}
+		// Categories are stored escaped, like the posts they are compared with.
+		target = subscription.Category(html.EscapeString(strings.TrimSpace(request.Category)))
+	case subscription.TargetAuthor:
+		userID, _, err := S.users.ResolveNickname(request.Nickname)
+		if errors.Is(err, account.ErrUserNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if userID == identity.UserID {
+			http.Error(w, "You cannot follow yourself", http.StatusBadRequest)
+			return
+		}
+		target = subscription.Author(userID)
+	default:
+		http.Error(w, "target_type must be post, category, or author", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if subscribe {
+		err = S.subscriptions.Subscribe(identity.UserID, target, time.Now())
+	} else {
+		err = S.subscriptions.Unsubscribe(identity.UserID, target)
+	}
+	if errors.Is(err, subscription.ErrInvalidTarget) {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	S.writeSubscriptions(w, identity.UserID)
+}
+
+func (S *Server) writeSubscriptions(w http.ResponseWriter, userID int64) {
+	subscriptions, err := S.subscriptions.List(userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch subscriptions", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"subscriptions": subscriptions})
+}
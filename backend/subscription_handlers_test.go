@@ -0,0 +1,115 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"real-time-forum/backend/notification"
+	"real-time-forum/backend/subscription"
+)
+
+func TestSubscriptionsFanOutPostsAndCommentsOnlyToSubscribers(t *testing.T) {
+	server := newModerationTestServer(t)
+	server.notifications = notification.NewRepository(server.db)
+	server.subscriptions = subscription.NewRepository(server.db)
+	if _, err := server.sessions.Create("alice-session", "alice", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	clients := map[string]*Client{}
+	for id, nickname := range []string{"alice", "bob", "carol"} {
+		clients[nickname] = &Client{ID: nickname + "-client", UserID: int64(id + 1), Username: nickname, Send: make(chan interface{}, 16)}
+		server.hub.Register(clients[nickname])
+	}
+	events := func(nickname string) map[string]int {
+		counts := map[string]int{}
+		for len(clients[nickname].Send) > 0 {
+			event := (<-clients[nickname].Send).(map[string]interface{})
+			counts[event["event"].(string)]++
+		}
+		return counts
+	}
+	post := func(path, sessionID, body string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		withSession(t, server, request, sessionID)
+		recorder := httptest.NewRecorder()
+		server.SessionMiddleware(handler).ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	if recorder := post("/subscriptions/subscribe", "bob-session", `{"target_type":"category","category":"news"}`, server.SubscribeHandler); recorder.Code != http.StatusOK {
+		t.Fatalf("category subscribe got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	recorder := post("/subscriptions/subscribe", "bob-session", `{"target_type":"author","nickname":"alice"}`, server.SubscribeHandler)
+	var listed struct {
+		Subscriptions []subscription.Subscription `json:"subscriptions"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed.Subscriptions) != 2 || listed.Subscriptions[0].Nickname != "alice" || listed.Subscriptions[1].Category != "news" {
+		t.Fatalf("bob's subscriptions = %+v", listed.Subscriptions)
+	}
+	if recorder := post("/subscriptions/subscribe", "bob-session", `{"target_type":"post","post_id":99}`, server.SubscribeHandler); recorder.Code != http.StatusNotFound {
+		t.Fatalf("subscribing to a missing post got %d, want 404", recorder.Code)
+	}
+
+	if recorder := post("/createPost", "alice-session", `{"title":"Release notes","content":"Version two is out today.","category":"News"}`, server.CreatePostHandler); recorder.Code != http.StatusCreated {
+		t.Fatalf("create post got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if got := events("bob"); got["notification"] != 1 || got["post_created"] != 1 {
+		t.Fatalf("bob follows the category and the author and got %v, want one of each", got)
+	}
+	if got := events("carol"); len(got) != 0 {
+		t.Fatalf("carol follows nothing and got %v", got)
+	}
+
+	comment := func(sessionID string) {
+		t.Helper()
+		if recorder := post("/createComment", sessionID, `{"post_id":2,"content":"Congrats"}`, server.CreateCommentHandler); recorder.Code != http.StatusCreated {
+			t.Fatalf("comment got %d: %s", recorder.Code, recorder.Body.String())
+		}
+	}
+	comment("bob-session")
+	if got := events("alice"); got["notification"] != 1 || got["comment_created"] != 1 {
+		t.Fatalf("the author is subscribed to their post and got %v", got)
+	}
+	if got := events("carol"); len(got) != 0 {
+		t.Fatalf("carol does not follow the post and got %v", got)
+	}
+
+	post("/subscriptions/subscribe", "carol-session", `{"target_type":"post","post_id":2}`, server.SubscribeHandler)
+	post("/subscriptions/unsubscribe", "alice-session", `{"target_type":"post","post_id":2}`, server.UnsubscribeHandler)
+	comment("bob-session")
+	if got := events("carol"); got["comment_created"] != 1 {
+		t.Fatalf("carol follows the post now and got %v", got)
+	}
+	if got := events("alice"); len(got) != 0 {
+		t.Fatalf("alice unsubscribed from her post and got %v", got)
+	}
+}
+
+func TestDeletingAPostDeletesItsSubscriptions(t *testing.T) {
+	server := newModerationTestServer(t)
+	server.subscriptions = subscription.NewRepository(server.db)
+	now := time.Now()
+	for _, target := range []subscription.Target{subscription.Post(1), subscription.Category("General")} {
+		if err := server.subscriptions.Subscribe(2, target, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := server.forum.DeletePost(1); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, server, "SELECT COUNT(*) FROM subscriptions WHERE target_type = 'post'"); n != 0 {
+		t.Fatalf("%d subscriptions still follow the deleted post", n)
+	}
+	if n := countRows(t, server, "SELECT COUNT(*) FROM subscriptions WHERE target_type = 'category'"); n != 1 {
+		t.Fatal("the category subscription should stay")
+	}
+}
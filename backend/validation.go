@@ -42,6 +42,11 @@ func isValidAge(age int) bool {
 	return age >= 13 && age <= 120
 }
 
+// isValidGender validates the gender values offered by the registration form
+func isValidGender(gender string) bool {
+	return gender == "male" || gender == "female"
+}
+
 // isValidNickname validates nickname (alphanumeric, underscore, 3-20 chars)
 func isValidNickname(nickname string) bool {
 	if len(nickname) < 3 || len(nickname) > 20 {
@@ -0,0 +1,129 @@
+package webpush
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+const (
+	saltLength   = 16
+	authLength   = 16
+	recordSize   = 4096
+	headerLength = saltLength + 4 + 1 + 65
+	tagLength    = 16
+)
+
+// MaxPayload is the largest plaintext that fits in the single 4096-byte
+// record push services are required to accept.
+const MaxPayload = recordSize - headerLength - tagLength - 1
+
+var (
+	ErrInvalidSubscriptionKeys = errors.New("push subscription keys are invalid")
+	ErrPayloadTooLarge         = errors.New("push payload is too large")
+)
+
+// Encrypt encrypts plaintext for one subscription with the aes128gcm content
+// coding, as RFC 8291 specifies for Web Push. p256dh is the browser's public
+// key and auth its authentication secret, both base64url encoded as the
+// browser hands them out.
+func Encrypt(plaintext []byte, p256dh, auth string) ([]byte, error) {
+	if len(plaintext) > MaxPayload {
+		return nil, ErrPayloadTooLarge
+	}
+	userAgentKey, authSecret, err := decodeKeys(p256dh, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	serverKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	sharedSecret, err := serverKey.ECDH(userAgentKey)
+	if err != nil {
+		return nil, err
+	}
+	contentKey, nonce, err := DeriveKeys(sharedSecret, authSecret, userAgentKey.Bytes(), serverKey.PublicKey().Bytes(), salt)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, headerLength)
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, recordSize)
+	header = append(header, 65)
+	header = append(header, serverKey.PublicKey().Bytes()...)
+
+	// 0x02 marks the last (and only) record; no padding follows it.
+	record := append(append([]byte{}, plaintext...), 2)
+	return gcm.Seal(header, nonce, record, nil), nil
+}
+
+// DeriveKeys computes the content encryption key and nonce of RFC 8291
+// section 3.4 from the ECDH secret of the two key pairs. userAgentKey and
+// serverKey are the browser's and the sender's public keys in uncompressed
+// form. It is exported so that a receiver, such as a fake push service in
+// tests, can decrypt.
+func DeriveKeys(sharedSecret, authSecret, userAgentKey, serverKey, salt []byte) ([]byte, []byte, error) {
+	keyInfo := "WebPush: info\x00" + string(userAgentKey) + string(serverKey)
+	ikm, err := hkdf.Key(sha256.New, sharedSecret, authSecret, keyInfo, 32)
+	if err != nil {
+		return nil, nil, err
+	}
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	contentKey, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, nil, err
+	}
+	return contentKey, nonce, nil
+}
+
+func decodeKeys(p256dh, auth string) (*ecdh.PublicKey, []byte, error) {
+	rawKey, err := decodeBase64(p256dh)
+	if err != nil {
+		return nil, nil, ErrInvalidSubscriptionKeys
+	}
+	userAgentKey, err := ecdh.P256().NewPublicKey(rawKey)
+	if err != nil {
+		return nil, nil, ErrInvalidSubscriptionKeys
+	}
+	authSecret, err := decodeBase64(auth)
+	if err != nil || len(authSecret) != authLength {
+		return nil, nil, ErrInvalidSubscriptionKeys
+	}
+	return userAgentKey, authSecret, nil
+}
+
+// decodeBase64 accepts base64url with or without padding; browsers and
+// libraries differ.
+func decodeBase64(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
@@ -0,0 +1,95 @@
+package webpush
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+// Keys returns the VAPID keys. A configured private key wins; otherwise the
+// keys generated on first start are loaded, so that subscriptions survive a
+// restart.
+func (r *Repository) Keys(configured string, now time.Time) (Keys, error) {
+	if configured != "" {
+		return ParseKeys(configured)
+	}
+	var stored string
+	err := r.db.QueryRow("SELECT private_key FROM vapid_keys WHERE id = 1").Scan(&stored)
+	if err == nil {
+		return ParseKeys(stored)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return Keys{}, err
+	}
+
+	keys, err := GenerateKeys()
+	if err != nil {
+		return Keys{}, err
+	}
+	if _, err := r.db.Exec("INSERT INTO vapid_keys (id, private_key, created_at) VALUES (1, ?, ?)",
+		keys.PrivateKey(), now.UTC()); err != nil {
+		return Keys{}, err
+	}
+	return keys, nil
+}
+
+// Save stores a subscription for the session. An endpoint belongs to one
+// browser profile, so subscribing again moves it to the new session.
+func (r *Repository) Save(subscription Subscription, now time.Time) error {
+	_, err := r.db.Exec(`
+		INSERT INTO push_subscriptions (user_id, session_id, endpoint, p256dh, auth, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(endpoint) DO UPDATE SET
+			user_id = excluded.user_id, session_id = excluded.session_id,
+			p256dh = excluded.p256dh, auth = excluded.auth, created_at = excluded.created_at`,
+		subscription.UserID, subscription.SessionID, subscription.Endpoint,
+		subscription.P256dh, subscription.Auth, now.UTC())
+	return err
+}
+
+// ForUser lists the user's subscriptions whose session is still valid.
+// Signing out or revoking a session therefore stops its pushes.
+func (r *Repository) ForUser(userID int64, now time.Time) ([]Subscription, error) {
+	rows, err := r.db.Query(`
+		SELECT push_subscriptions.id, push_subscriptions.user_id, push_subscriptions.session_id,
+		       push_subscriptions.endpoint, push_subscriptions.p256dh, push_subscriptions.auth
+		FROM push_subscriptions
+		JOIN sessions ON sessions.session_id = push_subscriptions.session_id
+		WHERE push_subscriptions.user_id = ? AND sessions.expires_at > ?
+		ORDER BY push_subscriptions.id`, userID, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []Subscription
+	for rows.Next() {
+		var subscription Subscription
+		if err := rows.Scan(&subscription.ID, &subscription.UserID, &subscription.SessionID,
+			&subscription.Endpoint, &subscription.P256dh, &subscription.Auth); err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, rows.Err()
+}
+
+// Delete removes one of the user's subscriptions by endpoint.
+func (r *Repository) Delete(userID int64, endpoint string) error {
+	_, err := r.db.Exec("DELETE FROM push_subscriptions WHERE user_id = ? AND endpoint = ?", userID, endpoint)
+	return err
+}
+
+// DeleteByID removes a subscription the push service reported gone.
+func (r *Repository) DeleteByID(id int64) error {
+	_, err := r.db.Exec("DELETE FROM push_subscriptions WHERE id = ?", id)
+	return err
+}
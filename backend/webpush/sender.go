@@ -0,0 +1,145 @@
+package webpush
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrInvalidEndpoint  = errors.New("push endpoint must be a public https URL")
+	ErrPrivateAddress   = errors.New("push endpoint is not a public address")
+	ErrSubscriptionGone = errors.New("push subscription has expired or was removed")
+)
+
+// sharedAddressSpace is the carrier-grade NAT range, which netip does not
+// count as private.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// Subscription is what PushManager.subscribe returns in the browser,
+// stored per session.
+type Subscription struct {
+	ID        int64
+	UserID    int64
+	SessionID string
+	Endpoint  string
+	P256dh    string
+	Auth      string
+}
+
+// Validate checks the endpoint and keys before the subscription is stored,
+// so a bad one fails at subscribe time rather than on every push. The
+// endpoint must use https because the server posts to it, and must not name
+// localhost or a non-public IP, so a subscription cannot point the server at
+// its own network. Host names are checked again when NewClient dials them.
+func (s Subscription) Validate() error {
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil || endpoint.Scheme != "https" || !publicHost(endpoint.Hostname()) {
+		return ErrInvalidEndpoint
+	}
+	_, _, err = decodeKeys(s.P256dh, s.Auth)
+	return err
+}
+
+func publicHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return publicIP(ip)
+	}
+	return true
+}
+
+func publicIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+// NewClient returns the HTTP client pushes should be sent with. It refuses
+// to connect to loopback, private, and link-local addresses, which also
+// covers host names that resolve to one and redirects to one, and it does
+// not go through a proxy, which would hide the real address.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !publicIP(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, address)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			TLSHandshakeTimeout: timeout,
+		},
+	}
+}
+
+// Sender delivers encrypted messages to push services. Subject is the
+// contact the push service can reach the operator at, a mailto: or https:
+// URL. Client should come from NewClient outside of tests.
+type Sender struct {
+	Keys    Keys
+	Subject string
+	Client  *http.Client
+}
+
+// Send encrypts payload for the subscription and posts it to its push
+// service, which keeps it for up to ttl while the browser is offline. It
+// returns ErrSubscriptionGone when the push service no longer knows the
+// subscription, which should then be deleted.
+func (s *Sender) Send(subscription Subscription, payload []byte, ttl time.Duration, now time.Time) error {
+	body, err := Encrypt(payload, subscription.P256dh, subscription.Auth)
+	if err != nil {
+		return err
+	}
+	authorization, err := s.Keys.authorization(subscription.Endpoint, s.Subject, now)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, subscription.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", authorization)
+	request.Header.Set("Content-Encoding", "aes128gcm")
+	request.Header.Set("Content-Type", "application/octet-stream")
+	request.Header.Set("TTL", strconv.Itoa(int(ttl.Seconds())))
+	request.Header.Set("Urgency", "high")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return fmt.Errorf("push to %s: %w", request.URL.Host, err)
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 4096))
+
+	switch {
+	case response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusGone:
+		return ErrSubscriptionGone
+	case response.StatusCode < 200 || response.StatusCode > 299:
+		return fmt.Errorf("push to %s: status %d", request.URL.Host, response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package webpush
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// tokenLifetime is how long a VAPID JWT is valid; RFC 8292 allows at most
+// 24 hours.
+const tokenLifetime = 12 * time.Hour
+
+// Keys is the application server's VAPID key pair (RFC 8292). Browsers tie
+// each subscription to the public key, so replacing the keys invalidates
+// every stored subscription.
+type Keys struct {
+	private *ecdsa.PrivateKey
+}
+
+func GenerateKeys() (Keys, error) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return Keys{}, fmt.Errorf("generate VAPID keys: %w", err)
+	}
+	return Keys{private: private}, nil
+}
+
+// ParseKeys reads a private key in the format PrivateKey returns.
+func ParseKeys(privateKey string) (Keys, error) {
+	raw, err := decodeBase64(privateKey)
+	if err != nil {
+		return Keys{}, fmt.Errorf("parse VAPID private key: %w", err)
+	}
+	private, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), raw)
+	if err != nil {
+		return Keys{}, fmt.Errorf("parse VAPID private key: %w", err)
+	}
+	return Keys{private: private}, nil
+}
+
+// PrivateKey is the raw P-256 scalar, base64url encoded, the format other
+// Web Push libraries use.
+func (k Keys) PrivateKey() string {
+	raw, err := k.private.Bytes()
+	if err != nil {
+		panic("webpush: encode private key: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// PublicKey is the uncompressed P-256 point, base64url encoded. The browser
+// passes it to PushManager.subscribe as applicationServerKey.
+func (k Keys) PublicKey() string {
+	raw, err := k.private.PublicKey.Bytes()
+	if err != nil {
+		panic("webpush: encode public key: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// authorization builds the "vapid" Authorization header for a push to
+// endpoint. The JWT audience is the origin of the push service.
+func (k Keys) authorization(endpoint, subject string, now time.Time) (string, error) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	header, err := json.Marshal(map[string]string{"typ": "JWT", "alg": "ES256"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": parsed.Scheme + "://" + parsed.Host,
+		"exp": now.Add(tokenLifetime).Unix(),
+		"sub": subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, k.private, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("sign VAPID token: %w", err)
+	}
+	// JWS wants the fixed-size r || s form, not ASN.1.
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	token := signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+	return "vapid t=" + token + ", k=" + k.PublicKey(), nil
+}
@@ -0,0 +1,115 @@
+package webpush_test
+
+import (
+	"crypto/ecdh"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"real-time-forum/backend/webpush"
+	"real-time-forum/backend/webpush/webpushtest"
+)
+
+// The example message of RFC 8291 appendix A.
+func TestDecryptsTheRFC8291Example(t *testing.T) {
+	decode := func(value string) []byte {
+		t.Helper()
+		raw, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	private, err := ecdh.P256().NewPrivateKey(decode("q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := decode("DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN")
+	plaintext, err := webpushtest.Decrypt(body, private, decode("BTBZMqHH6r4Tts7J_aSIgg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "When I grow up, I want to be a watermelon" {
+		t.Fatalf("got %q", plaintext)
+	}
+}
+
+func TestSendEncryptsForTheSubscriptionAndReportsExpiredOnes(t *testing.T) {
+	service := webpushtest.NewService()
+	defer service.Close()
+	keys, err := webpush.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := webpush.ParseKeys(keys.PrivateKey())
+	if err != nil || parsed.PublicKey() != keys.PublicKey() {
+		t.Fatalf("keys do not survive a round trip: %v", err)
+	}
+	sender := &webpush.Sender{Keys: parsed, Subject: "mailto:forum@example.com", Client: service.Client()}
+
+	endpoint, p256dh, auth := service.Subscribe("laptop")
+	subscription := webpush.Subscription{Endpoint: endpoint, P256dh: p256dh, Auth: auth}
+	if err := subscription.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Send(subscription, []byte(`{"type":"chat_message"}`), time.Hour, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	push := <-service.Pushes
+	if string(push.Payload) != `{"type":"chat_message"}` || push.TTL != "3600" {
+		t.Fatalf("service got %+v", push)
+	}
+
+	service.Expire("laptop")
+	if err := sender.Send(subscription, []byte("again"), time.Hour, time.Now()); !errors.Is(err, webpush.ErrSubscriptionGone) {
+		t.Fatalf("got %v, want ErrSubscriptionGone", err)
+	}
+	tooLarge := make([]byte, webpush.MaxPayload+1)
+	if err := sender.Send(subscription, tooLarge, time.Hour, time.Now()); !errors.Is(err, webpush.ErrPayloadTooLarge) {
+		t.Fatalf("got %v, want ErrPayloadTooLarge", err)
+	}
+}
+
+func TestValidateRejectsPlainHTTPAndMalformedKeys(t *testing.T) {
+	service := webpushtest.NewService()
+	defer service.Close()
+	endpoint, p256dh, auth := service.Subscribe("phone")
+	tests := []webpush.Subscription{
+		{Endpoint: "http://push.example.com/x", P256dh: p256dh, Auth: auth},
+		{Endpoint: "https://localhost/x", P256dh: p256dh, Auth: auth},
+		{Endpoint: "https://127.0.0.1:8443/x", P256dh: p256dh, Auth: auth},
+		{Endpoint: "https://10.0.0.8/x", P256dh: p256dh, Auth: auth},
+		{Endpoint: "https://169.254.169.254/latest/meta-data", P256dh: p256dh, Auth: auth},
+		{Endpoint: "https://[::1]/x", P256dh: p256dh, Auth: auth},
+		{Endpoint: "https://[::ffff:192.168.1.1]/x", P256dh: p256dh, Auth: auth},
+		{Endpoint: endpoint, P256dh: p256dh[:20], Auth: auth},
+		{Endpoint: endpoint, P256dh: p256dh, Auth: auth[:8]},
+	}
+	for _, subscription := range tests {
+		if err := subscription.Validate(); err == nil {
+			t.Fatalf("Validate(%+v) accepted an invalid subscription", subscription)
+		}
+	}
+}
+
+func TestNewClientRefusesPrivateAddresses(t *testing.T) {
+	service := webpushtest.NewService()
+	defer service.Close()
+	keys, err := webpush.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, p256dh, auth := service.Subscribe("phone")
+	// The endpoint skips Validate, as one stored before the check would.
+	subscription := webpush.Subscription{Endpoint: service.Server.URL + "/push/phone", P256dh: p256dh, Auth: auth}
+	sender := &webpush.Sender{Keys: keys, Subject: "mailto:forum@example.com", Client: webpush.NewClient(time.Second)}
+	if err := sender.Send(subscription, []byte("hello"), time.Hour, time.Now()); !errors.Is(err, webpush.ErrPrivateAddress) {
+		t.Fatalf("got %v, want ErrPrivateAddress", err)
+	}
+	select {
+	case push := <-service.Pushes:
+		t.Fatalf("service received %+v", push)
+	default:
+	}
+}
@@ -0,0 +1,241 @@
+// Package webpushtest runs an in-process push service for tests. It hands
+// out subscriptions the way a browser would, checks the VAPID header of each
+// push, and decrypts the payload.
+package webpushtest
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"real-time-forum/backend/webpush"
+)
+
+// Push is one message the service accepted.
+type Push struct {
+	Subscription string
+	TTL          string
+	Payload      []byte
+}
+
+type subscriber struct {
+	key  *ecdh.PrivateKey
+	auth []byte
+}
+
+type Service struct {
+	Server *httptest.Server
+	// URL is the origin the endpoints use.
+	URL string
+	// Pushes receives every accepted push. It is buffered; read it or the
+	// service blocks once the buffer is full.
+	Pushes chan Push
+
+	mu          sync.Mutex
+	subscribers map[string]subscriber
+	gone        map[string]bool
+}
+
+// NewService starts a TLS push service, since subscription endpoints must
+// use https. Endpoints name example.com, which the test certificate covers,
+// because loopback endpoints are rejected; Client() dials the service for
+// it. Close it when done.
+func NewService() *Service {
+	service := &Service{
+		Pushes:      make(chan Push, 16),
+		subscribers: make(map[string]subscriber),
+		gone:        make(map[string]bool),
+	}
+	service.Server = httptest.NewTLSServer(http.HandlerFunc(service.handle))
+	service.URL = "https://example.com:" + service.Server.URL[strings.LastIndex(service.Server.URL, ":")+1:]
+	return service
+}
+
+func (s *Service) Close() {
+	s.Server.Close()
+}
+
+// Client trusts the service's certificate and connects every request to
+// it, whatever the host.
+func (s *Service) Client() *http.Client {
+	client := s.Server.Client()
+	transport := client.Transport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, s.Server.Listener.Addr().String())
+	}
+	client.Transport = transport
+	return client
+}
+
+// Subscribe creates a subscription and returns what the browser would send
+// to the application server: the endpoint and base64url p256dh and auth.
+func (s *Service) Subscribe(name string) (endpoint, p256dh, auth string) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		panic("webpushtest: generate key: " + err.Error())
+	}
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		panic("webpushtest: generate auth secret: " + err.Error())
+	}
+	s.mu.Lock()
+	s.subscribers[name] = subscriber{key: key, auth: secret}
+	s.mu.Unlock()
+	return s.URL + "/push/" + name,
+		base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+		base64.RawURLEncoding.EncodeToString(secret)
+}
+
+// Expire makes the service answer 410 Gone for the subscription, as push
+// services do once the browser unsubscribes.
+func (s *Service) Expire(name string) {
+	s.mu.Lock()
+	s.gone[name] = true
+	s.mu.Unlock()
+}
+
+func (s *Service) handle(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/push/")
+	s.mu.Lock()
+	subscriber, ok := s.subscribers[name]
+	gone := s.gone[name]
+	s.mu.Unlock()
+	if r.Method != http.MethodPost || !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if gone {
+		http.Error(w, "subscription expired", http.StatusGone)
+		return
+	}
+	if err := s.checkVAPID(r.Header.Get("Authorization")); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if r.Header.Get("Content-Encoding") != "aes128gcm" || r.Header.Get("TTL") == "" {
+		http.Error(w, "aes128gcm content and a TTL are required", http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	payload, err := Decrypt(body, subscriber.key, subscriber.auth)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.Pushes <- Push{Subscription: name, TTL: r.Header.Get("TTL"), Payload: payload}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// checkVAPID verifies the ES256 JWT against the key sent with it and checks
+// that the audience is this service.
+func (s *Service) checkVAPID(header string) error {
+	var token, key string
+	for _, part := range strings.Split(strings.TrimPrefix(header, "vapid "), ",") {
+		part = strings.TrimSpace(part)
+		if value, ok := strings.CutPrefix(part, "t="); ok {
+			token = value
+		} else if value, ok := strings.CutPrefix(part, "k="); ok {
+			key = value
+		}
+	}
+	segments := strings.Split(token, ".")
+	if !strings.HasPrefix(header, "vapid ") || len(segments) != 3 {
+		return errors.New("missing vapid authorization")
+	}
+	rawKey, err := base64.RawURLEncoding.DecodeString(key)
+	if err != nil {
+		return errors.New("invalid vapid key")
+	}
+	publicKey, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), rawKey)
+	if err != nil {
+		return errors.New("invalid vapid key")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(segments[2])
+	if err != nil || len(signature) != 64 {
+		return errors.New("invalid vapid signature")
+	}
+	digest := sha256.Sum256([]byte(segments[0] + "." + segments[1]))
+	if !ecdsa.Verify(publicKey, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		return errors.New("invalid vapid signature")
+	}
+
+	rawClaims, err := base64.RawURLEncoding.DecodeString(segments[1])
+	if err != nil {
+		return errors.New("invalid vapid claims")
+	}
+	var claims struct {
+		Audience string `json:"aud"`
+		Subject  string `json:"sub"`
+	}
+	if err := json.Unmarshal(rawClaims, &claims); err != nil || claims.Audience != s.URL || claims.Subject == "" {
+		return errors.New("invalid vapid claims")
+	}
+	return nil
+}
+
+// Decrypt reverses webpush.Encrypt for the subscriber holding private and
+// authSecret.
+func Decrypt(body []byte, private *ecdh.PrivateKey, authSecret []byte) ([]byte, error) {
+	if len(body) < 21 {
+		return nil, errors.New("record header is truncated")
+	}
+	salt, keyLength := body[:16], int(body[20])
+	if binary.BigEndian.Uint32(body[16:20]) < 18 || len(body) < 21+keyLength {
+		return nil, errors.New("record header is invalid")
+	}
+	rawServerKey, ciphertext := body[21:21+keyLength], body[21+keyLength:]
+	serverKey, err := ecdh.P256().NewPublicKey(rawServerKey)
+	if err != nil {
+		return nil, err
+	}
+	sharedSecret, err := private.ECDH(serverKey)
+	if err != nil {
+		return nil, err
+	}
+	contentKey, nonce, err := webpush.DeriveKeys(sharedSecret, authSecret, private.PublicKey().Bytes(), rawServerKey, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	record, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Strip the padding back to the delimiter, 0x02 for the last record.
+	end := len(record) - 1
+	for end >= 0 && record[end] == 0 {
+		end--
+	}
+	if end < 0 || record[end] != 2 {
+		return nil, errors.New("record delimiter is missing")
+	}
+	return record[:end], nil
+}
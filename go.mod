@@ -7,6 +7,7 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.28
 	github.com/twinj/uuid v1.0.0
 	golang.org/x/crypto v0.38.0
+	modernc.org/sqlite v1.47.0
 )
 
 require (
@@ -23,5 +24,4 @@ require (
 	modernc.org/libc v1.70.0 // indirect
 	modernc.org/mathutil v1.7.1 // indirect
 	modernc.org/memory v1.11.0 // indirect
-	modernc.org/sqlite v1.47.0 // indirect
 )
@@ -7,6 +7,8 @@ import (
 	"real-time-forum/backend"
 	"syscall"
 	"time"
+	// Quiet hours use IANA time zones, which slim container images lack.
+	_ "time/tzdata"
 )
 
 func main() {
@@ -2,6 +2,8 @@ import { startChatFeature, stopChatFeature } from './chat.js';
 import { loadPosts } from './posts.js';
 import { ErrorPage } from './error.js';
 import { renderLoggedPage, renderLoginPage } from './dom.js';
+import { setCsrfToken } from './csrf.js';
+import { resumeOIDCSignup, resumeOIDCLink, redeemMagicLink } from './login.js';
 
 const checkLoggedIn = () => {
   fetch('/logged', {
@@ -16,6 +18,7 @@ const checkLoggedIn = () => {
       return res.json()
     })
     .then(data => {
+      setCsrfToken(data.csrf_token)
       logged(true, data.username)
       renderLoggedPage(data.username)
       startChatFeature(data.username)
@@ -24,6 +27,14 @@ const checkLoggedIn = () => {
     .catch(() => {
       stopChatFeature()
       logged(false)
+      if (window.location.hash == "#oidc-signup") {
+        resumeOIDCSignup()
+        return
+      }
+      if (window.location.hash == "#oidc-link") {
+        resumeOIDCLink()
+        return
+      }
       renderLoginPage()
     })
 }
@@ -40,9 +51,11 @@ const stillLogged = () => {
       if (!res.ok) throw new Error('Not logged in')
       return res.json()
     })
+    .then(data => setCsrfToken(data.csrf_token))
     .catch(() => {
       stopChatFeature()
       logged(false)
+      if (document.getElementById('oidcSignupSection') || document.getElementById('oidcLinkSection')) return
       renderLoginPage()
     })
 }
@@ -56,6 +69,10 @@ document.addEventListener('DOMContentLoaded', function () {
   if (window.location.pathname != "/") {
     ErrorPage({ status: 404, statusText: "Page not found" })
   }
+  if (window.location.hash.startsWith("#magic-link=")) {
+    redeemMagicLink(decodeURIComponent(window.location.hash.slice("#magic-link=".length)))
+    return
+  }
   checkLoggedIn();
 });
 
@@ -0,0 +1,150 @@
+import { csrfHeaders } from './csrf.js';
+import { errorToast, successToast } from './toast.js';
+import { showSection } from './app.js';
+
+// Bookmarks save posts, comments, and chat messages with an optional note
+// and folder. The saved list lives in its own section next to the feed.
+
+async function postBookmark(path, body) {
+  const response = await fetch(path, {
+    method: "POST",
+    headers: csrfHeaders({ "Content-Type": "application/json" }),
+    body: JSON.stringify(body),
+    credentials: "include",
+  })
+  if (!response.ok) {
+    errorToast((await response.text()).trim() || "Could not update the bookmark")
+    return false
+  }
+  return true
+}
+
+export function saveBookmark(targetType, targetId, note = "", folder = "") {
+  return postBookmark("/bookmarks/save", { target_type: targetType, target_id: targetId, note, folder })
+}
+
+export function deleteBookmark(targetType, targetId) {
+  return postBookmark("/bookmarks/delete", { target_type: targetType, target_id: targetId })
+}
+
+// bookmarkButton toggles the bookmark of one target. Posts pass whether they
+// are bookmarked; comments and messages only offer saving.
+export function bookmarkButton(targetType, targetId, bookmarked = false) {
+  const button = document.createElement("button")
+  button.type = "button"
+  button.className = "bookmark-btn"
+  const render = () => {
+    button.textContent = bookmarked ? "Saved ★" : "Save ☆"
+    button.setAttribute("aria-pressed", String(bookmarked))
+  }
+  render()
+  button.addEventListener("click", async () => {
+    const ok = bookmarked ? await deleteBookmark(targetType, targetId) : await saveBookmark(targetType, targetId)
+    if (!ok) return
+    bookmarked = !bookmarked
+    render()
+  })
+  return button
+}
+
+function renderBookmark(bookmark) {
+  const item = document.createElement("div")
+  item.className = "bookmark"
+
+  const heading = document.createElement("div")
+  heading.className = "bookmark-heading"
+  const kind = document.createElement("span")
+  kind.className = "eyebrow"
+  kind.textContent = bookmark.target_type
+  const title = document.createElement("strong")
+  title.textContent = bookmark.title || `From ${bookmark.author}`
+  heading.append(kind, title)
+
+  const content = document.createElement("p")
+  content.className = "bookmark-content"
+  content.textContent = bookmark.content
+
+  const form = document.createElement("form")
+  form.className = "bookmark-form"
+  const note = document.createElement("input")
+  note.placeholder = "Note"
+  note.maxLength = 500
+  note.value = bookmark.note
+  const folder = document.createElement("input")
+  folder.placeholder = "Folder"
+  folder.maxLength = 50
+  folder.value = bookmark.folder
+  const save = document.createElement("button")
+  save.type = "submit"
+  save.textContent = "Update"
+  const remove = document.createElement("button")
+  remove.type = "button"
+  remove.textContent = "Remove"
+  form.append(note, folder, save, remove)
+
+  form.addEventListener("submit", async (e) => {
+    e.preventDefault()
+    if (await saveBookmark(bookmark.target_type, bookmark.target_id, note.value, folder.value)) {
+      successToast("Bookmark updated")
+      loadBookmarks()
+    }
+  })
+  remove.addEventListener("click", async () => {
+    if (await deleteBookmark(bookmark.target_type, bookmark.target_id)) loadBookmarks()
+  })
+
+  item.append(heading, content, form)
+  return item
+}
+
+export async function loadBookmarks() {
+  const search = document.getElementById("bookmarkSearch")
+  const folderSelect = document.getElementById("bookmarkFolder")
+  const list = document.getElementById("bookmarksList")
+  if (!list) return
+
+  const params = new URLSearchParams()
+  if (search.value.trim()) params.set("q", search.value.trim())
+  if (folderSelect.value) params.set("folder", folderSelect.value)
+  const [bookmarksResponse, foldersResponse] = await Promise.all([
+    fetch(`/bookmarks?${params}`),
+    fetch("/bookmarks/folders"),
+  ])
+  if (!bookmarksResponse.ok || !foldersResponse.ok) {
+    errorToast("Could not load your bookmarks")
+    return
+  }
+  const { bookmarks } = await bookmarksResponse.json()
+  const { folders } = await foldersResponse.json()
+
+  const selected = folderSelect.value
+  folderSelect.replaceChildren(new Option("All folders", ""))
+  folders.filter((folder) => folder.name).forEach((folder) => {
+    folderSelect.append(new Option(`${folder.name} (${folder.count})`, folder.name))
+  })
+  folderSelect.value = selected
+
+  list.replaceChildren()
+  if (bookmarks.length === 0) {
+    const empty = document.createElement("p")
+    empty.className = "no-comments"
+    empty.textContent = "Nothing saved yet."
+    list.append(empty)
+    return
+  }
+  bookmarks.forEach((bookmark) => list.append(renderBookmark(bookmark)))
+}
+
+// setupBookmarks wires the header button that switches between the feed and
+// the saved list.
+export function setupBookmarks(button) {
+  let open = false
+  button.addEventListener("click", () => {
+    open = !open
+    button.textContent = open ? "Back to feed" : "Saved"
+    showSection(open ? "bookmarksSection" : "postsSection")
+    if (open) loadBookmarks()
+  })
+  document.getElementById("bookmarkSearch").addEventListener("input", () => loadBookmarks())
+  document.getElementById("bookmarkFolder").addEventListener("change", () => loadBookmarks())
+}
@@ -1,4 +1,9 @@
-import { errorToast } from './toast.js';
+import { errorToast, successToast } from './toast.js';
+import { csrfHeaders } from './csrf.js';
+import { appendWithMentions } from './mentions.js';
+import { loadPosts } from './posts.js';
+import { loadComments } from './comments.js';
+import { bookmarkButton } from './bookmarks.js';
 
 const notificationsCache = new Map() // Cache pour les notifications [username]: count
 let socket = null
@@ -77,6 +82,13 @@ function setUserList(users) {
       statusSpan.style.backgroundColor = "rgba(244, 67, 54, 0.1)"
     }
 
+    if (username.avatar_url) {
+      const avatar = document.createElement("img")
+      avatar.className = "avatar"
+      avatar.src = username.avatar_url
+      avatar.alt = ""
+      leftContainer.appendChild(avatar)
+    }
     leftContainer.appendChild(nameSpan)
     leftContainer.appendChild(statusSpan)
 
@@ -170,7 +182,8 @@ function setUserList(users) {
       // Load initial messages
       try {
         const res = await fetch(`/messages?from=${currentUser}&to=${selectedUser}&offset=0`, {
-          method: "POST"
+          method: "POST",
+          headers: csrfHeaders()
         })
         if (!res.ok) throw new Error("Failed to load chat history")
         const messages = await res.json()
@@ -193,6 +206,44 @@ function setUserList(users) {
   })
 }
 
+// renameUser follows a nickname change pushed by the server. The user list
+// itself is refreshed by the user_list event that follows.
+function renameUser(oldNickname, nickname) {
+  if (currentUser === oldNickname) {
+    currentUser = nickname
+    const usernameDisplay = document.getElementById("usernameDisplay")
+    if (usernameDisplay) usernameDisplay.textContent = nickname
+  }
+  if (selectedUser === oldNickname) {
+    selectedUser = nickname
+    document.getElementById("chatWithName").textContent = nickname
+  }
+  if (notificationsCache.has(oldNickname)) {
+    notificationsCache.set(nickname, notificationsCache.get(oldNickname))
+    notificationsCache.delete(oldNickname)
+  }
+}
+
+// describeNotification turns a feed entry into toast text. Warnings already
+// arrive as moderation_warning, so they are not announced twice.
+function describeNotification(notification) {
+  const payload = notification.payload || {}
+  switch (notification.type) {
+    case "comment":
+      return `${notification.actor} commented on "${payload.post_title}"`
+    case "post":
+      return `${notification.actor} published "${payload.post_title}" in ${payload.category}`
+    case "reply":
+      return `${notification.actor} replied to your comment on "${payload.post_title}"`
+    case "mention":
+      return `${notification.actor} mentioned you`
+    case "moderation":
+      return payload.action === "warn" ? "" : `A moderator took action on your ${payload.target_type || "account"}`
+    default:
+      return ""
+  }
+}
+
 export async function startChatFeature(currentUsername) {
   currentUser = currentUsername
 
@@ -211,6 +262,45 @@ export async function startChatFeature(currentUsername) {
       return
     }
 
+    if (data.event === "report_created") {
+      successToast(`New ${data.report.target_type} report: ${data.report.reason}`)
+      return
+    }
+
+    if (data.event === "moderation_warning") {
+      errorToast(`Warning from a moderator: ${data.message}`)
+      return
+    }
+
+    if (data.event === "unread_changed") {
+      notificationsCache.set(data.sender, data.count)
+      updateNotificationBadgeFromCache(data.sender)
+      setUnreadTotal(data.total)
+      return
+    }
+
+    if (data.event === "notification") {
+      const text = describeNotification(data.notification)
+      if (text) successToast(text)
+      return
+    }
+
+    if (data.event === "post_created") {
+      loadPosts()
+      return
+    }
+
+    if (data.event === "comment_created") {
+      const section = document.getElementById(`comments-section-${data.post_id}`)
+      if (section && !section.classList.contains("hidden")) loadComments(data.post_id)
+      return
+    }
+
+    if (data.event === "nickname_changed") {
+      renameUser(data.old_nickname, data.nickname)
+      return
+    }
+
     if (data.type === "user_list") {
       console.log("Received user list update")
       setUserList(data.users)
@@ -240,12 +330,8 @@ export async function startChatFeature(currentUsername) {
           markNotificationsAsRead(data.from)
           updateNotificationBadgeFromCache(data.from)
         }
-      } else if (data.to === currentUser) {
-        // Incrémenter le cache de notifications
-        const currentCount = notificationsCache.get(data.from) || 0
-        notificationsCache.set(data.from, currentCount + 1)
-        updateNotificationBadgeFromCache(data.from)
       }
+      // Unread counts for other conversations arrive as unread_changed.
     }
 
   })
@@ -304,7 +390,8 @@ async function loadMessagesPage(from, to) {
   try {
     const cursor = oldestMessageID ? `&before_id=${oldestMessageID}` : `&offset=${offset}`
     const res = await fetch(`/messages?from=${from}&to=${to}${cursor}`, {
-      method: "POST"
+      method: "POST",
+      headers: csrfHeaders()
     })
     if (!res.ok) throw new Error("Failed to load chat messages")
     const messages = await res.json()
@@ -403,7 +490,8 @@ function renderMessage(msg) {
 
   // Add strong + ": " + content
   p.appendChild(strong)
-  p.append(": " + msg.content)
+  p.append(": ")
+  appendWithMentions(p, msg.content, msg.mentions)
 
   // Line break
   p.appendChild(document.createElement("br"))
@@ -412,6 +500,8 @@ function renderMessage(msg) {
   const small = document.createElement("small")
   small.textContent = new Date(msg.timestamp).toLocaleTimeString()
   p.appendChild(small)
+  // Only stored messages carry an ID to bookmark.
+  if (msg.id) p.appendChild(bookmarkButton("message", msg.id))
 
   div.appendChild(p)
   container.appendChild(div)
@@ -443,12 +533,22 @@ async function loadNotificationsFromDB() {
       notificationsCache.set(sender, count)
     }
 
+    setUnreadTotal(Object.values(notifications).reduce((sum, count) => sum + count, 0))
     console.log("Notifications loaded from DB:", notificationsCache)
   } catch (err) {
     console.error("Error loading notifications:", err)
   }
 }
 
+// setUnreadTotal shows the unread message total next to the nickname. The
+// server sends the total with every unread_changed event.
+function setUnreadTotal(total) {
+  const badge = document.getElementById("unreadTotal")
+  if (!badge) return
+  badge.textContent = total
+  badge.classList.toggle("hidden", total === 0)
+}
+
 // Nouvelle fonction pour mettre à jour le badge depuis le cache
 function updateNotificationBadgeFromCache(username) {
   const userList = document.getElementById("userList")
@@ -463,7 +563,7 @@ function updateNotificationBadgeFromCache(username) {
     const leftContainer = mainRow.querySelector("div:first-child")
     if (!leftContainer) continue
 
-    const nameSpan = leftContainer.querySelector("span:first-child")
+    const nameSpan = leftContainer.querySelector("span:first-of-type")
     if (nameSpan && nameSpan.textContent === username) {
       const count = notificationsCache.get(username) || 0
       let badge = mainRow.querySelector(".notification-badge")
@@ -490,9 +590,9 @@ async function markNotificationsAsRead(sender) {
   try {
     const res = await fetch("/notifications/mark-read", {
       method: "POST",
-      headers: {
+      headers: csrfHeaders({
         "Content-Type": "application/json"
-      },
+      }),
       body: JSON.stringify({ sender: sender })
     })
     if (!res.ok) {
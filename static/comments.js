@@ -1,8 +1,11 @@
 import { showSection } from './app.js';
 import { ErrorPage } from './error.js';
 import { errorToast } from './toast.js';
+import { csrfHeaders } from './csrf.js';
+import { appendWithMentions } from './mentions.js';
+import { bookmarkButton } from './bookmarks.js';
 
-export async function loadComments(postId) {
+export async function loadComments(postId, opened = false) {
   try {
     const response = await fetch(`/comments?post_id=${postId}`)
     if (response.status != 200 && response.status != 401 && response.status != 201) {
@@ -13,9 +16,25 @@ export async function loadComments(postId) {
     }
     const comments = await response.json()
     displayComments(postId, comments)
+    markPostRead(postId, comments, opened)
   } catch (error) {
     errorToast("Failed to load comments");
   }
+}
+
+// markPostRead records the last shown comment as read and clears the post's
+// unread badge. opened counts a view, so reloading the comments of an open
+// post leaves it out. A failure only leaves the badge until the next visit.
+async function markPostRead(postId, comments, opened) {
+  const last = comments && comments.length ? Math.max(...comments.map((comment) => comment.id)) : 0
+  const response = await fetch("/posts/read", {
+    method: "POST",
+    headers: csrfHeaders({ "Content-Type": "application/json" }),
+    body: JSON.stringify({ post_id: Number(postId), comment_id: last, opened }),
+    credentials: "include",
+  })
+  if (!response.ok) return
+  document.querySelectorAll(`[data-unread-post="${postId}"]`).forEach((badge) => badge.classList.add("hidden"))
 }
 
 function displayComments(postId, comments) {
@@ -38,11 +57,18 @@ function displayComments(postId, comments) {
     const date = document.createElement('span')
     date.className = 'comment-date'
     date.textContent = new Date(comment.created_at).toLocaleString()
-    header.append(author, date)
+    if (comment.author_avatar) {
+      const avatar = document.createElement('img')
+      avatar.className = 'avatar'
+      avatar.src = comment.author_avatar
+      avatar.alt = ''
+      header.append(avatar)
+    }
+    header.append(author, date, bookmarkButton("comment", comment.id))
 
     const content = document.createElement('div')
     content.className = 'comment-content'
-    content.textContent = comment.content
+    appendWithMentions(content, comment.content, comment.mentions)
     commentElement.append(header, content)
     commentsContainer.appendChild(commentElement)
   })
@@ -58,9 +84,9 @@ export function setupCommentSubmission(postId) {
     try {
       const response = await fetch("/createComment", {
         method: "POST",
-        headers: {
+        headers: csrfHeaders({
           "Content-Type": "application/json",
-        },
+        }),
         body: JSON.stringify({
           post_id: postId,
           content: commentContent,
@@ -88,7 +114,7 @@ export function toggleComments(postId) {
   const commentsSection = document.getElementById(`comments-section-${postId}`)
   if (commentsSection.classList.contains("hidden")) {
     commentsSection.classList.remove("hidden")
-    loadComments(postId)
+    loadComments(postId, true)
   } else {
     commentsSection.classList.add("hidden")
   }
@@ -0,0 +1,11 @@
+// CSRF token of the current session. The server returns it from /login and
+// /logged and requires it on every state-changing request.
+let csrfToken = ""
+
+export function setCsrfToken(token) {
+  csrfToken = token || ""
+}
+
+export function csrfHeaders(headers = {}) {
+  return { ...headers, "X-CSRF-Token": csrfToken }
+}
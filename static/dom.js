@@ -1,8 +1,11 @@
 import { handleRegister } from './register.js';
-import { handleLogin } from './login.js';
+import { handleLogin, handleOIDCSignup, handleOIDCLink, showSingleSignOn, requestMagicLink } from './login.js';
 import { logout } from './logout.js';
 import { successToast, errorToast } from './toast.js';
 import { loadPosts } from './posts.js';
+import { csrfHeaders } from './csrf.js';
+import { setupPush } from './push.js';
+import { setupBookmarks } from './bookmarks.js';
 
 export function clearRoot() {
     const root = document.getElementById("root");
@@ -31,6 +34,8 @@ export function renderLoginPage() {
             <div id="loginError"></div>
             <button type="submit">Sign in <span aria-hidden="true">→</span></button>
           </form>
+          <button id="magicLinkButton" class="sso-button" type="button">Email me a sign-in link</button>
+          <a id="oidcLogin" class="sso-button hidden" href="/auth/oidc/login">Sign in with your organization</a>
           <p class="auth-switch">New to the forum? <button id="showRegister" type="button">Create an account</button></p>
         </div>
     `;
@@ -40,8 +45,82 @@ export function renderLoginPage() {
     // Attach event listeners AFTER elements are created
     document.getElementById('loginForm').addEventListener('submit', handleLogin);
     document.getElementById('showRegister').addEventListener('click', renderRegisterPage);
+    document.getElementById('magicLinkButton').addEventListener('click', requestMagicLink);
+    showSingleSignOn();
+}
+
+// renderOIDCSignupPage is shown after a first single sign-on, when the
+// provider account has no forum account yet.
+export function renderOIDCSignupPage(pending) {
+    const root = clearRoot();
+
+    const section = document.createElement("section");
+    section.id = "oidcSignupSection";
+    section.className = "auth-page";
+
+    section.innerHTML = `
+        <div class="auth-card auth-card-centered">
+          <div class="auth-brand"><span class="brand-mark">F</span><h1>Forum</h1></div>
+          <div class="auth-heading">
+            <div><h2>Choose a nickname</h2><p></p></div>
+          </div>
+          <form id="oidcSignupForm">
+            <label for="oidcNickname">Nickname</label>
+            <input id="oidcNickname" placeholder="your_nickname" autocomplete="username" required />
+            <div id="oidcSignupError"></div>
+            <button type="submit">Create account <span aria-hidden="true">→</span></button>
+          </form>
+          <p class="auth-switch">Not you? <button id="showLogin" type="button">Back to sign in</button></p>
+        </div>
+    `;
+
+    root.appendChild(section);
+    section.querySelector('.auth-heading p').textContent = `Signing in as ${pending.email}.`;
+    document.getElementById('oidcNickname').value = pending.suggested_nickname || "";
+
+    document.getElementById('oidcSignupForm').addEventListener('submit', handleOIDCSignup);
+    document.getElementById('showLogin').addEventListener('click', () => {
+        history.replaceState(null, "", "/");
+        renderLoginPage();
+    });
 }
 
+// renderOIDCLinkPage asks for the forum password before a single sign-on
+// identity is linked to an account whose email is not verified yet.
+export function renderOIDCLinkPage(pending) {
+    const root = clearRoot();
+
+    const section = document.createElement("section");
+    section.id = "oidcLinkSection";
+    section.className = "auth-page";
+
+    section.innerHTML = `
+        <div class="auth-card auth-card-centered">
+          <div class="auth-brand"><span class="brand-mark">F</span><h1>Forum</h1></div>
+          <div class="auth-heading">
+            <div><h2>Link your account</h2><p></p></div>
+          </div>
+          <form id="oidcLinkForm">
+            <label for="oidcLinkPassword">Password</label>
+            <input id="oidcLinkPassword" type="password" autocomplete="current-password" required />
+            <div id="oidcLinkError"></div>
+            <button type="submit">Link and sign in <span aria-hidden="true">→</span></button>
+          </form>
+          <p class="auth-switch">Not you? <button id="showLogin" type="button">Back to sign in</button></p>
+        </div>
+    `;
+
+    root.appendChild(section);
+    section.querySelector('.auth-heading p').textContent =
+        `${pending.email} belongs to ${pending.nickname}. Enter that account's password to link it to single sign-on.`;
+
+    document.getElementById('oidcLinkForm').addEventListener('submit', handleOIDCLink);
+    document.getElementById('showLogin').addEventListener('click', () => {
+        history.replaceState(null, "", "/");
+        renderLoginPage();
+    });
+}
+
 export function renderRegisterPage() {
     const root = clearRoot();
 
@@ -84,7 +163,9 @@ export function renderLoggedPage(username) {
     header.innerHTML = `
       <div class="brand-lockup"><span class="brand-mark">F</span><div><h1>My Forum</h1><small>Make room for better ideas.</small></div></div>
       <nav>
-        <span class="user-greeting">Signed in as <strong id="usernameDisplay"></strong></span>
+        <span class="user-greeting">Signed in as <strong id="usernameDisplay"></strong> <span id="unreadTotal" class="notification-badge unread-total hidden" title="Unread messages"></span></span>
+        <button id="showBookmarksBtn" type="button">Saved</button>
+        <button id="enablePushBtn" class="hidden" type="button">Enable notifications</button>
         <button id="logoutBtn">Log out</button>
       </nav>
     `;
@@ -119,7 +200,24 @@ export function renderLoggedPage(username) {
         </div>
 
         <section id="postsSection">
-          <div class="section-heading"><div><span class="eyebrow">COMMUNITY FEED</span><h2>Latest discussions</h2><p>Share something useful with the community.</p></div></div>
+          <div class="section-heading"><div><span class="eyebrow">COMMUNITY FEED</span><h2>Latest discussions</h2><p>Share something useful with the community.</p></div>
+            <div class="feed-order">
+              <select id="postsSort" aria-label="Sort posts">
+                <option value="newest">Newest</option>
+                <option value="activity">Recent activity</option>
+                <option value="hot">Hot</option>
+                <option value="top">Top</option>
+                <option value="discussed">Most discussed</option>
+              </select>
+              <select id="postsPeriod" class="hidden" aria-label="Ranking period">
+                <option value="day">Today</option>
+                <option value="week" selected>This week</option>
+                <option value="month">This month</option>
+                <option value="year">This year</option>
+                <option value="all">All time</option>
+              </select>
+            </div>
+          </div>
           <div id="postsContainer">
             <form id="createPostForm">
               <div class="composer-title"><span class="composer-avatar">${String(username).charAt(0).toUpperCase()}</span><input name="title" placeholder="Give your post a clear title" required /></div>
@@ -133,9 +231,19 @@ export function renderLoggedPage(username) {
             </form>
 
             <div id="postsList"></div>
+            <button id="morePosts" type="button" class="hidden">Load more posts</button>
           </div>
         </section>
 
+        <section id="bookmarksSection" class="hidden">
+          <div class="section-heading"><div><span class="eyebrow">BOOKMARKS</span><h2>Saved for later</h2><p>Posts, comments, and messages you kept.</p></div></div>
+          <div class="bookmark-filters">
+            <input id="bookmarkSearch" type="search" placeholder="Search notes and content" />
+            <select id="bookmarkFolder"><option value="">All folders</option></select>
+          </div>
+          <div id="bookmarksList"></div>
+        </section>
+
       </div>
     `;
 
@@ -145,6 +253,11 @@ export function renderLoggedPage(username) {
     document.getElementById('logoutBtn').addEventListener('click', (e) => {
         logout(e);
     });
+    setupPush(document.getElementById('enablePushBtn'));
+    setupBookmarks(document.getElementById('showBookmarksBtn'));
+    document.getElementById('postsSort').addEventListener('change', () => loadPosts());
+    document.getElementById('postsPeriod').addEventListener('change', () => loadPosts());
+    document.getElementById('morePosts').addEventListener('click', () => loadPosts(true));
 
     document.getElementById('createPostForm').addEventListener('submit', async function (e) {
         e.preventDefault();
@@ -167,9 +280,9 @@ export function renderLoggedPage(username) {
         try {
             const response = await fetch('/createPost', {
                 method: 'POST',
-                headers: {
+                headers: csrfHeaders({
                     'Content-Type': 'application/json'
-                },
+                }),
                 body: JSON.stringify(postData),
                 credentials: 'include'
             });
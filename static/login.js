@@ -2,7 +2,9 @@ import { showSection, logged } from './app.js';
 import { startChatFeature } from './chat.js';
 import { ErrorPage } from './error.js';
 import { loadPosts } from './posts.js';
-import { renderLoggedPage } from './dom.js';
+import { renderLoggedPage, renderOIDCSignupPage, renderOIDCLinkPage, renderLoginPage } from './dom.js';
+import { setCsrfToken } from './csrf.js';
+import { successToast } from './toast.js';
 
 
 
@@ -23,24 +25,209 @@ export function handleLogin(event) {
     },
     body: JSON.stringify(formData)
   })
-    .then(res => {
+    .then(async res => {
+      if (res.status == 429) {
+        throw new Error(await res.text())
+      }
+      if (res.status == 403) {
+        const suspension = await res.json()
+        throw new Error(suspension.message)
+      }
       if (res.status != 200 && res.status != 401 && res.status != 201) {
         ErrorPage(res)
       }
       if (!res.ok) {
-        throw new Error("Login failed");
+        throw new Error("Incorrect email/nickname or password.");
       }
       return res.json();
     })
     .then(data => {
+      setCsrfToken(data.csrf_token)
       logged(true, data.username)
       renderLoggedPage(data.username)
       startChatFeature(data.username)
       loadPosts()
     })
     .catch(err => {
-      loginError.textContent = "Incorrect email/nickname or password."
+      loginError.textContent = err.message
       loginError.style.display = "block"
       logged(false)
     })
 }
+
+// showSingleSignOn reveals the provider button when the server has OIDC
+// configured.
+export function showSingleSignOn() {
+  fetch("/auth/oidc/config")
+    .then(res => res.ok ? res.json() : { enabled: false })
+    .then(config => {
+      const button = document.getElementById("oidcLogin")
+      if (button && config.enabled) button.classList.remove("hidden")
+    })
+    .catch(() => {})
+}
+
+// resumeOIDCSignup shows the nickname form when the callback redirected to
+// #oidc-signup, and the login page if the pending signup has expired.
+export function resumeOIDCSignup() {
+  fetch("/auth/oidc/signup", { credentials: "include" })
+    .then(res => {
+      if (!res.ok) throw new Error("No pending signup")
+      return res.json()
+    })
+    .then(pending => renderOIDCSignupPage(pending))
+    .catch(() => {
+      history.replaceState(null, "", "/")
+      renderLoginPage()
+    })
+}
+
+export function handleOIDCSignup(event) {
+  event.preventDefault()
+  const signupError = document.getElementById("oidcSignupError")
+  signupError.style.display = "none"
+
+  fetch("/auth/oidc/signup/complete", {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json"
+    },
+    credentials: "include",
+    body: JSON.stringify({ nickname: document.getElementById("oidcNickname").value })
+  })
+    .then(async res => {
+      if (!res.ok) {
+        throw new Error(await res.text())
+      }
+      return res.json()
+    })
+    .then(data => {
+      history.replaceState(null, "", "/")
+      setCsrfToken(data.csrf_token)
+      logged(true, data.username)
+      renderLoggedPage(data.username)
+      startChatFeature(data.username)
+      loadPosts()
+    })
+    .catch(err => {
+      signupError.textContent = err.message
+      signupError.style.display = "block"
+    })
+}
+
+// resumeOIDCLink shows the password prompt when the callback redirected to
+// #oidc-link, and the login page if the pending link has expired.
+export function resumeOIDCLink() {
+  fetch("/auth/oidc/link", { credentials: "include" })
+    .then(res => {
+      if (!res.ok) throw new Error("No pending link")
+      return res.json()
+    })
+    .then(pending => renderOIDCLinkPage(pending))
+    .catch(() => {
+      history.replaceState(null, "", "/")
+      renderLoginPage()
+    })
+}
+
+export function handleOIDCLink(event) {
+  event.preventDefault()
+  const linkError = document.getElementById("oidcLinkError")
+  linkError.style.display = "none"
+
+  fetch("/auth/oidc/link/confirm", {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json"
+    },
+    credentials: "include",
+    body: JSON.stringify({ password: document.getElementById("oidcLinkPassword").value })
+  })
+    .then(async res => {
+      if (!res.ok) {
+        throw new Error(await res.text())
+      }
+      return res.json()
+    })
+    .then(data => {
+      history.replaceState(null, "", "/")
+      setCsrfToken(data.csrf_token)
+      logged(true, data.username)
+      renderLoggedPage(data.username)
+      startChatFeature(data.username)
+      loadPosts()
+    })
+    .catch(err => {
+      linkError.textContent = err.message
+      linkError.style.display = "block"
+    })
+}
+
+// requestMagicLink mails a one-time sign-in link to the email typed in the
+// identifier field.
+export function requestMagicLink() {
+  const loginError = document.getElementById("loginError")
+  loginError.style.display = "none"
+  const email = document.getElementById("identifier").value.trim()
+  if (!email.includes("@")) {
+    loginError.textContent = "Enter your email address to receive a sign-in link."
+    loginError.style.display = "block"
+    return
+  }
+
+  fetch("/auth/magic-link", {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json"
+    },
+    body: JSON.stringify({ email })
+  })
+    .then(async res => {
+      if (!res.ok) {
+        throw new Error(await res.text())
+      }
+      successToast("If an account uses that address, a sign-in link is on its way.")
+    })
+    .catch(err => {
+      loginError.textContent = err.message
+      loginError.style.display = "block"
+    })
+}
+
+// redeemMagicLink signs in with the token from a #magic-link= URL. The token
+// is removed from the address bar first so it does not stay in history.
+export function redeemMagicLink(token) {
+  history.replaceState(null, "", "/")
+  fetch("/auth/magic-link/verify", {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json"
+    },
+    credentials: "include",
+    body: JSON.stringify({ token })
+  })
+    .then(async res => {
+      if (res.status == 403) {
+        const suspension = await res.json()
+        throw new Error(suspension.message)
+      }
+      if (!res.ok) {
+        throw new Error(await res.text())
+      }
+      return res.json()
+    })
+    .then(data => {
+      setCsrfToken(data.csrf_token)
+      logged(true, data.username)
+      renderLoggedPage(data.username)
+      startChatFeature(data.username)
+      loadPosts()
+    })
+    .catch(err => {
+      logged(false)
+      renderLoginPage()
+      const loginError = document.getElementById("loginError")
+      loginError.textContent = err.message
+      loginError.style.display = "block"
+    })
+}
@@ -1,13 +1,14 @@
 import { ErrorPage } from './error.js';
 import { errorToast } from './toast.js';
+import { csrfHeaders } from './csrf.js';
 
 export function logout(event) {
   event.preventDefault();
   fetch("/logout", {
     method: "POST",
-    headers: {
+    headers: csrfHeaders({
       "Content-Type": "application/json"
-    }
+    })
   })
     .then(res => {
       if (res.status != 200 && res.status != 401 && res.status != 201) {
@@ -0,0 +1,17 @@
+// appendWithMentions appends text to element, turning the server's mention
+// spans into profile links. Span offsets are JavaScript string indices.
+export function appendWithMentions(element, text, mentions) {
+  let position = 0
+  for (const mention of mentions || []) {
+    if (mention.start < position || mention.end > text.length) continue
+    element.append(text.slice(position, mention.start))
+    const link = document.createElement("a")
+    link.className = "mention"
+    link.href = `/users/${mention.user_id}`
+    link.title = `@${mention.nickname}`
+    link.textContent = text.slice(mention.start, mention.end)
+    element.append(link)
+    position = mention.end
+  }
+  element.append(text.slice(position))
+}
@@ -1,8 +1,78 @@
 import { setupCommentSubmission, toggleComments } from "./comments.js"
 import { ErrorPage } from './error.js';
+import { appendWithMentions } from './mentions.js';
+import { csrfHeaders } from './csrf.js';
+import { errorToast } from './toast.js';
+import { bookmarkButton } from './bookmarks.js';
 
-export async function loadPosts() {
-  const response = await fetch("/posts")
+// followed holds the session user's subscriptions as "type:key" strings so
+// the follow buttons can show their state.
+let followed = new Set()
+
+function followKey(request) {
+  switch (request.target_type) {
+    case "post":
+      return `post:${request.post_id}`
+    case "category":
+      return `category:${request.category.toLowerCase()}`
+    default:
+      return `author:${request.nickname}`
+  }
+}
+
+function setFollowed(subscriptions) {
+  followed = new Set(subscriptions.map(followKey))
+}
+
+async function loadSubscriptions() {
+  const response = await fetch("/subscriptions")
+  if (response.ok) {
+    setFollowed((await response.json()).subscriptions)
+  }
+}
+
+function followButton(label, request) {
+  const button = document.createElement("button")
+  button.className = "follow-btn"
+  const render = () => {
+    button.textContent = followed.has(followKey(request)) ? `Unfollow ${label}` : `Follow ${label}`
+  }
+  render()
+  button.addEventListener("click", async () => {
+    const action = followed.has(followKey(request)) ? "unsubscribe" : "subscribe"
+    const response = await fetch(`/subscriptions/${action}`, {
+      method: "POST",
+      headers: csrfHeaders({ "Content-Type": "application/json" }),
+      body: JSON.stringify(request),
+      credentials: "include",
+    })
+    if (!response.ok) {
+      errorToast((await response.text()).trim() || "Could not update the subscription")
+      return
+    }
+    setFollowed((await response.json()).subscriptions)
+    document.querySelectorAll(".follow-btn").forEach((other) => other.dispatchEvent(new Event("refresh")))
+  })
+  button.addEventListener("refresh", render)
+  return button
+}
+
+// The feed is loaded a page at a time; "Load more" asks for the next page.
+const pageSize = 50
+let shownPosts = 0
+
+export async function loadPosts(more = false) {
+  const sort = document.getElementById("postsSort")
+  const period = document.getElementById("postsPeriod")
+  if (!more) shownPosts = 0
+  const params = new URLSearchParams({ sort: sort ? sort.value : "newest", limit: pageSize, offset: shownPosts })
+  // Only the top and discussed rankings are limited to a period.
+  const ranked = params.get("sort") === "top" || params.get("sort") === "discussed"
+  if (period) {
+    period.classList.toggle("hidden", !ranked)
+    if (ranked) params.set("period", period.value)
+  }
+  const response = await fetch(`/posts?${params}`)
 
   if (!response.ok) {
     ErrorPage(response)
@@ -10,17 +80,21 @@ export async function loadPosts() {
   }
 
   const posts = await response.json()
+  await loadSubscriptions()
 
   const postsList = document.getElementById("postsList")
-  postsList.innerHTML = ""
+  if (!more) postsList.innerHTML = ""
+  shownPosts += posts.length
+  const morePosts = document.getElementById("morePosts")
+  if (morePosts) morePosts.classList.toggle("hidden", posts.length < pageSize)
 
   posts.forEach((post) => {
     const div = document.createElement("div")
     div.classList.add("post")
     div.innerHTML = `
-      <h3 class="post-title"></h3>
+      <h3 class="post-title"><span class="post-title-text"></span> <span class="post-unopened hidden">Unopened</span> <span class="notification-badge post-unread hidden" data-unread-post="${post.id}"></span></h3>
       <p class="post-content"></p>
-      <small>Category: <span class="post-category"></span> | By: <span class="post-author"></span> | At: <span class="post-date"></span></small>
+      <small>Category: <span class="post-category"></span> | By: <span class="post-author"></span> | At: <span class="post-date"></span> | <span class="post-stats"></span></small>
       
       <div class="post-actions">
         <button class="toggle-comments-btn" data-post-id="${post.id}">
@@ -40,11 +114,33 @@ export async function loadPosts() {
         </form>
       </div>
     `
-    div.querySelector('.post-title').textContent = post.title
-    div.querySelector('.post-content').textContent = post.content
+    div.querySelector('.post-title-text').textContent = post.title
+    div.querySelector('.post-unopened').classList.toggle('hidden', !post.unopened)
+    if (post.unread_comments > 0) {
+      const unread = div.querySelector('.post-unread')
+      unread.textContent = post.unread_comments
+      unread.title = `${post.unread_comments} unread comments`
+      unread.classList.remove('hidden')
+    }
+    div.querySelector('.post-stats').textContent =
+      `${post.comment_count} comments · ${post.reader_count} readers · ${post.view_count} views`
+    appendWithMentions(div.querySelector('.post-content'), post.content, post.mentions)
     div.querySelector('.post-category').textContent = post.category
     div.querySelector('.post-author').textContent = post.author
+    if (post.author_avatar) {
+      const avatar = document.createElement("img")
+      avatar.className = "avatar"
+      avatar.src = post.author_avatar
+      avatar.alt = ""
+      div.querySelector('.post-author').before(avatar)
+    }
     div.querySelector('.post-date').textContent = new Date(post.created_at).toLocaleString()
+    div.querySelector('.post-actions').append(
+      bookmarkButton("post", post.id, post.bookmarked),
+      followButton("thread", { target_type: "post", post_id: post.id }),
+      followButton("category", { target_type: "category", category: post.category }),
+      followButton(post.author, { target_type: "author", nickname: post.author }),
+    )
     postsList.appendChild(div)
 
     const toggleBtn = div.querySelector(".toggle-comments-btn")
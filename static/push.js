@@ -0,0 +1,61 @@
+import { csrfHeaders } from './csrf.js';
+import { errorToast, successToast } from './toast.js';
+
+// Web Push delivers chat messages while no forum tab is open. The browser
+// subscription is stored per session on the server, so signing in again
+// re-sends it.
+
+function pushSupported() {
+  return "serviceWorker" in navigator && "PushManager" in window && "Notification" in window
+}
+
+function base64UrlToBytes(value) {
+  const base64 = (value + "=".repeat((4 - value.length % 4) % 4)).replace(/-/g, "+").replace(/_/g, "/")
+  return Uint8Array.from(atob(base64), (char) => char.charCodeAt(0))
+}
+
+async function subscribe() {
+  const response = await fetch("/push/key")
+  if (!response.ok) throw new Error("push is not configured")
+  const { public_key: publicKey } = await response.json()
+
+  const registration = await navigator.serviceWorker.register("/sw.js")
+  const subscription = await registration.pushManager.subscribe({
+    userVisibleOnly: true,
+    applicationServerKey: base64UrlToBytes(publicKey),
+  })
+  const saved = await fetch("/push/subscribe", {
+    method: "POST",
+    headers: csrfHeaders({ "Content-Type": "application/json" }),
+    body: JSON.stringify(subscription),
+  })
+  if (!saved.ok) throw new Error("failed to save push subscription")
+}
+
+// setupPush wires the header button and, when the user already allowed
+// notifications, refreshes the subscription for the current session.
+export function setupPush(button) {
+  if (!pushSupported() || Notification.permission === "denied") return
+
+  if (Notification.permission === "granted") {
+    subscribe().catch((err) => console.error("Push subscription failed:", err))
+    return
+  }
+
+  button.classList.remove("hidden")
+  button.addEventListener("click", async () => {
+    const permission = await Notification.requestPermission()
+    if (permission !== "granted") {
+      button.classList.add("hidden")
+      return
+    }
+    try {
+      await subscribe()
+      button.classList.add("hidden")
+      successToast("You will be notified of messages while the forum is closed.")
+    } catch (err) {
+      console.error("Push subscription failed:", err)
+      errorToast("Could not enable notifications.")
+    }
+  })
+}
@@ -0,0 +1,30 @@
+// Service worker for Web Push. It shows chat messages that arrive while no
+// tab of the forum is open, and focuses or opens the forum when clicked.
+self.addEventListener("push", (event) => {
+  if (!event.data) return
+  let data
+  try {
+    data = event.data.json()
+  } catch {
+    return
+  }
+  if (data.type !== "chat_message") return
+
+  event.waitUntil(self.registration.showNotification(`New message from ${data.from}`, {
+    body: data.content,
+    tag: `chat-${data.from}`,
+    renotify: true,
+    data: { from: data.from },
+  }))
+})
+
+self.addEventListener("notificationclick", (event) => {
+  event.notification.close()
+  event.waitUntil((async () => {
+    const windows = await self.clients.matchAll({ type: "window", includeUncontrolled: true })
+    for (const client of windows) {
+      if ("focus" in client) return client.focus()
+    }
+    return self.clients.openWindow("/")
+  })())
+})